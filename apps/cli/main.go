@@ -2,10 +2,18 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
+	"sort"
 	"strconv"
 	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
 
 	"github.com/acm19/pics/internal/logger"
 	"github.com/acm19/pics/internal/pics"
@@ -23,11 +31,24 @@ var rootCmd = &cobra.Command{
 }
 
 var parseCmd = &cobra.Command{
-	Use:   "parse SOURCE_DIR TARGET_DIR",
+	Use:   "parse SOURCE_DIR TARGET_DIR | parse (--resume | --rollback) TARGET_DIR",
 	Short: "Process and organise media files",
-	Long:  `Copies media files from source subdirectories, optionally compresses JPEGs, and organises into date-based directories.`,
-	Args:  cobra.ExactArgs(2),
-	Run:   runParse,
+	Long: `Copies media files from source subdirectories, optionally compresses JPEGs, and organises into date-based directories.
+
+Every change is recorded in a journal inside TARGET_DIR. If a parse is interrupted, finish it with --resume or undo it with --rollback.
+Pressing Ctrl-C cancels the parse and rolls back the changes already made to TARGET_DIR; press it again to exit immediately.`,
+	Args: parseArgs,
+	Run:  runParse,
+}
+
+var watchCmd = &cobra.Command{
+	Use:   "watch INBOX_DIR LIBRARY_DIR",
+	Short: "Import media files dropped into an inbox",
+	Long: `Watches INBOX_DIR (recursively) and imports media files into LIBRARY_DIR once they have stopped changing.
+
+Files that settle together are imported as one batch with the same pipeline as parse. Imported files are moved to INBOX_DIR/.imported so they are not imported again. Press Ctrl-C to stop.`,
+	Args: cobra.ExactArgs(2),
+	Run:  runWatch,
 }
 
 var renameCmd = &cobra.Command{
@@ -38,6 +59,16 @@ var renameCmd = &cobra.Command{
 	Run:   runRename,
 }
 
+var galleryCmd = &cobra.Command{
+	Use:   "gallery LIBRARY_DIR OUT_DIR",
+	Short: "Render a library as a static HTML gallery",
+	Long: `Writes a static site into OUT_DIR with an index of the date-based directories of LIBRARY_DIR by year, month and day, and a page for every day with thumbnails, a lightbox and links to its videos.
+
+The site is self-contained: the media files it shows are hard linked into OUT_DIR/media, or copied when OUT_DIR is on another filesystem, so it can be served or copied on its own. Running it again brings the site up to date.`,
+	Args: cobra.ExactArgs(2),
+	Run:  runGallery,
+}
+
 var backupCmd = &cobra.Command{
 	Use:   "backup SOURCE_DIR BUCKET",
 	Short: "Backup directories to S3",
@@ -55,17 +86,84 @@ var restoreCmd = &cobra.Command{
 }
 
 var (
-	compressJPEGs bool
-	jpegQuality   int
-	maxConcurrent int
-	fromFilter    string
-	toFilter      string
+	compressJPEGs  bool
+	jpegQuality    int
+	compressorArg  string
+	maxLongEdge    int
+	dryRun         bool
+	moveSource     bool
+	outputFormat   string
+	resumeParse    bool
+	rollbackParse  bool
+	onError        string
+	sourceType     string
+	watchOnError   string
+	settleTime     time.Duration
+	batchSize      int
+	maxConcurrent  int
+	fromFilter     string
+	toFilter       string
+	extensionsCfg  string
+	fixExtensions  bool
+	convertHEIC    bool
+	keepHEIC       bool
+	heicConverter  string
+	compressVideos bool
+	videoPreset    string
+	ffmpegPath     string
+	thumbnails     bool
+	timezone       string
 )
 
 func init() {
+	// Global flags
+	rootCmd.PersistentFlags().StringVar(&extensionsCfg, "extensions", "", "Extension registry config file (default: pics/extensions.json in the user config directory, if it exists)")
+	rootCmd.PersistentPreRun = useExtensionsRegistry
+
 	// Parse command flags
 	parseCmd.Flags().BoolVarP(&compressJPEGs, "compress", "c", true, "Enable JPEG compression")
 	parseCmd.Flags().IntVarP(&jpegQuality, "rate", "r", 50, "JPEG compression quality (0-100)")
+	parseCmd.Flags().StringVar(&compressorArg, "compressor", string(pics.CompressorJpegoptim), "JPEG compressor: jpegoptim, or go to compress without external binaries")
+	parseCmd.Flags().IntVar(&maxLongEdge, "max-long-edge", 0, "Downscale JPEGs whose long edge is larger than this many pixels, e.g. 3840 (0 = keep the resolution)")
+	parseCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Print the plan without touching the disk")
+	parseCmd.Flags().BoolVar(&moveSource, "move", false, "Delete each source file once its organised copy has been verified")
+	parseCmd.Flags().StringVarP(&outputFormat, "output", "o", "text", "Output format for the parse summary, --dry-run and reconciliation reports (text or json)")
+	parseCmd.Flags().BoolVar(&resumeParse, "resume", false, "Finish an interrupted parse in TARGET_DIR")
+	parseCmd.Flags().BoolVar(&rollbackParse, "rollback", false, "Undo an interrupted parse in TARGET_DIR")
+	parseCmd.Flags().StringVar(&onError, "on-error", string(pics.ErrorPolicyFailFast), "What to do when a file cannot be imported: fail-fast, or continue and move it to _quarantine")
+	parseCmd.Flags().StringVar(&sourceType, "source-mode", string(pics.SourceModeDirectory), "Kind of export SOURCE_DIR holds: directory, takeout (Google Takeout directories or zips) or icloud")
+	parseCmd.Flags().BoolVar(&fixExtensions, "fix-extensions", false, "Rename files whose content does not match their extension (e.g. HEIC files named .jpg)")
+	parseCmd.Flags().BoolVar(&convertHEIC, "convert-heic", false, "Convert HEIC files to JPEG")
+	parseCmd.Flags().BoolVar(&keepHEIC, "keep-heic", false, "Keep the original of every converted HEIC file next to its JPEG")
+	parseCmd.Flags().StringVar(&heicConverter, "heic-converter", "", "Path to the heif-convert binary (default: heif-convert in PATH)")
+	parseCmd.Flags().BoolVar(&compressVideos, "compress-videos", false, "Transcode videos with ffmpeg, keeping the original unless the transcoded copy is smaller")
+	parseCmd.Flags().StringVar(&videoPreset, "video-preset", pics.DefaultVideoPresetName, "Video transcoding preset: "+strings.Join(pics.VideoPresetNames(), ", "))
+	parseCmd.Flags().StringVar(&ffmpegPath, "ffmpeg", "", "Path to the ffmpeg binary (default: ffmpeg in PATH)")
+	parseCmd.Flags().BoolVar(&thumbnails, "thumbnails", false, "Write thumbnails and a contact sheet into .thumbs in every date directory that received files")
+	parseCmd.Flags().StringVar(&timezone, "timezone", "", "Library timezone files are put into calendar days in, e.g. Europe/Madrid (default: the system timezone)")
+	parseCmd.MarkFlagsMutuallyExclusive("resume", "rollback", "dry-run")
+
+	// Watch command flags
+	defaultWatch := pics.DefaultWatchOptions()
+	watchCmd.Flags().BoolVarP(&compressJPEGs, "compress", "c", true, "Enable JPEG compression")
+	watchCmd.Flags().IntVarP(&jpegQuality, "rate", "r", 50, "JPEG compression quality (0-100)")
+	watchCmd.Flags().StringVar(&compressorArg, "compressor", string(pics.CompressorJpegoptim), "JPEG compressor: jpegoptim, or go to compress without external binaries")
+	watchCmd.Flags().IntVar(&maxLongEdge, "max-long-edge", 0, "Downscale JPEGs whose long edge is larger than this many pixels, e.g. 3840 (0 = keep the resolution)")
+	watchCmd.Flags().StringVar(&watchOnError, "on-error", string(defaultWatch.ParseOptions.ErrorPolicy), "What to do when a file cannot be imported: fail-fast, or continue and move it to _quarantine")
+	watchCmd.Flags().BoolVar(&fixExtensions, "fix-extensions", false, "Rename files whose content does not match their extension (e.g. HEIC files named .jpg)")
+	watchCmd.Flags().BoolVar(&convertHEIC, "convert-heic", false, "Convert HEIC files to JPEG")
+	watchCmd.Flags().BoolVar(&keepHEIC, "keep-heic", false, "Keep the original of every converted HEIC file next to its JPEG")
+	watchCmd.Flags().StringVar(&heicConverter, "heic-converter", "", "Path to the heif-convert binary (default: heif-convert in PATH)")
+	watchCmd.Flags().BoolVar(&compressVideos, "compress-videos", false, "Transcode videos with ffmpeg, keeping the original unless the transcoded copy is smaller")
+	watchCmd.Flags().StringVar(&videoPreset, "video-preset", pics.DefaultVideoPresetName, "Video transcoding preset: "+strings.Join(pics.VideoPresetNames(), ", "))
+	watchCmd.Flags().StringVar(&ffmpegPath, "ffmpeg", "", "Path to the ffmpeg binary (default: ffmpeg in PATH)")
+	watchCmd.Flags().BoolVar(&thumbnails, "thumbnails", false, "Write thumbnails and a contact sheet into .thumbs in every date directory that received files")
+	watchCmd.Flags().StringVar(&timezone, "timezone", "", "Library timezone files are put into calendar days in, e.g. Europe/Madrid (default: the system timezone)")
+	watchCmd.Flags().DurationVar(&settleTime, "settle", defaultWatch.SettleTime, "How long a file must stay unchanged before it is imported")
+	watchCmd.Flags().IntVar(&batchSize, "batch-size", defaultWatch.MaxBatchSize, "Maximum number of files imported in a single batch (0 = unlimited)")
+
+	// Gallery command flags
+	galleryCmd.Flags().StringVar(&heicConverter, "heic-converter", "", "Path to the heif-convert binary (default: heif-convert in PATH)")
 
 	// Backup command flags
 	backupCmd.Flags().IntVarP(&maxConcurrent, "max-concurrent", "c", 5, "Maximum concurrent operations")
@@ -76,7 +174,7 @@ func init() {
 	restoreCmd.Flags().StringVar(&toFilter, "to", "", "Upper bound in format YYYY or MM/YYYY")
 
 	// Add all subcommands
-	rootCmd.AddCommand(parseCmd, renameCmd, backupCmd, restoreCmd)
+	rootCmd.AddCommand(parseCmd, watchCmd, renameCmd, galleryCmd, backupCmd, restoreCmd)
 }
 
 func main() {
@@ -85,7 +183,150 @@ func main() {
 	}
 }
 
+// useExtensionsRegistry makes every command use the extension registry config, if there is one
+func useExtensionsRegistry(cmd *cobra.Command, args []string) {
+	ext, err := extensionsRegistry(extensionsCfg)
+	if err != nil {
+		logger.Error("Failed to load extension registry", "error", err)
+		os.Exit(1)
+	}
+	if ext != nil {
+		pics.SetDefaultExtensions(ext)
+	}
+}
+
+// extensionsRegistry loads the given extension registry config file or, when none is
+// given, the default one if it exists. It returns nil to keep the built-in registry.
+func extensionsRegistry(configPath string) (pics.Extensions, error) {
+	if configPath == "" {
+		defaultPath, err := pics.DefaultExtensionsConfigPath()
+		if err != nil {
+			return nil, nil
+		}
+		if _, err := os.Stat(defaultPath); err != nil {
+			return nil, nil
+		}
+		configPath = defaultPath
+	}
+	return pics.LoadExtensions(configPath)
+}
+
+// parseArgs validates the parse arguments: TARGET_DIR alone when resuming or
+// rolling back, SOURCE_DIR and TARGET_DIR otherwise.
+func parseArgs(cmd *cobra.Command, args []string) error {
+	if resumeParse || rollbackParse {
+		return cobra.ExactArgs(1)(cmd, args)
+	}
+	return cobra.ExactArgs(2)(cmd, args)
+}
+
+// errorPolicy validates the --on-error flag
+func errorPolicy(value string) (pics.ErrorPolicy, error) {
+	switch policy := pics.ErrorPolicy(value); policy {
+	case pics.ErrorPolicyFailFast, pics.ErrorPolicyContinue:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown --on-error value %q (expected %s or %s)", value, pics.ErrorPolicyFailFast, pics.ErrorPolicyContinue)
+	}
+}
+
+// sourceMode validates the --source-mode flag
+func sourceMode(value string) (pics.SourceMode, error) {
+	switch mode := pics.SourceMode(value); mode {
+	case pics.SourceModeDirectory, pics.SourceModeTakeout, pics.SourceModeICloud:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown --source-mode value %q (expected %s, %s or %s)", value, pics.SourceModeDirectory, pics.SourceModeTakeout, pics.SourceModeICloud)
+	}
+}
+
+// newMediaParser creates a MediaParser that converts HEIC files and transcodes videos with
+// the binaries given with --heic-converter and --ffmpeg
+func newMediaParser() pics.MediaParser {
+	if heicConverter == "" && ffmpegPath == "" {
+		return pics.NewMediaParser()
+	}
+	return pics.NewMediaParserWithPaths("", heicConverter, ffmpegPath, pics.NewFileOrganiser())
+}
+
+// videoTranscodePreset validates the --video-preset flag
+func videoTranscodePreset(value string) (pics.VideoPreset, error) {
+	preset, err := pics.LookupVideoPreset(value)
+	if err != nil {
+		return pics.VideoPreset{}, fmt.Errorf("invalid --video-preset: %w", err)
+	}
+	return preset, nil
+}
+
+// compressorBackend validates the --compressor flag
+func compressorBackend(value string) (pics.CompressorBackend, error) {
+	switch backend := pics.CompressorBackend(value); backend {
+	case pics.CompressorJpegoptim, pics.CompressorGo:
+		return backend, nil
+	default:
+		return "", fmt.Errorf("unknown --compressor value %q (expected %s or %s)", value, pics.CompressorJpegoptim, pics.CompressorGo)
+	}
+}
+
+// libraryTimezone validates the --timezone flag, returning nil for the system timezone
+func libraryTimezone(value string) (*time.Location, error) {
+	if value == "" {
+		return nil, nil
+	}
+	loc, err := time.LoadLocation(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --timezone: %w", err)
+	}
+	return loc, nil
+}
+
+// reportPartialParse logs the files a parse could not import and reports whether err
+// is a PartialParseError. The parse result is still valid then, so the caller goes on
+// and exits non-zero once it has been reconciled and printed.
+func reportPartialParse(err error) bool {
+	var partialErr *pics.PartialParseError
+	if !errors.As(err, &partialErr) {
+		return false
+	}
+	for _, failure := range partialErr.Failures {
+		logger.Warn("File not imported", "file", failure.File, "stage", failure.Stage, "cause", failure.Cause, "quarantinedAs", failure.QuarantinedAs)
+	}
+	logger.Error("Some files could not be imported", "failed", len(partialErr.Failures), "report", partialErr.ReportPath)
+	return true
+}
+
 func runParse(cmd *cobra.Command, args []string) {
+	policy, err := errorPolicy(onError)
+	if err != nil {
+		logger.Error("Invalid flag", "error", err)
+		os.Exit(1)
+	}
+	mode, err := sourceMode(sourceType)
+	if err != nil {
+		logger.Error("Invalid flag", "error", err)
+		os.Exit(1)
+	}
+	backend, err := compressorBackend(compressorArg)
+	if err != nil {
+		logger.Error("Invalid flag", "error", err)
+		os.Exit(1)
+	}
+	preset, err := videoTranscodePreset(videoPreset)
+	if err != nil {
+		logger.Error("Invalid flag", "error", err)
+		os.Exit(1)
+	}
+	loc, err := libraryTimezone(timezone)
+	if err != nil {
+		logger.Error("Invalid flag", "error", err)
+		os.Exit(1)
+	}
+
+	if resumeParse || rollbackParse {
+		runParseRecovery(args[0], policy)
+		return
+	}
+
 	sourceDir := args[0]
 	targetDir := args[1]
 
@@ -98,32 +339,330 @@ func runParse(cmd *cobra.Command, args []string) {
 	opts := pics.DefaultParseOptions()
 	opts.CompressJPEGs = compressJPEGs
 	opts.JPEGQuality = jpegQuality
+	opts.Compressor = backend
+	opts.MaxLongEdge = maxLongEdge
+	opts.ErrorPolicy = policy
+	opts.MoveSource = moveSource
+	opts.SourceMode = mode
+	opts.FixExtensions = fixExtensions
+	opts.ConvertHEIC = convertHEIC
+	opts.KeepHEIC = keepHEIC
+	opts.CompressVideos = compressVideos
+	opts.VideoPreset = preset
+	opts.Thumbnails = thumbnails
+	opts.Timezone = loc
+
+	if dryRun {
+		plan, err := newMediaParser().Plan(sourceDir, targetDir, opts)
+		if err != nil {
+			logger.Error("Planning failed", "error", err)
+			os.Exit(1)
+		}
+		if err := printPlan(os.Stdout, plan, outputFormat); err != nil {
+			logger.Error("Failed to print plan", "error", err)
+			os.Exit(1)
+		}
+		return
+	}
 
-	sourceCount, err := fileStats.GetFileCount(sourceDir)
+	sourceSnapshot, err := fileStats.Snapshot(sourceDir)
 	if err != nil {
-		logger.Error("Error counting source files", "error", err)
+		logger.Error("Error listing source files", "error", err)
+		os.Exit(1)
+	}
+	targetSnapshot, err := fileStats.Snapshot(targetDir)
+	if err != nil {
+		logger.Error("Error listing target files", "error", err)
 		os.Exit(1)
 	}
 
+	ctx, stop := interruptContext()
+	defer stop()
+
 	logger.Info("Starting media parsing", "source", sourceDir, "target", targetDir)
-	parser := pics.NewMediaParser()
-	if err := parser.Parse(sourceDir, targetDir, opts); err != nil {
+	parser := newMediaParser()
+	result, err := parser.ParseContext(ctx, sourceDir, targetDir, opts)
+	partial := reportPartialParse(err)
+	if err != nil && !partial {
+		if errors.Is(err, context.Canceled) {
+			logger.Error("Parse cancelled", "error", err)
+			os.Exit(130)
+		}
 		logger.Error("Parse failed", "error", err)
+		logger.Info("Finish the parse with --resume or undo it with --rollback", "target", targetDir)
+		os.Exit(1)
+	}
+
+	reconciliation, err := fileStats.Reconcile(sourceSnapshot, targetSnapshot, result)
+	if err != nil {
+		logger.Error("Error reconciling files", "error", err)
+		os.Exit(1)
+	}
+
+	// Files that failed in continue mode show up as missing, so the reconciliation is
+	// printed along with the summary before exiting
+	if !reconciliation.OK() {
+		logger.Error("Reconciliation failed", "missing", len(reconciliation.Missing), "duplicated", len(reconciliation.Duplicated), "extra", len(reconciliation.Extra))
+		if err := printReconciliation(os.Stdout, reconciliation, outputFormat); err != nil {
+			logger.Error("Failed to print reconciliation", "error", err)
+		}
+		if !partial {
+			os.Exit(1)
+		}
+	}
+
+	if err := printResult(os.Stdout, result, outputFormat); err != nil {
+		logger.Error("Failed to print summary", "error", err)
+		os.Exit(1)
+	}
+	if partial {
+		logger.Error("Processing completed with failures", "files_processed", reconciliation.ImportedFiles, "failed", len(result.Failures))
 		os.Exit(1)
 	}
+	logger.Info("Processing completed successfully", "files_processed", reconciliation.ImportedFiles, "skipped", len(reconciliation.Skipped), "verification", "every source file reconciled")
+}
+
+func runWatch(cmd *cobra.Command, args []string) {
+	inboxDir := args[0]
+	libraryDir := args[1]
 
-	targetCount, err := fileStats.GetFileCount(targetDir)
+	policy, err := errorPolicy(watchOnError)
 	if err != nil {
-		logger.Error("Error counting target files", "error", err)
+		logger.Error("Invalid flag", "error", err)
+		os.Exit(1)
+	}
+	backend, err := compressorBackend(compressorArg)
+	if err != nil {
+		logger.Error("Invalid flag", "error", err)
+		os.Exit(1)
+	}
+	preset, err := videoTranscodePreset(videoPreset)
+	if err != nil {
+		logger.Error("Invalid flag", "error", err)
+		os.Exit(1)
+	}
+	loc, err := libraryTimezone(timezone)
+	if err != nil {
+		logger.Error("Invalid flag", "error", err)
+		os.Exit(1)
+	}
+
+	if err := pics.NewFileStats().ValidateDirectories(inboxDir, libraryDir); err != nil {
+		logger.Error("Directory validation failed", "error", err)
 		os.Exit(1)
 	}
 
-	if sourceCount != targetCount {
-		logger.Error("File count mismatch", "source_files", sourceCount, "target_files", targetCount, "difference", targetCount-sourceCount)
+	opts := pics.DefaultWatchOptions()
+	opts.ParseOptions.CompressJPEGs = compressJPEGs
+	opts.ParseOptions.JPEGQuality = jpegQuality
+	opts.ParseOptions.Compressor = backend
+	opts.ParseOptions.MaxLongEdge = maxLongEdge
+	opts.ParseOptions.ErrorPolicy = policy
+	opts.ParseOptions.FixExtensions = fixExtensions
+	opts.ParseOptions.ConvertHEIC = convertHEIC
+	opts.ParseOptions.KeepHEIC = keepHEIC
+	opts.ParseOptions.CompressVideos = compressVideos
+	opts.ParseOptions.VideoPreset = preset
+	opts.ParseOptions.Thumbnails = thumbnails
+	opts.ParseOptions.Timezone = loc
+	opts.SettleTime = settleTime
+	opts.MaxBatchSize = batchSize
+
+	ctx, stop := interruptContext()
+	defer stop()
+
+	watcher := pics.NewInboxWatcher(newMediaParser())
+	if err := watcher.Watch(ctx, inboxDir, libraryDir, opts); err != nil {
+		logger.Error("Watch failed", "error", err)
 		os.Exit(1)
 	}
+}
+
+// runParseRecovery resumes or rolls back an interrupted parse in targetDir.
+func runParseRecovery(targetDir string, policy pics.ErrorPolicy) {
+	parser := newMediaParser()
 
-	logger.Info("Processing completed successfully", "files_processed", sourceCount, "verification", "source and target file counts match")
+	if rollbackParse {
+		if err := parser.Rollback(targetDir); err != nil {
+			logger.Error("Rollback failed", "error", err)
+			os.Exit(1)
+		}
+		logger.Info("Rollback completed successfully", "target", targetDir)
+		return
+	}
+
+	ctx, stop := interruptContext()
+	defer stop()
+
+	opts := pics.DefaultParseOptions()
+	opts.ErrorPolicy = policy
+	result, err := parser.ResumeContext(ctx, targetDir, opts)
+	partial := reportPartialParse(err)
+	if err != nil && !partial {
+		if errors.Is(err, context.Canceled) {
+			logger.Error("Resume cancelled", "error", err)
+			os.Exit(130)
+		}
+		logger.Error("Resume failed", "error", err)
+		os.Exit(1)
+	}
+	if err := printResult(os.Stdout, result, outputFormat); err != nil {
+		logger.Error("Failed to print summary", "error", err)
+		os.Exit(1)
+	}
+	if partial {
+		logger.Error("Resume completed with failures", "target", targetDir, "failed", len(result.Failures))
+		os.Exit(1)
+	}
+	logger.Info("Resume completed successfully", "target", targetDir)
+}
+
+// interruptContext returns a context cancelled by the first Ctrl-C (or SIGTERM).
+// Once cancelled the signals are released, so a second Ctrl-C exits immediately.
+func interruptContext() (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	return ctx, stop
+}
+
+// printPlan writes a parse plan to w in the given format ("text" or "json").
+func printPlan(w io.Writer, plan *pics.ParsePlan, format string) error {
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(plan)
+	case "text":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "SOURCE\tSTAGING NAME\tDATE\tEXTRACTOR\tTARGET")
+		for _, file := range plan.Files {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n",
+				file.Source, file.StagingName, file.Date.Format("2006-01-02 15:04:05"), file.Extractor, file.FinalPath)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+		_, err := fmt.Fprintf(w, "\n%d files would be organised into %s\n", len(plan.Files), plan.TargetDir)
+		return err
+	default:
+		return fmt.Errorf("unknown output format: %s (expected text or json)", format)
+	}
+}
+
+// printReconciliation writes the findings of a reconciliation to w in the given format ("text" or "json").
+func printReconciliation(w io.Writer, reconciliation *pics.Reconciliation, format string) error {
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(reconciliation)
+	case "text":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "FINDING\tSOURCE\tTARGET\tREASON")
+		for _, finding := range []struct {
+			name  string
+			files []pics.ReconciledFile
+		}{
+			{"missing", reconciliation.Missing},
+			{"duplicated", reconciliation.Duplicated},
+			{"extra", reconciliation.Extra},
+		} {
+			for _, file := range finding.files {
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", finding.name, orDash(file.Source), orDash(file.Target), file.Reason)
+			}
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+		_, err := fmt.Fprintf(w, "\n%d of %d source files imported: %d missing, %d duplicated, %d extra, %d skipped\n",
+			reconciliation.ImportedFiles, reconciliation.SourceFiles, len(reconciliation.Missing),
+			len(reconciliation.Duplicated), len(reconciliation.Extra), len(reconciliation.Skipped))
+		return err
+	default:
+		return fmt.Errorf("unknown output format: %s (expected text or json)", format)
+	}
+}
+
+// printResult writes a summary of a parse to w in the given format ("text" or "json").
+func printResult(w io.Writer, result *pics.ParseResult, format string) error {
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(result)
+	case "text":
+		before := make(map[pics.MediaClass]int64)
+		after := make(map[pics.MediaClass]int64)
+		for _, file := range result.Files {
+			before[file.Class] += file.BytesBefore
+			after[file.Class] += file.BytesAfter
+		}
+		classes := make([]pics.MediaClass, 0, len(result.FilesByClass))
+		for class := range result.FilesByClass {
+			classes = append(classes, class)
+		}
+		sort.Slice(classes, func(i, j int) bool { return classes[i] < classes[j] })
+
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "CLASS\tFILES\tBEFORE\tAFTER")
+		for _, class := range classes {
+			fmt.Fprintf(tw, "%s\t%d\t%s\t%s\n", orDash(string(class)), result.FilesByClass[class], formatBytes(before[class]), formatBytes(after[class]))
+		}
+		fmt.Fprintln(tw, "\nDIRECTORY\tFILES\tSTATUS")
+		for _, dir := range result.DateDirs {
+			status := "extended"
+			if dir.Created {
+				status = "created"
+			}
+			fmt.Fprintf(tw, "%s\t%d\t%s\n", dir.Name, dir.Files, status)
+		}
+		fmt.Fprintln(tw, "\nSTAGE\tDURATION")
+		for _, stage := range result.Stages {
+			fmt.Fprintf(tw, "%s\t%s\n", stage.Stage, stage.Duration.Round(time.Millisecond))
+		}
+		if len(result.Failures) > 0 {
+			fmt.Fprintln(tw, "\nFAILED\tSTAGE\tCAUSE\tQUARANTINED AS")
+			for _, failure := range result.Failures {
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", failure.File, failure.Stage, failure.Cause, orDash(failure.QuarantinedAs))
+			}
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+		_, err := fmt.Fprintf(w, "\n%d files imported into %d directories, %s saved (%s to %s)\n",
+			len(result.Files), len(result.DateDirs), formatBytes(result.BytesBefore-result.BytesAfter),
+			formatBytes(result.BytesBefore), formatBytes(result.BytesAfter))
+		return err
+	default:
+		return fmt.Errorf("unknown output format: %s (expected text or json)", format)
+	}
+}
+
+// formatBytes returns a byte count in human-readable units (e.g. "1.5 MiB").
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit && n > -unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	value := float64(n)
+	for _, suffix := range []string{"KiB", "MiB", "GiB"} {
+		value /= unit
+		if value < unit && value > -unit {
+			return fmt.Sprintf("%.1f %s", value, suffix)
+		}
+	}
+	return fmt.Sprintf("%.1f TiB", value/unit)
+}
+
+// orDash returns value, or "-" when it is empty.
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
 }
 
 func runRename(cmd *cobra.Command, args []string) {
@@ -139,6 +678,32 @@ func runRename(cmd *cobra.Command, args []string) {
 	logger.Info("Rename completed successfully")
 }
 
+func runGallery(cmd *cobra.Command, args []string) {
+	libraryDir := args[0]
+	outDir := args[1]
+
+	// Validate library directory exists
+	if info, err := os.Stat(libraryDir); err != nil {
+		logger.Error("Library directory does not exist", "directory", libraryDir, "error", err)
+		os.Exit(1)
+	} else if !info.IsDir() {
+		logger.Error("Library path is not a directory", "path", libraryDir)
+		os.Exit(1)
+	}
+
+	ctx, stop := interruptContext()
+	defer stop()
+
+	logger.Info("Starting gallery", "library", libraryDir, "out", outDir)
+	summary, err := pics.NewGalleryGeneratorWithPath(heicConverter).Generate(ctx, libraryDir, outDir, nil)
+	if err != nil {
+		logger.Error("Gallery failed", "error", err)
+		os.Exit(1)
+	}
+
+	logger.Info("Gallery completed successfully", "index", summary.Index, "days", summary.Days, "images", summary.Images, "videos", summary.Videos)
+}
+
 func runBackup(cmd *cobra.Command, args []string) {
 	sourceDir := args[0]
 	bucket := args[1]
@@ -1,7 +1,15 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/acm19/pics/internal/pics"
 )
 
 func TestParseYearMonth(t *testing.T) {
@@ -132,3 +140,345 @@ func TestParseYearMonth(t *testing.T) {
 		})
 	}
 }
+
+func TestPrintPlan(t *testing.T) {
+	plan := &pics.ParsePlan{
+		SourceDir: "/source",
+		TargetDir: "/target",
+		Files: []pics.PlannedFile{
+			{
+				Source:      "/source/trip/IMG_0001.JPG",
+				StagingName: "trip-IMG_0001.JPG",
+				Date:        time.Date(2023, 6, 15, 10, 30, 0, 0, time.UTC),
+				Extractor:   "EXIF",
+				DateDir:     "2023 06 June 15",
+				FinalPath:   "2023 06 June 15/2023_06_June_15_00001.jpg",
+			},
+		},
+	}
+
+	t.Run("text", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := printPlan(&buf, plan, "text"); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if !strings.Contains(buf.String(), "2023 06 June 15/2023_06_June_15_00001.jpg") {
+			t.Errorf("Expected final path in output, got: %s", buf.String())
+		}
+	})
+
+	t.Run("json", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := printPlan(&buf, plan, "json"); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		var decoded pics.ParsePlan
+		if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+			t.Fatalf("Expected valid JSON, got: %v", err)
+		}
+		if len(decoded.Files) != 1 || decoded.Files[0].Extractor != "EXIF" {
+			t.Errorf("Unexpected decoded plan: %+v", decoded)
+		}
+	})
+
+	t.Run("unknown format", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := printPlan(&buf, plan, "xml"); err == nil {
+			t.Error("Expected error for unknown format")
+		}
+	})
+}
+
+func TestPrintReconciliation(t *testing.T) {
+	reconciliation := &pics.Reconciliation{
+		SourceFiles:   3,
+		ImportedFiles: 1,
+		Missing:       []pics.ReconciledFile{{Source: "trip/IMG_0002.JPG", Reason: "not imported"}},
+		Extra:         []pics.ReconciledFile{{Target: "2023 06 June 15/stray.jpg", Reason: "not produced by any source file"}},
+	}
+
+	t.Run("text", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := printReconciliation(&buf, reconciliation, "text"); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		output := buf.String()
+		for _, expected := range []string{"trip/IMG_0002.JPG", "2023 06 June 15/stray.jpg", "1 of 3 source files imported: 1 missing, 0 duplicated, 1 extra"} {
+			if !strings.Contains(output, expected) {
+				t.Errorf("Expected %q in output, got: %s", expected, output)
+			}
+		}
+	})
+
+	t.Run("json", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := printReconciliation(&buf, reconciliation, "json"); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		var decoded pics.Reconciliation
+		if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+			t.Fatalf("Expected valid JSON, got: %v", err)
+		}
+		if len(decoded.Missing) != 1 || decoded.Missing[0].Source != "trip/IMG_0002.JPG" {
+			t.Errorf("Unexpected decoded reconciliation: %+v", decoded)
+		}
+	})
+
+	t.Run("unknown format", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := printReconciliation(&buf, reconciliation, "xml"); err == nil {
+			t.Error("Expected error for unknown format")
+		}
+	})
+}
+
+func TestErrorPolicy(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected pics.ErrorPolicy
+		wantErr  bool
+	}{
+		{"fail-fast", pics.ErrorPolicyFailFast, false},
+		{"continue", pics.ErrorPolicyContinue, false},
+		{"skip", "", true},
+		{"", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			policy, err := errorPolicy(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("errorPolicy(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if policy != tt.expected {
+				t.Errorf("errorPolicy(%q) = %q, want %q", tt.value, policy, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSourceMode(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected pics.SourceMode
+		wantErr  bool
+	}{
+		{"directory", pics.SourceModeDirectory, false},
+		{"takeout", pics.SourceModeTakeout, false},
+		{"icloud", pics.SourceModeICloud, false},
+		{"photos", "", true},
+		{"", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			mode, err := sourceMode(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("sourceMode(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if mode != tt.expected {
+				t.Errorf("sourceMode(%q) = %q, want %q", tt.value, mode, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCompressorBackend(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected pics.CompressorBackend
+		wantErr  bool
+	}{
+		{"jpegoptim", pics.CompressorJpegoptim, false},
+		{"go", pics.CompressorGo, false},
+		{"mozjpeg", "", true},
+		{"", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			backend, err := compressorBackend(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("compressorBackend(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if backend != tt.expected {
+				t.Errorf("compressorBackend(%q) = %q, want %q", tt.value, backend, tt.expected)
+			}
+		})
+	}
+}
+
+func TestVideoTranscodePreset(t *testing.T) {
+	tests := []struct {
+		value   string
+		wantErr bool
+	}{
+		{"archive", false},
+		{"balanced", false},
+		{"small", false},
+		{"tiny", true},
+		{"", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			preset, err := videoTranscodePreset(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("videoTranscodePreset(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if !tt.wantErr && preset.Codec == "" {
+				t.Errorf("videoTranscodePreset(%q) returned a preset without a codec", tt.value)
+			}
+		})
+	}
+}
+
+func TestLibraryTimezone(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected string
+		wantErr  bool
+	}{
+		{"Europe/Madrid", "Europe/Madrid", false},
+		{"UTC", "UTC", false},
+		{"", "", false},
+		{"Mars/Olympus_Mons", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			loc, err := libraryTimezone(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("libraryTimezone(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			name := ""
+			if loc != nil {
+				name = loc.String()
+			}
+			if name != tt.expected {
+				t.Errorf("libraryTimezone(%q) = %q, want %q", tt.value, name, tt.expected)
+			}
+		})
+	}
+}
+
+func TestExtensionsRegistry(t *testing.T) {
+	configDir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", configDir)
+	t.Setenv("HOME", configDir)
+	t.Setenv("AppData", configDir)
+
+	// No config file keeps the built-in registry
+	ext, err := extensionsRegistry("")
+	if err != nil || ext != nil {
+		t.Fatalf("extensionsRegistry(\"\") = %v, %v, want nil, nil", ext, err)
+	}
+
+	// A missing config file given explicitly is an error
+	if _, err := extensionsRegistry(filepath.Join(configDir, "missing.json")); err == nil {
+		t.Error("Expected an error for a missing config file")
+	}
+
+	// The default config file is used when it exists
+	defaultPath, err := pics.DefaultExtensionsConfigPath()
+	if err != nil {
+		t.Fatalf("DefaultExtensionsConfigPath failed: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(defaultPath), 0755); err != nil {
+		t.Fatalf("Failed to create config directory: %v", err)
+	}
+	if err := os.WriteFile(defaultPath, []byte(`{"media": [{"class": "image", "extensions": [".png"]}]}`), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	ext, err = extensionsRegistry("")
+	if err != nil {
+		t.Fatalf("extensionsRegistry failed: %v", err)
+	}
+	if ext == nil || !ext.IsImage("photo.png") {
+		t.Error("Expected PNG to be an image")
+	}
+}
+
+func TestPrintResult(t *testing.T) {
+	result := &pics.ParseResult{
+		SourceDir: "/source",
+		TargetDir: "/target",
+		Files: []pics.ImportedFile{
+			{Source: "/source/IMG_0001.JPG", FinalPath: "2023 06 June 15/2023_06_June_15_00001.jpg", Class: pics.ClassImage, Extractor: "EXIF", Compressed: true, BytesBefore: 3 << 20, BytesAfter: 1 << 20},
+			{Source: "/source/VID_0001.MOV", FinalPath: "2023 06 June 15/videos/2023_06_June_15_00001.mov", Class: pics.ClassVideo, Extractor: "EXIF", BytesBefore: 2048, BytesAfter: 2048},
+		},
+		FilesByClass: map[pics.MediaClass]int{pics.ClassImage: 1, pics.ClassVideo: 1},
+		BytesBefore:  3<<20 + 2048,
+		BytesAfter:   1<<20 + 2048,
+		DateDirs:     []pics.DateDirSummary{{Name: "2023 06 June 15", Created: true, Files: 2}},
+		Stages:       []pics.StageTiming{{Stage: "copying", Duration: 1500 * time.Millisecond}},
+	}
+
+	t.Run("text", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := printResult(&buf, result, "text"); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		output := buf.String()
+		for _, expected := range []string{"3.0 MiB", "2023 06 June 15", "created", "1.5s", "2 files imported into 1 directories, 2.0 MiB saved"} {
+			if !strings.Contains(output, expected) {
+				t.Errorf("Expected %q in output, got: %s", expected, output)
+			}
+		}
+	})
+
+	t.Run("text with failures", func(t *testing.T) {
+		partial := *result
+		partial.Failures = []pics.FileFailure{{File: "/source/IMG_0002.JPG", Stage: "compressing", Cause: "exit status 1", QuarantinedAs: "/target/_quarantine/source-IMG_0002.JPG"}}
+		var buf bytes.Buffer
+		if err := printResult(&buf, &partial, "text"); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		output := buf.String()
+		for _, expected := range []string{"FAILED", "/source/IMG_0002.JPG", "compressing", "exit status 1", "_quarantine/source-IMG_0002.JPG"} {
+			if !strings.Contains(output, expected) {
+				t.Errorf("Expected %q in output, got: %s", expected, output)
+			}
+		}
+	})
+
+	t.Run("json", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := printResult(&buf, result, "json"); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		var decoded pics.ParseResult
+		if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+			t.Fatalf("Expected valid JSON, got: %v", err)
+		}
+		if decoded.FilesByClass[pics.ClassVideo] != 1 || decoded.Stages[0].Duration != 1500*time.Millisecond {
+			t.Errorf("Unexpected decoded result: %+v", decoded)
+		}
+	})
+
+	t.Run("unknown format", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := printResult(&buf, result, "xml"); err == nil {
+			t.Error("Expected error for unknown format")
+		}
+	})
+}
+
+func TestFormatBytes(t *testing.T) {
+	tests := []struct {
+		bytes    int64
+		expected string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1536, "1.5 KiB"},
+		{5 << 30, "5.0 GiB"},
+		{-2048, "-2.0 KiB"},
+	}
+
+	for _, tt := range tests {
+		if result := formatBytes(tt.bytes); result != tt.expected {
+			t.Errorf("formatBytes(%d) = %q, expected %q", tt.bytes, result, tt.expected)
+		}
+	}
+}
@@ -2,9 +2,13 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"os"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/acm19/pics/internal/logger"
 	"github.com/acm19/pics/internal/pics"
@@ -13,16 +17,16 @@ import (
 
 // App struct
 type App struct {
-	ctx            context.Context
-	exiftoolPath   string
-	jpegoptimPath  string
-	progressChan   chan pics.ProgressEvent
+	ctx           context.Context
+	jpegoptimPath string
+	progressChan  chan pics.ProgressEvent
+	parseMu       sync.Mutex
+	cancelParse   context.CancelFunc
 }
 
 // NewApp creates a new App application struct
-func NewApp(exiftoolPath, jpegoptimPath string) *App {
+func NewApp(jpegoptimPath string) *App {
 	return &App{
-		exiftoolPath:  exiftoolPath,
 		jpegoptimPath: jpegoptimPath,
 		progressChan:  make(chan pics.ProgressEvent, 100),
 	}
@@ -32,11 +36,30 @@ func NewApp(exiftoolPath, jpegoptimPath string) *App {
 func (a *App) startup(ctx context.Context) {
 	a.ctx = ctx
 	logger.Info("Application started", "version", version)
+	a.loadExtensions()
 
 	// Start progress event listener
 	go a.listenForProgress()
 }
 
+// loadExtensions uses the extension registry config file in the user config directory, if there is one
+func (a *App) loadExtensions() {
+	configPath, err := pics.DefaultExtensionsConfigPath()
+	if err != nil {
+		return
+	}
+	if _, err := os.Stat(configPath); err != nil {
+		return
+	}
+	ext, err := pics.LoadExtensions(configPath)
+	if err != nil {
+		logger.Error("Failed to load extension registry, using the built-in one", "error", err)
+		return
+	}
+	pics.SetDefaultExtensions(ext)
+	logger.Info("Loaded extension registry", "path", configPath)
+}
+
 // domReady is called after the front-end dom has been loaded
 func (a *App) domReady(ctx context.Context) {
 	logger.Debug("DOM ready")
@@ -67,36 +90,108 @@ type ParseOptions struct {
 	TargetDir      string `json:"targetDir"`
 	CompressJPEGs  bool   `json:"compressJPEGs"`
 	JPEGQuality    int    `json:"jpegQuality"`
+	Compressor     string `json:"compressor"`
+	MaxLongEdge    int    `json:"maxLongEdge"`
 	MaxConcurrency int    `json:"maxConcurrency"`
+	ErrorPolicy    string `json:"errorPolicy"`
+	MoveSource     bool   `json:"moveSource"`
+	SourceMode     string `json:"sourceMode"`
+	FixExtensions  bool   `json:"fixExtensions"`
+	ConvertHEIC    bool   `json:"convertHEIC"`
+	KeepHEIC       bool   `json:"keepHEIC"`
+	CompressVideos bool   `json:"compressVideos"`
+	VideoPreset    string `json:"videoPreset"`
+	Thumbnails     bool   `json:"thumbnails"`
+	Timezone       string `json:"timezone"`
 }
 
 // Parse processes media files from source to target directory
-func (a *App) Parse(opts ParseOptions) error {
+func (a *App) Parse(opts ParseOptions) (*pics.ParseResult, error) {
 	logger.Info("Starting parse operation", "source", opts.SourceDir, "target", opts.TargetDir)
 
-	// Create file organiser with custom binary paths
-	organiser := pics.NewFileOrganiserWithPaths(a.exiftoolPath)
+	// Dates and Live Photo identifiers are read natively, exiftool from PATH is only a
+	// fallback when installed
+	organiser := pics.NewFileOrganiser()
 
 	// Create media parser with custom binary paths
-	parser := pics.NewMediaParserWithPaths(a.jpegoptimPath, organiser)
+	parser := pics.NewMediaParserWithPaths(a.jpegoptimPath, "", "", organiser)
+
+	preset := pics.VideoPreset{}
+	if opts.CompressVideos {
+		var err error
+		if preset, err = pics.LookupVideoPreset(opts.VideoPreset); err != nil {
+			return nil, err
+		}
+	}
+
+	// An empty timezone uses the system one
+	var loc *time.Location
+	if opts.Timezone != "" {
+		var err error
+		if loc, err = time.LoadLocation(opts.Timezone); err != nil {
+			return nil, fmt.Errorf("invalid timezone: %w", err)
+		}
+	}
 
 	// Create parse options with progress channel
 	parseOpts := pics.ParseOptions{
 		CompressJPEGs:  opts.CompressJPEGs,
 		JPEGQuality:    opts.JPEGQuality,
+		Compressor:     pics.CompressorBackend(opts.Compressor),
+		MaxLongEdge:    opts.MaxLongEdge,
 		MaxConcurrency: opts.MaxConcurrency,
+		ErrorPolicy:    pics.ErrorPolicy(opts.ErrorPolicy),
+		MoveSource:     opts.MoveSource,
+		SourceMode:     pics.SourceMode(opts.SourceMode),
+		FixExtensions:  opts.FixExtensions,
+		ConvertHEIC:    opts.ConvertHEIC,
+		KeepHEIC:       opts.KeepHEIC,
+		CompressVideos: opts.CompressVideos,
+		VideoPreset:    preset,
+		Thumbnails:     opts.Thumbnails,
+		Timezone:       loc,
 		TempDirName:    ".pics-temp",
 		ProgressChan:   a.progressChan,
 	}
 
+	// Make the parse cancellable from the frontend
+	ctx, cancel := context.WithCancel(a.ctx)
+	a.parseMu.Lock()
+	a.cancelParse = cancel
+	a.parseMu.Unlock()
+	defer func() {
+		a.parseMu.Lock()
+		a.cancelParse = nil
+		a.parseMu.Unlock()
+		cancel()
+	}()
+
 	// Execute parse
-	if err := parser.Parse(opts.SourceDir, opts.TargetDir, parseOpts); err != nil {
+	result, err := parser.ParseContext(ctx, opts.SourceDir, opts.TargetDir, parseOpts)
+	var partialErr *pics.PartialParseError
+	if errors.As(err, &partialErr) {
+		// The files that did import are in the result, the frontend lists the failures
+		logger.Warn("Parse operation completed with failures", "files", len(result.Files), "failed", len(result.Failures), "report", partialErr.ReportPath)
+		return result, nil
+	}
+	if err != nil {
 		logger.Error("Parse operation failed", "error", err)
-		return err
+		return nil, err
 	}
 
-	logger.Info("Parse operation completed successfully")
-	return nil
+	logger.Info("Parse operation completed successfully", "files", len(result.Files))
+	return result, nil
+}
+
+// CancelParse cancels the running Parse operation, rolling back its changes
+func (a *App) CancelParse() {
+	a.parseMu.Lock()
+	defer a.parseMu.Unlock()
+
+	if a.cancelParse != nil {
+		logger.Info("Cancelling parse operation")
+		a.cancelParse()
+	}
 }
 
 // BackupOptions holds options for the Backup operation
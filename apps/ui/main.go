@@ -17,13 +17,13 @@ var version = "dev"
 
 func main() {
 	// Extract embedded binaries on startup
-	exiftoolPath, jpegoptimPath, err := ExtractBinaries()
+	jpegoptimPath, err := ExtractBinaries()
 	if err != nil {
 		log.Fatalf("Failed to extract binaries: %v", err)
 	}
 
 	// Create application instance with extracted binary paths
-	app := NewApp(exiftoolPath, jpegoptimPath)
+	app := NewApp(jpegoptimPath)
 
 	// Create application with options
 	err = wails.Run(&options.App{
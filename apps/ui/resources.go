@@ -9,23 +9,18 @@ import (
 	"runtime"
 )
 
-//go:embed build/resources/windows/exiftool.exe
 //go:embed build/resources/windows/jpegoptim.exe
-//go:embed build/resources/darwin/exiftool
 //go:embed build/resources/darwin/jpegoptim
-//go:embed build/resources/darwin/lib
-//go:embed build/resources/linux/exiftool
 //go:embed build/resources/linux/jpegoptim
-//go:embed build/resources/linux/lib
 var resources embed.FS
 
-// ExtractBinaries extracts the platform-specific exiftool and jpegoptim binaries
-// to a temporary directory and returns their paths.
-func ExtractBinaries() (exiftoolPath, jpegoptimPath string, err error) {
+// ExtractBinaries extracts the platform-specific jpegoptim binary to a temporary
+// directory and returns its path. Dates are read natively, so exiftool is not embedded.
+func ExtractBinaries() (jpegoptimPath string, err error) {
 	// Create temp directory for extracted binaries
 	tempDir := filepath.Join(os.TempDir(), "pics-ui-tools")
 	if err := os.MkdirAll(tempDir, 0755); err != nil {
-		return "", "", fmt.Errorf("failed to create temp directory: %w", err)
+		return "", fmt.Errorf("failed to create temp directory: %w", err)
 	}
 
 	// Determine platform
@@ -35,42 +30,21 @@ func ExtractBinaries() (exiftoolPath, jpegoptimPath string, err error) {
 		ext = ".exe"
 	}
 
-	// Extract exiftool
-	exiftoolSrc := fmt.Sprintf("build/resources/%s/exiftool%s", platform, ext)
-	exiftoolPath = filepath.Join(tempDir, "exiftool"+ext)
-	if err := extractFile(exiftoolSrc, exiftoolPath); err != nil {
-		return "", "", fmt.Errorf("failed to extract exiftool: %w", err)
-	}
-
-	// Make executable on Unix systems
-	if platform != "windows" {
-		if err := os.Chmod(exiftoolPath, 0755); err != nil {
-			return "", "", fmt.Errorf("failed to make exiftool executable: %w", err)
-		}
-
-		// Extract lib directory for exiftool
-		libSrc := fmt.Sprintf("build/resources/%s/lib", platform)
-		libDest := filepath.Join(tempDir, "lib")
-		if err := extractDir(libSrc, libDest); err != nil {
-			return "", "", fmt.Errorf("failed to extract exiftool lib: %w", err)
-		}
-	}
-
 	// Extract jpegoptim
 	jpegoptimSrc := fmt.Sprintf("build/resources/%s/jpegoptim%s", platform, ext)
 	jpegoptimPath = filepath.Join(tempDir, "jpegoptim"+ext)
 	if err := extractFile(jpegoptimSrc, jpegoptimPath); err != nil {
-		return "", "", fmt.Errorf("failed to extract jpegoptim: %w", err)
+		return "", fmt.Errorf("failed to extract jpegoptim: %w", err)
 	}
 
 	// Make executable on Unix systems
 	if platform != "windows" {
 		if err := os.Chmod(jpegoptimPath, 0755); err != nil {
-			return "", "", fmt.Errorf("failed to make jpegoptim executable: %w", err)
+			return "", fmt.Errorf("failed to make jpegoptim executable: %w", err)
 		}
 	}
 
-	return exiftoolPath, jpegoptimPath, nil
+	return jpegoptimPath, nil
 }
 
 // extractFile extracts a single file from the embedded filesystem to the destination path.
@@ -94,32 +68,3 @@ func extractFile(src, dst string) error {
 
 	return nil
 }
-
-// extractDir recursively extracts a directory from the embedded filesystem.
-func extractDir(src, dst string) error {
-	entries, err := resources.ReadDir(src)
-	if err != nil {
-		return fmt.Errorf("failed to read embedded dir %s: %w", src, err)
-	}
-
-	if err := os.MkdirAll(dst, 0755); err != nil {
-		return fmt.Errorf("failed to create dir %s: %w", dst, err)
-	}
-
-	for _, entry := range entries {
-		srcPath := filepath.Join(src, entry.Name())
-		dstPath := filepath.Join(dst, entry.Name())
-
-		if entry.IsDir() {
-			if err := extractDir(srcPath, dstPath); err != nil {
-				return err
-			}
-		} else {
-			if err := extractFile(srcPath, dstPath); err != nil {
-				return err
-			}
-		}
-	}
-
-	return nil
-}
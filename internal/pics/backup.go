@@ -139,9 +139,10 @@ func (b *s3Backup) BackupDirectories(ctx context.Context, sourceDir, bucket stri
 		return fmt.Errorf("failed to read source directory: %w", err)
 	}
 
+	// The journal and the quarantine are left out, they are not date directories
 	var directories []string
 	for _, entry := range entries {
-		if entry.IsDir() {
+		if entry.IsDir() && !isInternalDir(entry.Name()) {
 			directories = append(directories, entry.Name())
 		}
 	}
@@ -198,12 +199,13 @@ func (b *s3Backup) BackupDirectories(ctx context.Context, sourceDir, bucket stri
 	return nil
 }
 
-// countMediaFiles counts images and videos in a directory
-func (b *s3Backup) countMediaFiles(dirPath string) (images int, videos int, err error) {
-	// Count images
+// countMediaFiles counts images, RAW files and videos in a directory. The motion clips of
+// Live Photos, kept next to their still, count as videos and screenshots count as images.
+func (b *s3Backup) countMediaFiles(dirPath string) (images int, raws int, videos int, err error) {
+	// Count images and motion clips
 	entries, err := os.ReadDir(dirPath)
 	if err != nil {
-		return 0, 0, err
+		return 0, 0, 0, err
 	}
 
 	for _, entry := range entries {
@@ -213,29 +215,59 @@ func (b *s3Backup) countMediaFiles(dirPath string) (images int, videos int, err
 		filePath := filepath.Join(dirPath, entry.Name())
 		if b.extensions.IsImage(filePath) {
 			images++
+		} else if b.extensions.IsVideo(filePath) {
+			videos++
 		}
 	}
 
+	// Count RAW files in raw subdirectory
+	raws, err = b.countSubdirFiles(filepath.Join(dirPath, b.extensions.Subdir(ClassRaw)), b.extensions.IsRaw)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
 	// Count videos in videos subdirectory
-	videosDir := filepath.Join(dirPath, "videos")
-	if info, err := os.Stat(videosDir); err == nil && info.IsDir() {
-		videoEntries, err := os.ReadDir(videosDir)
-		if err != nil {
-			return 0, 0, err
-		}
+	subdirVideos, err := b.countSubdirFiles(filepath.Join(dirPath, b.extensions.Subdir(ClassVideo)), b.extensions.IsVideo)
+	if err != nil {
+		return 0, 0, 0, err
+	}
 
-		for _, entry := range videoEntries {
-			if entry.IsDir() {
-				continue
-			}
-			filePath := filepath.Join(videosDir, entry.Name())
-			if b.extensions.IsVideo(filePath) {
-				videos++
-			}
+	// Count screenshots in their subdirectory as images
+	screenshots, err := b.countSubdirFiles(filepath.Join(dirPath, b.extensions.Subdir(ClassScreenshot)), b.extensions.IsScreenshot)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	return images + screenshots, raws, videos + subdirVideos, nil
+}
+
+// countSubdirFiles counts the files in dir that match the filter, if dir exists
+func (b *s3Backup) countSubdirFiles(dir string, filter fileFilter) (int, error) {
+	info, err := os.Stat(dir)
+	if err != nil || !info.IsDir() {
+		return 0, nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, entry := range entries {
+		if !entry.IsDir() && filter(filepath.Join(dir, entry.Name())) {
+			count++
 		}
 	}
+	return count, nil
+}
 
-	return images, videos, nil
+// backupKey builds the S3 key of a directory with its media counts. RAW files are only
+// mentioned when there are some, so directories without them keep their existing key.
+func backupKey(dirName string, images, raws, videos int) string {
+	if raws > 0 {
+		return fmt.Sprintf("%s (%d images, %d raw, %d videos).tar.gz", dirName, images, raws, videos)
+	}
+	return fmt.Sprintf("%s (%d images, %d videos).tar.gz", dirName, images, videos)
 }
 
 // backupDirectory backs up a single directory to S3
@@ -243,13 +275,13 @@ func (b *s3Backup) backupDirectory(ctx context.Context, sourceDir, dirName, buck
 	dirPath := filepath.Join(sourceDir, dirName)
 
 	// Count media files
-	imageCount, videoCount, err := b.countMediaFiles(dirPath)
+	imageCount, rawCount, videoCount, err := b.countMediaFiles(dirPath)
 	if err != nil {
 		return fmt.Errorf("failed to count media files: %w", err)
 	}
 
 	// Build S3 key with counts
-	s3Key := fmt.Sprintf("%s (%d images, %d videos).tar.gz", dirName, imageCount, videoCount)
+	s3Key := backupKey(dirName, imageCount, rawCount, videoCount)
 
 	// Create temporary directory
 	tmpDir, cleanup, err := createTempDir(tempDirPrefix)
@@ -259,7 +291,7 @@ func (b *s3Backup) backupDirectory(ctx context.Context, sourceDir, dirName, buck
 	defer cleanup()
 
 	archivePath := filepath.Join(tmpDir, filepath.Base(s3Key))
-	logger.Info("Creating archive", "directory", dirName, "images", imageCount, "videos", videoCount)
+	logger.Info("Creating archive", "directory", dirName, "images", imageCount, "raw", rawCount, "videos", videoCount)
 
 	if err := b.createTarGz(dirPath, archivePath); err != nil {
 		return fmt.Errorf("failed to create tar.gz: %w", err)
@@ -531,7 +563,7 @@ func (b *s3Backup) RestoreDirectories(ctx context.Context, bucket, targetDir str
 
 // restoreObject downloads and extracts a single object from S3
 func (b *s3Backup) restoreObject(ctx context.Context, bucket, targetDir, key string) error {
-	// Extract directory name from key (remove " (X images, Y videos).tar.gz" suffix)
+	// Extract directory name from key (remove the " (X images, Y videos).tar.gz" suffix)
 	dirName := b.extractDirNameFromKey(key)
 	if dirName == "" {
 		return fmt.Errorf("invalid or unsafe directory name in S3 key: %s", key)
@@ -629,7 +661,7 @@ func (b *s3Backup) matchesFilter(key string, filter RestoreFilter) bool {
 func (b *s3Backup) extractDirNameFromKey(key string) string {
 	// Remove ".tar.gz" extension
 	name := strings.TrimSuffix(key, ".tar.gz")
-	// Remove " (X images, Y videos)" suffix
+	// Remove " (X images, Y videos)" or " (X images, Z raw, Y videos)" suffix
 	if idx := strings.Index(name, " ("); idx != -1 {
 		name = name[:idx]
 	}
@@ -249,6 +249,15 @@ func TestBackup_BackupDirectories(t *testing.T) {
 	}
 	createTempTestFile(t, videosDir, "video1.mov")
 
+	// The quarantine and the journal of an interrupted parse are not backed up
+	for _, name := range []string{quarantineDirName, ".pics-journal"} {
+		dir := filepath.Join(sourceDir, name)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("Failed to create %s: %v", name, err)
+		}
+		createTempTestFile(t, dir, "IMG_0001.jpg")
+	}
+
 	// Create backup with in-memory client
 	client := NewInMemoryS3Client()
 	backup := &s3Backup{
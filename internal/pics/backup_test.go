@@ -164,7 +164,9 @@ func TestS3Backup_CountMediaFiles(t *testing.T) {
 		name           string
 		files          []string
 		videoFiles     []string
+		rawFiles       []string
 		expectedImages int
+		expectedRaws   int
 		expectedVideos int
 	}{
 		{
@@ -208,6 +210,34 @@ func TestS3Backup_CountMediaFiles(t *testing.T) {
 			expectedImages: 1,
 			expectedVideos: 0,
 		},
+		{
+			name: "live photos count their motion clips as videos",
+			files: []string{
+				"2023_06_June_15_00001.heic",
+				"2023_06_June_15_00001.mov",
+				"2023_06_June_15_00002.jpg",
+			},
+			videoFiles: []string{
+				"2023_06_June_15_00001.mov",
+			},
+			expectedImages: 2,
+			expectedVideos: 2,
+		},
+		{
+			name: "raw files in the raw directory",
+			files: []string{
+				"2023_06_June_15_00001.jpg",
+				"2023_06_June_15_00002.jpg",
+			},
+			rawFiles: []string{
+				"2023_06_June_15_00001.cr2",
+				"2023_06_June_15_00003.DNG",
+				"2023_06_June_15_00003.xmp",
+			},
+			expectedImages: 2,
+			expectedRaws:   2,
+			expectedVideos: 0,
+		},
 		{
 			name: "mixed supported and unsupported files",
 			files: []string{
@@ -245,11 +275,21 @@ func TestS3Backup_CountMediaFiles(t *testing.T) {
 				}
 			}
 
+			if len(tt.rawFiles) > 0 {
+				rawDir := filepath.Join(tmpDir, "raw")
+				if err := os.MkdirAll(rawDir, 0755); err != nil {
+					t.Fatalf("Failed to create raw directory: %v", err)
+				}
+				for _, filename := range tt.rawFiles {
+					createTempTestFile(t, rawDir, filename)
+				}
+			}
+
 			backup := &s3Backup{
 				extensions: NewExtensions(),
 			}
 
-			images, videos, err := backup.countMediaFiles(tmpDir)
+			images, raws, videos, err := backup.countMediaFiles(tmpDir)
 
 			if err != nil {
 				t.Errorf("Expected no error, got: %v", err)
@@ -259,6 +299,10 @@ func TestS3Backup_CountMediaFiles(t *testing.T) {
 				t.Errorf("Expected %d images, got %d", tt.expectedImages, images)
 			}
 
+			if raws != tt.expectedRaws {
+				t.Errorf("Expected %d raw files, got %d", tt.expectedRaws, raws)
+			}
+
 			if videos != tt.expectedVideos {
 				t.Errorf("Expected %d videos, got %d", tt.expectedVideos, videos)
 			}
@@ -266,6 +310,39 @@ func TestS3Backup_CountMediaFiles(t *testing.T) {
 	}
 }
 
+func TestS3Backup_TarGz_KeepsLivePhotosTogether(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir := filepath.Join(tmpDir, "2023 06 June 15")
+	if err := os.MkdirAll(filepath.Join(sourceDir, "videos"), 0755); err != nil {
+		t.Fatalf("Failed to create source directory: %v", err)
+	}
+	files := []string{
+		"2023_06_June_15_00001.heic",
+		"2023_06_June_15_00001.mov",
+		filepath.Join("videos", "2023_06_June_15_00001.mov"),
+	}
+	for _, name := range files {
+		createTempTestFile(t, sourceDir, name)
+	}
+
+	backup := &s3Backup{extensions: NewExtensions()}
+	archivePath := filepath.Join(tmpDir, "backup.tar.gz")
+	if err := backup.createTarGz(sourceDir, archivePath); err != nil {
+		t.Fatalf("Failed to create archive: %v", err)
+	}
+	restoreDir := filepath.Join(tmpDir, "restored")
+	if err := backup.extractTarGz(archivePath, restoreDir); err != nil {
+		t.Fatalf("Failed to extract archive: %v", err)
+	}
+
+	// The archive holds the directory itself
+	for _, name := range files {
+		if _, err := os.Stat(filepath.Join(restoreDir, "2023 06 June 15", name)); err != nil {
+			t.Errorf("Expected %s to be restored next to its pair", name)
+		}
+	}
+}
+
 func TestS3Backup_MatchesFilter(t *testing.T) {
 	backup := &s3Backup{}
 
@@ -354,6 +431,11 @@ func TestS3Backup_ExtractDirNameFromKey(t *testing.T) {
 			key:      "2023 06 June 15 vacation (10 images, 5 videos).tar.gz",
 			expected: "2023 06 June 15 vacation",
 		},
+		{
+			name:     "with raw counts suffix",
+			key:      "2023 06 June 15 vacation (10 images, 4 raw, 5 videos).tar.gz",
+			expected: "2023 06 June 15 vacation",
+		},
 		{
 			name:     "without counts suffix",
 			key:      "2023 06 June 15 vacation.tar.gz",
@@ -376,6 +458,33 @@ func TestS3Backup_ExtractDirNameFromKey(t *testing.T) {
 	}
 }
 
+func TestBackupKey(t *testing.T) {
+	tests := []struct {
+		name     string
+		raws     int
+		expected string
+	}{
+		{
+			name:     "without raw files",
+			raws:     0,
+			expected: "2023 06 June 15 (10 images, 5 videos).tar.gz",
+		},
+		{
+			name:     "with raw files",
+			raws:     4,
+			expected: "2023 06 June 15 (10 images, 4 raw, 5 videos).tar.gz",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if key := backupKey("2023 06 June 15", 10, tt.raws, 5); key != tt.expected {
+				t.Errorf("backupKey() = %q, expected %q", key, tt.expected)
+			}
+		})
+	}
+}
+
 func TestIsNotFoundError(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -0,0 +1,229 @@
+package pics
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// companionFile is a file considered when grouping a media file with its companions
+type companionFile struct {
+	// name is used to pair files by base name
+	name string
+	// path is used to read the ContentIdentifier of the file
+	path string
+}
+
+// companionFinder groups every primary media file with the files that travel with
+// it: the motion clip of a Live Photo, the RAW file shot along with a JPEG, the HEIC
+// original a JPEG was converted from and their sidecars
+type companionFinder struct {
+	extensions Extensions
+	livePhotos *livePhotoMatcher
+	// conversions returns the current path of every converted JPEG mapped to the HEIC
+	// original kept next to it, nil to pair them by base name
+	conversions func() map[string]string
+}
+
+func newCompanionFinder(exiftoolPath string) *companionFinder {
+	return &companionFinder{
+		extensions: NewExtensions(),
+		livePhotos: newLivePhotoMatcher(exiftoolPath),
+	}
+}
+
+// withExiftoolPool returns a copy of the finder that reads ContentIdentifiers through pool
+func (f *companionFinder) withExiftoolPool(pool *exiftoolPool) *companionFinder {
+	livePhotos := *f.livePhotos
+	if native, isNative := livePhotos.identifiers.(nativeContentIdentifierReader); isNative {
+		if _, isExiftool := native.fallback.(exiftoolContentIdentifierReader); isExiftool {
+			livePhotos.identifiers = nativeContentIdentifierReader{fallback: exiftoolContentIdentifierReader{pool: pool}}
+		}
+	}
+	finder := *f
+	finder.livePhotos = &livePhotos
+	return &finder
+}
+
+// withConversions returns a copy of the finder that pairs converted JPEG files only with
+// the HEIC originals conversions returns for them
+func (f *companionFinder) withConversions(conversions func() map[string]string) *companionFinder {
+	finder := *f
+	finder.conversions = conversions
+	return &finder
+}
+
+// find returns the companions of every primary file among files, whose names must
+// be unique, in the order they have to be renamed. Live Photos are paired by
+// ContentIdentifier too when byContentIdentifier is set.
+func (f *companionFinder) find(files []companionFile, byContentIdentifier bool) map[string][]string {
+	names := make([]string, len(files))
+	for i, file := range files {
+		names[i] = file.name
+	}
+	primaries := sidecarPrimaries(names, f.extensions)
+	sidecars := make(map[string][]string)
+	for _, name := range names {
+		if primary, found := primaries[name]; found {
+			sidecars[primary] = append(sidecars[primary], name)
+		}
+	}
+
+	pairs := make(map[string][]string)
+	for still, clip := range f.livePhotos.match(files, byContentIdentifier) {
+		pairs[still] = append(pairs[still], clip)
+	}
+	for image, raw := range rawPairs(names, f.extensions) {
+		pairs[image] = append(pairs[image], raw)
+	}
+	// An original kept alongside its converted JPEG follows the JPEG, and so do its own companions
+	var conversions map[string]string
+	if f.conversions != nil {
+		conversions = f.conversions()
+	}
+	for jpeg, heic := range convertedPairs(files, f.extensions, conversions) {
+		pairs[jpeg] = append(append(pairs[jpeg], heic), pairs[heic]...)
+		delete(pairs, heic)
+	}
+
+	companions := make(map[string][]string)
+	for primary, paired := range pairs {
+		companions[primary] = append(paired, sidecars[primary]...)
+		for _, name := range paired {
+			companions[primary] = append(companions[primary], sidecars[name]...)
+			delete(sidecars, name)
+		}
+		delete(sidecars, primary)
+	}
+	for primary, names := range sidecars {
+		companions[primary] = names
+	}
+	return companions
+}
+
+// rawPairs returns the RAW file shot along with each image among names (all in the
+// same directory), paired by base name (IMG_1234.JPG and IMG_1234.CR2)
+func rawPairs(names []string, extensions Extensions) map[string]string {
+	images := make(map[string]string)
+	for _, name := range slices.Sorted(slices.Values(names)) {
+		stem := livePhotoStem(name)
+		if _, taken := images[stem]; extensions.IsImage(name) && !taken {
+			images[stem] = name
+		}
+	}
+
+	pairs := make(map[string]string)
+	for _, name := range slices.Sorted(slices.Values(names)) {
+		if !extensions.IsRaw(name) {
+			continue
+		}
+		image, found := images[livePhotoStem(name)]
+		if _, taken := pairs[image]; found && !taken {
+			pairs[image] = name
+		}
+	}
+	return pairs
+}
+
+// findInDir returns the companions of every primary file directly inside dir
+func (f *companionFinder) findInDir(dir string, byContentIdentifier bool) (map[string][]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []companionFile
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			files = append(files, companionFile{name: entry.Name(), path: filepath.Join(dir, entry.Name())})
+		}
+	}
+	return f.find(files, byContentIdentifier), nil
+}
+
+// sidecarPrimaries returns the media file each sidecar among names (all in the same
+// directory) describes. A sidecar belongs to the media file it is named after, with
+// its extension (IMG_1234.JPG.xmp, or IMG_1234.JPG.json from Google Takeout) or
+// without it (IMG_1234.xmp), preferring images when several media files share a base name.
+func sidecarPrimaries(names []string, extensions Extensions) map[string]string {
+	media := make(map[string]string)
+	byStem := make(map[string][]string)
+	for _, name := range names {
+		if extensions.IsSupported(name) {
+			media[strings.ToLower(name)] = name
+			stem := livePhotoStem(name)
+			byStem[stem] = append(byStem[stem], name)
+		}
+	}
+	for _, candidates := range byStem {
+		sort.Slice(candidates, func(i, j int) bool {
+			if extensions.IsImage(candidates[i]) != extensions.IsImage(candidates[j]) {
+				return extensions.IsImage(candidates[i])
+			}
+			return candidates[i] < candidates[j]
+		})
+	}
+
+	primaries := make(map[string]string)
+	for _, name := range names {
+		if !extensions.IsSidecar(name) {
+			continue
+		}
+		base := strings.TrimSuffix(name, filepath.Ext(name))
+		if strings.EqualFold(filepath.Ext(name), ".json") {
+			base = takeoutMediaName(name)
+		}
+		if primary, found := media[strings.ToLower(base)]; found {
+			primaries[name] = primary
+		} else if candidates := byStem[strings.ToLower(base)]; len(candidates) > 0 {
+			primaries[name] = candidates[0]
+		}
+	}
+	return primaries
+}
+
+// companionNames returns the names the companions of a file get when it is renamed
+// from oldName to newName. A companion named after the full name of the file or of
+// an earlier companion (IMG_1234.MOV.json) keeps that form, any other one gets the
+// new base name with its own extension.
+func companionNames(oldName, newName string, companions []string) map[string]string {
+	renamed := map[string]string{oldName: newName}
+	stem := strings.TrimSuffix(newName, filepath.Ext(newName))
+	names := make(map[string]string, len(companions))
+	for _, companion := range companions {
+		ext := strings.ToLower(filepath.Ext(companion))
+		name := stem + ext
+		longest := 0
+		for from, to := range renamed {
+			if len(from) > longest && strings.HasPrefix(strings.ToLower(companion), strings.ToLower(from)+".") {
+				name, longest = to+ext, len(from)
+			}
+		}
+		names[companion] = name
+		renamed[companion] = name
+	}
+	return names
+}
+
+// companionPaths turns the companion names found in dir into paths, as used by FileRenamer
+func companionPaths(dir string, companions map[string][]string) map[string][]string {
+	paths := make(map[string][]string, len(companions))
+	for primary, names := range companions {
+		for _, name := range names {
+			paths[filepath.Join(dir, primary)] = append(paths[filepath.Join(dir, primary)], filepath.Join(dir, name))
+		}
+	}
+	return paths
+}
+
+// companionSet returns the set of files that are companions of another one
+func companionSet(companions map[string][]string) map[string]bool {
+	set := make(map[string]bool)
+	for _, names := range companions {
+		for _, name := range names {
+			set[name] = true
+		}
+	}
+	return set
+}
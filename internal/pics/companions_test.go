@@ -0,0 +1,125 @@
+package pics
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSidecarPrimaries(t *testing.T) {
+	names := []string{
+		"IMG_0001.HEIC", "IMG_0001.MOV", "IMG_0001.AAE",
+		"IMG_0002.JPG", "IMG_0002.JPG.xmp", "IMG_0002.JPG.supplemental-metadata.json",
+		"clip.mp4", "clip.THM", "clip.mp4.json",
+		"metadata.json", "orphan.xmp", "notes.txt",
+	}
+
+	primaries := sidecarPrimaries(names, NewExtensions())
+
+	expected := map[string]string{
+		"IMG_0001.AAE":     "IMG_0001.HEIC",
+		"IMG_0002.JPG.xmp": "IMG_0002.JPG",
+		"IMG_0002.JPG.supplemental-metadata.json": "IMG_0002.JPG",
+		"clip.THM":      "clip.mp4",
+		"clip.mp4.json": "clip.mp4",
+	}
+	if !reflect.DeepEqual(primaries, expected) {
+		t.Errorf("Expected %v, got %v", expected, primaries)
+	}
+}
+
+func TestCompanionNames(t *testing.T) {
+	names := companionNames("IMG_0001.HEIC", "2023_06_June_15_00012.heic", []string{"other.MOV", "IMG_0001.XMP", "other.MOV.json", "IMG_0001.HEIC.json"})
+
+	expected := map[string]string{
+		"other.MOV":          "2023_06_June_15_00012.mov",
+		"IMG_0001.XMP":       "2023_06_June_15_00012.xmp",
+		"other.MOV.json":     "2023_06_June_15_00012.mov.json",
+		"IMG_0001.HEIC.json": "2023_06_June_15_00012.heic.json",
+	}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected %v, got %v", expected, names)
+	}
+}
+
+func TestCompanionFinder_Find(t *testing.T) {
+	finder := &companionFinder{
+		extensions: NewExtensions(),
+		livePhotos: &livePhotoMatcher{extensions: NewExtensions()},
+	}
+
+	companions := finder.find(companionFiles("IMG_0001.HEIC", "IMG_0001.MOV", "IMG_0001.AAE", "IMG_0001.MOV.json", "IMG_0002.jpg", "IMG_0002.xmp", "IMG_0003.jpg"), false)
+
+	expected := map[string][]string{
+		"IMG_0001.HEIC": {"IMG_0001.MOV", "IMG_0001.AAE", "IMG_0001.MOV.json"},
+		"IMG_0002.jpg":  {"IMG_0002.xmp"},
+	}
+	if !reflect.DeepEqual(companions, expected) {
+		t.Errorf("Expected %v, got %v", expected, companions)
+	}
+}
+
+func TestCompanionFinder_Find_RawPairs(t *testing.T) {
+	finder := &companionFinder{
+		extensions: NewExtensions(),
+		livePhotos: &livePhotoMatcher{extensions: NewExtensions()},
+	}
+
+	companions := finder.find(companionFiles("IMG_0001.JPG", "IMG_0001.CR2", "IMG_0001.CR2.xmp", "IMG_0002.HEIC", "IMG_0002.MOV", "IMG_0002.DNG", "IMG_0003.NEF", "IMG_0003.xmp"), false)
+
+	expected := map[string][]string{
+		"IMG_0001.JPG":  {"IMG_0001.CR2", "IMG_0001.CR2.xmp"},
+		"IMG_0002.HEIC": {"IMG_0002.MOV", "IMG_0002.DNG"},
+		"IMG_0003.NEF":  {"IMG_0003.xmp"},
+	}
+	if !reflect.DeepEqual(companions, expected) {
+		t.Errorf("Expected %v, got %v", expected, companions)
+	}
+}
+
+func TestMediaParser_Parse_Sidecars(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir, targetDir := createSourceAndTarget(t, tmpDir)
+	testDate := time.Date(2023, 6, 15, 10, 30, 0, 0, time.UTC)
+
+	createMediaFile(t, sourceDir, "IMG_0001.JPG", testDate)
+	// Sidecars are dated by their media file, not by their own modification time
+	createMediaFile(t, sourceDir, "IMG_0001.xmp", testDate.AddDate(1, 0, 0))
+	createMediaFile(t, sourceDir, "IMG_0002.HEIC", testDate)
+	createMediaFile(t, sourceDir, "IMG_0002.AAE", testDate)
+	createMediaFile(t, sourceDir, "MVI_0003.MP4", testDate)
+	createMediaFile(t, sourceDir, "MVI_0003.THM", testDate)
+	createMediaFile(t, sourceDir, "orphan.xmp", testDate)
+
+	plan, err := testParser.Plan(sourceDir, targetDir, testParseOptions)
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+	if _, err := testParser.Parse(sourceDir, targetDir, testParseOptions); err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	dateDir := "2023 06 June 15"
+	expected := map[string]string{
+		"IMG_0001.JPG":  filepath.Join(dateDir, "2023_06_June_15_00001.jpg"),
+		"IMG_0001.xmp":  filepath.Join(dateDir, "2023_06_June_15_00001.xmp"),
+		"IMG_0002.HEIC": filepath.Join(dateDir, "2023_06_June_15_00002.heic"),
+		"IMG_0002.AAE":  filepath.Join(dateDir, "2023_06_June_15_00002.aae"),
+		"MVI_0003.MP4":  filepath.Join(dateDir, "videos", "2023_06_June_15_00001.mp4"),
+		"MVI_0003.THM":  filepath.Join(dateDir, "videos", "2023_06_June_15_00001.thm"),
+	}
+	if len(plan.Files) != len(expected) {
+		t.Errorf("Expected %d planned files, got %+v", len(expected), plan.Files)
+	}
+	for _, file := range plan.Files {
+		name := filepath.Base(file.Source)
+		if file.FinalPath != expected[name] {
+			t.Errorf("Expected %s planned at %s, got %s", name, expected[name], file.FinalPath)
+		}
+	}
+	for _, finalPath := range expected {
+		assertMediaFileExists(t, filepath.Join(targetDir, finalPath))
+	}
+	assertMediaFileNotExists(t, filepath.Join(targetDir, "2024 06 June 15"))
+}
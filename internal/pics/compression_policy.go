@@ -0,0 +1,239 @@
+package pics
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+)
+
+// compressedMarker starts the comment written into every JPEG file pics compresses
+const compressedMarker = "pics: compressed at quality "
+
+// JPEG markers read by the compression policy
+const (
+	markerSOI = 0xD8
+	markerEOI = 0xD9
+	markerSOS = 0xDA
+	markerDQT = 0xDB
+	markerCOM = 0xFE
+)
+
+// standardLuminanceTable is the luminance quantisation table of the JPEG standard
+// (Annex K), in natural order. Encoders derived from libjpeg scale it by quality.
+var standardLuminanceTable = [64]float64{
+	16, 11, 10, 16, 24, 40, 51, 61,
+	12, 12, 14, 19, 26, 58, 60, 55,
+	14, 13, 16, 24, 40, 57, 69, 56,
+	14, 17, 22, 29, 51, 87, 80, 62,
+	18, 22, 37, 56, 68, 109, 103, 77,
+	24, 35, 55, 64, 81, 104, 113, 92,
+	49, 64, 78, 87, 103, 121, 120, 101,
+	72, 92, 95, 98, 112, 100, 103, 99,
+}
+
+// zigzagOrder maps the position of a coefficient in a DQT segment to its natural position
+var zigzagOrder = [64]int{
+	0, 1, 8, 16, 9, 2, 3, 10,
+	17, 24, 32, 25, 18, 11, 4, 5,
+	12, 19, 26, 33, 40, 48, 41, 34,
+	27, 20, 13, 6, 7, 14, 21, 28,
+	35, 42, 49, 56, 57, 50, 43, 36,
+	29, 22, 15, 23, 30, 37, 44, 51,
+	58, 59, 52, 45, 38, 31, 39, 46,
+	53, 60, 61, 54, 47, 55, 62, 63,
+}
+
+// jpegInfo is what the compression policy reads from the header of a JPEG file
+type jpegInfo struct {
+	// quality is the estimated quality of the file, 0 when it has no luminance table
+	quality int
+	// markedQuality is the quality pics compressed the file at, 0 when it did not
+	markedQuality int
+}
+
+// compressionSkip returns why a JPEG file should not be compressed at quality, or an
+// empty string when it should. Files pics already compressed at quality or below are
+// skipped, as are files whose estimated quality is at or below quality, as re-encoding
+// them only degrades them.
+func compressionSkip(path string, quality int) string {
+	info, err := readJPEGInfo(path)
+	if err != nil {
+		// Let the compressor decide what to do with files that cannot be read
+		return ""
+	}
+	switch {
+	case info.markedQuality > 0 && info.markedQuality <= quality:
+		return fmt.Sprintf("already compressed by pics at quality %d", info.markedQuality)
+	case info.quality > 0 && info.quality <= quality:
+		return fmt.Sprintf("estimated quality %d is at or below %d", info.quality, quality)
+	}
+	return ""
+}
+
+// readJPEGInfo reads the segments of a JPEG file up to its image data
+func readJPEGInfo(path string) (jpegInfo, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return jpegInfo{}, err
+	}
+	defer file.Close()
+	return jpegInfoFrom(bufio.NewReader(file))
+}
+
+// jpegInfoFrom reads the segments of a JPEG stream up to its image data
+func jpegInfoFrom(r io.Reader) (jpegInfo, error) {
+	var info jpegInfo
+	err := walkJPEGSegments(r, func(marker byte, payload []byte) error {
+		switch marker {
+		case markerDQT:
+			if quality, ok := luminanceQuality(payload); ok {
+				info.quality = quality
+			}
+		case markerCOM:
+			if marked, found := bytes.CutPrefix(payload, []byte(compressedMarker)); found {
+				if quality, err := strconv.Atoi(string(marked)); err == nil {
+					info.markedQuality = quality
+				}
+			}
+		}
+		return nil
+	})
+	return info, err
+}
+
+// walkJPEGSegments calls fn with the marker and payload of every segment of a JPEG
+// stream, stopping at the start of the image data
+func walkJPEGSegments(r io.Reader, fn func(marker byte, payload []byte) error) error {
+	var header [2]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return err
+	}
+	if header[0] != 0xFF || header[1] != markerSOI {
+		return errors.New("not a JPEG file")
+	}
+
+	for {
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return err
+		}
+		if header[0] != 0xFF {
+			return fmt.Errorf("invalid JPEG marker %#x", header[0])
+		}
+		marker := header[1]
+		switch {
+		case marker == markerSOS || marker == markerEOI:
+			return nil
+		case marker == 0xFF:
+			// Fill byte, the marker follows
+			continue
+		case marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7):
+			// Standalone markers have no payload
+			continue
+		}
+
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return err
+		}
+		length := int(binary.BigEndian.Uint16(header[:]))
+		if length < 2 {
+			return fmt.Errorf("invalid JPEG segment length %d", length)
+		}
+		payload := make([]byte, length-2)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return err
+		}
+		if err := fn(marker, payload); err != nil {
+			return err
+		}
+	}
+}
+
+// luminanceQuality estimates the quality a DQT segment was encoded with from its
+// luminance table, inverting the scaling libjpeg applies to the standard table
+func luminanceQuality(payload []byte) (int, bool) {
+	for len(payload) > 0 {
+		precision, id := payload[0]>>4, payload[0]&0x0F
+		size := 64
+		if precision == 1 {
+			size = 128
+		}
+		if len(payload) < 1+size {
+			return 0, false
+		}
+		table := payload[1 : 1+size]
+		payload = payload[1+size:]
+		if id != 0 {
+			continue
+		}
+
+		var scale float64
+		for i := range 64 {
+			value := float64(table[i])
+			if precision == 1 {
+				value = float64(binary.BigEndian.Uint16(table[2*i:]))
+			}
+			scale += value * 100 / standardLuminanceTable[zigzagOrder[i]]
+		}
+		scale /= 64
+
+		var quality float64
+		if scale <= 100 {
+			quality = (200 - scale) / 2
+		} else {
+			quality = 5000 / scale
+		}
+		return max(1, min(100, int(math.Round(quality)))), true
+	}
+	return 0, false
+}
+
+// markCompressed writes the compressed-by-pics comment into a JPEG file, after its
+// APP segments so EXIF data stays where readers expect it. The modification time is kept.
+func markCompressed(path string, quality int) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	offset, err := appSegmentsEnd(data)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	comment := []byte(compressedMarker + strconv.Itoa(quality))
+	segment := []byte{0xFF, markerCOM, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(comment)+2))
+	segment = append(segment, comment...)
+
+	marked := make([]byte, 0, len(data)+len(segment))
+	marked = append(marked, data[:offset]...)
+	marked = append(marked, segment...)
+	marked = append(marked, data[offset:]...)
+
+	return replaceFile(path, marked, info)
+}
+
+// appSegmentsEnd returns the offset of the first segment of a JPEG file after its SOI
+// marker and APP segments
+func appSegmentsEnd(data []byte) (int, error) {
+	if len(data) < 2 || data[0] != 0xFF || data[1] != markerSOI {
+		return 0, errors.New("not a JPEG file")
+	}
+	offset := 2
+	for offset+4 <= len(data) && data[offset] == 0xFF && data[offset+1] >= 0xE0 && data[offset+1] <= 0xEF {
+		offset += 2 + int(binary.BigEndian.Uint16(data[offset+2:]))
+	}
+	if offset > len(data) {
+		return 0, errors.New("truncated JPEG segment")
+	}
+	return offset, nil
+}
@@ -0,0 +1,217 @@
+package pics
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func encodeJPEG(t testing.TB, quality int) []byte {
+	t.Helper()
+	img := image.NewGray(image.Rect(0, 0, 16, 16))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i)
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		t.Fatalf("Failed to encode JPEG: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestReadJPEGInfo_EstimatesQuality(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	for _, quality := range []int{30, 40, 50, 75, 95} {
+		path := createFileWithContent(t, tmpDir, "photo.jpg", encodeJPEG(t, quality), time.Now())
+		info, err := readJPEGInfo(path)
+		if err != nil {
+			t.Fatalf("readJPEGInfo failed: %v", err)
+		}
+		if info.quality < quality-1 || info.quality > quality+1 {
+			t.Errorf("Expected quality %d, estimated %d", quality, info.quality)
+		}
+		if info.markedQuality != 0 {
+			t.Errorf("Expected an unmarked file at quality %d", quality)
+		}
+	}
+}
+
+func TestReadJPEGInfo_NotJPEG(t *testing.T) {
+	path := createFileWithContent(t, t.TempDir(), "photo.jpg", []byte("test media content"), time.Now())
+	if _, err := readJPEGInfo(path); err == nil {
+		t.Error("Expected an error for a file that is not a JPEG")
+	}
+}
+
+func TestCompressionSkip(t *testing.T) {
+	tmpDir := t.TempDir()
+	low := createFileWithContent(t, tmpDir, "low.jpg", encodeJPEG(t, 40), time.Now())
+	high := createFileWithContent(t, tmpDir, "high.jpg", encodeJPEG(t, 90), time.Now())
+	// Both are encoded at 90, marked as compressed at or above the target quality
+	marked := createFileWithContent(t, tmpDir, "marked.jpg", encodeJPEG(t, 90), time.Now())
+	if err := markCompressed(marked, 50); err != nil {
+		t.Fatalf("markCompressed failed: %v", err)
+	}
+	markedHigher := createFileWithContent(t, tmpDir, "marked-higher.jpg", encodeJPEG(t, 90), time.Now())
+	if err := markCompressed(markedHigher, 90); err != nil {
+		t.Fatalf("markCompressed failed: %v", err)
+	}
+	text := createFileWithContent(t, tmpDir, "text.jpg", []byte("test media content"), time.Now())
+
+	tests := []struct {
+		name    string
+		path    string
+		skipped bool
+	}{
+		{"below target quality", low, true},
+		{"above target quality", high, false},
+		{"compressed by pics", marked, true},
+		{"compressed by pics above target quality", markedHigher, false},
+		{"unreadable", text, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reason := compressionSkip(tt.path, 50)
+			if (reason != "") != tt.skipped {
+				t.Errorf("Expected skipped %v, got reason %q", tt.skipped, reason)
+			}
+		})
+	}
+}
+
+func TestMarkCompressed(t *testing.T) {
+	tmpDir := t.TempDir()
+	modTime := time.Date(2023, 6, 15, 10, 30, 0, 0, time.UTC)
+
+	// An EXIF segment has to stay right after the SOI marker
+	app1 := []byte{0xFF, 0xE1, 0x00, 0x08, 'E', 'x', 'i', 'f', 0x00, 0x00}
+	encoded := encodeJPEG(t, 80)
+	content := append(append([]byte{0xFF, 0xD8}, app1...), encoded[2:]...)
+	path := createFileWithContent(t, tmpDir, "photo.jpg", content, modTime)
+
+	if err := markCompressed(path, 50); err != nil {
+		t.Fatalf("markCompressed failed: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	}
+	if !bytes.Equal(data[2:2+len(app1)], app1) {
+		t.Error("Expected the APP1 segment to stay right after the SOI marker")
+	}
+	if !bytes.Contains(data, []byte(compressedMarker+"50")) {
+		t.Error("Expected the compressed marker in the file")
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(data)); err != nil {
+		t.Errorf("Expected the marked file to decode, got: %v", err)
+	}
+
+	info, err := readJPEGInfo(path)
+	if err != nil || info.markedQuality != 50 {
+		t.Errorf("Expected the file to be read as marked, got %+v, %v", info, err)
+	}
+	stat, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Failed to stat file: %v", err)
+	}
+	if !stat.ModTime().Equal(modTime) {
+		t.Errorf("Expected modification time %v, got %v", modTime, stat.ModTime())
+	}
+	if entries, _ := os.ReadDir(tmpDir); len(entries) != 1 {
+		t.Errorf("Expected no temporary file left behind, got %d entries", len(entries))
+	}
+}
+
+func TestMediaParser_Parse_SkipsOptimisedJPEGs(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir, targetDir := createSourceAndTarget(t, tmpDir)
+	testDate := time.Date(2023, 6, 15, 10, 30, 0, 0, time.UTC)
+
+	content := encodeJPEG(t, 40)
+	createFileWithContent(t, sourceDir, "IMG_0001.jpg", content, testDate)
+
+	progressChan := make(chan ProgressEvent, 100)
+	opts := testParseOptions
+	opts.CompressJPEGs = true
+	opts.JPEGQuality = 50
+	opts.ProgressChan = progressChan
+
+	plan, err := testParser.Plan(sourceDir, targetDir, opts)
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+	if len(plan.Files) != 1 || plan.Files[0].Compress {
+		t.Fatalf("Expected a single file planned without compression, got %+v", plan.Files)
+	}
+
+	// jpegoptim is never run, so this passes without it installed
+	result, err := testParser.Parse(sourceDir, targetDir, opts)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	close(progressChan)
+
+	if len(result.Files) != 1 || result.Files[0].Compressed {
+		t.Errorf("Expected the file to be imported uncompressed, got %+v", result.Files)
+	}
+	data, err := os.ReadFile(filepath.Join(targetDir, "2023 06 June 15", "2023_06_June_15_00001.jpg"))
+	if err != nil {
+		t.Fatalf("Failed to read imported file: %v", err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Error("Expected the imported file to be left untouched")
+	}
+
+	skipped := false
+	for event := range progressChan {
+		if event.Stage == "skipping" {
+			skipped = true
+		}
+	}
+	if !skipped {
+		t.Error("Expected a skipping progress event")
+	}
+}
+
+// keepingCompressor never shrinks a file, like jpegoptim on an already optimised one
+type keepingCompressor struct{}
+
+func (c *keepingCompressor) CompressFile(ctx context.Context, path string, quality int) (bool, error) {
+	return false, nil
+}
+
+func TestMediaParser_Parse_KeptOriginalIsNotMarked(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir, targetDir := createSourceAndTarget(t, tmpDir)
+	content := encodeJPEG(t, 90)
+	createFileWithContent(t, sourceDir, "IMG_0001.jpg", content, time.Date(2023, 6, 15, 10, 30, 0, 0, time.UTC))
+
+	parser := NewMediaParser().(*mediaParser)
+	parser.compressor = &keepingCompressor{}
+	opts := testParseOptions
+	opts.CompressJPEGs = true
+	opts.JPEGQuality = 50
+
+	result, err := parser.Parse(sourceDir, targetDir, opts)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if len(result.Files) != 1 || result.Files[0].Compressed {
+		t.Errorf("Expected the file to be imported uncompressed, got %+v", result.Files)
+	}
+	data, err := os.ReadFile(filepath.Join(targetDir, "2023 06 June 15", "2023_06_June_15_00001.jpg"))
+	if err != nil {
+		t.Fatalf("Failed to read imported file: %v", err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Error("Expected the kept original to be left unmarked")
+	}
+}
@@ -1,6 +1,7 @@
 package pics
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"os/exec"
@@ -8,8 +9,9 @@ import (
 
 // ImageCompressor defines the interface for compressing images
 type ImageCompressor interface {
-	// CompressFile compresses a single JPEG file
-	CompressFile(path string, quality int) error
+	// CompressFile compresses a single JPEG file, stopping if ctx is cancelled. It reports
+	// whether the file was replaced; a file that does not get smaller is left as it is.
+	CompressFile(ctx context.Context, path string, quality int) (bool, error)
 }
 
 // jpegCompressor implements the ImageCompressor interface
@@ -30,10 +32,11 @@ func NewImageCompressorWithPath(jpegoptimPath string) ImageCompressor {
 }
 
 // CompressFile compresses a single JPEG file using jpegoptim (preserves EXIF)
-func (c *jpegCompressor) CompressFile(path string, quality int) error {
+func (c *jpegCompressor) CompressFile(ctx context.Context, path string, quality int) (bool, error) {
 	// Check if file exists first
-	if _, err := os.Stat(path); err != nil {
-		return fmt.Errorf("file does not exist: %w", err)
+	before, err := os.Stat(path)
+	if err != nil {
+		return false, fmt.Errorf("file does not exist: %w", err)
 	}
 
 	// Determine jpegoptim path
@@ -42,11 +45,22 @@ func (c *jpegCompressor) CompressFile(path string, quality int) error {
 		jpegoptim = "jpegoptim" // Use system PATH
 	}
 
-	// jpegoptim preserves EXIF data and file modification time by default with -p flag
-	cmd := exec.Command(jpegoptim, fmt.Sprintf("-m%d", quality), "-p", path)
+	// jpegoptim preserves EXIF data and file modification time by default with -p flag.
+	// The process is killed if ctx is cancelled; jpegoptim only replaces the file
+	// once the compressed copy is complete, so the original is left intact.
+	cmd := exec.CommandContext(ctx, jpegoptim, fmt.Sprintf("-m%d", quality), "-p", path)
 	output, err := cmd.CombinedOutput()
+	if ctx.Err() != nil {
+		return false, ctx.Err()
+	}
+	if err != nil {
+		return false, fmt.Errorf("jpegoptim failed for %s: %w, output: %s", path, err, output)
+	}
+
+	// jpegoptim skips files it cannot shrink, so a smaller file is one it replaced
+	after, err := os.Stat(path)
 	if err != nil {
-		return fmt.Errorf("jpegoptim failed for %s: %w, output: %s", path, err, output)
+		return false, fmt.Errorf("failed to stat %s: %w", path, err)
 	}
-	return nil
+	return after.Size() < before.Size(), nil
 }
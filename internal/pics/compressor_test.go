@@ -1,6 +1,7 @@
 package pics
 
 import (
+	"context"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -68,7 +69,7 @@ func TestJpegCompressor_CompressFile(t *testing.T) {
 	}
 
 	compressor := NewImageCompressor()
-	err = compressor.CompressFile(testFile, 50)
+	_, err = compressor.CompressFile(context.Background(), testFile, 50)
 
 	if err != nil {
 		t.Errorf("Expected no error, got: %v", err)
@@ -95,7 +96,7 @@ func TestJpegCompressor_CompressFile_NonexistentFile(t *testing.T) {
 	}
 
 	compressor := NewImageCompressor()
-	err := compressor.CompressFile("/nonexistent/file.jpg", 50)
+	_, err := compressor.CompressFile(context.Background(), "/nonexistent/file.jpg", 50)
 
 	if err == nil {
 		t.Error("Expected error for nonexistent file, got nil")
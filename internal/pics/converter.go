@@ -0,0 +1,138 @@
+package pics
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// convertQuality is the JPEG quality HEIC files are converted at, before any compression
+const convertQuality = 95
+
+// ImageConverter defines the interface for converting images to JPEG
+type ImageConverter interface {
+	// ConvertFile converts the HEIC file at src into a JPEG file at dst, keeping its EXIF
+	// data and modification time, stopping if ctx is cancelled
+	ConvertFile(ctx context.Context, src, dst string) error
+}
+
+// heicConverter implements the ImageConverter interface
+type heicConverter struct {
+	converterPath string
+}
+
+// NewImageConverter creates a new ImageConverter instance using system heif-convert
+func NewImageConverter() ImageConverter {
+	return &heicConverter{}
+}
+
+// NewImageConverterWithPath creates a new ImageConverter with a custom heif-convert path
+func NewImageConverterWithPath(converterPath string) ImageConverter {
+	return &heicConverter{
+		converterPath: converterPath,
+	}
+}
+
+// ConvertFile converts a single HEIC file to JPEG using heif-convert, which copies the
+// EXIF data of the HEIC file into the JPEG
+func (c *heicConverter) ConvertFile(ctx context.Context, src, dst string) error {
+	srcInfo, err := os.Stat(src)
+	if err != nil {
+		return fmt.Errorf("file does not exist: %w", err)
+	}
+
+	// Determine heif-convert path
+	converter := c.converterPath
+	if converter == "" {
+		converter = "heif-convert" // Use system PATH
+	}
+
+	// The process is killed if ctx is cancelled, a partial JPEG is removed
+	cmd := exec.CommandContext(ctx, converter, "-q", fmt.Sprint(convertQuality), src, dst)
+	output, err := cmd.CombinedOutput()
+	if ctx.Err() != nil {
+		os.Remove(dst)
+		return ctx.Err()
+	}
+	if err != nil {
+		os.Remove(dst)
+		return fmt.Errorf("heif-convert failed for %s: %w, output: %s", src, err, output)
+	}
+
+	if err := os.Chtimes(dst, time.Now(), srcInfo.ModTime()); err != nil {
+		return fmt.Errorf("failed to set file times: %w", err)
+	}
+	return nil
+}
+
+// convertedName returns the name the JPEG converted from the HEIC file at path is staged
+// under. When a JPEG with the same base name sits next to the HEIC file (as in the "Most
+// Compatible" exports of iPhones), the converted file gets a "-converted" suffix instead,
+// so neither overwrites the other.
+func convertedName(path, name string) string {
+	converted := formatJPEG.rename(name)
+	stem := strings.ToLower(strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)))
+	siblings, err := readDirNames(filepath.Dir(path))
+	if err != nil {
+		return converted
+	}
+	for _, sibling := range siblings {
+		ext := strings.ToLower(filepath.Ext(sibling))
+		if slices.Contains(formatJPEG.exts, ext) && strings.ToLower(strings.TrimSuffix(sibling, filepath.Ext(sibling))) == stem {
+			return strings.TrimSuffix(converted, filepath.Ext(converted)) + "-converted" + formatJPEG.exts[0]
+		}
+	}
+	return converted
+}
+
+// convertedPairs returns the HEIC file each JPEG among files (all in the same directory)
+// was converted from, so an original kept alongside its JPEG takes the same name. With
+// conversions (converted JPEG path -> kept HEIC path) only those pairs are returned, as a
+// JPEG and a HEIC file sharing a base name may just come from two devices. Without them
+// the files are paired by base name (IMG_1234.jpg and IMG_1234.heic), as in a library
+// organised by an earlier parse.
+func convertedPairs(files []companionFile, extensions Extensions, conversions map[string]string) map[string]string {
+	if conversions != nil {
+		names := make(map[string]string, len(files))
+		for _, file := range files {
+			names[file.path] = file.name
+		}
+		pairs := make(map[string]string)
+		for _, file := range files {
+			heicPath, converted := conversions[file.path]
+			if heic, found := names[heicPath]; converted && found {
+				pairs[file.name] = heic
+			}
+		}
+		return pairs
+	}
+
+	names := make([]string, len(files))
+	for i, file := range files {
+		names[i] = file.name
+	}
+	jpegs := make(map[string]string)
+	for _, name := range slices.Sorted(slices.Values(names)) {
+		stem := livePhotoStem(name)
+		if _, taken := jpegs[stem]; extensions.IsImage(name) && formatJPEG.fits(name, extensions) && !taken {
+			jpegs[stem] = name
+		}
+	}
+
+	pairs := make(map[string]string)
+	for _, name := range slices.Sorted(slices.Values(names)) {
+		if !extensions.IsImage(name) || !formatHEIC.fits(name, extensions) {
+			continue
+		}
+		jpeg, found := jpegs[livePhotoStem(name)]
+		if _, taken := pairs[jpeg]; found && !taken {
+			pairs[jpeg] = name
+		}
+	}
+	return pairs
+}
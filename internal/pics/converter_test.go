@@ -0,0 +1,220 @@
+package pics
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeConverter writes a JPEG header for every file, failing for paths that contain failOn
+type fakeConverter struct {
+	failOn string
+}
+
+func (c *fakeConverter) ConvertFile(ctx context.Context, src, dst string) error {
+	if c.failOn != "" && strings.Contains(src, c.failOn) {
+		return fmt.Errorf("simulated failure for %s", src)
+	}
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(dst, jpegHeader, 0644); err != nil {
+		return err
+	}
+	return os.Chtimes(dst, info.ModTime(), info.ModTime())
+}
+
+func newParserWithConverter(failOn string) *mediaParser {
+	return &mediaParser{
+		compressor: NewImageCompressor(),
+		converter:  &fakeConverter{failOn: failOn},
+		organiser:  NewFileOrganiser(),
+		extensions: NewExtensions(),
+	}
+}
+
+func TestHeicConverter_ConvertFile(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	if err := NewImageConverter().ConvertFile(context.Background(), filepath.Join(tmpDir, "missing.heic"), filepath.Join(tmpDir, "missing.jpg")); err == nil {
+		t.Error("Expected an error for a missing file")
+	}
+
+	src := createFileWithContent(t, tmpDir, "IMG_0001.heic", heicHeader, time.Now())
+	dst := filepath.Join(tmpDir, "IMG_0001.jpg")
+	converter := NewImageConverterWithPath(filepath.Join(tmpDir, "no-such-converter"))
+	if err := converter.ConvertFile(context.Background(), src, dst); err == nil {
+		t.Error("Expected an error for a missing converter binary")
+	}
+	assertMediaFileNotExists(t, dst)
+}
+
+func TestHeicConverter_ConvertFile_HeifConvert(t *testing.T) {
+	if _, err := exec.LookPath("heif-convert"); err != nil {
+		t.Skip("heif-convert not installed, skipping test")
+	}
+
+	// A truncated HEIC file must fail without leaving a JPEG behind
+	tmpDir := t.TempDir()
+	src := createFileWithContent(t, tmpDir, "IMG_0001.heic", heicHeader, time.Now())
+	dst := filepath.Join(tmpDir, "IMG_0001.jpg")
+	if err := NewImageConverter().ConvertFile(context.Background(), src, dst); err == nil {
+		t.Error("Expected an error for a truncated HEIC file")
+	}
+	assertMediaFileNotExists(t, dst)
+}
+
+func TestConvertedName(t *testing.T) {
+	tmpDir := t.TempDir()
+	single := createFileWithContent(t, tmpDir, "IMG_0001.HEIC", heicHeader, time.Now())
+	both := createFileWithContent(t, tmpDir, "IMG_0002.HEIC", heicHeader, time.Now())
+	createFileWithContent(t, tmpDir, "IMG_0002.JPG", jpegHeader, time.Now())
+
+	if name := convertedName(single, "trip-IMG_0001.HEIC"); name != "trip-IMG_0001.jpg" {
+		t.Errorf("Expected trip-IMG_0001.jpg, got %s", name)
+	}
+	if name := convertedName(both, "trip-IMG_0002.HEIC"); name != "trip-IMG_0002-converted.jpg" {
+		t.Errorf("Expected trip-IMG_0002-converted.jpg, got %s", name)
+	}
+}
+
+func TestCompanionFinder_Find_ConvertedPairs(t *testing.T) {
+	finder := newCompanionFinder("")
+	files := companionFiles("IMG_0001.heic", "IMG_0001.jpg", "IMG_0001.mov", "IMG_0001.xmp", "IMG_0002.heic")
+
+	companions := finder.find(files, false)
+
+	expected := map[string][]string{
+		"IMG_0001.jpg": {"IMG_0001.heic", "IMG_0001.mov", "IMG_0001.xmp"},
+	}
+	if !reflect.DeepEqual(companions, expected) {
+		t.Errorf("Expected %v, got %v", expected, companions)
+	}
+}
+
+func TestCompanionFinder_Find_RecordedConversionsOnly(t *testing.T) {
+	finder := newCompanionFinder("").withConversions(func() map[string]string {
+		return map[string]string{"/dir/IMG_0002-converted.jpg": "/dir/IMG_0002.heic"}
+	})
+	files := companionFiles("IMG_0001.heic", "IMG_0001.jpg", "IMG_0002-converted.jpg", "IMG_0002.heic", "IMG_0002.jpg")
+
+	companions := finder.find(files, false)
+
+	// IMG_0001.jpg and IMG_0001.heic share a name but neither was converted from the other
+	expected := map[string][]string{
+		"IMG_0002-converted.jpg": {"IMG_0002.heic"},
+	}
+	if !reflect.DeepEqual(companions, expected) {
+		t.Errorf("Expected %v, got %v", expected, companions)
+	}
+}
+
+func TestMediaParser_Parse_KeepsUnconvertedPairsApart(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir, targetDir := createSourceAndTarget(t, tmpDir)
+	testDate := time.Date(2023, 6, 15, 10, 30, 0, 0, time.UTC)
+	createFileWithContent(t, sourceDir, "IMG_0001.heic", heicHeader, testDate)
+	createMediaFile(t, sourceDir, "IMG_0001.jpg", testDate)
+
+	if _, err := newParserWithConverter("").Parse(sourceDir, targetDir, testParseOptions); err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	// Two devices numbered IMG_0001 the same day, so each file gets its own number
+	dateDir := filepath.Join(targetDir, "2023 06 June 15")
+	assertMediaFileExists(t, filepath.Join(dateDir, "2023_06_June_15_00001.heic"))
+	assertMediaFileExists(t, filepath.Join(dateDir, "2023_06_June_15_00002.jpg"))
+}
+
+func TestMediaParser_Parse_ConvertsHEIC(t *testing.T) {
+	tests := []struct {
+		name string
+		keep bool
+	}{
+		{"replace original", false},
+		{"keep original", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			sourceDir, targetDir := createSourceAndTarget(t, tmpDir)
+			testDate := time.Date(2023, 6, 15, 10, 30, 0, 0, time.UTC)
+			createFileWithContent(t, sourceDir, "IMG_0001.heic", heicHeader, testDate)
+			createMediaFile(t, sourceDir, "IMG_0002.jpg", testDate)
+
+			parser := newParserWithConverter("")
+			opts := testParseOptions
+			opts.ConvertHEIC = true
+			opts.KeepHEIC = tt.keep
+
+			plan, err := parser.Plan(sourceDir, targetDir, opts)
+			if err != nil {
+				t.Fatalf("Plan failed: %v", err)
+			}
+			if len(plan.Files) != 2 || !plan.Files[0].Convert || !strings.HasSuffix(plan.Files[0].StagingName, "IMG_0001.jpg") {
+				t.Fatalf("Expected IMG_0001.heic planned for conversion, got %+v", plan.Files)
+			}
+
+			stats := NewFileStats()
+			sourceSnapshot, _ := stats.Snapshot(sourceDir)
+			targetSnapshot, _ := stats.Snapshot(targetDir)
+			result, err := parser.Parse(sourceDir, targetDir, opts)
+			if err != nil {
+				t.Fatalf("Parse failed: %v", err)
+			}
+
+			dateDir := filepath.Join(targetDir, "2023 06 June 15")
+			assertMediaFileExists(t, filepath.Join(dateDir, "2023_06_June_15_00001.jpg"))
+			assertMediaFileExists(t, filepath.Join(dateDir, "2023_06_June_15_00002.jpg"))
+			original := filepath.Join("2023 06 June 15", "2023_06_June_15_00001.heic")
+			if tt.keep {
+				assertMediaFileExists(t, filepath.Join(targetDir, original))
+			} else {
+				assertMediaFileNotExists(t, filepath.Join(targetDir, original))
+				original = ""
+			}
+			if result.Files[0].Original != original {
+				t.Errorf("Expected original %q, got %q", original, result.Files[0].Original)
+			}
+
+			reconciliation, err := stats.Reconcile(sourceSnapshot, targetSnapshot, result)
+			if err != nil {
+				t.Fatalf("Reconcile failed: %v", err)
+			}
+			if !reconciliation.OK() {
+				t.Errorf("Expected reconciliation to pass, got %+v", reconciliation)
+			}
+		})
+	}
+}
+
+func TestMediaParser_Parse_ContinueQuarantinesFailedConversions(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir, targetDir := createSourceAndTarget(t, tmpDir)
+	testDate := time.Date(2023, 6, 15, 10, 30, 0, 0, time.UTC)
+	createFileWithContent(t, sourceDir, "broken.heic", heicHeader, testDate)
+
+	opts := testParseOptions
+	opts.ConvertHEIC = true
+	opts.ErrorPolicy = ErrorPolicyContinue
+
+	result, err := newParserWithConverter("broken").Parse(sourceDir, targetDir, opts)
+	if err == nil {
+		t.Fatal("Expected the failed conversion to be reported")
+	}
+	if result == nil || len(result.Failures) != 1 || result.Failures[0].Stage != "converting" {
+		t.Fatalf("Expected a single converting failure, got %+v", result)
+	}
+	assertMediaFileExists(t, filepath.Join(targetDir, quarantineDirName, "root-broken.heic"))
+	if entries, _ := os.ReadDir(filepath.Join(targetDir, quarantineDirName)); len(entries) != 2 {
+		t.Errorf("Expected the HEIC file and the report in quarantine, got %d entries", len(entries))
+	}
+}
@@ -1,9 +1,12 @@
 package pics
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/acm19/pics/internal/logger"
@@ -36,9 +39,25 @@ func (e *modTimeExtractor) getFileDate(filePath string) (time.Time, error) {
 	return info.ModTime(), nil
 }
 
+// prefetchingExtractor is a fileDateExtractor that can read the dates of many files at once
+type prefetchingExtractor interface {
+	// prefetch reads the dates of paths ahead of getFileDate and returns the paths it
+	// has no date for
+	prefetch(paths []string) []string
+}
+
 // exifDateExtractor extracts date from EXIF metadata
 type exifDateExtractor struct {
 	exiftoolPath string
+	// pool runs the exiftool processes of a parse. Without one, every file starts and
+	// stops its own exiftool.
+	pool *exiftoolPool
+	// location is where dates that carry no timezone are read in, nil for the system timezone
+	location *time.Location
+
+	mu sync.Mutex
+	// prefetched holds the metadata read by prefetch, keyed by path, until getFileDate uses it
+	prefetched map[string]exiftool.FileMetadata
 }
 
 func newExifDateExtractor() *exifDateExtractor {
@@ -51,73 +70,177 @@ func newExifDateExtractorWithPath(exiftoolPath string) *exifDateExtractor {
 	}
 }
 
+func newExifDateExtractorWithPool(pool *exiftoolPool) *exifDateExtractor {
+	return &exifDateExtractor{
+		pool:       pool,
+		prefetched: make(map[string]exiftool.FileMetadata),
+	}
+}
+
 func (e *exifDateExtractor) name() string {
 	return "EXIF"
 }
 
 func (e *exifDateExtractor) getFileDate(filePath string) (time.Time, error) {
-	var et *exiftool.Exiftool
-	var err error
+	e.mu.Lock()
+	fileInfo, found := e.prefetched[filePath]
+	delete(e.prefetched, filePath)
+	e.mu.Unlock()
 
-	// Use custom exiftool path if provided
-	if e.exiftoolPath != "" {
-		et, err = exiftool.NewExiftool(exiftool.SetExiftoolBinaryPath(e.exiftoolPath))
-	} else {
-		et, err = exiftool.NewExiftool()
+	if !found {
+		pool := e.pool
+		if pool == nil {
+			pool = newExiftoolPool(context.Background(), e.exiftoolPath, 1)
+			defer pool.close()
+		}
+		fileInfo = pool.extractMetadata([]string{filePath})[0]
 	}
+	return exifDate(fileInfo, e.location)
+}
 
-	if err != nil {
-		return time.Time{}, err
+// withLocation returns a copy of the extractor that reads dates that carry no timezone in
+// loc. Metadata read ahead is not carried over.
+func (e *exifDateExtractor) withLocation(loc *time.Location) *exifDateExtractor {
+	extractor := &exifDateExtractor{
+		exiftoolPath: e.exiftoolPath,
+		pool:         e.pool,
+		location:     loc,
+	}
+	if e.pool != nil {
+		extractor.prefetched = make(map[string]exiftool.FileMetadata)
+	}
+	return extractor
+}
+
+// prefetch reads the metadata of paths in batches through the pool, so the organiser
+// does not wait for exiftool file by file. Without a pool nothing is read ahead.
+func (e *exifDateExtractor) prefetch(paths []string) []string {
+	if e.pool == nil {
+		return paths
 	}
-	defer et.Close()
 
-	fileInfos := et.ExtractMetadata(filePath)
-	if len(fileInfos) == 0 {
-		return time.Time{}, fmt.Errorf("no metadata found")
+	var undated []string
+	results := e.pool.extractMetadata(paths)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, fileInfo := range results {
+		// Failures are not kept, getFileDate asks again and reports them
+		if fileInfo.Err == nil {
+			e.prefetched[fileInfo.File] = fileInfo
+		}
+		if _, err := exifDate(fileInfo, e.location); err != nil {
+			undated = append(undated, fileInfo.File)
+		}
 	}
+	return undated
+}
 
-	fileInfo := fileInfos[0]
+// exifDate returns the date in the metadata exiftool read from a file, reading dates that
+// carry no timezone in loc
+func exifDate(fileInfo exiftool.FileMetadata, loc *time.Location) (time.Time, error) {
 	if fileInfo.Err != nil {
 		return time.Time{}, fileInfo.Err
 	}
+	return metadataDate(fileInfo.File, func(field string) (string, bool) {
+		val, err := fileInfo.GetString(field)
+		return val, err == nil
+	}, loc)
+}
+
+// metadataDateField is a date field of the metadata of a file, by its exiftool name
+type metadataDateField struct {
+	name string
+	// offset names the field holding the timezone of the date, if there is one
+	offset string
+	// utc marks dates kept in UTC
+	utc bool
+}
+
+// metadataDate returns the date in the metadata of file, given lookup to read its fields
+// by their exiftool names. A date is read in the timezone of its suffix or offset field,
+// then in UTC for the CreateDate of videos, as QuickTime keeps it in UTC, and otherwise
+// as the wall clock of loc (nil for the system timezone).
+func metadataDate(file string, lookup func(field string) (string, bool), loc *time.Location) (time.Time, error) {
+	mimeType, _ := lookup("MIMEType")
+	dateFields := []metadataDateField{
+		{name: "CreationDate"},
+		{name: "DateTimeOriginal", offset: "OffsetTimeOriginal"},
+		{name: "CreateDate", offset: "OffsetTimeDigitized", utc: strings.HasPrefix(mimeType, "video/")},
+	}
 
-	// Try date fields in order of preference: CreationDate first, then CreateDate
-	dateFields := []string{"CreationDate", "CreateDate"}
+	// Try date fields in order of preference, moving on when one cannot be parsed
+	err := fmt.Errorf("no EXIF date field found")
 	for _, field := range dateFields {
-		if val, err := fileInfo.GetString(field); err == nil {
-			logger.Debug("Using EXIF date field", "file", filepath.Base(filePath), "field", field, "date", val)
-
-			// Parse the EXIF date string (format: "2006:01:02 15:04:05")
-			parsedTime, err := time.Parse("2006:01:02 15:04:05", val)
-			if err != nil {
-				logger.Debug("Failed to parse EXIF date", "file", filePath, "date", val, "error", err)
-				return time.Time{}, err
-			}
-			return parsedTime, nil
+		val, found := lookup(field.name)
+		if !found {
+			continue
+		}
+		var offset string
+		if field.offset != "" {
+			offset, _ = lookup(field.offset)
+		}
+		zone := orLocal(loc)
+		if field.utc {
+			zone = time.UTC
 		}
+
+		var parsedTime time.Time
+		if parsedTime, err = parseMetadataDate(val, offset, zone); err != nil {
+			logger.Debug("Failed to parse EXIF date", "file", file, "field", field.name, "date", val, "error", err)
+			continue
+		}
+		logger.Debug("Using EXIF date field", "file", filepath.Base(file), "field", field.name, "date", val, "offset", offset)
+		return parsedTime, nil
 	}
+	return time.Time{}, err
+}
 
-	// No valid EXIF date found
-	return time.Time{}, fmt.Errorf("no EXIF date field found")
+// parseMetadataDate parses a date in exiftool's format ("2006:01:02 15:04:05"), in the
+// timezone of its suffix (e.g. "+02:00") or of offset when it has one, and in loc otherwise
+func parseMetadataDate(value, offset string, loc *time.Location) (time.Time, error) {
+	for _, zoned := range []string{value, value + offset} {
+		if date, err := time.Parse("2006:01:02 15:04:05Z07:00", zoned); err == nil {
+			return date, nil
+		}
+	}
+	return time.ParseInLocation("2006:01:02 15:04:05", value, loc)
+}
+
+// orLocal returns loc, or the system timezone when loc is nil
+func orLocal(loc *time.Location) *time.Location {
+	if loc == nil {
+		return time.Local
+	}
+	return loc
 }
 
 // AggregatedFileDateExtractor iterates through multiple extractors until one succeeds
 type AggregatedFileDateExtractor struct {
 	extractors []fileDateExtractor
+	// location is the library timezone dates are returned in, nil for the system timezone
+	location *time.Location
 }
 
-// NewFileDateExtractor creates a new AggregatedFileDateExtractor with EXIF and ModTime extractors
+// NewFileDateExtractor creates a new AggregatedFileDateExtractor with Native, EXIF and
+// ModTime extractors
 //
 // Prioritises extracting the dates from the EXIF metadata in the following
 // order:
 //
 //   - CreationDate: because modified iPhone videos keep the original date in
-//     this field.
-//   - CreateDate: holds the date when the image/video was created.
+//     this field, along with their timezone.
+//   - DateTimeOriginal: holds the date when the photo was taken, in the timezone
+//     of OffsetTimeOriginal when the camera recorded it.
+//   - CreateDate: holds the date when the image/video was created. Videos keep
+//     it in UTC.
 //   - ModTime: if nothing else works falls back to modification time.
+//
+// The metadata of JPEG, HEIC, TIFF-based RAW and QuickTime/MP4 files is read natively,
+// exiftool is only run for the files the native reader has no date for.
 func NewFileDateExtractor() *AggregatedFileDateExtractor {
 	return &AggregatedFileDateExtractor{
 		extractors: []fileDateExtractor{
+			newNativeDateExtractor(),
 			newExifDateExtractor(),
 			newModTimeExtractor(),
 		},
@@ -128,24 +251,85 @@ func NewFileDateExtractor() *AggregatedFileDateExtractor {
 func NewFileDateExtractorWithPath(exiftoolPath string) *AggregatedFileDateExtractor {
 	return &AggregatedFileDateExtractor{
 		extractors: []fileDateExtractor{
+			newNativeDateExtractor(),
 			newExifDateExtractorWithPath(exiftoolPath),
 			newModTimeExtractor(),
 		},
 	}
 }
 
+// withFirst returns a copy of the extractor that tries extractor before the others
+func (e *AggregatedFileDateExtractor) withFirst(extractor fileDateExtractor) *AggregatedFileDateExtractor {
+	return &AggregatedFileDateExtractor{
+		extractors: append([]fileDateExtractor{extractor}, e.extractors...),
+		location:   e.location,
+	}
+}
+
+// withLocation returns a copy of the extractor that returns dates in loc, the library
+// timezone, and reads dates that carry no timezone as its wall clock
+func (e *AggregatedFileDateExtractor) withLocation(loc *time.Location) *AggregatedFileDateExtractor {
+	extractors := make([]fileDateExtractor, len(e.extractors))
+	for i, extractor := range e.extractors {
+		switch located := extractor.(type) {
+		case *exifDateExtractor:
+			extractor = located.withLocation(loc)
+		case *nativeDateExtractor:
+			extractor = located.withLocation(loc)
+		}
+		extractors[i] = extractor
+	}
+	return &AggregatedFileDateExtractor{extractors: extractors, location: loc}
+}
+
+// withExiftoolPool returns a copy of the extractor whose EXIF extractor reads through pool
+func (e *AggregatedFileDateExtractor) withExiftoolPool(pool *exiftoolPool) *AggregatedFileDateExtractor {
+	extractors := make([]fileDateExtractor, len(e.extractors))
+	for i, extractor := range e.extractors {
+		if exif, isExif := extractor.(*exifDateExtractor); isExif {
+			pooled := newExifDateExtractorWithPool(pool)
+			pooled.location = exif.location
+			extractor = pooled
+		}
+		extractors[i] = extractor
+	}
+	return &AggregatedFileDateExtractor{extractors: extractors, location: e.location}
+}
+
+// prefetch has the extractors read the dates of paths ahead of GetFileDateWithSource,
+// for those that can read many files at once. A file one extractor has a date for is
+// not read by the ones after it. Reading ahead stops at the first extractor that
+// cannot, the rest read file by file.
+func (e *AggregatedFileDateExtractor) prefetch(paths []string) {
+	for _, extractor := range e.extractors {
+		batch, ok := extractor.(prefetchingExtractor)
+		if !ok || len(paths) == 0 {
+			return
+		}
+		paths = batch.prefetch(paths)
+	}
+}
+
 // GetFileDate extracts the creation date by trying each extractor in order
 // Works for both images (JPG, HEIC) and videos (MOV)
 func (e *AggregatedFileDateExtractor) GetFileDate(filePath string) (time.Time, error) {
+	date, _, err := e.GetFileDateWithSource(filePath)
+	return date, err
+}
+
+// GetFileDateWithSource extracts the creation date like GetFileDate and also
+// returns the name of the extractor that produced it (e.g. "Native", "EXIF", "ModTime").
+// The date is in the library timezone, so its calendar day is the one it is organised by.
+func (e *AggregatedFileDateExtractor) GetFileDateWithSource(filePath string) (time.Time, string, error) {
 	for _, extractor := range e.extractors {
 		date, err := extractor.getFileDate(filePath)
 		if err == nil && !date.IsZero() {
-			return date, nil
+			return date.In(orLocal(e.location)), extractor.name(), nil
 		}
 		if err != nil {
 			logger.Debug("Extractor failed, trying next", "extractor", extractor.name(), "file", filepath.Base(filePath), "error", err)
 		}
 	}
 
-	return time.Time{}, fmt.Errorf("all extractors failed for file: %s", filePath)
+	return time.Time{}, "", fmt.Errorf("all extractors failed for file: %s", filePath)
 }
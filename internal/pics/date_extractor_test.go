@@ -133,6 +133,33 @@ func TestAggregatedFileDateExtractor_ExtractorOrder(t *testing.T) {
 	}
 }
 
+func TestAggregatedFileDateExtractor_GetFileDateWithSource(t *testing.T) {
+	failExtractor := &mockExtractor{
+		returnErr: os.ErrNotExist,
+		nameStr:   "Fail",
+	}
+	successExtractor := &mockExtractor{
+		returnDate: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+		nameStr:    "Success",
+	}
+
+	extractor := &AggregatedFileDateExtractor{
+		extractors: []fileDateExtractor{
+			failExtractor,
+			successExtractor,
+		},
+	}
+
+	_, source, err := extractor.GetFileDateWithSource("dummy.txt")
+
+	if err != nil {
+		t.Errorf("Expected no error, got: %v", err)
+	}
+	if source != "Success" {
+		t.Errorf("Expected source 'Success', got '%s'", source)
+	}
+}
+
 func TestAggregatedFileDateExtractor_AllExtractorsFail(t *testing.T) {
 	// Create mock extractors that all fail
 	failExtractor1 := &mockExtractor{
@@ -159,6 +186,91 @@ func TestAggregatedFileDateExtractor_AllExtractorsFail(t *testing.T) {
 	}
 }
 
+func TestMetadataDate(t *testing.T) {
+	madrid, err := time.LoadLocation("Europe/Madrid")
+	if err != nil {
+		t.Skipf("Timezone database not available: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		fields   map[string]string
+		expected time.Time
+	}{
+		{
+			name:     "CreationDate with timezone suffix",
+			fields:   map[string]string{"MIMEType": "video/quicktime", "CreationDate": "2023:05:01 23:30:00-07:00", "CreateDate": "2023:05:02 06:30:00"},
+			expected: time.Date(2023, 5, 2, 6, 30, 0, 0, time.UTC),
+		},
+		{
+			name:     "QuickTime CreateDate is UTC",
+			fields:   map[string]string{"MIMEType": "video/mp4", "CreateDate": "2023:05:02 06:30:00"},
+			expected: time.Date(2023, 5, 2, 6, 30, 0, 0, time.UTC),
+		},
+		{
+			name:     "DateTimeOriginal with OffsetTimeOriginal",
+			fields:   map[string]string{"MIMEType": "image/jpeg", "DateTimeOriginal": "2023:05:01 23:30:00", "OffsetTimeOriginal": "-07:00", "CreateDate": "2023:05:01 23:30:00"},
+			expected: time.Date(2023, 5, 2, 6, 30, 0, 0, time.UTC),
+		},
+		{
+			name:     "photo date without timezone is the library wall clock",
+			fields:   map[string]string{"MIMEType": "image/jpeg", "CreateDate": "2023:05:01 23:30:00"},
+			expected: time.Date(2023, 5, 1, 23, 30, 0, 0, madrid),
+		},
+		{
+			name:     "unparsable date falls through",
+			fields:   map[string]string{"MIMEType": "image/jpeg", "DateTimeOriginal": "0000:00:00 00:00:00", "CreateDate": "2023:05:01 23:30:00", "OffsetTimeDigitized": "+02:00"},
+			expected: time.Date(2023, 5, 1, 21, 30, 0, 0, time.UTC),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			date, err := metadataDate("file", func(field string) (string, bool) {
+				value, found := tt.fields[field]
+				return value, found
+			}, madrid)
+			if err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+			if !date.Equal(tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, date)
+			}
+		})
+	}
+}
+
+func TestMetadataDate_NoDate(t *testing.T) {
+	_, err := metadataDate("file", func(field string) (string, bool) {
+		return "", false
+	}, time.UTC)
+	if err == nil {
+		t.Error("Expected error without date fields, got nil")
+	}
+}
+
+func TestAggregatedFileDateExtractor_WithLocation(t *testing.T) {
+	losAngeles, err := time.LoadLocation("America/Los_Angeles")
+	if err != nil {
+		t.Skipf("Timezone database not available: %v", err)
+	}
+
+	// An evening video, recorded in UTC the next day
+	extractor := (&AggregatedFileDateExtractor{
+		extractors: []fileDateExtractor{
+			&mockExtractor{returnDate: time.Date(2023, 5, 2, 3, 30, 0, 0, time.UTC), nameStr: "Mock"},
+		},
+	}).withLocation(losAngeles)
+
+	date, err := extractor.GetFileDate("video.mov")
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if date.Location() != losAngeles || date.Day() != 1 || date.Hour() != 20 {
+		t.Errorf("Expected 2023-05-01 20:30 in Los Angeles, got %v", date)
+	}
+}
+
 // mockExtractor is a mock implementation for testing
 type mockExtractor struct {
 	returnDate time.Time
@@ -20,6 +20,7 @@ type DirectoryRenamer interface {
 type directoryRenamer struct {
 	extensions  Extensions
 	fileRenamer FileRenamer
+	companions  *companionFinder
 }
 
 // NewDirectoryRenamer creates a new DirectoryRenamer instance
@@ -27,6 +28,7 @@ func NewDirectoryRenamer() DirectoryRenamer {
 	return &directoryRenamer{
 		extensions:  NewExtensions(),
 		fileRenamer: NewFileRenamer(),
+		companions:  newCompanionFinder(""),
 	}
 }
 
@@ -97,13 +99,16 @@ func (r *directoryRenamer) RenameDirectory(directory, newName string) error {
 	// Convert directory name to base name for file renaming
 	newBaseName := strings.ReplaceAll(newDirName, " ", "_")
 
-	// Rename image files first (before moving directory)
+	// Rename image and RAW files first (before moving directory)
 	if err := r.renameImages(absDir, newBaseName); err != nil {
 		return err
 	}
 
-	// Rename videos in videos subdirectory if it exists
-	if err := r.renameVideos(absDir, newBaseName); err != nil {
+	// Rename videos and screenshots in their subdirectories if they exist
+	if err := r.renameSubdir(absDir, newBaseName, ClassVideo, r.extensions.IsVideo); err != nil {
+		return err
+	}
+	if err := r.renameSubdir(absDir, newBaseName, ClassScreenshot, r.extensions.IsScreenshot); err != nil {
 		return err
 	}
 
@@ -115,9 +120,24 @@ func (r *directoryRenamer) RenameDirectory(directory, newName string) error {
 	return nil
 }
 
-// renameImages renames all image files in the directory
+// renameImages renames all image and RAW files in the directory, along with their companions.
+// RAW files are renumbered next to the images they were shot with, then moved back to the raw subdirectory.
 func (r *directoryRenamer) renameImages(absDir, newBaseName string) error {
-	imageCount, err := r.fileRenamer.RenameFilesWithPattern(absDir, newBaseName, r.extensions.IsImage, nil)
+	if err := unpackRawFiles(absDir, r.extensions, osFileMover{}); err != nil {
+		return err
+	}
+
+	companions, err := r.companions.findInDir(absDir, false)
+	if err != nil {
+		return fmt.Errorf("failed to read directory: %w", err)
+	}
+	isCompanion := companionSet(companions)
+	isPhoto := func(filePath string) bool {
+		return (r.extensions.IsImage(filePath) || r.extensions.IsRaw(filePath)) && !isCompanion[filepath.Base(filePath)]
+	}
+	// Thumbnails written by parse follow their image
+	renamer := r.fileRenamer.withMover(thumbnailMover{mover: osFileMover{}}).withCompanions(companionPaths(absDir, companions))
+	imageCount, err := renamer.RenameFilesWithPattern(absDir, newBaseName, isPhoto, nil)
 	if err != nil {
 		return err
 	}
@@ -126,24 +146,29 @@ func (r *directoryRenamer) renameImages(absDir, newBaseName string) error {
 		logger.Info("Renaming images", "count", imageCount, "pattern", newBaseName)
 	}
 
-	return nil
+	return packRawFiles(absDir, r.extensions, osFileMover{})
 }
 
-// renameVideos renames all video files in the videos subdirectory
-func (r *directoryRenamer) renameVideos(absDir, newBaseName string) error {
-	videosDir := filepath.Join(absDir, "videos")
-	info, err := os.Stat(videosDir)
+// renameSubdir renames all files of a class in its subdirectory, along with their sidecars
+func (r *directoryRenamer) renameSubdir(absDir, newBaseName string, class MediaClass, filter fileFilter) error {
+	subdir := filepath.Join(absDir, r.extensions.Subdir(class))
+	info, err := os.Stat(subdir)
 	if err != nil || !info.IsDir() {
 		return nil
 	}
 
-	videoCount, err := r.fileRenamer.MoveAndRenameFilesWithPattern(videosDir, videosDir, newBaseName, r.extensions.IsVideo, nil)
+	companions, err := r.companions.findInDir(subdir, false)
+	if err != nil {
+		return fmt.Errorf("failed to read directory: %w", err)
+	}
+	renamer := r.fileRenamer.withCompanions(companionPaths(subdir, companions))
+	count, err := renamer.MoveAndRenameFilesWithPattern(subdir, subdir, newBaseName, filter, nil)
 	if err != nil {
 		return err
 	}
 
-	if videoCount > 0 {
-		logger.Info("Renaming videos", "count", videoCount, "pattern", newBaseName)
+	if count > 0 {
+		logger.Info("Renaming files", "class", class, "count", count, "pattern", newBaseName)
 	}
 
 	return nil
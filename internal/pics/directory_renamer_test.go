@@ -161,6 +161,92 @@ func TestDirectoryRenamer_RenameDirectory_WithImagesAndVideos(t *testing.T) {
 	assertFilesExist(t, newVideosDir, []string{"2023_12_December_25_christmas_00001.mov"})
 }
 
+func TestDirectoryRenamer_RenameDirectory_KeepsLivePhotosTogether(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	// Create a test directory with a gap in the numbering
+	testDir := createTestDirectory(t, tmpDir, "2023 06 June 15")
+	createTestImage(t, testDir, "2023_06_June_15_00001.jpg")
+	createTestImage(t, testDir, "2023_06_June_15_00003.heic")
+	createTestVideo(t, testDir, "2023_06_June_15_00003.mov")
+
+	// A regular video keeps its own numbering
+	videosDir := createTestDirectory(t, testDir, "videos")
+	createTestVideo(t, videosDir, "2023_06_June_15_00001.mov")
+
+	renamer := NewDirectoryRenamer()
+	if err := renamer.RenameDirectory(testDir, "zoo"); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	newDirPath := filepath.Join(tmpDir, "2023 06 June 15 zoo")
+	assertFilesExist(t, newDirPath, []string{
+		"2023_06_June_15_zoo_00001.jpg",
+		"2023_06_June_15_zoo_00002.heic",
+		"2023_06_June_15_zoo_00002.mov",
+	})
+	assertFilesExist(t, filepath.Join(newDirPath, "videos"), []string{"2023_06_June_15_zoo_00001.mov"})
+}
+
+func TestDirectoryRenamer_RenameDirectory_RenamesSidecars(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	testDir := createTestDirectory(t, tmpDir, "2023 06 June 15")
+	createTestImage(t, testDir, "2023_06_June_15_00001.jpg")
+	createTestImage(t, testDir, "2023_06_June_15_00001.xmp")
+	createTestImage(t, testDir, "2023_06_June_15_00002.jpg")
+	createTestImage(t, testDir, "2023_06_June_15_00002.jpg.json")
+	videosDir := createTestDirectory(t, testDir, "videos")
+	createTestVideo(t, videosDir, "2023_06_June_15_00001.mp4")
+	createTestVideo(t, videosDir, "2023_06_June_15_00001.thm")
+
+	renamer := NewDirectoryRenamer()
+	if err := renamer.RenameDirectory(testDir, "zoo"); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	newDirPath := filepath.Join(tmpDir, "2023 06 June 15 zoo")
+	assertFilesExist(t, newDirPath, []string{
+		"2023_06_June_15_zoo_00001.jpg",
+		"2023_06_June_15_zoo_00001.xmp",
+		"2023_06_June_15_zoo_00002.jpg",
+		"2023_06_June_15_zoo_00002.jpg.json",
+	})
+	assertFilesExist(t, filepath.Join(newDirPath, "videos"), []string{
+		"2023_06_June_15_zoo_00001.mp4",
+		"2023_06_June_15_zoo_00001.thm",
+	})
+}
+
+func TestDirectoryRenamer_RenameDirectory_KeepsRawFilesWithTheirImages(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	// Create a test directory with a gap in the numbering
+	testDir := createTestDirectory(t, tmpDir, "2023 06 June 15")
+	createTestImage(t, testDir, "2023_06_June_15_00001.jpg")
+	createTestImage(t, testDir, "2023_06_June_15_00003.jpg")
+	rawDir := createTestDirectory(t, testDir, "raw")
+	createTestImage(t, rawDir, "2023_06_June_15_00003.cr2")
+	createTestImage(t, rawDir, "2023_06_June_15_00003.cr2.xmp")
+	createTestImage(t, rawDir, "2023_06_June_15_00004.nef")
+
+	renamer := NewDirectoryRenamer()
+	if err := renamer.RenameDirectory(testDir, "zoo"); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	newDirPath := filepath.Join(tmpDir, "2023 06 June 15 zoo")
+	assertFilesExist(t, newDirPath, []string{
+		"2023_06_June_15_zoo_00001.jpg",
+		"2023_06_June_15_zoo_00002.jpg",
+	})
+	assertFilesExist(t, filepath.Join(newDirPath, "raw"), []string{
+		"2023_06_June_15_zoo_00002.cr2",
+		"2023_06_June_15_zoo_00002.cr2.xmp",
+		"2023_06_June_15_zoo_00003.nef",
+	})
+}
+
 func TestDirectoryRenamer_RenameDirectory_EmptyName(t *testing.T) {
 	tmpDir := t.TempDir()
 
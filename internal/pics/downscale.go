@@ -0,0 +1,216 @@
+package pics
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"os"
+
+	"github.com/acm19/pics/internal/logger"
+)
+
+// downscaleJPEG shrinks the JPEG file at path so its long edge is at most maxLongEdge
+// pixels, re-encoding it at quality, or at the estimated quality of the file when that
+// is lower so the result does not grow. It reports whether the file was replaced;
+// smaller images and CMYK images are left as they are.
+//
+// The pixels are scaled as stored, and the APP (EXIF, XMP, ICC) and COM segments are
+// kept, so the EXIF orientation still applies to the result. Only the EXIF pixel
+// dimensions are updated and the EXIF thumbnail, which shows the original, is dropped.
+// The long edge is the same whichever way the image is displayed, so a portrait photo
+// stored sideways is limited just like a landscape one.
+func downscaleJPEG(ctx context.Context, path string, maxLongEdge, quality int) (bool, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, fmt.Errorf("file does not exist: %w", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	config, err := jpeg.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return false, fmt.Errorf("failed to decode %s: %w", path, err)
+	}
+	width, height, fits := scaledSize(config.Width, config.Height, maxLongEdge)
+	if fits {
+		return false, nil
+	}
+	if config.ColorModel == color.CMYKModel {
+		logger.Debug("Not downscaling CMYK JPEG", "path", path)
+		return false, nil
+	}
+
+	segments, err := metadataSegments(data)
+	if err != nil {
+		return false, fmt.Errorf("failed to read segments of %s: %w", path, err)
+	}
+	resizeExif(segments, width, height)
+	if source, err := jpegInfoFrom(bytes.NewReader(data)); err == nil && source.quality > 0 {
+		quality = min(quality, source.quality)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		return false, fmt.Errorf("failed to decode %s: %w", path, err)
+	}
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	resized, err := encodeWithSegments(resizeArea(img, width, height), segments, quality)
+	if err != nil {
+		return false, fmt.Errorf("failed to encode %s: %w", path, err)
+	}
+	if err := replaceFile(path, resized, info); err != nil {
+		return false, err
+	}
+	logger.Debug("Downscaled file", "path", path, "from", fmt.Sprintf("%dx%d", config.Width, config.Height), "to", fmt.Sprintf("%dx%d", width, height))
+	return true, nil
+}
+
+// resizeExif updates the EXIF segment among segments, in place, for the image resized to
+// width x height: its pixel dimensions are set to the new size and its IFD1 thumbnail is
+// unlinked. Values are overwritten where they are, so the segment keeps its length.
+func resizeExif(segments []byte, width, height int) {
+	for pos := 0; pos+4 <= len(segments); {
+		end := pos + 2 + int(binary.BigEndian.Uint16(segments[pos+2:]))
+		if end > len(segments) {
+			return
+		}
+		if segments[pos+1] == 0xE1 {
+			if tiff, found := bytes.CutPrefix(segments[pos+4:end], []byte("Exif\x00\x00")); found {
+				resizeExifTIFF(tiff, width, height)
+			}
+		}
+		pos = end
+	}
+}
+
+// resizeExifTIFF sets the image size tags of IFD0 and the EXIF IFD of a TIFF structure
+// to width x height and unlinks IFD1, the thumbnail
+func resizeExifTIFF(tiff []byte, width, height int) {
+	order, err := tiffOrder(tiff)
+	if err != nil {
+		return
+	}
+	offset := order.Uint32(tiff[4:])
+	ifd0, err := tiffEntries(tiff, order, offset)
+	if err != nil {
+		return
+	}
+	// The offset of IFD1 follows the entries of IFD0
+	if next := uint64(offset) + 2 + uint64(order.Uint16(tiff[offset:]))*12; next+4 <= uint64(len(tiff)) {
+		order.PutUint32(tiff[next:], 0)
+	}
+
+	// ImageWidth and ImageLength in IFD0, PixelXDimension and PixelYDimension in the EXIF IFD
+	setTIFFSize(ifd0, order, 0x0100, 0x0101, width, height)
+	if pointer, found := ifd0[0x8769]; found {
+		if exifIFD, err := tiffEntries(tiff, order, order.Uint32(pointer[8:])); err == nil {
+			setTIFFSize(exifIFD, order, 0xA002, 0xA003, width, height)
+		}
+	}
+}
+
+// setTIFFSize sets the single SHORT or LONG values of the width and height tags of an IFD
+func setTIFFSize(entries map[uint16][]byte, order binary.ByteOrder, widthTag, heightTag uint16, width, height int) {
+	const typeShort, typeLong = 3, 4
+	for tag, value := range map[uint16]int{widthTag: width, heightTag: height} {
+		entry, found := entries[tag]
+		if !found || order.Uint32(entry[4:]) != 1 {
+			continue
+		}
+		switch order.Uint16(entry[2:]) {
+		case typeShort:
+			order.PutUint16(entry[8:], uint16(value))
+		case typeLong:
+			order.PutUint32(entry[8:], uint32(value))
+		}
+	}
+}
+
+// scaledSize returns the size of a width x height image shrunk so its long edge is
+// maxLongEdge, keeping the aspect ratio, and whether it already fits
+func scaledSize(width, height, maxLongEdge int) (int, int, bool) {
+	long := max(width, height)
+	if maxLongEdge <= 0 || long <= maxLongEdge {
+		return width, height, true
+	}
+	scale := func(n int) int {
+		return max(1, (n*maxLongEdge+long/2)/long)
+	}
+	return scale(width), scale(height), false
+}
+
+// resizeArea shrinks img to width x height, averaging the source pixels each
+// destination pixel covers (a box filter), which avoids the aliasing of sampling
+func resizeArea(img image.Image, width, height int) *image.RGBA {
+	bounds := img.Bounds()
+	src := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+
+	// Rows are shrunk first, then columns, as the filter is separable
+	rows := image.NewRGBA(image.Rect(0, 0, width, bounds.Dy()))
+	horizontal := areaWeights(bounds.Dx(), width)
+	for y := 0; y < bounds.Dy(); y++ {
+		resample(src.Pix[y*src.Stride:], 4, rows.Pix[y*rows.Stride:], 4, horizontal)
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	vertical := areaWeights(bounds.Dy(), height)
+	for x := 0; x < width; x++ {
+		resample(rows.Pix[x*4:], rows.Stride, dst.Pix[x*4:], dst.Stride, vertical)
+	}
+	return dst
+}
+
+// areaSpan holds the weights of the source pixels, from start, that one destination pixel covers
+type areaSpan struct {
+	start   int
+	weights []float32
+}
+
+// areaWeights returns the span of source pixels each of the dstLen destination pixels
+// covers when srcLen pixels are shrunk to dstLen, weighted by how much of each they cover
+func areaWeights(srcLen, dstLen int) []areaSpan {
+	scale := float64(srcLen) / float64(dstLen)
+	spans := make([]areaSpan, dstLen)
+	for i := range spans {
+		from, to := float64(i)*scale, float64(i+1)*scale
+		start := int(from)
+		span := areaSpan{start: start}
+		for j := start; j < srcLen && float64(j) < to; j++ {
+			covered := min(to, float64(j+1)) - max(from, float64(j))
+			span.weights = append(span.weights, float32(covered/scale))
+		}
+		spans[i] = span
+	}
+	return spans
+}
+
+// resample writes one row or column of RGBA pixels into dst, stride bytes apart, each
+// the weighted average of the src pixels its span covers
+func resample(src []uint8, srcStride int, dst []uint8, dstStride int, spans []areaSpan) {
+	for i, span := range spans {
+		var r, g, b, a float32
+		for k, weight := range span.weights {
+			p := src[(span.start+k)*srcStride:]
+			r += float32(p[0]) * weight
+			g += float32(p[1]) * weight
+			b += float32(p[2]) * weight
+			a += float32(p[3]) * weight
+		}
+		q := dst[i*dstStride:]
+		q[0], q[1], q[2], q[3] = clampByte(r), clampByte(g), clampByte(b), clampByte(a)
+	}
+}
+
+// clampByte rounds v to the nearest byte
+func clampByte(v float32) uint8 {
+	return uint8(min(255, max(0, v+0.5)))
+}
@@ -0,0 +1,226 @@
+package pics
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// orientedJPEG encodes a width x height JPEG with an EXIF segment holding orientation
+func orientedJPEG(t *testing.T, width, height int, orientation uint16) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), 128, 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatalf("Failed to encode JPEG: %v", err)
+	}
+
+	// A big-endian TIFF header and an IFD with the orientation tag alone
+	exif := []byte("Exif\x00\x00MM\x00\x2A\x00\x00\x00\x08\x00\x01\x01\x12\x00\x03\x00\x00\x00\x01\x00\x00\x00\x00\x00\x00\x00\x00")
+	binary.BigEndian.PutUint16(exif[24:], orientation)
+	app1 := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(app1[2:], uint16(len(exif)+2))
+	app1 = append(app1, exif...)
+
+	return append(append([]byte{0xFF, 0xD8}, app1...), buf.Bytes()[2:]...)
+}
+
+func TestScaledSize(t *testing.T) {
+	tests := []struct {
+		name           string
+		width, height  int
+		maxLongEdge    int
+		expectedWidth  int
+		expectedHeight int
+		fits           bool
+	}{
+		{"landscape", 8000, 6000, 3840, 3840, 2880, false},
+		{"portrait", 6000, 8000, 3840, 2880, 3840, false},
+		{"already fits", 3840, 2160, 3840, 3840, 2160, true},
+		{"unlimited", 8000, 6000, 0, 8000, 6000, true},
+		{"panorama", 20000, 10, 1000, 1000, 1, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			width, height, fits := scaledSize(tt.width, tt.height, tt.maxLongEdge)
+			if width != tt.expectedWidth || height != tt.expectedHeight || fits != tt.fits {
+				t.Errorf("scaledSize(%d, %d, %d) = %d, %d, %v, want %d, %d, %v",
+					tt.width, tt.height, tt.maxLongEdge, width, height, fits, tt.expectedWidth, tt.expectedHeight, tt.fits)
+			}
+		})
+	}
+}
+
+func TestResizeArea(t *testing.T) {
+	// Alternating black and white columns average out to grey rather than aliasing
+	img := image.NewGray(image.Rect(0, 0, 8, 4))
+	for i := range img.Pix {
+		if i%2 == 0 {
+			img.Pix[i] = 255
+		}
+	}
+
+	resized := resizeArea(img, 4, 2)
+	if resized.Bounds().Dx() != 4 || resized.Bounds().Dy() != 2 {
+		t.Fatalf("Expected 4x2, got %v", resized.Bounds())
+	}
+	for i := 0; i < len(resized.Pix); i += 4 {
+		if r := resized.Pix[i]; r < 127 || r > 128 {
+			t.Fatalf("Expected grey pixels, got %d at %d", r, i/4)
+		}
+	}
+}
+
+func TestDownscaleJPEG(t *testing.T) {
+	tmpDir := t.TempDir()
+	modTime := time.Date(2023, 6, 15, 10, 30, 0, 0, time.UTC)
+
+	// Stored landscape and displayed portrait, the long edge is the same either way
+	content := orientedJPEG(t, 64, 48, 6)
+	path := createFileWithContent(t, tmpDir, "photo.jpg", content, modTime)
+
+	downscaled, err := downscaleJPEG(context.Background(), path, 32, 80)
+	if err != nil {
+		t.Fatalf("downscaleJPEG failed: %v", err)
+	}
+	if !downscaled {
+		t.Fatal("Expected the file to be downscaled")
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	}
+	config, err := jpeg.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Failed to decode downscaled file: %v", err)
+	}
+	if config.Width != 32 || config.Height != 24 {
+		t.Errorf("Expected 32x24, got %dx%d", config.Width, config.Height)
+	}
+	app1Len := int(binary.BigEndian.Uint16(content[4:])) + 2
+	if !bytes.Equal(data[2:2+app1Len], content[2:2+app1Len]) {
+		t.Error("Expected the EXIF segment, orientation included, to stay right after the SOI marker")
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Failed to stat file: %v", err)
+	}
+	if !info.ModTime().Equal(modTime) {
+		t.Errorf("Expected modification time %v, got %v", modTime, info.ModTime())
+	}
+	if entries, _ := os.ReadDir(tmpDir); len(entries) != 1 {
+		t.Errorf("Expected no temporary file left behind, got %d entries", len(entries))
+	}
+
+	// A file that already fits is left untouched
+	small := createFileWithContent(t, tmpDir, "small.jpg", content, modTime)
+	if downscaled, err := downscaleJPEG(context.Background(), small, 64, 80); err != nil || downscaled {
+		t.Errorf("Expected a fitting file to be left alone, got %v, %v", downscaled, err)
+	}
+	if data, _ := os.ReadFile(small); !bytes.Equal(data, content) {
+		t.Error("Expected a fitting file to be left untouched")
+	}
+
+	text := createFileWithContent(t, tmpDir, "text.jpg", []byte("test media content"), modTime)
+	if _, err := downscaleJPEG(context.Background(), text, 32, 80); err == nil {
+		t.Error("Expected an error for a file that is not a JPEG")
+	}
+}
+
+func TestDownscaleJPEG_UpdatesExif(t *testing.T) {
+	// IFD0 links the EXIF IFD, with the pixel dimensions, and IFD1, the thumbnail
+	tiff := []byte("MM\x00\x2A\x00\x00\x00\x08")
+	tiff = append(tiff, 0x00, 0x01, 0x87, 0x69, 0x00, 0x04, 0, 0, 0, 1, 0, 0, 0, 26, 0, 0, 0, 56)
+	tiff = append(tiff, 0x00, 0x02,
+		0xA0, 0x02, 0x00, 0x04, 0, 0, 0, 1, 0, 0, 0, 64,
+		0xA0, 0x03, 0x00, 0x03, 0, 0, 0, 1, 0, 48, 0, 0,
+		0, 0, 0, 0)
+	tiff = append(tiff, 0x00, 0x01, 0x01, 0x03, 0x00, 0x03, 0, 0, 0, 1, 0, 6, 0, 0, 0, 0, 0, 0, 0, 0)
+
+	// Encoded below the target quality, which the downscaled file does not go above
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, image.NewGray(image.Rect(0, 0, 64, 48)), &jpeg.Options{Quality: 40}); err != nil {
+		t.Fatalf("Failed to encode JPEG: %v", err)
+	}
+	content := withExif(encoded.Bytes(), tiff)
+	path := createFileWithContent(t, t.TempDir(), "photo.jpg", content, time.Now())
+
+	if downscaled, err := downscaleJPEG(context.Background(), path, 32, 90); err != nil || !downscaled {
+		t.Fatalf("Expected the file to be downscaled, got %v, %v", downscaled, err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	}
+	resized := data[4+2+6 : 4+2+6+len(tiff)]
+	order := binary.BigEndian
+	if next := order.Uint32(resized[22:]); next != 0 {
+		t.Errorf("Expected the thumbnail to be unlinked, IFD1 is at %d", next)
+	}
+	if width := order.Uint32(resized[36:]); width != 32 {
+		t.Errorf("Expected PixelXDimension 32, got %d", width)
+	}
+	if height := order.Uint16(resized[48:]); height != 24 {
+		t.Errorf("Expected PixelYDimension 24, got %d", height)
+	}
+	if info, err := readJPEGInfo(path); err != nil || info.quality > 41 {
+		t.Errorf("Expected the estimated quality of 40 to be kept, got %+v, %v", info, err)
+	}
+}
+
+func TestMediaParser_Parse_DownscalesJPEGs(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir, targetDir := createSourceAndTarget(t, tmpDir)
+	testDate := time.Date(2023, 6, 15, 10, 30, 0, 0, time.UTC)
+	createFileWithContent(t, sourceDir, "IMG_0001.jpg", orientedJPEG(t, 64, 48, 1), testDate)
+	createFileWithContent(t, sourceDir, "IMG_0002.jpg", orientedJPEG(t, 16, 12, 1), testDate)
+
+	opts := testParseOptions
+	opts.MaxLongEdge = 32
+	opts.CompressJPEGs = true
+	opts.JPEGQuality = 50
+	// The fitting file is still compressed, in Go so the test runs without jpegoptim
+	opts.Compressor = CompressorGo
+	result, err := testParser.Parse(sourceDir, targetDir, opts)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	dateDir := filepath.Join(targetDir, "2023 06 June 15")
+	expected := map[string]int{"2023_06_June_15_00001.jpg": 32, "2023_06_June_15_00002.jpg": 16}
+	for name, width := range expected {
+		path := filepath.Join(dateDir, name)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", name, err)
+		}
+		config, err := jpeg.DecodeConfig(bytes.NewReader(data))
+		if err != nil || config.Width != width {
+			t.Errorf("Expected %s to be %d pixels wide, got %d (%v)", name, width, config.Width, err)
+		}
+		if info, err := readJPEGInfo(path); err != nil || info.markedQuality == 0 {
+			t.Errorf("Expected %s to be marked as compressed, got %+v, %v", name, info, err)
+		}
+	}
+	for _, file := range result.Files {
+		if !file.Compressed {
+			t.Errorf("Expected %s to be reported as compressed", file.Source)
+		}
+	}
+}
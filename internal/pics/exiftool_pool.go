@@ -0,0 +1,214 @@
+package pics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"runtime"
+	"sync"
+
+	"github.com/acm19/pics/internal/logger"
+	"github.com/barasher/go-exiftool"
+)
+
+const (
+	// exiftoolBatchSize is the largest number of files read in a single exiftool request
+	exiftoolBatchSize = 64
+)
+
+// errExiftoolPoolClosed is returned for requests made after the pool was closed
+var errExiftoolPoolClosed = errors.New("exiftool pool is closed")
+
+// exiftoolPool runs up to size long-lived exiftool processes in -stay_open mode, so
+// reading metadata costs a request to a running process rather than starting a Perl
+// interpreter per file. Processes are started on first use and stopped by close.
+type exiftoolPool struct {
+	ctx          context.Context
+	exiftoolPath string
+	// slots holds a token for every process in use, so at most size run at once
+	slots chan struct{}
+
+	mu       sync.Mutex
+	idle     []*exiftool.Exiftool
+	startErr error
+	closed   bool
+}
+
+// newExiftoolPool creates a pool of up to size exiftool processes. Requests stop being
+// sent once ctx is done. An empty exiftoolPath runs exiftool from PATH.
+func newExiftoolPool(ctx context.Context, exiftoolPath string, size int) *exiftoolPool {
+	return &exiftoolPool{
+		ctx:          ctx,
+		exiftoolPath: exiftoolPath,
+		slots:        make(chan struct{}, max(1, size)),
+	}
+}
+
+// exiftoolPoolSize returns the number of exiftool processes for a parse that handles
+// maxConcurrency files at once (0 = unlimited). Each process keeps a core busy, so there
+// are never more than there are CPUs.
+func exiftoolPoolSize(maxConcurrency int) int {
+	if maxConcurrency <= 0 {
+		return runtime.NumCPU()
+	}
+	return min(maxConcurrency, runtime.NumCPU())
+}
+
+// extractMetadata reads the metadata of paths, splitting them into batches read by the
+// processes of the pool at the same time. A file that cannot be read has its Err set.
+func (p *exiftoolPool) extractMetadata(paths []string) []exiftool.FileMetadata {
+	if len(paths) == 0 {
+		return nil
+	}
+
+	// Batches are spread evenly over the processes, and kept small enough that a
+	// cancelled parse stops soon after
+	size := min(exiftoolBatchSize, max(1, (len(paths)+cap(p.slots)-1)/cap(p.slots)))
+	var batches [][]int
+	for start := 0; start < len(paths); start += size {
+		batch := make([]int, 0, size)
+		for i := start; i < min(start+size, len(paths)); i++ {
+			batch = append(batch, i)
+		}
+		batches = append(batches, batch)
+	}
+
+	results := make([]exiftool.FileMetadata, len(paths))
+	runWorkerPool(batches, cap(p.slots), func(batch []int) error {
+		files := make([]string, len(batch))
+		for k, i := range batch {
+			files[k] = paths[i]
+		}
+		for k, fileInfo := range p.extractBatch(files) {
+			results[batch[k]] = fileInfo
+		}
+		return nil
+	})
+	return results
+}
+
+// extractBatch reads the metadata of files with a single process of the pool
+func (p *exiftoolPool) extractBatch(files []string) []exiftool.FileMetadata {
+	et, err := p.acquire()
+	if err != nil {
+		results := make([]exiftool.FileMetadata, len(files))
+		for i, file := range files {
+			results[i] = exiftool.FileMetadata{File: file, Err: err}
+		}
+		return results
+	}
+	results := et.ExtractMetadata(files...)
+	p.release(et, exiftoolBroken(results))
+	return results
+}
+
+// exiftoolBroken reports whether the process that returned results can no longer be used.
+// Once it fails to answer, because it exited or its output did not fit the buffer, every
+// later request fails too, so the last file it was asked about tells.
+func exiftoolBroken(results []exiftool.FileMetadata) bool {
+	for i := len(results) - 1; i >= 0; i-- {
+		// Files that cannot be stat'ed never reach the process, a failed write to it does
+		var pathErr *fs.PathError
+		if err := results[i].Err; errors.Is(err, exiftool.ErrNotExist) || errors.Is(err, exiftool.ErrNotFile) || (errors.As(err, &pathErr) && pathErr.Op != "write") {
+			continue
+		}
+		return results[i].Err != nil
+	}
+	return false
+}
+
+// acquire returns an idle process, starting one if fewer than size are running, and
+// waits for one to be released otherwise
+func (p *exiftoolPool) acquire() (*exiftool.Exiftool, error) {
+	if err := p.ctx.Err(); err != nil {
+		return nil, err
+	}
+	select {
+	case p.slots <- struct{}{}:
+	case <-p.ctx.Done():
+		return nil, p.ctx.Err()
+	}
+
+	p.mu.Lock()
+	switch {
+	case p.closed:
+		p.mu.Unlock()
+		<-p.slots
+		return nil, errExiftoolPoolClosed
+	case p.startErr != nil:
+		// exiftool is missing or broken, there is no point in starting it for every file
+		p.mu.Unlock()
+		<-p.slots
+		return nil, p.startErr
+	case len(p.idle) > 0:
+		et := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		p.mu.Unlock()
+		return et, nil
+	}
+	p.mu.Unlock()
+
+	et, err := p.start()
+	if err != nil {
+		logger.Warn("Cannot run exiftool, metadata will not be read for the rest of the run", "error", err)
+		p.mu.Lock()
+		p.startErr = err
+		p.mu.Unlock()
+		<-p.slots
+		return nil, err
+	}
+	return et, nil
+}
+
+// release returns a process to the pool, or stops it if it is broken or the pool was
+// closed meanwhile. A broken process is replaced on the next request.
+func (p *exiftoolPool) release(et *exiftool.Exiftool, broken bool) {
+	p.mu.Lock()
+	if broken || p.closed {
+		p.mu.Unlock()
+		closeExiftool(et)
+	} else {
+		p.idle = append(p.idle, et)
+		p.mu.Unlock()
+	}
+	<-p.slots
+}
+
+// start starts a new exiftool process
+func (p *exiftoolPool) start() (*exiftool.Exiftool, error) {
+	var et *exiftool.Exiftool
+	var err error
+	if p.exiftoolPath != "" {
+		et, err = exiftool.NewExiftool(exiftool.SetExiftoolBinaryPath(p.exiftoolPath))
+	} else {
+		et, err = exiftool.NewExiftool()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to start exiftool: %w", err)
+	}
+	logger.Debug("Started exiftool process", "running", len(p.slots))
+	return et, nil
+}
+
+// close stops the idle processes, and the busy ones as they are released. Requests
+// made afterwards fail.
+func (p *exiftoolPool) close() {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.closed = true
+	p.mu.Unlock()
+
+	for _, et := range idle {
+		closeExiftool(et)
+	}
+}
+
+// closeExiftool stops an exiftool process, logging rather than returning a failure as
+// the metadata it read is not affected
+func closeExiftool(et *exiftool.Exiftool) {
+	if err := et.Close(); err != nil {
+		logger.Debug("Failed to stop exiftool", "error", err)
+	}
+}
@@ -0,0 +1,238 @@
+package pics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/barasher/go-exiftool"
+)
+
+// fakeExiftool is a shell script that answers -stay_open requests like exiftool, with a
+// CreateDate of 2023-06-15 10:30 for every file, and records every process started
+const fakeExiftool = `#!/bin/sh
+echo started >> "$0.starts"
+while IFS= read -r line; do
+  case "$line" in
+    -j) ;;
+    -stay_open) ;;
+    False) exit 0 ;;
+    -execute) printf '[{"SourceFile":"%s","CreateDate":"2023:06:15 10:30:00"}]\n{ready}\n' "$file" ;;
+    *) file="$line" ;;
+  esac
+done
+`
+
+// writeFakeExiftool writes fakeExiftool into dir and returns its path
+func writeFakeExiftool(t *testing.T, dir string) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("fake exiftool is a shell script, skipping test")
+	}
+	path := filepath.Join(dir, "exiftool")
+	if err := os.WriteFile(path, []byte(fakeExiftool), 0755); err != nil {
+		t.Fatalf("Failed to write fake exiftool: %v", err)
+	}
+	return path
+}
+
+// exiftoolStarts returns the number of processes the fake exiftool at path started
+func exiftoolStarts(t *testing.T, path string) int {
+	t.Helper()
+	data, err := os.ReadFile(path + ".starts")
+	if err != nil {
+		return 0
+	}
+	return strings.Count(string(data), "started")
+}
+
+func TestExiftoolPool_ExtractMetadata(t *testing.T) {
+	tmpDir := t.TempDir()
+	exiftoolPath := writeFakeExiftool(t, tmpDir)
+	var paths []string
+	for i := range 10 {
+		paths = append(paths, createMediaFile(t, tmpDir, fmt.Sprintf("IMG_%04d.jpg", i), time.Now()))
+	}
+	paths = append(paths, filepath.Join(tmpDir, "missing.jpg"))
+
+	pool := newExiftoolPool(context.Background(), exiftoolPath, 2)
+	for range 2 {
+		results := pool.extractMetadata(paths)
+		if len(results) != len(paths) {
+			t.Fatalf("Expected %d results, got %d", len(paths), len(results))
+		}
+		for i, fileInfo := range results[:10] {
+			if fileInfo.File != paths[i] || fileInfo.Err != nil {
+				t.Errorf("Expected metadata of %s, got %s, %v", paths[i], fileInfo.File, fileInfo.Err)
+			}
+			if date, err := fileInfo.GetString("CreateDate"); err != nil || date != "2023:06:15 10:30:00" {
+				t.Errorf("Expected CreateDate of %s, got %q, %v", paths[i], date, err)
+			}
+		}
+		if !errors.Is(results[10].Err, exiftool.ErrNotExist) {
+			t.Errorf("Expected a missing file error, got %v", results[10].Err)
+		}
+	}
+
+	// Both requests were served by the same two processes
+	if starts := exiftoolStarts(t, exiftoolPath); starts != 2 {
+		t.Errorf("Expected 2 exiftool processes, got %d", starts)
+	}
+
+	pool.close()
+	if results := pool.extractMetadata(paths[:1]); !errors.Is(results[0].Err, errExiftoolPoolClosed) {
+		t.Errorf("Expected requests to fail once the pool is closed, got %v", results[0].Err)
+	}
+}
+
+func TestExiftoolPool_MissingExiftool(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := createMediaFile(t, tmpDir, "IMG_0001.jpg", time.Now())
+
+	pool := newExiftoolPool(context.Background(), filepath.Join(tmpDir, "missing-exiftool"), 2)
+	defer pool.close()
+	for _, fileInfo := range pool.extractMetadata([]string{path, path, path}) {
+		if fileInfo.Err == nil {
+			t.Error("Expected an error without exiftool")
+		}
+	}
+}
+
+func TestExiftoolPool_Cancelled(t *testing.T) {
+	tmpDir := t.TempDir()
+	exiftoolPath := writeFakeExiftool(t, tmpDir)
+	path := createMediaFile(t, tmpDir, "IMG_0001.jpg", time.Now())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	pool := newExiftoolPool(ctx, exiftoolPath, 1)
+	defer pool.close()
+	if results := pool.extractMetadata([]string{path}); !errors.Is(results[0].Err, context.Canceled) {
+		t.Errorf("Expected a cancelled request, got %v", results[0].Err)
+	}
+	if starts := exiftoolStarts(t, exiftoolPath); starts != 0 {
+		t.Errorf("Expected no exiftool process, got %d", starts)
+	}
+}
+
+func TestExiftoolBroken(t *testing.T) {
+	answered := exiftool.FileMetadata{File: "a.jpg"}
+	missing := exiftool.FileMetadata{File: "b.jpg", Err: exiftool.ErrNotExist}
+	unreadable := exiftool.FileMetadata{File: "c.jpg", Err: &fs.PathError{Op: "stat", Path: "c.jpg", Err: fs.ErrPermission}}
+	noAnswer := exiftool.FileMetadata{File: "d.jpg", Err: errors.New("error while reading stdMergedOut: EOF")}
+	brokenPipe := exiftool.FileMetadata{File: "e.jpg", Err: &fs.PathError{Op: "write", Path: "|1", Err: errors.New("broken pipe")}}
+
+	tests := []struct {
+		name     string
+		results  []exiftool.FileMetadata
+		expected bool
+	}{
+		{"answered", []exiftool.FileMetadata{answered, answered}, false},
+		{"files that never reached it", []exiftool.FileMetadata{answered, missing, unreadable}, false},
+		{"only missing files", []exiftool.FileMetadata{missing}, false},
+		{"stopped answering", []exiftool.FileMetadata{answered, noAnswer, missing}, true},
+		{"exited", []exiftool.FileMetadata{brokenPipe}, true},
+		{"empty", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if broken := exiftoolBroken(tt.results); broken != tt.expected {
+				t.Errorf("Expected broken %v, got %v", tt.expected, broken)
+			}
+		})
+	}
+}
+
+func TestFileOrganiser_OrganiseByDate_ExiftoolPool(t *testing.T) {
+	tmpDir := t.TempDir()
+	exiftoolPath := writeFakeExiftool(t, tmpDir)
+	sourceDir, targetDir := createSourceAndTarget(t, tmpDir)
+	modTime := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i := range 20 {
+		createMediaFile(t, sourceDir, fmt.Sprintf("IMG_%04d.jpg", i), modTime)
+	}
+
+	organiser, stop := NewFileOrganiserWithPaths(exiftoolPath).withExiftoolPool(context.Background(), 3)
+	dirNames, err := organiser.OrganiseByDate(sourceDir, targetDir, nil)
+	stop()
+	if err != nil {
+		t.Fatalf("OrganiseByDate failed: %v", err)
+	}
+
+	// Dated by exiftool rather than by modification time, with no more processes than the pool holds
+	if len(dirNames) != 1 || dirNames[0] != "2023 06 June 15" {
+		t.Errorf("Expected files dated by exiftool, got %v", dirNames)
+	}
+	if starts := exiftoolStarts(t, exiftoolPath); starts < 1 || starts > 3 {
+		t.Errorf("Expected 1 to 3 exiftool processes, got %d", starts)
+	}
+}
+
+// benchmarkExiftool returns exiftool from PATH, skipping the benchmark when it is not
+// installed: what the pool saves is the start-up of exiftool itself, which a fake one
+// does not have
+func benchmarkExiftool(b *testing.B) string {
+	b.Helper()
+	path, err := exec.LookPath("exiftool")
+	if err != nil {
+		b.Skip("exiftool is not installed")
+	}
+	return path
+}
+
+// benchmarkFiles creates n JPEG files for the date extraction benchmarks
+func benchmarkFiles(b *testing.B, dir string, n int) []string {
+	b.Helper()
+	content := encodeJPEG(b, 80)
+	paths := make([]string, n)
+	for i := range paths {
+		paths[i] = filepath.Join(dir, fmt.Sprintf("IMG_%04d.jpg", i))
+		if err := os.WriteFile(paths[i], content, 0644); err != nil {
+			b.Fatalf("Failed to create file: %v", err)
+		}
+	}
+	return paths
+}
+
+// BenchmarkExifDateExtractor_PerFile dates files the way it is done without a pool,
+// starting and stopping exiftool for every file
+func BenchmarkExifDateExtractor_PerFile(b *testing.B) {
+	tmpDir := b.TempDir()
+	extractor := newExifDateExtractorWithPath(benchmarkExiftool(b))
+	paths := benchmarkFiles(b, tmpDir, 50)
+
+	b.ResetTimer()
+	for range b.N {
+		for _, path := range paths {
+			extractor.getFileDate(path)
+		}
+	}
+}
+
+// BenchmarkExifDateExtractor_Pool dates the same files as a parse does, reading them
+// ahead in batches through a pool of long-lived exiftool processes
+func BenchmarkExifDateExtractor_Pool(b *testing.B) {
+	tmpDir := b.TempDir()
+	exiftoolPath := benchmarkExiftool(b)
+	paths := benchmarkFiles(b, tmpDir, 50)
+
+	b.ResetTimer()
+	for range b.N {
+		pool := newExiftoolPool(context.Background(), exiftoolPath, exiftoolPoolSize(0))
+		extractor := newExifDateExtractorWithPool(pool)
+		extractor.prefetch(paths)
+		for _, path := range paths {
+			extractor.getFileDate(path)
+		}
+		pool.close()
+	}
+}
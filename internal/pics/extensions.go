@@ -1,56 +1,248 @@
 package pics
 
 import (
+	"encoding/json"
+	"fmt"
+	"os"
 	"path/filepath"
-	"slices"
 	"strings"
+	"sync"
 )
 
+// MediaClass is a kind of file the extension registry knows about.
+type MediaClass string
+
+const (
+	// ClassImage files are numbered in the date directory itself.
+	ClassImage MediaClass = "image"
+	// ClassVideo files are numbered in their own subdirectory.
+	ClassVideo MediaClass = "video"
+	// ClassRaw files share the image numbering and are kept in their own subdirectory.
+	ClassRaw MediaClass = "raw"
+	// ClassSidecar files describe a media file and follow it wherever it goes.
+	ClassSidecar MediaClass = "sidecar"
+	// ClassScreenshot files are numbered in their own subdirectory.
+	ClassScreenshot MediaClass = "screenshot"
+)
+
+// MediaType registers a group of extensions under a media class.
+type MediaType struct {
+	// Class is the media class of the files.
+	Class MediaClass `json:"class"`
+	// Extensions are the file extensions, with or without the leading dot.
+	Extensions []string `json:"extensions"`
+	// Subdir is the subdirectory of a date directory that holds the class. It is
+	// set once per class, only for the classes kept in a subdirectory.
+	Subdir string `json:"subdir,omitempty"`
+	// Compressible files are handed to the JPEG compressor.
+	Compressible bool `json:"compressible,omitempty"`
+}
+
+// ExtensionsConfig is the content of an extension registry config file.
+type ExtensionsConfig struct {
+	// Media types added to the built-in ones. An extension registered again
+	// moves to its new media type.
+	Media []MediaType `json:"media"`
+}
+
+// defaultMediaTypes are the media types every registry starts from.
+var defaultMediaTypes = []MediaType{
+	{Class: ClassImage, Extensions: []string{".jpg", ".jpeg"}, Compressible: true},
+	{Class: ClassImage, Extensions: []string{".heic"}},
+	{Class: ClassVideo, Extensions: []string{".mov", ".mp4"}, Subdir: "videos"},
+	{Class: ClassRaw, Extensions: []string{".dng", ".cr2", ".cr3", ".nef", ".arw"}, Subdir: "raw"},
+	{Class: ClassSidecar, Extensions: []string{".xmp", ".aae", ".thm", ".json"}},
+	{Class: ClassScreenshot, Subdir: "screenshots"},
+}
+
 // Extensions defines the interface for file extension operations.
 type Extensions interface {
 	// IsImage returns true if the file extension is a supported image format.
 	IsImage(filePath string) bool
 	// IsVideo returns true if the file extension is a supported video format.
 	IsVideo(filePath string) bool
+	// IsRaw returns true if the file extension is a supported camera RAW format.
+	IsRaw(filePath string) bool
+	// IsScreenshot returns true if the file extension is registered as a screenshot format.
+	IsScreenshot(filePath string) bool
 	// IsSupported returns true if the file extension is any supported media format.
 	IsSupported(filePath string) bool
-	// IsJPEG returns true if the file extension is JPEG (jpg or jpeg).
-	IsJPEG(filePath string) bool
+	// IsSidecar returns true if the file extension is a sidecar that describes a media file.
+	IsSidecar(filePath string) bool
+	// IsCompressible returns true if the file extension is handed to the JPEG compressor.
+	IsCompressible(filePath string) bool
+	// Class returns the media class of a file, or an empty class when its extension is not registered.
+	Class(filePath string) MediaClass
+	// Subdir returns the subdirectory of a date directory that holds the given class,
+	// or an empty string for the classes kept in the date directory itself.
+	Subdir(class MediaClass) string
 }
 
 // extensions implements the Extensions interface.
 type extensions struct {
-	imageExts []string
-	videoExts []string
+	types   map[string]MediaType
+	subdirs map[MediaClass]string
 }
 
-// NewExtensions creates a new Extensions instance.
+var (
+	defaultExtensionsMu sync.RWMutex
+	defaultExtensions   Extensions = mustNewRegistry(nil)
+)
+
+// NewExtensions returns the extension registry in use, the built-in one unless
+// SetDefaultExtensions replaced it.
 func NewExtensions() Extensions {
-	return &extensions{
-		imageExts: []string{".jpg", ".jpeg", ".heic"},
-		videoExts: []string{".mov", ".mp4"},
+	defaultExtensionsMu.RLock()
+	defer defaultExtensionsMu.RUnlock()
+	return defaultExtensions
+}
+
+// SetDefaultExtensions makes every component created afterwards use the given registry.
+func SetDefaultExtensions(ext Extensions) {
+	defaultExtensionsMu.Lock()
+	defer defaultExtensionsMu.Unlock()
+	defaultExtensions = ext
+}
+
+// NewExtensionsWithConfig creates a registry with the built-in media types and the ones in config.
+func NewExtensionsWithConfig(config ExtensionsConfig) (Extensions, error) {
+	return newRegistry(config.Media)
+}
+
+// LoadExtensions reads an extension registry config file (JSON) and creates a
+// registry with the built-in media types and the ones in the file.
+func LoadExtensions(path string) (Extensions, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read extensions config: %w", err)
+	}
+	var config ExtensionsConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse extensions config %s: %w", path, err)
 	}
+	ext, err := NewExtensionsWithConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid extensions config %s: %w", path, err)
+	}
+	return ext, nil
+}
+
+// DefaultExtensionsConfigPath returns where the extension registry config file is
+// looked up when none is given: pics/extensions.json in the user config directory.
+func DefaultExtensionsConfigPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "pics", "extensions.json"), nil
+}
+
+func mustNewRegistry(types []MediaType) Extensions {
+	ext, err := newRegistry(types)
+	if err != nil {
+		panic(err)
+	}
+	return ext
+}
+
+// newRegistry registers the built-in media types followed by the given ones
+func newRegistry(types []MediaType) (*extensions, error) {
+	e := &extensions{
+		types:   make(map[string]MediaType),
+		subdirs: make(map[MediaClass]string),
+	}
+	for _, mediaType := range append(append([]MediaType{}, defaultMediaTypes...), types...) {
+		if err := e.register(mediaType); err != nil {
+			return nil, err
+		}
+	}
+
+	dirs := make(map[string]MediaClass)
+	for class, subdir := range e.subdirs {
+		if other, taken := dirs[strings.ToLower(subdir)]; taken {
+			return nil, fmt.Errorf("classes %s and %s share the subdirectory %s", other, class, subdir)
+		}
+		dirs[strings.ToLower(subdir)] = class
+	}
+	return e, nil
+}
+
+// register adds the extensions of a media type, replacing earlier registrations
+func (e *extensions) register(mediaType MediaType) error {
+	switch mediaType.Class {
+	case ClassImage, ClassSidecar:
+		if mediaType.Subdir != "" {
+			return fmt.Errorf("%s files cannot be kept in a subdirectory", mediaType.Class)
+		}
+	case ClassVideo, ClassRaw, ClassScreenshot:
+		if mediaType.Subdir != "" {
+			if mediaType.Subdir != filepath.Base(mediaType.Subdir) || mediaType.Subdir == "." || mediaType.Subdir == ".." || strings.HasPrefix(mediaType.Subdir, ".") {
+				return fmt.Errorf("invalid subdirectory for %s files: %q", mediaType.Class, mediaType.Subdir)
+			}
+			e.subdirs[mediaType.Class] = mediaType.Subdir
+		}
+	default:
+		return fmt.Errorf("unknown media class %q", mediaType.Class)
+	}
+	if mediaType.Compressible && mediaType.Class == ClassSidecar {
+		return fmt.Errorf("sidecar files cannot be compressible")
+	}
+
+	for _, ext := range mediaType.Extensions {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		if ext == "." || strings.ContainsAny(ext[1:], `./\`) {
+			return fmt.Errorf("invalid extension %q for %s files", ext, mediaType.Class)
+		}
+		e.types[ext] = mediaType
+	}
+	return nil
+}
+
+// Class returns the media class of a file, or an empty class when its extension is not registered.
+func (e *extensions) Class(filePath string) MediaClass {
+	return e.types[strings.ToLower(filepath.Ext(filePath))].Class
 }
 
 // IsImage returns true if the file extension is a supported image format.
 func (e *extensions) IsImage(filePath string) bool {
-	ext := strings.ToLower(filepath.Ext(filePath))
-	return slices.Contains(e.imageExts, ext)
+	return e.Class(filePath) == ClassImage
 }
 
 // IsVideo returns true if the file extension is a supported video format.
 func (e *extensions) IsVideo(filePath string) bool {
-	ext := strings.ToLower(filepath.Ext(filePath))
-	return slices.Contains(e.videoExts, ext)
+	return e.Class(filePath) == ClassVideo
+}
+
+// IsRaw returns true if the file extension is a supported camera RAW format.
+func (e *extensions) IsRaw(filePath string) bool {
+	return e.Class(filePath) == ClassRaw
+}
+
+// IsScreenshot returns true if the file extension is registered as a screenshot format.
+func (e *extensions) IsScreenshot(filePath string) bool {
+	return e.Class(filePath) == ClassScreenshot
 }
 
 // IsSupported returns true if the file extension is any supported media format.
 func (e *extensions) IsSupported(filePath string) bool {
-	return e.IsImage(filePath) || e.IsVideo(filePath)
+	class := e.Class(filePath)
+	return class != "" && class != ClassSidecar
+}
+
+// IsSidecar returns true if the file extension is a sidecar that describes a media file.
+func (e *extensions) IsSidecar(filePath string) bool {
+	return e.Class(filePath) == ClassSidecar
+}
+
+// IsCompressible returns true if the file extension is handed to the JPEG compressor.
+func (e *extensions) IsCompressible(filePath string) bool {
+	return e.types[strings.ToLower(filepath.Ext(filePath))].Compressible
 }
 
-// IsJPEG returns true if the file extension is JPEG (jpg or jpeg).
-func (e *extensions) IsJPEG(filePath string) bool {
-	ext := strings.ToLower(filepath.Ext(filePath))
-	return ext == ".jpg" || ext == ".jpeg"
+// Subdir returns the subdirectory of a date directory that holds the given class.
+func (e *extensions) Subdir(class MediaClass) string {
+	return e.subdirs[class]
 }
@@ -1,7 +1,10 @@
 package pics
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestExtensions_IsImage(t *testing.T) {
@@ -60,6 +63,32 @@ func TestExtensions_IsVideo(t *testing.T) {
 	}
 }
 
+func TestExtensions_IsRaw(t *testing.T) {
+	ext := NewExtensions()
+
+	tests := []struct {
+		filePath string
+		expected bool
+	}{
+		{"photo.dng", true},
+		{"photo.CR2", true},
+		{"photo.cr3", true},
+		{"photo.NEF", true},
+		{"photo.arw", true},
+		{"photo.jpg", false},
+		{"photo.heic", false},
+		{"video.mov", false},
+		{"/path/to/photo.nef", true},
+	}
+
+	for _, tt := range tests {
+		result := ext.IsRaw(tt.filePath)
+		if result != tt.expected {
+			t.Errorf("IsRaw(%s) = %v, expected %v", tt.filePath, result, tt.expected)
+		}
+	}
+}
+
 func TestExtensions_IsSupported(t *testing.T) {
 	ext := NewExtensions()
 
@@ -75,6 +104,9 @@ func TestExtensions_IsSupported(t *testing.T) {
 		{"video.mov", true},
 		{"video.mp4", true},
 		{"video.MP4", true},
+		// RAW
+		{"photo.dng", true},
+		{"photo.CR3", true},
 		// Unsupported
 		{"document.txt", false},
 		{"file.png", false},
@@ -92,7 +124,33 @@ func TestExtensions_IsSupported(t *testing.T) {
 	}
 }
 
-func TestExtensions_IsJPEG(t *testing.T) {
+func TestExtensions_IsSidecar(t *testing.T) {
+	ext := NewExtensions()
+
+	tests := []struct {
+		filePath string
+		expected bool
+	}{
+		{"photo.xmp", true},
+		{"photo.JPG.xmp", true},
+		{"IMG_0001.AAE", true},
+		{"clip.THM", true},
+		{"photo.jpg.json", true},
+		{"photo.jpg", false},
+		{"video.mov", false},
+		{"document.txt", false},
+		{"/path/to/photo.xmp", true},
+	}
+
+	for _, tt := range tests {
+		result := ext.IsSidecar(tt.filePath)
+		if result != tt.expected {
+			t.Errorf("IsSidecar(%s) = %v, expected %v", tt.filePath, result, tt.expected)
+		}
+	}
+}
+
+func TestExtensions_IsCompressible(t *testing.T) {
 	ext := NewExtensions()
 
 	tests := []struct {
@@ -112,9 +170,9 @@ func TestExtensions_IsJPEG(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		result := ext.IsJPEG(tt.filePath)
+		result := ext.IsCompressible(tt.filePath)
 		if result != tt.expected {
-			t.Errorf("IsJPEG(%s) = %v, expected %v", tt.filePath, result, tt.expected)
+			t.Errorf("IsCompressible(%s) = %v, expected %v", tt.filePath, result, tt.expected)
 		}
 	}
 }
@@ -154,8 +212,132 @@ func TestExtensions_NoExtension(t *testing.T) {
 		if ext.IsSupported(filePath) {
 			t.Errorf("IsSupported(%s) should be false for file without extension", filePath)
 		}
-		if ext.IsJPEG(filePath) {
-			t.Errorf("IsJPEG(%s) should be false for file without extension", filePath)
+		if ext.IsCompressible(filePath) {
+			t.Errorf("IsCompressible(%s) should be false for file without extension", filePath)
 		}
 	}
 }
+
+func TestExtensions_Subdir(t *testing.T) {
+	ext := NewExtensions()
+
+	tests := []struct {
+		class    MediaClass
+		expected string
+	}{
+		{ClassImage, ""},
+		{ClassVideo, "videos"},
+		{ClassRaw, "raw"},
+		{ClassSidecar, ""},
+		{ClassScreenshot, "screenshots"},
+	}
+
+	for _, tt := range tests {
+		if result := ext.Subdir(tt.class); result != tt.expected {
+			t.Errorf("Subdir(%s) = %q, expected %q", tt.class, result, tt.expected)
+		}
+	}
+}
+
+func TestLoadExtensions(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "extensions.json")
+	config := `{
+		"media": [
+			{"class": "image", "extensions": [".png", "GIF", ".webp"]},
+			{"class": "video", "extensions": [".avi", ".mkv", ".3gp", ".m4v"], "subdir": "clips"},
+			{"class": "screenshot", "extensions": [".png"]}
+		]
+	}`
+	if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	ext, err := LoadExtensions(configPath)
+	if err != nil {
+		t.Fatalf("LoadExtensions failed: %v", err)
+	}
+
+	if !ext.IsImage("photo.GIF") || !ext.IsImage("photo.webp") {
+		t.Error("Expected GIF and WebP to be images")
+	}
+	// Registered again, PNG moves to the screenshots
+	if ext.IsImage("screen.png") || !ext.IsScreenshot("screen.png") {
+		t.Error("Expected PNG to be a screenshot")
+	}
+	for _, name := range []string{"video.avi", "video.MKV", "video.3gp", "video.m4v", "video.mov"} {
+		if !ext.IsVideo(name) {
+			t.Errorf("Expected %s to be a video", name)
+		}
+	}
+	if ext.Subdir(ClassVideo) != "clips" {
+		t.Errorf("Expected videos in clips, got %q", ext.Subdir(ClassVideo))
+	}
+	// Built-in media types are kept
+	if !ext.IsCompressible("photo.jpg") || ext.IsCompressible("photo.webp") || !ext.IsRaw("photo.dng") {
+		t.Error("Expected the built-in media types to be kept")
+	}
+}
+
+func TestLoadExtensions_Invalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+	}{
+		{"malformed JSON", `{"media": [`},
+		{"unknown class", `{"media": [{"class": "audio", "extensions": [".mp3"]}]}`},
+		{"image subdirectory", `{"media": [{"class": "image", "extensions": [".png"], "subdir": "png"}]}`},
+		{"nested subdirectory", `{"media": [{"class": "video", "subdir": "a/b"}]}`},
+		{"shared subdirectory", `{"media": [{"class": "screenshot", "subdir": "videos"}]}`},
+		{"empty extension", `{"media": [{"class": "image", "extensions": [""]}]}`},
+		{"compressible sidecar", `{"media": [{"class": "sidecar", "extensions": [".txt"], "compressible": true}]}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configPath := filepath.Join(t.TempDir(), "extensions.json")
+			if err := os.WriteFile(configPath, []byte(tt.config), 0644); err != nil {
+				t.Fatalf("Failed to write config: %v", err)
+			}
+			if _, err := LoadExtensions(configPath); err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+}
+
+func TestMediaParser_Parse_ConfiguredExtensions(t *testing.T) {
+	ext, err := NewExtensionsWithConfig(ExtensionsConfig{Media: []MediaType{
+		{Class: ClassImage, Extensions: []string{".webp"}},
+		{Class: ClassVideo, Extensions: []string{".mkv"}},
+		{Class: ClassScreenshot, Extensions: []string{".png"}},
+	}})
+	if err != nil {
+		t.Fatalf("NewExtensionsWithConfig failed: %v", err)
+	}
+	SetDefaultExtensions(ext)
+	t.Cleanup(func() { SetDefaultExtensions(mustNewRegistry(nil)) })
+
+	tmpDir := t.TempDir()
+	sourceDir, targetDir := createSourceAndTarget(t, tmpDir)
+	testDate := time.Date(2023, 6, 15, 10, 30, 0, 0, time.UTC)
+	createMediaFile(t, sourceDir, "IMG_0001.jpg", testDate)
+	createMediaFile(t, sourceDir, "IMG_0002.webp", testDate)
+	createMediaFile(t, sourceDir, "clip.mkv", testDate)
+	createMediaFile(t, sourceDir, "Screenshot 1.png", testDate)
+	createMediaFile(t, sourceDir, "Screenshot 1.xmp", testDate)
+
+	if _, err := NewMediaParser().Parse(sourceDir, targetDir, testParseOptions); err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	dateDir := filepath.Join(targetDir, "2023 06 June 15")
+	for _, path := range []string{
+		"2023_06_June_15_00001.jpg",
+		"2023_06_June_15_00002.webp",
+		filepath.Join("videos", "2023_06_June_15_00001.mkv"),
+		filepath.Join("screenshots", "2023_06_June_15_00001.png"),
+		filepath.Join("screenshots", "2023_06_June_15_00001.xmp"),
+	} {
+		assertMediaFileExists(t, filepath.Join(dateDir, path))
+	}
+}
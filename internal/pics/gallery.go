@@ -0,0 +1,521 @@
+package pics
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"hash/crc32"
+	"html/template"
+	"io/fs"
+	"maps"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/acm19/pics/internal/logger"
+)
+
+const (
+	// galleryDaysDir is the directory of the site that holds a page for every date directory
+	galleryDaysDir = "days"
+	// galleryThumbsDir is the directory of the site that holds the thumbnails, one
+	// directory for every date directory
+	galleryThumbsDir = "thumbs"
+	// galleryMediaDir is the directory of the site that holds the media files shown or
+	// linked, one directory for every date directory
+	galleryMediaDir = "media"
+)
+
+//go:embed gallery_templates/*.html
+var galleryTemplateFS embed.FS
+
+// galleryTemplates renders the index and the day pages
+var galleryTemplates = template.Must(template.ParseFS(galleryTemplateFS, "gallery_templates/*.html"))
+
+// browserImageExts are the image extensions browsers display, other images are shown as their thumbnail
+var browserImageExts = []string{".jpg", ".jpeg", ".png", ".gif", ".webp"}
+
+// GalleryGenerator defines the interface for rendering a library as a static HTML site
+type GalleryGenerator interface {
+	// Generate renders the date directories of libraryDir (YYYY MM Month DD [name]) into a
+	// static site in outDir: an index by year, month and day, and a page for every day
+	// with its thumbnails, a lightbox and links to its videos. The site is self-contained:
+	// the media files are hard linked into it, or copied when outDir is on another
+	// filesystem than libraryDir.
+	Generate(ctx context.Context, libraryDir, outDir string, progressChan chan<- ProgressEvent) (*GallerySummary, error)
+}
+
+// GallerySummary reports what a generated gallery holds.
+type GallerySummary struct {
+	// Index is the path of the index page.
+	Index string `json:"index"`
+	// Days is the number of day pages.
+	Days int `json:"days"`
+	// Images is the number of images shown on the day pages.
+	Images int `json:"images"`
+	// Videos is the number of videos linked from the day pages.
+	Videos int `json:"videos"`
+}
+
+// galleryGenerator implements the GalleryGenerator interface
+type galleryGenerator struct {
+	thumbnails *thumbnailer
+	extensions Extensions
+}
+
+// NewGalleryGenerator creates a new GalleryGenerator instance using system heif-convert
+// for the thumbnails of HEIC files
+func NewGalleryGenerator() GalleryGenerator {
+	return NewGalleryGeneratorWithPath("")
+}
+
+// NewGalleryGeneratorWithPath creates a new GalleryGenerator with a custom heif-convert path
+func NewGalleryGeneratorWithPath(converterPath string) GalleryGenerator {
+	extensions := NewExtensions()
+	return &galleryGenerator{
+		thumbnails: &thumbnailer{converter: NewImageConverterWithPath(converterPath), extensions: extensions},
+		extensions: extensions,
+	}
+}
+
+// galleryDay is a date directory as shown in the gallery
+type galleryDay struct {
+	Dir    string
+	Date   time.Time
+	Name   string
+	Page   string
+	Sheet  string
+	Items  []galleryItem
+	Videos []galleryLink
+}
+
+// Title returns the date of the day followed by its event name, if any
+func (d galleryDay) Title() string {
+	title := d.Date.Format("Monday 2 January 2006")
+	if d.Name != "" {
+		title += " · " + d.Name
+	}
+	return title
+}
+
+// galleryItem is an image on a day page
+type galleryItem struct {
+	Name string
+	// Thumb is the thumbnail, Full what the lightbox shows and Original the file itself
+	Thumb    string
+	Full     string
+	Original string
+	// Live is the motion clip of a Live Photo, empty for other images
+	Live string
+}
+
+// galleryLink is a file linked from a page
+type galleryLink struct {
+	Name string
+	Href string
+}
+
+// galleryMonth and galleryYear group the days on the index
+type galleryMonth struct {
+	Name string
+	Days []galleryDay
+}
+
+type galleryYear struct {
+	Year   int
+	Months []galleryMonth
+}
+
+// Generate renders libraryDir into a static site in outDir
+func (g *galleryGenerator) Generate(ctx context.Context, libraryDir, outDir string, progressChan chan<- ProgressEvent) (*GallerySummary, error) {
+	days, err := g.findDays(libraryDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read library: %w", err)
+	}
+	for _, dir := range []string{galleryDaysDir, galleryThumbsDir, galleryMediaDir} {
+		if err := os.MkdirAll(filepath.Join(outDir, dir), 0755); err != nil {
+			return nil, fmt.Errorf("failed to create output directory: %w", err)
+		}
+	}
+	if err := g.removeStale(outDir, days); err != nil {
+		return nil, err
+	}
+
+	summary := &GallerySummary{Index: filepath.Join(outDir, "index.html"), Days: len(days)}
+	for i := range days {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		// Emit gallery progress event
+		if progressChan != nil {
+			select {
+			case progressChan <- ProgressEvent{
+				Stage:   "gallery",
+				Current: i + 1,
+				Total:   len(days),
+				Message: fmt.Sprintf("Rendering day %d of %d", i+1, len(days)),
+				File:    days[i].Dir,
+			}:
+			default:
+				logger.Debug("Progress event dropped (channel full)", "stage", "gallery")
+			}
+		}
+
+		if err := g.fillDay(ctx, &days[i], libraryDir, outDir); err != nil {
+			return nil, err
+		}
+		if err := renderGalleryPage(filepath.Join(outDir, galleryDaysDir, days[i].Page), "day.html", days[i]); err != nil {
+			return nil, err
+		}
+		summary.Images += len(days[i].Items)
+		summary.Videos += len(days[i].Videos)
+	}
+
+	if err := renderGalleryPage(summary.Index, "index.html", galleryIndex(days)); err != nil {
+		return nil, err
+	}
+	return summary, nil
+}
+
+// findDays returns the date directories of libraryDir, oldest first. Directories whose
+// name does not start with a date are not part of the library and are skipped.
+func (g *galleryGenerator) findDays(libraryDir string) ([]galleryDay, error) {
+	entries, err := os.ReadDir(libraryDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var days []galleryDay
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		date, name, ok := parseDateDirName(entry.Name())
+		if !ok {
+			continue
+		}
+		days = append(days, galleryDay{Dir: entry.Name(), Date: date, Name: name})
+	}
+	sort.Slice(days, func(i, k int) bool {
+		if !days[i].Date.Equal(days[k].Date) {
+			return days[i].Date.Before(days[k].Date)
+		}
+		return days[i].Dir < days[k].Dir
+	})
+
+	taken := make(map[string]bool, len(days))
+	for i := range days {
+		days[i].Page = galleryPageName(days[i].Dir, taken)
+	}
+	return days, nil
+}
+
+// parseDateDirName splits a date directory name (YYYY MM Month DD [name]) into its date
+// and event name, as written by the organiser and DirectoryRenamer
+func parseDateDirName(dirName string) (time.Time, string, bool) {
+	parts := strings.Fields(dirName)
+	if len(parts) < 4 {
+		return time.Time{}, "", false
+	}
+	// The month is both a number and a name, a directory where they disagree is not the organiser's
+	datePart := strings.Join(parts[:4], " ")
+	date, err := time.Parse(dateDirLayout, datePart)
+	if err != nil || date.Format(dateDirLayout) != datePart {
+		return time.Time{}, "", false
+	}
+	return date, strings.Join(parts[4:], " "), true
+}
+
+// galleryPageName returns the name of the page of a date directory, which is also the
+// name of its thumbnails directory in the site, and adds it to taken. Directories whose
+// names only differ in the characters a page name cannot hold (or in case, for
+// case-insensitive filesystems) would share a page, so a page name already taken gets a
+// hash of the directory name.
+func galleryPageName(dirName string, taken map[string]bool) string {
+	base := strings.Map(func(r rune) rune {
+		if strings.ContainsRune(` /\:*?"<>|#%`, r) {
+			return '_'
+		}
+		return r
+	}, dirName)
+	if taken[strings.ToLower(base)] {
+		base = fmt.Sprintf("%s_%08x", base, crc32.ChecksumIEEE([]byte(dirName)))
+	}
+	taken[strings.ToLower(base)] = true
+	return base + ".html"
+}
+
+// fillDay lists the images and videos of a date directory and puts them and their
+// thumbnails in the site
+func (g *galleryGenerator) fillDay(ctx context.Context, day *galleryDay, libraryDir, outDir string) error {
+	dir := filepath.Join(libraryDir, day.Dir)
+	thumbsName := strings.TrimSuffix(day.Page, ".html")
+	thumbsDir := filepath.Join(outDir, galleryThumbsDir, thumbsName)
+	if err := os.MkdirAll(thumbsDir, 0755); err != nil {
+		return fmt.Errorf("failed to create thumbnails directory: %w", err)
+	}
+
+	// Every media file the page shows or links is put in the site, the rest is removed
+	mediaDir := filepath.Join(outDir, galleryMediaDir, thumbsName)
+	linked := make(map[string]bool)
+	var linkErr error
+	href := func(rel ...string) string {
+		path := filepath.Join(rel...)
+		if err := linkMedia(filepath.Join(dir, path), filepath.Join(mediaDir, path)); err != nil && linkErr == nil {
+			linkErr = err
+		}
+		linked[path] = true
+		return "../" + escapePath(append([]string{galleryMediaDir, thumbsName}, rel...)...)
+	}
+	thumbHref := func(name string) string {
+		return "../" + escapePath(galleryThumbsDir, thumbsName, name)
+	}
+
+	names, err := readDirNames(dir)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", dir, err)
+	}
+	images, err := g.thumbnails.images(dir)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", dir, err)
+	}
+
+	// Motion clips next to their still are Live Photos, other videos are linked on their own
+	clips := make(map[string]string)
+	for _, name := range slices.Sorted(slices.Values(names)) {
+		if !g.extensions.IsVideo(name) {
+			continue
+		}
+		stem := strings.TrimSuffix(name, filepath.Ext(name))
+		if _, isStill := images[stem]; isStill {
+			clips[stem] = name
+		} else {
+			day.Videos = append(day.Videos, galleryLink{Name: name, Href: href(name)})
+		}
+	}
+
+	// An image without a thumbnail is still listed, the page shows a placeholder for it
+	for _, stem := range slices.Sorted(maps.Keys(images)) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		name := filepath.Base(images[stem])
+		item := galleryItem{Name: name, Original: href(name)}
+		if err := g.copyThumbnail(ctx, images[stem], filepath.Join(dir, thumbsDirName, stem+".jpg"), filepath.Join(thumbsDir, stem+".jpg")); err != nil {
+			logger.Warn("Failed to write thumbnail", "path", images[stem], "error", err)
+		} else {
+			item.Thumb = thumbHref(stem + ".jpg")
+		}
+		if clip, found := clips[stem]; found {
+			item.Live = href(clip)
+		}
+		day.Items = append(day.Items, item)
+	}
+
+	// Screenshots are images browsers show as they are, so they are their own thumbnail
+	screenshotsDir := g.extensions.Subdir(ClassScreenshot)
+	screenshots, err := readDirNames(filepath.Join(dir, screenshotsDir))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read screenshots of %s: %w", dir, err)
+	}
+	for _, name := range slices.Sorted(slices.Values(screenshots)) {
+		if g.extensions.IsScreenshot(name) {
+			day.Items = append(day.Items, galleryItem{Name: name, Thumb: href(screenshotsDir, name), Original: href(screenshotsDir, name)})
+		}
+	}
+
+	videosDir := g.extensions.Subdir(ClassVideo)
+	videos, err := readDirNames(filepath.Join(dir, videosDir))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read videos of %s: %w", dir, err)
+	}
+	for _, name := range slices.Sorted(slices.Values(videos)) {
+		if g.extensions.IsVideo(name) {
+			day.Videos = append(day.Videos, galleryLink{Name: name, Href: href(videosDir, name)})
+		}
+	}
+
+	// Browsers cannot show HEIC files, the lightbox shows their thumbnail instead
+	for i, item := range day.Items {
+		day.Items[i].Full = item.Thumb
+		if item.Thumb == "" || slices.Contains(browserImageExts, strings.ToLower(filepath.Ext(item.Name))) {
+			day.Items[i].Full = item.Original
+		}
+	}
+
+	if linkErr != nil {
+		return linkErr
+	}
+	if err := removeUnlinked(mediaDir, linked); err != nil {
+		return err
+	}
+
+	sheet := filepath.Join(dir, thumbsDirName, contactSheetName)
+	if _, err := os.Stat(sheet); err == nil {
+		if err := copyIfChanged(sheet, filepath.Join(thumbsDir, contactSheetName)); err != nil {
+			return err
+		}
+		day.Sheet = thumbHref(contactSheetName)
+	}
+	return nil
+}
+
+// linkMedia puts the media file src at dst in the site: a hard link, so the site takes no
+// room of its own, or a copy when they are on different filesystems. A dst that is
+// already src, or a copy of it going by size and modification time, is left as it is.
+func linkMedia(src, dst string) error {
+	srcInfo, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if dstInfo, err := os.Stat(dst); err == nil {
+		if os.SameFile(srcInfo, dstInfo) || (dstInfo.Size() == srcInfo.Size() && dstInfo.ModTime().Equal(srcInfo.ModTime())) {
+			return nil
+		}
+		if err := os.Remove(dst); err != nil {
+			return fmt.Errorf("failed to replace %s: %w", dst, err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return fmt.Errorf("failed to create media directory: %w", err)
+	}
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+	if _, err := copyFilePreserveTime(src, dst); err != nil {
+		return fmt.Errorf("failed to copy %s: %w", src, err)
+	}
+	return nil
+}
+
+// removeUnlinked removes the files below mediaDir whose path relative to it is not in
+// linked, left by media files that are gone from the library
+func removeUnlinked(mediaDir string, linked map[string]bool) error {
+	return filepath.WalkDir(mediaDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(mediaDir, path)
+		if err != nil || linked[rel] {
+			return err
+		}
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("failed to remove stale media file: %w", err)
+		}
+		return nil
+	})
+}
+
+// copyThumbnail puts the thumbnail of the image at src into dst: the one written by parse
+// at libraryThumb if there is one, a new one otherwise
+func (g *galleryGenerator) copyThumbnail(ctx context.Context, src, libraryThumb, dst string) error {
+	if libraryThumb != "" {
+		if _, err := os.Stat(libraryThumb); err == nil {
+			return copyIfChanged(libraryThumb, dst)
+		}
+	}
+	if _, err := os.Stat(dst); err == nil {
+		return nil
+	}
+	return g.thumbnails.writeThumbnail(ctx, src, dst)
+}
+
+// copyIfChanged copies src to dst unless dst is already a copy of it, going by size and
+// modification time, which the copy keeps
+func copyIfChanged(src, dst string) error {
+	srcInfo, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if dstInfo, err := os.Stat(dst); err == nil && dstInfo.Size() == srcInfo.Size() && dstInfo.ModTime().Equal(srcInfo.ModTime()) {
+		return nil
+	}
+	if _, err := copyFilePreserveTime(src, dst); err != nil {
+		return fmt.Errorf("failed to copy %s: %w", src, err)
+	}
+	return nil
+}
+
+// removeStale removes the pages and thumbnails of date directories that are no longer in the library
+func (g *galleryGenerator) removeStale(outDir string, days []galleryDay) error {
+	pages := make(map[string]bool)
+	for _, day := range days {
+		pages[day.Page] = true
+	}
+
+	entries, err := os.ReadDir(filepath.Join(outDir, galleryDaysDir))
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !pages[entry.Name()] {
+			if err := os.Remove(filepath.Join(outDir, galleryDaysDir, entry.Name())); err != nil {
+				return fmt.Errorf("failed to remove stale page: %w", err)
+			}
+		}
+	}
+	for _, dir := range []string{galleryThumbsDir, galleryMediaDir} {
+		entries, err = os.ReadDir(filepath.Join(outDir, dir))
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if !pages[entry.Name()+".html"] {
+				if err := os.RemoveAll(filepath.Join(outDir, dir, entry.Name())); err != nil {
+					return fmt.Errorf("failed to remove stale %s: %w", dir, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// galleryIndex groups days by year, newest first, and by month
+func galleryIndex(days []galleryDay) []galleryYear {
+	var years []galleryYear
+	for _, day := range days {
+		if len(years) == 0 || years[0].Year != day.Date.Year() {
+			years = slices.Insert(years, 0, galleryYear{Year: day.Date.Year()})
+		}
+		year := &years[0]
+		month := day.Date.Format("January")
+		if len(year.Months) == 0 || year.Months[len(year.Months)-1].Name != month {
+			year.Months = append(year.Months, galleryMonth{Name: month})
+		}
+		year.Months[len(year.Months)-1].Days = append(year.Months[len(year.Months)-1].Days, day)
+	}
+	return years
+}
+
+// escapePath joins path segments into a URL path, escaping each of them
+func escapePath(segments ...string) string {
+	escaped := make([]string, len(segments))
+	for i, segment := range segments {
+		escaped[i] = url.PathEscape(segment)
+	}
+	return strings.Join(escaped, "/")
+}
+
+// renderGalleryPage renders the named template with data into path
+func renderGalleryPage(path, name string, data any) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", path, err)
+	}
+	if err := galleryTemplates.ExecuteTemplate(file, name, data); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to render %s: %w", path, err)
+	}
+	return file.Close()
+}
@@ -0,0 +1,190 @@
+package pics
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestGallery() *galleryGenerator {
+	extensions := NewExtensions()
+	return &galleryGenerator{
+		thumbnails: &thumbnailer{converter: jpegConverter{}, extensions: extensions},
+		extensions: extensions,
+	}
+}
+
+func readGalleryPage(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", path, err)
+	}
+	return string(data)
+}
+
+func TestParseDateDirName(t *testing.T) {
+	tests := []struct {
+		dirName  string
+		date     time.Time
+		name     string
+		expected bool
+	}{
+		{"2023 06 June 15", time.Date(2023, 6, 15, 0, 0, 0, 0, time.UTC), "", true},
+		{"2023 06 June 15 Picnic", time.Date(2023, 6, 15, 0, 0, 0, 0, time.UTC), "Picnic", true},
+		{"2023 12 December 01 Trip to the  lake", time.Date(2023, 12, 1, 0, 0, 0, 0, time.UTC), "Trip to the lake", true},
+		{"2023 06 July 15", time.Time{}, "", false},
+		{"2023 06 June", time.Time{}, "", false},
+		{"_quarantine", time.Time{}, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.dirName, func(t *testing.T) {
+			date, name, ok := parseDateDirName(tt.dirName)
+			if ok != tt.expected || !date.Equal(tt.date) || name != tt.name {
+				t.Errorf("parseDateDirName(%q) = %v, %q, %v, want %v, %q, %v", tt.dirName, date, name, ok, tt.date, tt.name, tt.expected)
+			}
+		})
+	}
+}
+
+func TestGalleryPageName(t *testing.T) {
+	taken := make(map[string]bool)
+	first := galleryPageName("2023 06 June 15 a b", taken)
+	if first != "2023_06_June_15_a_b.html" {
+		t.Errorf("Expected 2023_06_June_15_a_b.html, got %s", first)
+	}
+
+	// Names that map to the same page are told apart
+	pages := map[string]bool{first: true}
+	for _, dirName := range []string{"2023 06 June 15 a_b", "2023 06 June 15 a:b", "2023 06 June 15 A B"} {
+		page := galleryPageName(dirName, taken)
+		if pages[page] {
+			t.Errorf("Expected a page of its own for %q, got %s", dirName, page)
+		}
+		pages[page] = true
+	}
+}
+
+func TestGalleryIndex(t *testing.T) {
+	day := func(year int, month time.Month, d int) galleryDay {
+		return galleryDay{Date: time.Date(year, month, d, 0, 0, 0, 0, time.UTC)}
+	}
+	years := galleryIndex([]galleryDay{day(2022, 12, 24), day(2023, 6, 15), day(2023, 6, 16), day(2023, 7, 1)})
+
+	if len(years) != 2 || years[0].Year != 2023 || years[1].Year != 2022 {
+		t.Fatalf("Expected 2023 then 2022, got %+v", years)
+	}
+	if len(years[0].Months) != 2 || years[0].Months[0].Name != "June" || len(years[0].Months[0].Days) != 2 || years[0].Months[1].Name != "July" {
+		t.Errorf("Expected June with two days then July, got %+v", years[0].Months)
+	}
+}
+
+func TestGalleryGenerator_Generate(t *testing.T) {
+	tmpDir := t.TempDir()
+	library := createSubdir(t, tmpDir, "library")
+	outDir := filepath.Join(tmpDir, "site")
+	now := time.Now()
+
+	picnic := createSubdir(t, library, "2023 06 June 15 Picnic")
+	createFileWithContent(t, picnic, "2023_06_June_15_Picnic_00001.jpg", orientedJPEG(t, 64, 48, 1), now)
+	createFileWithContent(t, picnic, "2023_06_June_15_Picnic_00002.heic", heicHeader, now)
+	createMediaFile(t, picnic, "2023_06_June_15_Picnic_00002.mov", now)
+	videos := createSubdir(t, picnic, "videos")
+	createMediaFile(t, videos, "2023_06_June_15_Picnic_00001.mp4", now)
+	raw := createSubdir(t, picnic, "raw")
+	createMediaFile(t, raw, "2023_06_June_15_Picnic_00001.dng", now)
+
+	// A thumbnail written by parse is copied rather than generated again
+	winter := createSubdir(t, library, "2022 12 December 24")
+	createFileWithContent(t, winter, "2022_12_December_24_00001.jpg", orientedJPEG(t, 64, 48, 1), now)
+	thumbs := createSubdir(t, winter, thumbsDirName)
+	createFileWithContent(t, thumbs, "2022_12_December_24_00001.jpg", orientedJPEG(t, 8, 6, 1), now)
+	createSubdir(t, library, "_quarantine")
+
+	gallery := newTestGallery()
+	progressChan := make(chan ProgressEvent, 10)
+	summary, err := gallery.Generate(context.Background(), library, outDir, progressChan)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	close(progressChan)
+
+	if summary.Days != 2 || summary.Images != 3 || summary.Videos != 1 {
+		t.Errorf("Expected 2 days, 3 images and 1 video, got %+v", summary)
+	}
+	events := 0
+	for event := range progressChan {
+		if event.Stage == "gallery" {
+			events++
+		}
+	}
+	if events != 2 {
+		t.Errorf("Expected a gallery progress event per day, got %d", events)
+	}
+
+	// The index lists the newest year first, with the event name of every day
+	index := readGalleryPage(t, summary.Index)
+	if strings.Index(index, "2023") > strings.Index(index, "2022") {
+		t.Error("Expected 2023 before 2022 on the index")
+	}
+	for _, expected := range []string{`days/2023_06_June_15_Picnic.html`, "Thursday 15 June 2023 · Picnic", "December"} {
+		if !strings.Contains(index, expected) {
+			t.Errorf("Expected the index to contain %q", expected)
+		}
+	}
+
+	page := readGalleryPage(t, filepath.Join(outDir, galleryDaysDir, "2023_06_June_15_Picnic.html"))
+	for _, expected := range []string{
+		// The JPEG opens as it is, the HEIC file as its thumbnail, and the Live Photo links its clip
+		`data-full="../media/2023_06_June_15_Picnic/2023_06_June_15_Picnic_00001.jpg"`,
+		`data-full="../thumbs/2023_06_June_15_Picnic/2023_06_June_15_Picnic_00002.jpg"`,
+		`data-live="../media/2023_06_June_15_Picnic/2023_06_June_15_Picnic_00002.mov"`,
+		`href="../media/2023_06_June_15_Picnic/videos/2023_06_June_15_Picnic_00001.mp4"`,
+	} {
+		if !strings.Contains(page, expected) {
+			t.Errorf("Expected the day page to contain %q", expected)
+		}
+	}
+	if strings.Contains(page, ".dng") {
+		t.Error("Expected RAW files to be left out")
+	}
+	assertMediaFileExists(t, filepath.Join(outDir, galleryThumbsDir, "2023_06_June_15_Picnic", "2023_06_June_15_Picnic_00002.jpg"))
+
+	// The site holds the media files it links, so it can be served on its own
+	media := filepath.Join(outDir, galleryMediaDir, "2023_06_June_15_Picnic")
+	assertMediaFileExists(t, filepath.Join(media, "2023_06_June_15_Picnic_00001.jpg"))
+	assertMediaFileExists(t, filepath.Join(media, "2023_06_June_15_Picnic_00002.mov"))
+	assertMediaFileExists(t, filepath.Join(media, "videos", "2023_06_June_15_Picnic_00001.mp4"))
+	assertMediaFileExists(t, filepath.Join(media, "2023_06_June_15_Picnic_00002.heic"))
+	assertMediaFileNotExists(t, filepath.Join(media, "raw", "2023_06_June_15_Picnic_00001.dng"))
+
+	copied := filepath.Join(outDir, galleryThumbsDir, "2022_12_December_24", "2022_12_December_24_00001.jpg")
+	if bounds := decodeJPEGFile(t, copied).Bounds(); bounds.Dx() != 8 {
+		t.Errorf("Expected the thumbnail of the library to be copied, got %v", bounds)
+	}
+	if _, err := os.Stat(filepath.Join(library, "2023 06 June 15 Picnic", thumbsDirName)); !os.IsNotExist(err) {
+		t.Error("Expected the library to be left untouched")
+	}
+
+	// A day or a video removed from the library disappears from the site
+	if err := os.RemoveAll(winter); err != nil {
+		t.Fatalf("Failed to remove day: %v", err)
+	}
+	if err := os.Remove(filepath.Join(videos, "2023_06_June_15_Picnic_00001.mp4")); err != nil {
+		t.Fatalf("Failed to remove video: %v", err)
+	}
+	if _, err := gallery.Generate(context.Background(), library, outDir, nil); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	assertMediaFileNotExists(t, filepath.Join(outDir, galleryDaysDir, "2022_12_December_24.html"))
+	assertMediaFileNotExists(t, filepath.Join(outDir, galleryThumbsDir, "2022_12_December_24"))
+	assertMediaFileNotExists(t, filepath.Join(outDir, galleryMediaDir, "2022_12_December_24"))
+	assertMediaFileNotExists(t, filepath.Join(media, "videos", "2023_06_June_15_Picnic_00001.mp4"))
+	if strings.Contains(readGalleryPage(t, summary.Index), "2022") {
+		t.Error("Expected the removed day to be gone from the index")
+	}
+}
@@ -0,0 +1,125 @@
+package pics
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+
+	"github.com/acm19/pics/internal/logger"
+)
+
+// markerAPP14 is the Adobe segment, which describes the colour transform of the original encoding
+const markerAPP14 = 0xEE
+
+// goJPEGCompressor implements the ImageCompressor interface in Go, without external binaries
+type goJPEGCompressor struct{}
+
+// NewGoImageCompressor creates a new ImageCompressor that re-encodes JPEG files with the
+// Go standard library, so it works where jpegoptim cannot be installed
+func NewGoImageCompressor() ImageCompressor {
+	return &goJPEGCompressor{}
+}
+
+// CompressFile re-encodes a single JPEG file at quality, keeping its APP (EXIF, XMP, ICC)
+// and COM segments byte for byte and its modification time. Like jpegoptim, the file is
+// only replaced when the re-encoded copy is smaller, and it reports whether it was.
+func (c *goJPEGCompressor) CompressFile(ctx context.Context, path string, quality int) (bool, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, fmt.Errorf("file does not exist: %w", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	segments, err := metadataSegments(data)
+	if err != nil {
+		return false, fmt.Errorf("failed to read segments of %s: %w", path, err)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		return false, fmt.Errorf("failed to decode %s: %w", path, err)
+	}
+	if _, ok := img.(*image.CMYK); ok {
+		logger.Debug("Not compressing CMYK JPEG", "path", path)
+		return false, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	compressed, err := encodeWithSegments(img, segments, quality)
+	if err != nil {
+		return false, fmt.Errorf("failed to encode %s: %w", path, err)
+	}
+	if len(compressed) >= len(data) {
+		logger.Debug("Keeping original, re-encoded file is not smaller", "path", path, "original", len(data), "compressed", len(compressed))
+		return false, nil
+	}
+	if err := replaceFile(path, compressed, info); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// encodeWithSegments encodes img as a JPEG file at quality holding segments, the APP and
+// COM segments returned by metadataSegments. Callers leave CMYK images alone, as
+// re-encoding would turn their data into YCbCr under the original ICC profile.
+func encodeWithSegments(img image.Image, segments []byte, quality int) ([]byte, error) {
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, img, &jpeg.Options{Quality: quality}); err != nil {
+		return nil, err
+	}
+
+	// The encoder writes no APP segments, so the original ones go right after its SOI marker
+	data := make([]byte, 0, len(segments)+encoded.Len())
+	data = append(data, encoded.Bytes()[:2]...)
+	data = append(data, segments...)
+	return append(data, encoded.Bytes()[2:]...), nil
+}
+
+// replaceFile replaces the file at path, described by info, with data, keeping its
+// permissions and modification time. The data is written next to the file and renamed
+// over it, so a failure leaves the original intact.
+func replaceFile(path string, data []byte, info os.FileInfo) error {
+	tmpPath := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".replacing")
+	if err := os.WriteFile(tmpPath, data, info.Mode().Perm()); err != nil {
+		return fmt.Errorf("failed to write %s: %w", tmpPath, err)
+	}
+	if err := os.Chtimes(tmpPath, info.ModTime(), info.ModTime()); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to set file times: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to replace %s: %w", path, err)
+	}
+	return nil
+}
+
+// metadataSegments returns the APP and COM segments of a JPEG file, markers included,
+// in their original order. The Adobe APP14 segment is left out, as it describes the
+// original encoding rather than the image.
+func metadataSegments(data []byte) ([]byte, error) {
+	var segments []byte
+	err := walkJPEGSegments(bytes.NewReader(data), func(marker byte, payload []byte) error {
+		if (marker < 0xE0 || marker > 0xEF || marker == markerAPP14) && marker != markerCOM {
+			return nil
+		}
+		header := []byte{0xFF, marker, 0, 0}
+		binary.BigEndian.PutUint16(header[2:], uint16(len(payload)+2))
+		segments = append(segments, header...)
+		segments = append(segments, payload...)
+		return nil
+	})
+	return segments, err
+}
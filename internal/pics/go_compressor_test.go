@@ -0,0 +1,152 @@
+package pics
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// encodeDetailedJPEG encodes an image with enough detail for its size to depend on the quality
+func encodeDetailedJPEG(t *testing.T, quality int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for y := range 64 {
+		for x := range 64 {
+			img.Set(x, y, color.RGBA{uint8(x * y), uint8(x*7 + y*13), uint8(x ^ y), 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		t.Fatalf("Failed to encode JPEG: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// withSegments inserts segments right after the SOI marker of a JPEG file
+func withSegments(data []byte, segments ...[]byte) []byte {
+	result := append([]byte{}, data[:2]...)
+	for _, segment := range segments {
+		result = append(result, segment...)
+	}
+	return append(result, data[2:]...)
+}
+
+func TestGoJPEGCompressor_CompressFile(t *testing.T) {
+	tmpDir := t.TempDir()
+	modTime := time.Date(2023, 6, 15, 10, 30, 0, 0, time.UTC)
+
+	app1 := []byte{0xFF, 0xE1, 0x00, 0x0A, 'E', 'x', 'i', 'f', 0x00, 0x00, 'I', 'I'}
+	app2 := []byte{0xFF, 0xE2, 0x00, 0x08, 'I', 'C', 'C', '_', 'P', 'R'}
+	com := []byte{0xFF, 0xFE, 0x00, 0x06, 'n', 'o', 't', 'e'}
+	original := withSegments(encodeDetailedJPEG(t, 95), app1, app2, com)
+	path := createFileWithContent(t, tmpDir, "photo.jpg", original, modTime)
+
+	replaced, err := NewGoImageCompressor().CompressFile(context.Background(), path, 30)
+	if err != nil {
+		t.Fatalf("CompressFile failed: %v", err)
+	}
+	if !replaced {
+		t.Error("Expected the file to be reported as replaced")
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	}
+	if len(data) >= len(original) {
+		t.Errorf("Expected the file to shrink from %d bytes, got %d", len(original), len(data))
+	}
+	segments := append(append(append([]byte{}, app1...), app2...), com...)
+	if !bytes.Equal(data[2:2+len(segments)], segments) {
+		t.Error("Expected the APP1, APP2 and COM segments to be kept byte for byte, in order")
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(data)); err != nil {
+		t.Errorf("Expected the compressed file to decode, got: %v", err)
+	}
+	if info, err := readJPEGInfo(path); err != nil || info.quality < 29 || info.quality > 31 {
+		t.Errorf("Expected quality 30, got %+v, %v", info, err)
+	}
+
+	stat, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Failed to stat file: %v", err)
+	}
+	if !stat.ModTime().Equal(modTime) {
+		t.Errorf("Expected modification time %v, got %v", modTime, stat.ModTime())
+	}
+	if entries, _ := os.ReadDir(tmpDir); len(entries) != 1 {
+		t.Errorf("Expected no temporary file left behind, got %d entries", len(entries))
+	}
+}
+
+func TestGoJPEGCompressor_CompressFile_KeepsSmallerOriginal(t *testing.T) {
+	original := encodeDetailedJPEG(t, 30)
+	path := createFileWithContent(t, t.TempDir(), "photo.jpg", original, time.Now())
+
+	replaced, err := NewGoImageCompressor().CompressFile(context.Background(), path, 95)
+	if err != nil {
+		t.Fatalf("CompressFile failed: %v", err)
+	}
+	if replaced {
+		t.Error("Expected the file to be reported as kept")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	}
+	if !bytes.Equal(data, original) {
+		t.Error("Expected the original to be kept when re-encoding does not shrink it")
+	}
+}
+
+func TestGoJPEGCompressor_CompressFile_Errors(t *testing.T) {
+	tmpDir := t.TempDir()
+	compressor := NewGoImageCompressor()
+
+	if _, err := compressor.CompressFile(context.Background(), filepath.Join(tmpDir, "missing.jpg"), 50); err == nil {
+		t.Error("Expected an error for a missing file")
+	}
+
+	text := createFileWithContent(t, tmpDir, "text.jpg", []byte("test media content"), time.Now())
+	if _, err := compressor.CompressFile(context.Background(), text, 50); err == nil {
+		t.Error("Expected an error for a file that is not a JPEG")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	path := createFileWithContent(t, tmpDir, "photo.jpg", encodeDetailedJPEG(t, 95), time.Now())
+	if _, err := compressor.CompressFile(ctx, path, 50); err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
+
+func TestMediaParser_Parse_GoCompressor(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir, targetDir := createSourceAndTarget(t, tmpDir)
+	testDate := time.Date(2023, 6, 15, 10, 30, 0, 0, time.UTC)
+	createFileWithContent(t, sourceDir, "IMG_0001.jpg", encodeDetailedJPEG(t, 95), testDate)
+
+	opts := testParseOptions
+	opts.CompressJPEGs = true
+	opts.JPEGQuality = 50
+	opts.Compressor = CompressorGo
+
+	result, err := NewMediaParser().Parse(sourceDir, targetDir, opts)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if len(result.Files) != 1 || !result.Files[0].Compressed || result.BytesAfter >= result.BytesBefore {
+		t.Fatalf("Expected the file to be compressed, got %+v", result.Files)
+	}
+
+	info, err := readJPEGInfo(filepath.Join(targetDir, result.Files[0].FinalPath))
+	if err != nil || info.markedQuality == 0 {
+		t.Errorf("Expected the imported file to be marked as compressed, got %+v, %v", info, err)
+	}
+}
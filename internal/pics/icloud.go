@@ -0,0 +1,108 @@
+package pics
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/acm19/pics/internal/logger"
+)
+
+// iCloudDetailsPrefix is how the CSV files listing the photos of an iCloud export
+// are named ("Photo Details.csv", "Photo Details-1.csv"), lower cased
+const iCloudDetailsPrefix = "photo details"
+
+// iCloudDateLayouts are the layouts of originalCreationDate ("Thursday June 15,2023 10:30 AM GMT")
+var iCloudDateLayouts = []string{
+	"Monday January 2,2006 3:04 PM MST",
+	"Monday January 2, 2006 3:04 PM MST",
+}
+
+// loadICloudDates reads the "Photo Details" CSV files below root and returns the
+// originalCreationDate of every media file they list. Each CSV describes the files
+// in its own directory.
+func loadICloudDates(root string) (map[string]time.Time, error) {
+	dates := make(map[string]time.Time)
+	err := walkExportDirs(root, func(dir string, names []string) error {
+		for _, name := range names {
+			lower := strings.ToLower(name)
+			if !strings.HasPrefix(lower, iCloudDetailsPrefix) || filepath.Ext(lower) != ".csv" {
+				continue
+			}
+			if err := readICloudDetails(filepath.Join(dir, name), dates); err != nil {
+				logger.Warn("Skipping unreadable iCloud photo details", "file", filepath.Join(dir, name), "error", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read iCloud photo details: %w", err)
+	}
+	logger.Info("Read iCloud photo details", "files", len(dates))
+	return dates, nil
+}
+
+// readICloudDetails adds the dates listed in a "Photo Details" CSV file to dates
+func readICloudDetails(path string, dates map[string]time.Time) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return err
+	}
+	nameColumn, dateColumn := -1, -1
+	for i, column := range header {
+		switch strings.TrimSpace(strings.TrimPrefix(column, "\ufeff")) {
+		case "imgName":
+			nameColumn = i
+		case "originalCreationDate":
+			dateColumn = i
+		}
+	}
+	if nameColumn < 0 || dateColumn < 0 {
+		return errors.New("missing imgName or originalCreationDate column")
+	}
+
+	dir := filepath.Dir(path)
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(row) <= nameColumn || len(row) <= dateColumn {
+			continue
+		}
+		date, err := parseICloudDate(row[dateColumn])
+		if err != nil {
+			logger.Debug("Failed to parse iCloud date", "file", row[nameColumn], "date", row[dateColumn], "error", err)
+			continue
+		}
+		dates[filepath.Join(dir, row[nameColumn])] = date
+	}
+}
+
+// parseICloudDate parses an originalCreationDate into local time
+func parseICloudDate(value string) (time.Time, error) {
+	var err error
+	for _, layout := range iCloudDateLayouts {
+		var date time.Time
+		if date, err = time.Parse(layout, strings.TrimSpace(value)); err == nil {
+			return date.Local(), nil
+		}
+	}
+	return time.Time{}, err
+}
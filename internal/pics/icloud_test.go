@@ -0,0 +1,67 @@
+package pics
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func createICloudDetails(t *testing.T, dir, name, content string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to create %s: %v", name, err)
+	}
+}
+
+func TestParseICloudDate(t *testing.T) {
+	expected := time.Date(2019, 6, 15, 12, 5, 0, 0, time.UTC)
+
+	for _, value := range []string{"Saturday June 15,2019 12:05 PM GMT", "Saturday June 15, 2019 12:05 PM GMT"} {
+		date, err := parseICloudDate(value)
+		if err != nil {
+			t.Errorf("Expected no error for %q, got: %v", value, err)
+			continue
+		}
+		if !date.Equal(expected) {
+			t.Errorf("Expected %v for %q, got %v", expected, value, date)
+		}
+	}
+
+	if _, err := parseICloudDate("15/06/2019"); err == nil {
+		t.Error("Expected error for unknown layout")
+	}
+}
+
+func TestLoadICloudDates(t *testing.T) {
+	tmpDir := t.TempDir()
+	partDir := createSubdir(t, tmpDir, filepath.Join("iCloud Photos Part 1 of 2", "Photos"))
+	otherDir := createSubdir(t, tmpDir, filepath.Join("iCloud Photos Part 2 of 2", "Photos"))
+
+	createICloudDetails(t, partDir, "Photo Details.csv",
+		"imgName,fileChecksum,favorite,hidden,deleted,originalCreationDate,viewCount,importDate\n"+
+			"IMG_0001.HEIC,abc,no,no,no,\"Saturday June 15,2019 12:05 PM GMT\",1,\"Saturday June 15,2019 12:10 PM GMT\"\n"+
+			"IMG_0002.MOV,def,no,no,no,not a date,0,\n")
+	createICloudDetails(t, otherDir, "Photo Details-1.csv",
+		"\ufeffimgName,originalCreationDate\n"+
+			"IMG_0003.JPG,\"Monday July 15, 2019 8:00 AM GMT\"\n")
+	createICloudDetails(t, otherDir, "Albums.csv", "Album,Images\nTrip,IMG_0003.JPG\n")
+
+	dates, err := loadICloudDates(tmpDir)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	expected := map[string]time.Time{
+		filepath.Join(partDir, "IMG_0001.HEIC"): time.Date(2019, 6, 15, 12, 5, 0, 0, time.UTC),
+		filepath.Join(otherDir, "IMG_0003.JPG"): time.Date(2019, 7, 15, 8, 0, 0, 0, time.UTC),
+	}
+	if len(dates) != len(expected) {
+		t.Errorf("Expected %d dates, got %d: %v", len(expected), len(dates), dates)
+	}
+	for path, date := range expected {
+		if !dates[path].Equal(date) {
+			t.Errorf("Expected %v for %s, got %v", date, path, dates[path])
+		}
+	}
+}
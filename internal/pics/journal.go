@@ -0,0 +1,564 @@
+package pics
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/acm19/pics/internal/logger"
+)
+
+const (
+	// journalDirName is the directory inside the target where an in-progress parse keeps its state
+	journalDirName = ".pics-journal"
+	// journalFileName is the append-only journal file inside journalDirName
+	journalFileName = "journal.jsonl"
+	// stagingDirName is the staging directory inside journalDirName
+	stagingDirName = "staging"
+	// extractDirName is the directory inside journalDirName that Takeout archives are extracted to
+	extractDirName = "takeout"
+)
+
+// Journal operations
+const (
+	journalOpBegin  = "begin"
+	journalOpCopy   = "copy"
+	journalOpMkdir  = "mkdir"
+	journalOpMove   = "move"
+	journalOpRename = "rename"
+	journalOpDate   = "date"
+)
+
+// ErrJournalExists is returned when a parse is started on a target that still
+// holds the journal of an interrupted parse
+var ErrJournalExists = errors.New("target has an unfinished parse journal")
+
+// ErrNoJournal is returned when resuming or rolling back a target without a journal
+var ErrNoJournal = errors.New("target has no parse journal")
+
+// fileMover performs the filesystem changes made while organising files
+type fileMover interface {
+	// mkdirAll creates a directory and any missing parents
+	mkdirAll(dir string) error
+	// rename moves or renames a file
+	rename(from, to string) error
+}
+
+// osFileMover applies changes directly to the filesystem
+type osFileMover struct{}
+
+func (osFileMover) mkdirAll(dir string) error {
+	return os.MkdirAll(dir, 0755)
+}
+
+func (osFileMover) rename(from, to string) error {
+	return os.Rename(from, to)
+}
+
+// contextMover stops applying changes once ctx is cancelled
+type contextMover struct {
+	ctx   context.Context
+	mover fileMover
+}
+
+func (m contextMover) mkdirAll(dir string) error {
+	if err := m.ctx.Err(); err != nil {
+		return err
+	}
+	return m.mover.mkdirAll(dir)
+}
+
+func (m contextMover) rename(from, to string) error {
+	if err := m.ctx.Err(); err != nil {
+		return err
+	}
+	return m.mover.rename(from, to)
+}
+
+// journalRecord is a single line of the journal
+type journalRecord struct {
+	Op   string `json:"op"`
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+	Done bool   `json:"done"`
+
+	// Only set on copy records when the source is moved: the hash of the source
+	// and the hash of the staged copy after compression
+	SourceHash string `json:"sourceHash,omitempty"`
+	Hash       string `json:"hash,omitempty"`
+
+	// Only set on copy records: the size of the source and of the staged copy, and
+	// whether the copy was compressed. Original is the staged HEIC file kept next to
+	// a copy converted to JPEG.
+	Size       int64  `json:"size,omitempty"`
+	StagedSize int64  `json:"stagedSize,omitempty"`
+	Compressed bool   `json:"compressed,omitempty"`
+	Original   string `json:"original,omitempty"`
+
+	// Only set on date records: the extractor that dated the staged file in From
+	Extractor string `json:"extractor,omitempty"`
+
+	// Only set on the begin record
+	SourceDir     string            `json:"sourceDir,omitempty"`
+	TargetDir     string            `json:"targetDir,omitempty"`
+	CompressJPEGs bool              `json:"compressJPEGs,omitempty"`
+	JPEGQuality   int               `json:"jpegQuality,omitempty"`
+	Compressor    CompressorBackend `json:"compressor,omitempty"`
+	MaxLongEdge   int               `json:"maxLongEdge,omitempty"`
+	MoveSource    bool              `json:"moveSource,omitempty"`
+	SourceMode    SourceMode        `json:"sourceMode,omitempty"`
+	FixExtensions bool              `json:"fixExtensions,omitempty"`
+	ConvertHEIC   bool              `json:"convertHEIC,omitempty"`
+	KeepHEIC      bool              `json:"keepHEIC,omitempty"`
+	Thumbnails    bool              `json:"thumbnails,omitempty"`
+	VideoPreset   *VideoPreset      `json:"videoPreset,omitempty"`
+	Timezone      string            `json:"timezone,omitempty"`
+}
+
+// parseJournal is a write-ahead log of every copy, move and rename made by a
+// parse. Each operation is recorded as planned before it happens and as done
+// afterwards, so an interrupted parse can be resumed or rolled back.
+type parseJournal struct {
+	dir     string
+	file    *os.File
+	begin   journalRecord
+	records []journalRecord
+	mu      sync.Mutex
+}
+
+// createParseJournal starts a new journal in targetDir
+func createParseJournal(sourceDir, targetDir string, opts ParseOptions) (*parseJournal, error) {
+	dir := filepath.Join(targetDir, journalDirName)
+	if _, err := os.Stat(dir); err == nil {
+		return nil, fmt.Errorf("%w: %s", ErrJournalExists, dir)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, stagingDirName), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create journal directory: %w", err)
+	}
+
+	file, err := os.OpenFile(filepath.Join(dir, journalFileName), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create journal: %w", err)
+	}
+
+	j := &parseJournal{dir: dir, file: file}
+	begin := journalRecord{
+		Op:            journalOpBegin,
+		Done:          true,
+		SourceDir:     sourceDir,
+		TargetDir:     targetDir,
+		CompressJPEGs: opts.CompressJPEGs,
+		JPEGQuality:   opts.JPEGQuality,
+		Compressor:    opts.Compressor,
+		MaxLongEdge:   opts.MaxLongEdge,
+		MoveSource:    opts.MoveSource,
+		SourceMode:    opts.SourceMode,
+		FixExtensions: opts.FixExtensions,
+		ConvertHEIC:   opts.ConvertHEIC,
+		KeepHEIC:      opts.KeepHEIC,
+		Thumbnails:    opts.Thumbnails,
+	}
+	if opts.CompressVideos {
+		begin.VideoPreset = &opts.VideoPreset
+	}
+	if opts.Timezone != nil {
+		begin.Timezone = opts.Timezone.String()
+	}
+	if err := j.append(begin); err != nil {
+		file.Close()
+		return nil, err
+	}
+	j.begin = begin
+	return j, nil
+}
+
+// openParseJournal loads the journal of an interrupted parse in targetDir
+func openParseJournal(targetDir string) (*parseJournal, error) {
+	dir := filepath.Join(targetDir, journalDirName)
+	path := filepath.Join(dir, journalFileName)
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%w: %s", ErrNoJournal, targetDir)
+		}
+		return nil, fmt.Errorf("failed to open journal: %w", err)
+	}
+
+	j := &parseJournal{dir: dir, file: file}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var record journalRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			// A torn last line is expected if the process died while writing it
+			logger.Warn("Ignoring unreadable journal record", "journal", path, "error", err)
+			continue
+		}
+		j.records = append(j.records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to read journal: %w", err)
+	}
+
+	if len(j.records) == 0 || j.records[0].Op != journalOpBegin {
+		file.Close()
+		return nil, fmt.Errorf("journal %s is missing its begin record", path)
+	}
+	j.begin = j.records[0]
+	logger.Info("Loaded parse journal", "journal", path, "records", len(j.records))
+	return j, nil
+}
+
+// stagingDir returns the directory files are copied into before being organised
+func (j *parseJournal) stagingDir() string {
+	return filepath.Join(j.dir, stagingDirName)
+}
+
+// extractDir returns the directory the archives of a Takeout export are extracted to
+func (j *parseJournal) extractDir() string {
+	return filepath.Join(j.dir, extractDirName)
+}
+
+// append writes a record to the journal and flushes it to disk
+func (j *parseJournal) append(record journalRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, err := j.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write journal: %w", err)
+	}
+	if err := j.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync journal: %w", err)
+	}
+	j.records = append(j.records, record)
+	return nil
+}
+
+// planned records that an operation is about to happen
+func (j *parseJournal) planned(op, from, to string) error {
+	return j.append(journalRecord{Op: op, From: from, To: to})
+}
+
+// done records that an operation has completed
+func (j *parseJournal) done(op, from, to string) error {
+	return j.append(journalRecord{Op: op, From: from, To: to, Done: true})
+}
+
+// copied records that a copy has completed, with its sizes and the hashes used to verify it when moving
+func (j *parseJournal) copied(record journalRecord) error {
+	record.Op = journalOpCopy
+	record.Done = true
+	return j.append(record)
+}
+
+// dated records the extractor that dated a staged file
+func (j *parseJournal) dated(path, extractor string) error {
+	return j.append(journalRecord{Op: journalOpDate, From: path, Extractor: extractor, Done: true})
+}
+
+// copiedFiles returns the source files whose copy completed
+func (j *parseJournal) copiedFiles() map[string]bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	copied := make(map[string]bool)
+	for _, record := range j.records {
+		if record.Op == journalOpCopy && record.Done {
+			copied[record.From] = true
+		}
+	}
+	return copied
+}
+
+// stagedSources maps every staged file to the source file it was copied from
+func (j *parseJournal) stagedSources() map[string]string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	sources := make(map[string]string)
+	for _, record := range j.records {
+		if record.Op == journalOpCopy && record.Done {
+			sources[record.To] = record.From
+			if record.Original != "" {
+				sources[record.Original] = record.From
+			}
+		}
+	}
+	return sources
+}
+
+// finalPaths follows the moves and renames of every file the parse touched,
+// mapping where it was first seen (the staged copy, or its place in the library
+// for files that were already there) to where it ended up
+func (j *parseJournal) finalPaths() map[string]string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	// Current location -> first location
+	current := make(map[string]string)
+	final := make(map[string]string)
+	for _, record := range j.records {
+		if !record.Done {
+			continue
+		}
+		switch record.Op {
+		case journalOpCopy:
+			current[record.To] = record.To
+			final[record.To] = record.To
+			if record.Original != "" {
+				current[record.Original] = record.Original
+				final[record.Original] = record.Original
+			}
+		case journalOpMove, journalOpRename:
+			origin, found := current[record.From]
+			if !found {
+				origin = record.From
+			}
+			delete(current, record.From)
+			current[record.To] = origin
+			final[origin] = record.To
+		}
+	}
+	return final
+}
+
+// conversions maps the current path of every converted JPEG to the current path of the
+// HEIC original kept next to it
+func (j *parseJournal) conversions() map[string]string {
+	finalPaths := j.finalPaths()
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	conversions := make(map[string]string)
+	for _, record := range j.records {
+		if record.Op == journalOpCopy && record.Done && record.Original != "" {
+			conversions[finalPaths[record.To]] = finalPaths[record.Original]
+		}
+	}
+	return conversions
+}
+
+// renamedFiles returns the files that were already in the target directory and
+// were renamed by the parse, relative to the target directory
+func (j *parseJournal) renamedFiles() []RenamedFile {
+	finalPaths := j.finalPaths()
+	targetDir := j.begin.TargetDir
+	stagingDir := j.stagingDir() + string(filepath.Separator)
+
+	renamed := []RenamedFile{}
+	for origin, final := range finalPaths {
+		if origin == final || strings.HasPrefix(origin, stagingDir) {
+			continue
+		}
+		from, err := filepath.Rel(targetDir, origin)
+		if err != nil || strings.HasPrefix(from, "..") {
+			continue
+		}
+		to, err := filepath.Rel(targetDir, final)
+		if err != nil {
+			continue
+		}
+		renamed = append(renamed, RenamedFile{From: from, To: to})
+	}
+	sort.Slice(renamed, func(i, k int) bool {
+		return renamed[i].From < renamed[k].From
+	})
+	return renamed
+}
+
+// importedFiles returns every source file whose copy completed and where it ended
+// up, relative to the target directory. Quarantined files are left out.
+func (j *parseJournal) importedFiles() []ImportedFile {
+	finalPaths := j.finalPaths()
+	targetDir := j.begin.TargetDir
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	extractors := make(map[string]string)
+	for _, record := range j.records {
+		if record.Op == journalOpDate {
+			extractors[record.From] = record.Extractor
+		}
+	}
+
+	files := []ImportedFile{}
+	for _, record := range j.records {
+		if record.Op != journalOpCopy || !record.Done {
+			continue
+		}
+		rel, err := filepath.Rel(targetDir, finalPaths[record.To])
+		if err != nil || strings.HasPrefix(rel, "..") || strings.HasPrefix(rel, quarantineDirName+string(filepath.Separator)) {
+			continue
+		}
+		original := ""
+		if record.Original != "" {
+			original, _ = filepath.Rel(targetDir, finalPaths[record.Original])
+		}
+		files = append(files, ImportedFile{
+			Source:      record.From,
+			FinalPath:   rel,
+			Original:    original,
+			Extractor:   extractors[record.To],
+			Compressed:  record.Compressed,
+			BytesBefore: record.Size,
+			BytesAfter:  record.StagedSize,
+		})
+	}
+	sort.Slice(files, func(i, k int) bool {
+		return files[i].Source < files[k].Source
+	})
+	return files
+}
+
+// hashedCopies returns the completed copy records that carry hashes
+func (j *parseJournal) hashedCopies() []journalRecord {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var records []journalRecord
+	for _, record := range j.records {
+		if record.Op == journalOpCopy && record.Done && record.Hash != "" {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+// createdDirs returns the directories the parse created
+func (j *parseJournal) createdDirs() map[string]bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	created := make(map[string]bool)
+	for _, record := range j.records {
+		if record.Op == journalOpMkdir {
+			created[record.To] = true
+		}
+	}
+	return created
+}
+
+// touchedDirs returns the names of the date directories that received files
+func (j *parseJournal) touchedDirs() []string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	seen := make(map[string]bool)
+	dirNames := []string{}
+	for _, record := range j.records {
+		if record.Op != journalOpMove {
+			continue
+		}
+		rel, err := filepath.Rel(j.begin.TargetDir, record.To)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		dirName := strings.Split(rel, string(filepath.Separator))[0]
+		if dirName != journalDirName && dirName != quarantineDirName && !seen[dirName] {
+			seen[dirName] = true
+			dirNames = append(dirNames, dirName)
+		}
+	}
+	sort.Strings(dirNames)
+	return dirNames
+}
+
+// mkdirAll creates a directory, recording every directory it creates
+func (j *parseJournal) mkdirAll(dir string) error {
+	var missing []string
+	for d := dir; ; d = filepath.Dir(d) {
+		if _, err := os.Stat(d); err == nil || d == filepath.Dir(d) {
+			break
+		}
+		missing = append(missing, d)
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for i := len(missing) - 1; i >= 0; i-- {
+		if err := j.done(journalOpMkdir, "", missing[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rename moves or renames a file, recording it before and after
+func (j *parseJournal) rename(from, to string) error {
+	op := journalOpRename
+	if filepath.Dir(from) != filepath.Dir(to) {
+		op = journalOpMove
+	}
+	if err := j.planned(op, from, to); err != nil {
+		return err
+	}
+	if err := os.Rename(from, to); err != nil {
+		return err
+	}
+	return j.done(op, from, to)
+}
+
+// rollback undoes every move and rename in reverse order and removes the
+// directories created by the parse. Source files are never touched by a parse,
+// so removing the staging directory completes the rollback.
+func (j *parseJournal) rollback() error {
+	j.mu.Lock()
+	records := slices.Clone(j.records)
+	j.mu.Unlock()
+
+	var createdDirs []string
+	for i := len(records) - 1; i >= 0; i-- {
+		record := records[i]
+		switch record.Op {
+		case journalOpMove, journalOpRename:
+			// Planned operations may or may not have happened, so check the filesystem
+			if _, err := os.Stat(record.To); err != nil {
+				continue
+			}
+			if _, err := os.Stat(record.From); err == nil {
+				continue
+			}
+			logger.Debug("Rolling back", "op", record.Op, "from", record.To, "to", record.From)
+			if err := os.Rename(record.To, record.From); err != nil {
+				return fmt.Errorf("failed to restore %s: %w", record.From, err)
+			}
+		case journalOpMkdir:
+			createdDirs = append(createdDirs, record.To)
+		}
+	}
+
+	// createdDirs is already ordered from the deepest directory to the shallowest
+	for _, dir := range createdDirs {
+		if err := os.Remove(dir); err != nil && !os.IsNotExist(err) {
+			logger.Warn("Could not remove directory created by the parse", "dir", dir, "error", err)
+		}
+	}
+	return nil
+}
+
+// close closes the journal file, keeping it on disk
+func (j *parseJournal) close() error {
+	return j.file.Close()
+}
+
+// remove deletes the journal and the staging directory
+func (j *parseJournal) remove() error {
+	j.file.Close()
+	return os.RemoveAll(j.dir)
+}
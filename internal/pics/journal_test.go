@@ -0,0 +1,177 @@
+package pics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// failingCompressor fails for files whose path contains failOn
+type failingCompressor struct {
+	failOn string
+}
+
+func (c *failingCompressor) CompressFile(ctx context.Context, path string, quality int) (bool, error) {
+	if strings.Contains(path, c.failOn) {
+		return false, fmt.Errorf("simulated failure for %s", path)
+	}
+	return true, nil
+}
+
+// interruptAfterOrganiseByDate simulates a crash after files were moved into
+// date directories but before they were renamed
+func interruptAfterOrganiseByDate(t *testing.T, sourceDir, targetDir string) {
+	t.Helper()
+	parser := NewMediaParser().(*mediaParser)
+
+	journal, err := createParseJournal(sourceDir, targetDir, testParseOptions)
+	if err != nil {
+		t.Fatalf("Failed to create journal: %v", err)
+	}
+	if err := parser.copyAndCompressFiles(context.Background(), []string{sourceDir}, journal.stagingDir(), testParseOptions, journal, nil); err != nil {
+		t.Fatalf("Failed to copy files: %v", err)
+	}
+	if _, err := parser.organiser.withMover(journal).OrganiseByDate(journal.stagingDir(), targetDir, nil); err != nil {
+		t.Fatalf("Failed to organise by date: %v", err)
+	}
+	journal.close()
+}
+
+func TestMediaParser_Parse_RemovesJournalOnSuccess(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir, targetDir := createSourceAndTarget(t, tmpDir)
+	createMediaFile(t, sourceDir, "image.jpg", time.Date(2023, 6, 15, 10, 30, 0, 0, time.UTC))
+
+	if _, err := testParser.Parse(sourceDir, targetDir, testParseOptions); err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	assertMediaFileNotExists(t, filepath.Join(targetDir, journalDirName))
+}
+
+func TestMediaParser_Parse_RefusesWithExistingJournal(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir, targetDir := createSourceAndTarget(t, tmpDir)
+	createMediaFile(t, sourceDir, "image.jpg", time.Date(2023, 6, 15, 10, 30, 0, 0, time.UTC))
+
+	interruptAfterOrganiseByDate(t, sourceDir, targetDir)
+
+	_, err := testParser.Parse(sourceDir, targetDir, testParseOptions)
+	if !errors.Is(err, ErrJournalExists) {
+		t.Errorf("Expected ErrJournalExists, got: %v", err)
+	}
+}
+
+func TestMediaParser_Parse_KeepsJournalOnFailure(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir, targetDir := createSourceAndTarget(t, tmpDir)
+	testDate := time.Date(2023, 6, 15, 10, 30, 0, 0, time.UTC)
+	createMediaFile(t, sourceDir, "good.jpg", testDate)
+	createMediaFile(t, sourceDir, "bad.jpg", testDate)
+
+	parser := &mediaParser{
+		compressor: &failingCompressor{failOn: "bad"},
+		organiser:  NewFileOrganiser(),
+		extensions: NewExtensions(),
+	}
+	opts := testParseOptions
+	opts.CompressJPEGs = true
+
+	if _, err := parser.Parse(sourceDir, targetDir, opts); err == nil {
+		t.Fatal("Expected parse to fail")
+	}
+	assertMediaFileExists(t, filepath.Join(targetDir, journalDirName, journalFileName))
+
+	// Once the problem is fixed, the run can be resumed
+	parser.compressor = &failingCompressor{failOn: "nothing"}
+	if _, err := parser.Resume(targetDir, testParseOptions); err != nil {
+		t.Fatalf("Resume failed: %v", err)
+	}
+
+	dateDir := filepath.Join(targetDir, "2023 06 June 15")
+	assertMediaFileExists(t, filepath.Join(dateDir, "2023_06_June_15_00001.jpg"))
+	assertMediaFileExists(t, filepath.Join(dateDir, "2023_06_June_15_00002.jpg"))
+	assertMediaFileNotExists(t, filepath.Join(targetDir, journalDirName))
+}
+
+func TestMediaParser_Resume(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir, targetDir := createSourceAndTarget(t, tmpDir)
+	testDate := time.Date(2023, 6, 15, 10, 30, 0, 0, time.UTC)
+	createMediaFile(t, sourceDir, "image.jpg", testDate)
+	createMediaFile(t, sourceDir, "video.mov", testDate)
+
+	interruptAfterOrganiseByDate(t, sourceDir, targetDir)
+
+	if _, err := testParser.Resume(targetDir, testParseOptions); err != nil {
+		t.Fatalf("Resume failed: %v", err)
+	}
+
+	dateDir := filepath.Join(targetDir, "2023 06 June 15")
+	assertMediaFileExists(t, filepath.Join(dateDir, "2023_06_June_15_00001.jpg"))
+	assertMediaFileExists(t, filepath.Join(dateDir, "videos", "2023_06_June_15_00001.mov"))
+	assertMediaFileNotExists(t, filepath.Join(dateDir, "root-image.jpg"))
+	assertMediaFileNotExists(t, filepath.Join(targetDir, journalDirName))
+}
+
+func TestMediaParser_Rollback(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir, targetDir := createSourceAndTarget(t, tmpDir)
+
+	// Existing library directory with a file that the import would rename
+	existingDir := createSubdir(t, targetDir, "2023 06 June 15 Beach")
+	createMediaFile(t, existingDir, "2023_06_June_15_Beach_00001.jpg", time.Now())
+	createMediaFile(t, existingDir, "unsorted.jpg", time.Now())
+
+	testDate := time.Date(2023, 6, 15, 10, 30, 0, 0, time.UTC)
+	otherDate := time.Date(2023, 7, 20, 14, 0, 0, 0, time.UTC)
+	createMediaFile(t, sourceDir, "image.jpg", testDate)
+	createMediaFile(t, sourceDir, "video.mov", testDate)
+	createMediaFile(t, sourceDir, "other.jpg", otherDate)
+
+	// Simulate a crash after everything was organised but before the journal was removed
+	parser := NewMediaParser().(*mediaParser)
+	journal, err := createParseJournal(sourceDir, targetDir, testParseOptions)
+	if err != nil {
+		t.Fatalf("Failed to create journal: %v", err)
+	}
+	if err := parser.stageAndOrganise(context.Background(), journal, nil, &stageTimer{}, []string{sourceDir}, journal.stagingDir(), targetDir, testParseOptions); err != nil {
+		t.Fatalf("Failed to stage and organise: %v", err)
+	}
+	journal.close()
+	assertMediaFileExists(t, filepath.Join(existingDir, "2023_06_June_15_Beach_00003.jpg"))
+
+	if err := testParser.Rollback(targetDir); err != nil {
+		t.Fatalf("Rollback failed: %v", err)
+	}
+
+	// The library is back to how it was
+	assertMediaFileExists(t, filepath.Join(existingDir, "2023_06_June_15_Beach_00001.jpg"))
+	assertMediaFileExists(t, filepath.Join(existingDir, "unsorted.jpg"))
+	assertMediaFileNotExists(t, filepath.Join(existingDir, "2023_06_June_15_Beach_00002.jpg"))
+	assertMediaFileNotExists(t, filepath.Join(existingDir, "2023_06_June_15_Beach_00003.jpg"))
+	assertMediaFileNotExists(t, filepath.Join(existingDir, "videos"))
+	assertMediaFileNotExists(t, filepath.Join(targetDir, "2023 07 July 20"))
+	assertMediaFileNotExists(t, filepath.Join(targetDir, journalDirName))
+
+	// Source files are never touched
+	assertMediaFileExists(t, filepath.Join(sourceDir, "image.jpg"))
+	assertMediaFileExists(t, filepath.Join(sourceDir, "video.mov"))
+	assertMediaFileExists(t, filepath.Join(sourceDir, "other.jpg"))
+}
+
+func TestMediaParser_ResumeAndRollback_NoJournal(t *testing.T) {
+	tmpDir := t.TempDir()
+	_, targetDir := createSourceAndTarget(t, tmpDir)
+
+	if _, err := testParser.Resume(targetDir, testParseOptions); !errors.Is(err, ErrNoJournal) {
+		t.Errorf("Expected ErrNoJournal from Resume, got: %v", err)
+	}
+	if err := testParser.Rollback(targetDir); !errors.Is(err, ErrNoJournal) {
+		t.Errorf("Expected ErrNoJournal from Rollback, got: %v", err)
+	}
+}
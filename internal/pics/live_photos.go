@@ -0,0 +1,175 @@
+package pics
+
+import (
+	"context"
+	"maps"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/acm19/pics/internal/logger"
+)
+
+// contentIdentifierReader reads the Apple ContentIdentifier that links the still
+// image and the motion clip of a Live Photo
+type contentIdentifierReader interface {
+	contentIdentifiers(paths []string) map[string]string
+}
+
+// exiftoolContentIdentifierReader reads ContentIdentifiers with exiftool
+type exiftoolContentIdentifierReader struct {
+	exiftoolPath string
+	// pool runs the exiftool processes of a parse, without one a process is started for every call
+	pool *exiftoolPool
+}
+
+// contentIdentifiers returns the ContentIdentifier of every file that has one, keyed by path
+func (r exiftoolContentIdentifierReader) contentIdentifiers(paths []string) map[string]string {
+	identifiers := make(map[string]string)
+
+	pool := r.pool
+	if pool == nil {
+		pool = newExiftoolPool(context.Background(), r.exiftoolPath, 1)
+		defer pool.close()
+	}
+	for _, fileInfo := range pool.extractMetadata(paths) {
+		if fileInfo.Err != nil {
+			logger.Debug("Cannot read ContentIdentifier", "file", fileInfo.File, "error", fileInfo.Err)
+			continue
+		}
+		if id, err := fileInfo.GetString("ContentIdentifier"); err == nil && id != "" {
+			identifiers[fileInfo.File] = id
+		}
+	}
+	return identifiers
+}
+
+// nativeContentIdentifierReader reads ContentIdentifiers without exiftool, from the
+// Apple MakerNote of stills and the QuickTime metadata of motion clips. The files whose
+// metadata it cannot read are left to fallback.
+type nativeContentIdentifierReader struct {
+	fallback contentIdentifierReader
+}
+
+// contentIdentifiers returns the ContentIdentifier of every file that has one, keyed by path
+func (r nativeContentIdentifierReader) contentIdentifiers(paths []string) map[string]string {
+	identifiers := make(map[string]string)
+	var unread []string
+	for _, path := range paths {
+		tags, err := readNativeMetadata(path)
+		if err != nil {
+			unread = append(unread, path)
+			continue
+		}
+		if id := tags["ContentIdentifier"]; id != "" {
+			identifiers[path] = id
+		}
+	}
+	if len(unread) > 0 && r.fallback != nil {
+		maps.Copy(identifiers, r.fallback.contentIdentifiers(unread))
+	}
+	return identifiers
+}
+
+// livePhotoMatcher finds Live Photos: a still image and a motion clip taken together
+type livePhotoMatcher struct {
+	extensions  Extensions
+	identifiers contentIdentifierReader
+}
+
+func newLivePhotoMatcher(exiftoolPath string) *livePhotoMatcher {
+	return &livePhotoMatcher{
+		extensions:  NewExtensions(),
+		identifiers: nativeContentIdentifierReader{fallback: exiftoolContentIdentifierReader{exiftoolPath: exiftoolPath}},
+	}
+}
+
+// match pairs the still images and motion clips among files, whose names must be
+// unique. Files are paired when they share their base name (IMG_1234.HEIC and
+// IMG_1234.MOV), otherwise, when byContentIdentifier is set, when they share a
+// ContentIdentifier. It returns the name of the motion clip of every still that has one.
+func (m *livePhotoMatcher) match(files []companionFile, byContentIdentifier bool) map[string]string {
+	var stills, clips []companionFile
+	for _, file := range files {
+		switch {
+		case m.extensions.IsImage(file.name):
+			stills = append(stills, file)
+		case m.extensions.IsVideo(file.name):
+			clips = append(clips, file)
+		}
+	}
+	pairs := make(map[string]string)
+	if len(stills) == 0 || len(clips) == 0 {
+		return pairs
+	}
+	sort.Slice(stills, func(i, j int) bool { return stills[i].name < stills[j].name })
+
+	stillsByStem := make(map[string]string)
+	for _, still := range stills {
+		stem := livePhotoStem(still.name)
+		if _, taken := stillsByStem[stem]; !taken {
+			stillsByStem[stem] = still.name
+		}
+	}
+
+	paired := make(map[string]bool)
+	var unpairedClips []companionFile
+	for _, clip := range clips {
+		still, found := stillsByStem[livePhotoStem(clip.name)]
+		if found && !paired[still] {
+			pairs[still] = clip.name
+			paired[still] = true
+			continue
+		}
+		unpairedClips = append(unpairedClips, clip)
+	}
+
+	var unpairedStills []companionFile
+	for _, still := range stills {
+		if !paired[still.name] {
+			unpairedStills = append(unpairedStills, still)
+		}
+	}
+	if !byContentIdentifier || len(unpairedStills) == 0 || len(unpairedClips) == 0 || m.identifiers == nil {
+		return pairs
+	}
+
+	// Only read when base names are not enough, as it reads every file and may run
+	// exiftool. Most videos are not motion clips, so the stills are only read when a
+	// clip has an identifier.
+	clipPaths := make([]string, 0, len(unpairedClips))
+	for _, clip := range unpairedClips {
+		clipPaths = append(clipPaths, clip.path)
+	}
+	clipIdentifiers := m.identifiers.contentIdentifiers(clipPaths)
+	if len(clipIdentifiers) == 0 {
+		return pairs
+	}
+	stillPaths := make([]string, 0, len(unpairedStills))
+	for _, still := range unpairedStills {
+		stillPaths = append(stillPaths, still.path)
+	}
+	identifiers := m.identifiers.contentIdentifiers(stillPaths)
+
+	stillsByID := make(map[string]string)
+	for _, still := range unpairedStills {
+		if id := identifiers[still.path]; id != "" {
+			if _, taken := stillsByID[id]; !taken {
+				stillsByID[id] = still.name
+			}
+		}
+	}
+	for _, clip := range unpairedClips {
+		id := clipIdentifiers[clip.path]
+		if still, found := stillsByID[id]; found && id != "" && !paired[still] {
+			pairs[still] = clip.name
+			paired[still] = true
+		}
+	}
+	return pairs
+}
+
+// livePhotoStem returns the name of a file without its extension, lower cased
+func livePhotoStem(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, filepath.Ext(name)))
+}
@@ -0,0 +1,153 @@
+package pics
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// stubContentIdentifiers returns fixed ContentIdentifiers and records the paths it was asked about
+type stubContentIdentifiers struct {
+	identifiers map[string]string
+	read        [][]string
+}
+
+func (s *stubContentIdentifiers) contentIdentifiers(paths []string) map[string]string {
+	s.read = append(s.read, paths)
+	identifiers := make(map[string]string)
+	for _, path := range paths {
+		if id, found := s.identifiers[path]; found {
+			identifiers[path] = id
+		}
+	}
+	return identifiers
+}
+
+func companionFiles(names ...string) []companionFile {
+	files := make([]companionFile, len(names))
+	for i, name := range names {
+		files[i] = companionFile{name: name, path: "/dir/" + name}
+	}
+	return files
+}
+
+func TestNativeContentIdentifierReader_ContentIdentifiers(t *testing.T) {
+	tmpDir := t.TempDir()
+	still := writeTestFile(t, tmpDir, "IMG_0001.jpg", tiffJPEG(appleTIFF("2023:05:01 18:30:00", "A1B2")))
+	clip := writeTestFile(t, tmpDir, "IMG_0001.mov", quickTimeMovieWithKeys(time.Now(), quickTimeContentIdentifierKey, "A1B2"))
+	plain := writeTestFile(t, tmpDir, "plain.jpg", exifJPEG("2023:05:01 18:30:00"))
+	unread := writeTestFile(t, tmpDir, "clip.avi", []byte("RIFF"))
+	fallback := &stubContentIdentifiers{identifiers: map[string]string{unread: "E5F6"}}
+
+	identifiers := nativeContentIdentifierReader{fallback: fallback}.contentIdentifiers([]string{still, clip, plain, unread})
+
+	expected := map[string]string{still: "A1B2", clip: "A1B2", unread: "E5F6"}
+	if !reflect.DeepEqual(identifiers, expected) {
+		t.Errorf("Expected %v, got %v", expected, identifiers)
+	}
+	// Only the file the native reader cannot read is left to the fallback
+	if !reflect.DeepEqual(fallback.read, [][]string{{unread}}) {
+		t.Errorf("Expected only %s read by the fallback, got %v", unread, fallback.read)
+	}
+}
+
+func TestLivePhotoMatcher_Match_ByBaseName(t *testing.T) {
+	matcher := &livePhotoMatcher{extensions: NewExtensions()}
+
+	pairs := matcher.match(companionFiles("IMG_0001.HEIC", "IMG_0001.MOV", "img_0002.jpg", "IMG_0002.mov", "IMG_0003.JPG", "clip.mp4", "notes.txt"), true)
+
+	expected := map[string]string{
+		"IMG_0001.HEIC": "IMG_0001.MOV",
+		"img_0002.jpg":  "IMG_0002.mov",
+	}
+	if !reflect.DeepEqual(pairs, expected) {
+		t.Errorf("Expected %v, got %v", expected, pairs)
+	}
+}
+
+func TestLivePhotoMatcher_Match_ByContentIdentifier(t *testing.T) {
+	identifiers := &stubContentIdentifiers{identifiers: map[string]string{
+		"/dir/IMG_0001.HEIC":  "A1B2",
+		"/dir/IMG_0001.MOV":   "A1B2",
+		"/dir/root-0002.jpg":  "C3D4",
+		"/dir/other-0002.mov": "C3D4",
+	}}
+	matcher := &livePhotoMatcher{extensions: NewExtensions(), identifiers: identifiers}
+
+	pairs := matcher.match(companionFiles("IMG_0001.HEIC", "IMG_0001.MOV", "root-0002.jpg", "other-0002.mov", "root-0003.jpg", "clip.mov"), true)
+
+	expected := map[string]string{
+		"IMG_0001.HEIC": "IMG_0001.MOV",
+		"root-0002.jpg": "other-0002.mov",
+	}
+	if !reflect.DeepEqual(pairs, expected) {
+		t.Errorf("Expected %v, got %v", expected, pairs)
+	}
+
+	// Files paired by base name are not read
+	expectedReads := [][]string{
+		{"/dir/other-0002.mov", "/dir/clip.mov"},
+		{"/dir/root-0002.jpg", "/dir/root-0003.jpg"},
+	}
+	if !reflect.DeepEqual(identifiers.read, expectedReads) {
+		t.Errorf("Expected reads %v, got %v", expectedReads, identifiers.read)
+	}
+}
+
+func TestLivePhotoMatcher_Match_SkipsStillsWhenNoClipHasIdentifier(t *testing.T) {
+	identifiers := &stubContentIdentifiers{}
+	matcher := &livePhotoMatcher{extensions: NewExtensions(), identifiers: identifiers}
+
+	pairs := matcher.match(companionFiles("a.jpg", "b.jpg", "clip.mov"), true)
+	if len(pairs) != 0 {
+		t.Errorf("Expected no pairs, got %v", pairs)
+	}
+	if len(identifiers.read) != 1 {
+		t.Errorf("Expected only the clips to be read, got %v", identifiers.read)
+	}
+
+	identifiers.read = nil
+	matcher.match(companionFiles("a.jpg", "clip.mov"), false)
+	if len(identifiers.read) != 0 {
+		t.Errorf("Expected no reads without byContentIdentifier, got %v", identifiers.read)
+	}
+}
+
+func TestMediaParser_Parse_LivePhotos(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir, targetDir := createSourceAndTarget(t, tmpDir)
+	testDate := time.Date(2023, 6, 15, 10, 30, 0, 0, time.UTC)
+
+	createMediaFile(t, sourceDir, "IMG_0001.HEIC", testDate)
+	// The motion clip is dated just after midnight, it still follows its still
+	createMediaFile(t, sourceDir, "IMG_0001.MOV", testDate.AddDate(0, 0, 1))
+	createMediaFile(t, sourceDir, "IMG_0002.JPG", testDate)
+	createMediaFile(t, sourceDir, "IMG_0003.MOV", testDate)
+
+	plan, err := testParser.Plan(sourceDir, targetDir, testParseOptions)
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+	if _, err := testParser.Parse(sourceDir, targetDir, testParseOptions); err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	dateDir := "2023 06 June 15"
+	expected := map[string]string{
+		"IMG_0001.HEIC": filepath.Join(dateDir, "2023_06_June_15_00001.heic"),
+		"IMG_0001.MOV":  filepath.Join(dateDir, "2023_06_June_15_00001.mov"),
+		"IMG_0002.JPG":  filepath.Join(dateDir, "2023_06_June_15_00002.jpg"),
+		"IMG_0003.MOV":  filepath.Join(dateDir, "videos", "2023_06_June_15_00001.mov"),
+	}
+	for _, file := range plan.Files {
+		name := filepath.Base(file.Source)
+		if file.FinalPath != expected[name] {
+			t.Errorf("Expected %s planned at %s, got %s", name, expected[name], file.FinalPath)
+		}
+	}
+	for _, finalPath := range expected {
+		assertMediaFileExists(t, filepath.Join(targetDir, finalPath))
+	}
+	assertMediaFileNotExists(t, filepath.Join(targetDir, "2023 06 June 16"))
+}
@@ -0,0 +1,140 @@
+package pics
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/acm19/pics/internal/logger"
+)
+
+// freePath returns the path for name in dir, adding a counter before the extension
+// when a file of that name is already there
+func freePath(dir, name string) string {
+	dest := filepath.Join(dir, name)
+	ext := filepath.Ext(name)
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(dest); errors.Is(err, fs.ErrNotExist) {
+			return dest
+		}
+		dest = filepath.Join(dir, fmt.Sprintf("%s-%d%s", strings.TrimSuffix(name, ext), i, ext))
+	}
+}
+
+// hashFile returns the hex encoded SHA-256 hash of a file
+func hashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// verifiedSource is a source file whose organised copy matches the hashes recorded when it was copied
+type verifiedSource struct {
+	source    string
+	finalPath string
+}
+
+// verifySources checks every copy recorded with hashes in the journal. A source
+// is verified when its organised file still has the hash recorded after
+// compression and the source itself still has the hash it had when copied.
+// Files that ended up in the quarantine directory are never verified.
+func verifySources(journal *parseJournal, maxConcurrency int) []verifiedSource {
+	if maxConcurrency <= 0 {
+		maxConcurrency = 100
+	}
+	quarantineDir := filepath.Join(journal.begin.TargetDir, quarantineDirName) + string(filepath.Separator)
+
+	finalPaths := journal.finalPaths()
+
+	// Files extracted from an archive are removed with the journal, the archive itself is kept
+	var copies []journalRecord
+	for _, record := range journal.hashedCopies() {
+		if !strings.HasPrefix(record.From, journal.dir+string(filepath.Separator)) {
+			copies = append(copies, record)
+		}
+	}
+
+	var mu sync.Mutex
+	var verified []verifiedSource
+	err := runWorkerPool(copies, maxConcurrency, func(record journalRecord) error {
+		finalPath := finalPaths[record.To]
+		if strings.HasPrefix(finalPath, quarantineDir) {
+			logger.Warn("Keeping source of quarantined file", "source", record.From)
+			return fmt.Errorf("%s was quarantined", record.From)
+		}
+
+		if err := checkHash(finalPath, record.Hash); err != nil {
+			logger.Warn("Keeping source, organised file does not match", "source", record.From, "file", finalPath, "error", err)
+			return err
+		}
+		if err := syncFile(finalPath); err != nil {
+			logger.Warn("Keeping source, organised file could not be flushed to disk", "source", record.From, "file", finalPath, "error", err)
+			return err
+		}
+		if err := checkHash(record.From, record.SourceHash); err != nil {
+			logger.Warn("Keeping source, it changed after it was copied", "source", record.From, "error", err)
+			return err
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		verified = append(verified, verifiedSource{source: record.From, finalPath: finalPath})
+		return nil
+	})
+	if err != nil {
+		logger.Warn("Some source files will not be deleted", "error", err)
+	}
+	return verified
+}
+
+// checkHash returns an error unless the file at path has the expected hash
+func checkHash(path, expected string) error {
+	actual, err := hashFile(path)
+	if err != nil {
+		return err
+	}
+	if actual != expected {
+		return fmt.Errorf("hash mismatch for %s: expected %s, got %s", path, expected, actual)
+	}
+	return nil
+}
+
+// syncFile flushes a file to disk
+func syncFile(path string) error {
+	// Opened for writing as some platforms refuse to flush read-only handles
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return file.Sync()
+}
+
+// deleteSources deletes verified source files, returning how many were deleted
+func deleteSources(verified []verifiedSource) int {
+	deleted := 0
+	for _, file := range verified {
+		if err := os.Remove(file.source); err != nil {
+			logger.Warn("Failed to delete source file", "source", file.source, "error", err)
+			continue
+		}
+		logger.Debug("Deleted source file", "source", file.source, "verifiedAgainst", file.finalPath)
+		deleted++
+	}
+	return deleted
+}
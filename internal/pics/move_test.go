@@ -0,0 +1,128 @@
+package pics
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// appendingCompressor "compresses" files by appending to them, so their hash
+// changes, and preserves their modification time like jpegoptim -p
+type appendingCompressor struct{}
+
+func (c *appendingCompressor) CompressFile(ctx context.Context, path string, quality int) (bool, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		return false, err
+	}
+	if _, err := file.WriteString("compressed"); err != nil {
+		file.Close()
+		return false, err
+	}
+	if err := file.Close(); err != nil {
+		return false, err
+	}
+	return true, os.Chtimes(path, info.ModTime(), info.ModTime())
+}
+
+func TestMediaParser_Parse_MoveSource(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir, targetDir := createSourceAndTarget(t, tmpDir)
+	testDate := time.Date(2023, 6, 15, 10, 30, 0, 0, time.UTC)
+	image := createMediaFile(t, sourceDir, "image.jpg", testDate)
+	video := createMediaFile(t, createSubdir(t, sourceDir, "sub"), "video.mov", testDate)
+	unsupported := filepath.Join(sourceDir, "notes.txt")
+	if err := os.WriteFile(unsupported, []byte("notes"), 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+
+	parser := &mediaParser{
+		compressor: &appendingCompressor{},
+		organiser:  NewFileOrganiser(),
+		extensions: NewExtensions(),
+	}
+	opts := testParseOptions
+	opts.CompressJPEGs = true
+	opts.MoveSource = true
+
+	if _, err := parser.Parse(sourceDir, targetDir, opts); err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	dateDir := filepath.Join(targetDir, "2023 06 June 15")
+	assertMediaFileExists(t, filepath.Join(dateDir, "2023_06_June_15_00001.jpg"))
+	assertMediaFileExists(t, filepath.Join(dateDir, "videos", "2023_06_June_15_00001.mov"))
+
+	// Verified sources are deleted, files that were not imported are left alone
+	assertMediaFileNotExists(t, image)
+	assertMediaFileNotExists(t, video)
+	assertMediaFileExists(t, unsupported)
+}
+
+func TestMediaParser_Parse_MoveSourceKeepsQuarantinedFiles(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir, targetDir := createSourceAndTarget(t, tmpDir)
+	testDate := time.Date(2023, 6, 15, 10, 30, 0, 0, time.UTC)
+	good := createMediaFile(t, sourceDir, "good.jpg", testDate)
+	nodate := createMediaFile(t, sourceDir, "nodate.jpg", testDate)
+
+	parser := newParserWithFailures("nothing", "nodate")
+	opts := testParseOptions
+	opts.MoveSource = true
+	opts.ErrorPolicy = ErrorPolicyContinue
+
+	if _, err := parser.Parse(sourceDir, targetDir, opts); err == nil {
+		t.Fatal("Expected a partial parse error")
+	}
+
+	assertMediaFileNotExists(t, good)
+	assertMediaFileExists(t, nodate)
+}
+
+func TestVerifySources_Mismatch(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir, targetDir := createSourceAndTarget(t, tmpDir)
+	testDate := time.Date(2023, 6, 15, 10, 30, 0, 0, time.UTC)
+	createMediaFile(t, sourceDir, "a.jpg", testDate)
+	createMediaFile(t, sourceDir, "b.jpg", testDate)
+	changedSource := createMediaFile(t, sourceDir, "c.jpg", testDate)
+
+	opts := testParseOptions
+	opts.MoveSource = true
+
+	parser := NewMediaParser().(*mediaParser)
+	journal, err := createParseJournal(sourceDir, targetDir, opts)
+	if err != nil {
+		t.Fatalf("Failed to create journal: %v", err)
+	}
+	defer journal.remove()
+	if err := parser.stageAndOrganise(context.Background(), journal, nil, &stageTimer{}, []string{sourceDir}, journal.stagingDir(), targetDir, opts); err != nil {
+		t.Fatalf("Failed to stage and organise: %v", err)
+	}
+
+	// b's organised copy is corrupted and c's source changes after it was copied
+	dateDir := filepath.Join(targetDir, "2023 06 June 15")
+	if err := os.WriteFile(filepath.Join(dateDir, "2023_06_June_15_00002.jpg"), []byte("corrupted"), 0644); err != nil {
+		t.Fatalf("Failed to corrupt file: %v", err)
+	}
+	if err := os.WriteFile(changedSource, []byte("edited"), 0644); err != nil {
+		t.Fatalf("Failed to change source: %v", err)
+	}
+
+	verified := verifySources(journal, 2)
+	if len(verified) != 1 {
+		t.Fatalf("Expected 1 verified source, got %d: %+v", len(verified), verified)
+	}
+	if verified[0].source != filepath.Join(sourceDir, "a.jpg") {
+		t.Errorf("Expected a.jpg to be verified, got %s", verified[0].source)
+	}
+	if verified[0].finalPath != filepath.Join(dateDir, "2023_06_June_15_00001.jpg") {
+		t.Errorf("Unexpected final path: %s", verified[0].finalPath)
+	}
+}
@@ -0,0 +1,794 @@
+package pics
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/acm19/pics/internal/logger"
+)
+
+const (
+	// maxMetadataSize is the largest box or EXIF item read into memory, well above
+	// what cameras and phones write, so a corrupt size cannot exhaust memory
+	maxMetadataSize = 16 << 20
+
+	// rawMetadataSize is how much of a TIFF-based RAW file is read for its metadata. The
+	// IFDs sit at the start, ahead of the previews and the image data.
+	rawMetadataSize = 1 << 20
+
+	// quickTimeCreationDateKey is the metadata key of the capture date Apple devices
+	// write into QuickTime files, with the timezone of the device
+	quickTimeCreationDateKey = "com.apple.quicktime.creationdate"
+
+	// quickTimeContentIdentifierKey is the metadata key of the ContentIdentifier that
+	// links the motion clip of a Live Photo to its still image
+	quickTimeContentIdentifierKey = "com.apple.quicktime.content.identifier"
+
+	// exifMakerNoteTag is the EXIF tag of the MakerNote, and appleContentIdentifierTag
+	// the tag of the ContentIdentifier in the MakerNote of Apple devices
+	exifMakerNoteTag          = 0x927C
+	appleContentIdentifierTag = 0x0011
+)
+
+// quickTimeEpoch is the start of the times in QuickTime and MP4 files
+var quickTimeEpoch = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// exifDateTags maps the EXIF tags the native reader collects to the names exiftool gives them
+var exifDateTags = map[uint16]string{
+	0x9003: "DateTimeOriginal",
+	0x9004: "CreateDate",
+	0x9011: "OffsetTimeOriginal",
+	0x9012: "OffsetTimeDigitized",
+}
+
+// nativeDateExtractor reads the date from the metadata of JPEG, HEIC, TIFF-based RAW and
+// QuickTime/MP4 files without exiftool. It reads the fields exiftool would and formats them the way
+// exiftool does, so a file is dated the same whichever reads it. Other formats are left
+// to the EXIF extractor.
+type nativeDateExtractor struct {
+	// location is where dates that carry no timezone are read in, nil for the system timezone
+	location *time.Location
+
+	mu sync.Mutex
+	// prefetched holds the dates read by prefetch, keyed by path, until getFileDate uses them
+	prefetched map[string]time.Time
+}
+
+func newNativeDateExtractor() *nativeDateExtractor {
+	return &nativeDateExtractor{}
+}
+
+// withLocation returns a copy of the extractor that reads dates that carry no timezone in
+// loc. Dates read ahead are not carried over.
+func (e *nativeDateExtractor) withLocation(loc *time.Location) *nativeDateExtractor {
+	return &nativeDateExtractor{location: loc}
+}
+
+func (e *nativeDateExtractor) name() string {
+	return "Native"
+}
+
+func (e *nativeDateExtractor) getFileDate(filePath string) (time.Time, error) {
+	e.mu.Lock()
+	date, found := e.prefetched[filePath]
+	delete(e.prefetched, filePath)
+	e.mu.Unlock()
+	if found {
+		return date, nil
+	}
+	return e.readFileDate(filePath)
+}
+
+// readFileDate reads the date of a file from its metadata
+func (e *nativeDateExtractor) readFileDate(filePath string) (time.Time, error) {
+	tags, err := readNativeMetadata(filePath)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return metadataDate(filePath, func(field string) (string, bool) {
+		value, found := tags[field]
+		return value, found
+	}, e.location)
+}
+
+// prefetch reads the dates of paths ahead of getFileDate and returns the paths the native
+// reader has no date for, so only those are read ahead by the extractors after it. Dates
+// left by an earlier prefetch are dropped, as staged paths repeat across parses.
+func (e *nativeDateExtractor) prefetch(paths []string) []string {
+	dates := make([]time.Time, len(paths))
+	indices := make([]int, len(paths))
+	for i := range indices {
+		indices[i] = i
+	}
+	runWorkerPool(indices, runtime.NumCPU(), func(i int) error {
+		// Failures are not kept, getFileDate reads again and reports them
+		if date, err := e.readFileDate(paths[i]); err == nil {
+			dates[i] = date
+		}
+		return nil
+	})
+
+	prefetched := make(map[string]time.Time, len(paths))
+	var undated []string
+	for i, path := range paths {
+		if dates[i].IsZero() {
+			undated = append(undated, path)
+			continue
+		}
+		prefetched[path] = dates[i]
+	}
+	e.mu.Lock()
+	e.prefetched = prefetched
+	e.mu.Unlock()
+	return undated
+}
+
+// readNativeMetadata returns the date tags and the Live Photo ContentIdentifier of the
+// file at path, named and formatted like exiftool's
+func readNativeMetadata(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	header := make([]byte, sniffLength)
+	n, _ := io.ReadFull(file, header)
+	format, ok := detectFormat(header[:n])
+	if !ok {
+		return nil, errors.New("unrecognised file format")
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	var tags map[string]string
+	var mimeType string
+	switch format.name {
+	case formatJPEG.name:
+		tags, err = jpegMetadata(bufio.NewReader(file))
+		mimeType = "image/jpeg"
+	case formatHEIC.name:
+		tags, err = heicMetadata(file)
+		mimeType = "image/heic"
+	case formatMOV.name:
+		tags, err = quickTimeMetadata(file)
+		mimeType = "video/quicktime"
+	case formatMP4.name:
+		tags, err = quickTimeMetadata(file)
+		mimeType = "video/mp4"
+	case formatCR2.name:
+		tags, err = rawMetadata(file)
+		mimeType = "image/x-canon-cr2"
+	case formatTIFFRaw.name:
+		tags, err = rawMetadata(file)
+		mimeType = "image/tiff"
+	default:
+		return nil, fmt.Errorf("%s metadata is not read natively", format.name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Tells QuickTime dates, kept in UTC, from EXIF ones
+	tags["MIMEType"] = mimeType
+	logger.Debug("Read metadata natively", "file", filepath.Base(path), "format", format.name, "tags", tags)
+	return tags, nil
+}
+
+// jpegMetadata returns the date tags of the EXIF segment of a JPEG stream
+func jpegMetadata(r io.Reader) (map[string]string, error) {
+	var tiff []byte
+	err := walkJPEGSegments(r, func(marker byte, payload []byte) error {
+		exif, found := bytes.CutPrefix(payload, []byte("Exif\x00\x00"))
+		if marker != 0xE1 || !found {
+			return nil
+		}
+		tiff = exif
+		return errSegmentFound
+	})
+	if tiff == nil {
+		if err != nil {
+			return nil, err
+		}
+		return nil, errors.New("no EXIF segment")
+	}
+	return exifTags(tiff)
+}
+
+// rawMetadata returns the date tags of a TIFF-based RAW file, which is a TIFF structure
+func rawMetadata(r io.ReadSeeker) (map[string]string, error) {
+	end, err := fileEnd(r)
+	if err != nil {
+		return nil, err
+	}
+	tiff, err := readRange(r, 0, min(end, rawMetadataSize))
+	if err != nil {
+		return nil, err
+	}
+	return exifTags(tiff)
+}
+
+// exifTags returns the date tags of the EXIF IFD of a TIFF structure, and the
+// ContentIdentifier of its MakerNote when an Apple device wrote it
+func exifTags(tiff []byte) (map[string]string, error) {
+	order, err := tiffOrder(tiff)
+	if err != nil {
+		return nil, err
+	}
+	ifd0, err := tiffEntries(tiff, order, order.Uint32(tiff[4:]))
+	if err != nil {
+		return nil, err
+	}
+	pointer, found := ifd0[0x8769]
+	if !found {
+		return nil, errors.New("no EXIF IFD")
+	}
+	exifIFD, err := tiffEntries(tiff, order, order.Uint32(pointer[8:]))
+	if err != nil {
+		return nil, err
+	}
+
+	tags := make(map[string]string)
+	for tag, name := range exifDateTags {
+		if entry, found := exifIFD[tag]; found {
+			if value, ok := tiffASCII(tiff, order, entry); ok {
+				tags[name] = value
+			}
+		}
+	}
+	if entry, found := exifIFD[exifMakerNoteTag]; found {
+		if makerNote, ok := tiffBytes(tiff, order, entry); ok {
+			if id, ok := appleContentIdentifier(makerNote); ok && id != "" {
+				tags["ContentIdentifier"] = id
+			}
+		}
+	}
+	return tags, nil
+}
+
+// appleContentIdentifier returns the ContentIdentifier of an Apple MakerNote: an
+// "Apple iOS" header, its byte order at offset 12 and an IFD at offset 14, whose value
+// offsets count from the start of the MakerNote
+func appleContentIdentifier(makerNote []byte) (string, bool) {
+	if !bytes.HasPrefix(makerNote, []byte("Apple iOS\x00")) || len(makerNote) < 16 {
+		return "", false
+	}
+	var order binary.ByteOrder = binary.BigEndian
+	if string(makerNote[12:14]) == "II" {
+		order = binary.LittleEndian
+	}
+	entries, err := tiffEntries(makerNote, order, 14)
+	if err != nil {
+		return "", false
+	}
+	entry, found := entries[appleContentIdentifierTag]
+	if !found {
+		return "", false
+	}
+	return tiffASCII(makerNote, order, entry)
+}
+
+// tiffOrder returns the byte order of a TIFF structure from its header
+func tiffOrder(tiff []byte) (binary.ByteOrder, error) {
+	if len(tiff) < 8 {
+		return nil, errors.New("truncated TIFF header")
+	}
+	switch string(tiff[:4]) {
+	case "II*\x00":
+		return binary.LittleEndian, nil
+	case "MM\x00*":
+		return binary.BigEndian, nil
+	}
+	return nil, errors.New("invalid TIFF header")
+}
+
+// tiffEntries returns the 12 byte entries of the IFD at offset, keyed by tag
+func tiffEntries(tiff []byte, order binary.ByteOrder, offset uint32) (map[uint16][]byte, error) {
+	if offset < 8 || uint64(offset)+2 > uint64(len(tiff)) {
+		return nil, fmt.Errorf("IFD offset %d out of range", offset)
+	}
+	count := int(order.Uint16(tiff[offset:]))
+	start := int(offset) + 2
+	if start+count*12 > len(tiff) {
+		return nil, errors.New("truncated IFD")
+	}
+	entries := make(map[uint16][]byte, count)
+	for i := 0; i < count; i++ {
+		entry := tiff[start+i*12 : start+(i+1)*12]
+		entries[order.Uint16(entry)] = entry
+	}
+	return entries, nil
+}
+
+// tiffASCII returns the string value of an IFD entry, false when it is not an ASCII one
+func tiffASCII(tiff []byte, order binary.ByteOrder, entry []byte) (string, bool) {
+	const typeASCII = 2
+	if order.Uint16(entry[2:]) != typeASCII {
+		return "", false
+	}
+	value, ok := tiffBytes(tiff, order, entry)
+	if !ok {
+		return "", false
+	}
+	return string(bytes.TrimRight(value, "\x00 ")), true
+}
+
+// tiffBytes returns the value of an IFD entry of a single byte type (BYTE, ASCII or
+// UNDEFINED), false when it is of another type or lies outside tiff
+func tiffBytes(tiff []byte, order binary.ByteOrder, entry []byte) ([]byte, bool) {
+	const typeByte, typeASCII, typeUndefined = 1, 2, 7
+	switch order.Uint16(entry[2:]) {
+	case typeByte, typeASCII, typeUndefined:
+	default:
+		return nil, false
+	}
+	count := uint64(order.Uint32(entry[4:]))
+	value := entry[8:12]
+	if count > 4 {
+		offset := uint64(order.Uint32(entry[8:]))
+		if offset+count > uint64(len(tiff)) {
+			return nil, false
+		}
+		value = tiff[offset : offset+count]
+	}
+	return value[:min(count, uint64(len(value)))], true
+}
+
+// bmffBox is a box of an ISO-BMFF (HEIC, QuickTime, MP4) file
+type bmffBox struct {
+	kind string
+	// start and end are the offsets of the payload of the box, after its header
+	start, end int64
+}
+
+// walkBoxes calls fn with every box between the offsets start and end of r, stopping
+// at the first error fn returns
+func walkBoxes(r io.ReadSeeker, start, end int64, fn func(box bmffBox) error) error {
+	header := make([]byte, 16)
+	for offset := start; offset+8 <= end; {
+		if _, err := r.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(r, header[:8]); err != nil {
+			return err
+		}
+		size := int64(binary.BigEndian.Uint32(header))
+		headerSize := int64(8)
+		switch size {
+		case 0:
+			// The last box runs to the end
+			size = end - offset
+		case 1:
+			if _, err := io.ReadFull(r, header[8:16]); err != nil {
+				return err
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:]))
+			headerSize = 16
+		}
+		if size < headerSize || offset+size > end {
+			return fmt.Errorf("invalid size %d of %q box", size, header[4:8])
+		}
+
+		box := bmffBox{kind: string(header[4:8]), start: offset + headerSize, end: offset + size}
+		if err := fn(box); err != nil {
+			return err
+		}
+		offset += size
+	}
+	return nil
+}
+
+// errBoxFound stops walking the boxes of a file once the one looked for is found
+var errBoxFound = errors.New("box found")
+
+// findBox returns the first box of kind between the offsets start and end of r
+func findBox(r io.ReadSeeker, start, end int64, kind string) (bmffBox, error) {
+	var found bmffBox
+	err := walkBoxes(r, start, end, func(box bmffBox) error {
+		if box.kind != kind {
+			return nil
+		}
+		found = box
+		return errBoxFound
+	})
+	switch {
+	case errors.Is(err, errBoxFound):
+		return found, nil
+	case err != nil:
+		return bmffBox{}, err
+	}
+	return bmffBox{}, fmt.Errorf("no %s box", kind)
+}
+
+// readRange reads the length bytes at offset of r
+func readRange(r io.ReadSeeker, offset, length int64) ([]byte, error) {
+	if length < 0 || length > maxMetadataSize {
+		return nil, fmt.Errorf("metadata of %d bytes is too large", length)
+	}
+	if _, err := r.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// readPayload reads the payload of box
+func readPayload(r io.ReadSeeker, box bmffBox) ([]byte, error) {
+	return readRange(r, box.start, box.end-box.start)
+}
+
+// fileEnd returns the size of r
+func fileEnd(r io.ReadSeeker) (int64, error) {
+	return r.Seek(0, io.SeekEnd)
+}
+
+// byteCursor reads big-endian integers from data, recording the first read past its end
+type byteCursor struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+// uint reads an integer of size bytes (0, 1, 2, 4 or 8), 0 being an absent field
+func (c *byteCursor) uint(size int) uint64 {
+	if c.err != nil || size == 0 {
+		return 0
+	}
+	if c.pos+size > len(c.data) {
+		c.err = errors.New("truncated box")
+		return 0
+	}
+	field := c.data[c.pos : c.pos+size]
+	c.pos += size
+	switch size {
+	case 1:
+		return uint64(field[0])
+	case 2:
+		return uint64(binary.BigEndian.Uint16(field))
+	case 4:
+		return uint64(binary.BigEndian.Uint32(field))
+	case 8:
+		return binary.BigEndian.Uint64(field)
+	}
+	c.err = fmt.Errorf("invalid field size %d", size)
+	return 0
+}
+
+// next reads the following n bytes, nil when there are fewer left
+func (c *byteCursor) next(n int) []byte {
+	if c.err != nil {
+		return nil
+	}
+	if c.pos+n > len(c.data) {
+		c.err = errors.New("truncated box")
+		return nil
+	}
+	c.pos += n
+	return c.data[c.pos-n : c.pos]
+}
+
+// skip moves the cursor n bytes forward
+func (c *byteCursor) skip(n int) {
+	c.next(n)
+}
+
+// heicMetadata returns the date tags of the Exif item of a HEIC file, found through the
+// item information (iinf) and item location (iloc) boxes of its meta box
+func heicMetadata(r io.ReadSeeker) (map[string]string, error) {
+	end, err := fileEnd(r)
+	if err != nil {
+		return nil, err
+	}
+	meta, err := findBox(r, 0, end, "meta")
+	if err != nil {
+		return nil, err
+	}
+	// meta is a full box, its children follow the version and flags
+	iinf, err := findBox(r, meta.start+4, meta.end, "iinf")
+	if err != nil {
+		return nil, err
+	}
+	itemID, err := exifItemID(r, iinf)
+	if err != nil {
+		return nil, err
+	}
+	iloc, err := findBox(r, meta.start+4, meta.end, "iloc")
+	if err != nil {
+		return nil, err
+	}
+	item, err := readItem(r, iloc, itemID)
+	if err != nil {
+		return nil, err
+	}
+
+	// The item starts with the offset of the TIFF header from the end of the offset itself
+	if len(item) < 4 {
+		return nil, errors.New("truncated Exif item")
+	}
+	offset := uint64(binary.BigEndian.Uint32(item)) + 4
+	if offset > uint64(len(item)) {
+		return nil, errors.New("invalid Exif item header")
+	}
+	return exifTags(item[offset:])
+}
+
+// exifItemID returns the ID of the Exif item listed in an iinf box
+func exifItemID(r io.ReadSeeker, iinf bmffBox) (uint64, error) {
+	data, err := readPayload(r, iinf)
+	if err != nil {
+		return 0, err
+	}
+	cursor := &byteCursor{data: data}
+	version := cursor.uint(1)
+	cursor.skip(3)
+	if version == 0 {
+		cursor.uint(2)
+	} else {
+		cursor.uint(4)
+	}
+	if cursor.err != nil {
+		return 0, cursor.err
+	}
+
+	var itemID uint64
+	entries := bytes.NewReader(data)
+	err = walkBoxes(entries, int64(cursor.pos), int64(len(data)), func(box bmffBox) error {
+		if box.kind != "infe" {
+			return nil
+		}
+		infe := &byteCursor{data: data[box.start:box.end]}
+		version := infe.uint(1)
+		infe.skip(3)
+		if version < 2 {
+			// Older entries have no item type
+			return nil
+		}
+		idSize := 2
+		if version > 2 {
+			idSize = 4
+		}
+		id := infe.uint(idSize)
+		infe.uint(2)
+		if string(infe.next(4)) == "Exif" {
+			itemID = id
+			return errBoxFound
+		}
+		return nil
+	})
+	switch {
+	case errors.Is(err, errBoxFound):
+		return itemID, nil
+	case err != nil:
+		return 0, err
+	}
+	return 0, errors.New("no Exif item")
+}
+
+// readItem reads the data of item itemID from the extents an iloc box lists for it
+func readItem(r io.ReadSeeker, iloc bmffBox, itemID uint64) ([]byte, error) {
+	data, err := readPayload(r, iloc)
+	if err != nil {
+		return nil, err
+	}
+	cursor := &byteCursor{data: data}
+	version := cursor.uint(1)
+	cursor.skip(3)
+	sizes := cursor.uint(1)
+	offsetSize, lengthSize := int(sizes>>4), int(sizes&0x0F)
+	sizes = cursor.uint(1)
+	baseOffsetSize, indexSize := int(sizes>>4), 0
+	if version == 1 || version == 2 {
+		indexSize = int(sizes & 0x0F)
+	}
+	idSize := 2
+	if version == 2 {
+		idSize = 4
+	}
+	count := cursor.uint(idSize)
+
+	for i := uint64(0); i < count && cursor.err == nil; i++ {
+		id := cursor.uint(idSize)
+		method := uint64(0)
+		if version == 1 || version == 2 {
+			method = cursor.uint(2) & 0x0F
+		}
+		cursor.uint(2)
+		baseOffset := cursor.uint(baseOffsetSize)
+		extents := cursor.uint(2)
+
+		var item []byte
+		for j := uint64(0); j < extents && cursor.err == nil; j++ {
+			cursor.uint(indexSize)
+			offset := cursor.uint(offsetSize)
+			length := cursor.uint(lengthSize)
+			if id != itemID || cursor.err != nil {
+				continue
+			}
+			if method != 0 {
+				return nil, fmt.Errorf("unsupported construction method %d of item %d", method, id)
+			}
+			if uint64(len(item))+length > maxMetadataSize {
+				return nil, fmt.Errorf("item %d is too large", id)
+			}
+			extent, err := readRange(r, int64(baseOffset+offset), int64(length))
+			if err != nil {
+				return nil, err
+			}
+			item = append(item, extent...)
+		}
+		if id == itemID && cursor.err == nil {
+			return item, nil
+		}
+	}
+	if cursor.err != nil {
+		return nil, cursor.err
+	}
+	return nil, fmt.Errorf("no location for item %d", itemID)
+}
+
+// quickTimeMetadata returns the date tags of a QuickTime or MP4 file: CreationDate from
+// the com.apple.quicktime.creationdate key of its metadata and CreateDate from its movie
+// header (mvhd), which QuickTime keeps in UTC. The ContentIdentifier of a Live Photo
+// clip is read from its metadata too.
+func quickTimeMetadata(r io.ReadSeeker) (map[string]string, error) {
+	end, err := fileEnd(r)
+	if err != nil {
+		return nil, err
+	}
+	moov, err := findBox(r, 0, end, "moov")
+	if err != nil {
+		return nil, err
+	}
+
+	tags := make(map[string]string)
+	if mvhd, err := findBox(r, moov.start, moov.end, "mvhd"); err == nil {
+		if created, err := movieCreationTime(r, mvhd); err == nil && !created.IsZero() {
+			tags["CreateDate"] = created.Format("2006:01:02 15:04:05")
+		}
+	}
+
+	// Apple devices write the metadata into moov, other recorders into moov/udta
+	meta, err := findBox(r, moov.start, moov.end, "meta")
+	if err != nil {
+		udta, udtaErr := findBox(r, moov.start, moov.end, "udta")
+		if udtaErr == nil {
+			meta, err = findBox(r, udta.start, udta.end, "meta")
+		}
+	}
+	if err == nil {
+		if value, err := quickTimeKey(r, meta, quickTimeCreationDateKey); err == nil {
+			if date, ok := parseQuickTimeDate(value); ok {
+				tags["CreationDate"] = date
+			}
+		}
+		if id, err := quickTimeKey(r, meta, quickTimeContentIdentifierKey); err == nil && id != "" {
+			tags["ContentIdentifier"] = id
+		}
+	}
+
+	if len(tags) == 0 {
+		return nil, errors.New("no QuickTime metadata")
+	}
+	return tags, nil
+}
+
+// movieCreationTime returns the creation time of an mvhd box, zero when it is not set
+func movieCreationTime(r io.ReadSeeker, mvhd bmffBox) (time.Time, error) {
+	data, err := readRange(r, mvhd.start, min(mvhd.end-mvhd.start, 12))
+	if err != nil {
+		return time.Time{}, err
+	}
+	cursor := &byteCursor{data: data}
+	version := cursor.uint(1)
+	cursor.skip(3)
+	var seconds uint64
+	if version == 1 {
+		seconds = cursor.uint(8)
+	} else {
+		seconds = cursor.uint(4)
+	}
+	if cursor.err != nil || seconds == 0 {
+		return time.Time{}, cursor.err
+	}
+	return quickTimeEpoch.Add(time.Duration(seconds) * time.Second), nil
+}
+
+// quickTimeKey returns the string value of key in a QuickTime meta box, which names its
+// keys in a keys box and holds their values in an ilst box, indexed from 1
+func quickTimeKey(r io.ReadSeeker, meta bmffBox, key string) (string, error) {
+	// In QuickTime files meta is a plain box, in MP4 files a full box with version and flags
+	start := meta.start
+	header, err := readRange(r, start, min(meta.end-start, 4))
+	if err != nil {
+		return "", err
+	}
+	if len(header) == 4 && binary.BigEndian.Uint32(header) == 0 {
+		start += 4
+	}
+
+	keysBox, err := findBox(r, start, meta.end, "keys")
+	if err != nil {
+		return "", err
+	}
+	keys, err := readPayload(r, keysBox)
+	if err != nil {
+		return "", err
+	}
+	cursor := &byteCursor{data: keys}
+	cursor.skip(4)
+	count := cursor.uint(4)
+	var index uint64
+	for i := uint64(1); i <= count && cursor.err == nil; i++ {
+		size := int(cursor.uint(4))
+		cursor.skip(4)
+		if cursor.err != nil || size < 8 || cursor.pos+size-8 > len(keys) {
+			return "", errors.New("invalid keys box")
+		}
+		if string(keys[cursor.pos:cursor.pos+size-8]) == key {
+			index = i
+			break
+		}
+		cursor.skip(size - 8)
+	}
+	if index == 0 {
+		return "", fmt.Errorf("no %s key", key)
+	}
+
+	ilst, err := findBox(r, start, meta.end, "ilst")
+	if err != nil {
+		return "", err
+	}
+	var entry bmffBox
+	err = walkBoxes(r, ilst.start, ilst.end, func(box bmffBox) error {
+		if uint64(binary.BigEndian.Uint32([]byte(box.kind))) != index {
+			return nil
+		}
+		entry = box
+		return errBoxFound
+	})
+	if err != nil && !errors.Is(err, errBoxFound) {
+		return "", err
+	}
+	if entry.kind == "" {
+		return "", fmt.Errorf("no value for %s key", key)
+	}
+
+	dataBox, err := findBox(r, entry.start, entry.end, "data")
+	if err != nil {
+		return "", err
+	}
+	data, err := readPayload(r, dataBox)
+	if err != nil {
+		return "", err
+	}
+	// A type indicator and a locale precede the value, type 1 is UTF-8
+	const typeUTF8 = 1
+	if len(data) < 8 || binary.BigEndian.Uint32(data) != typeUTF8 {
+		return "", fmt.Errorf("%s key is not a string", key)
+	}
+	return string(data[8:]), nil
+}
+
+// parseQuickTimeDate turns an ISO 8601 date of QuickTime metadata (e.g.
+// "2023-05-01T18:30:00+0200") into the format exiftool prints it in
+// ("2023:05:01 18:30:00+02:00")
+func parseQuickTimeDate(value string) (string, bool) {
+	for _, layout := range []string{"2006-01-02T15:04:05-0700", time.RFC3339} {
+		if date, err := time.Parse(layout, value); err == nil {
+			return date.Format("2006:01:02 15:04:05-07:00"), true
+		}
+	}
+	return "", false
+}
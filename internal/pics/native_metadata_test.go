@@ -0,0 +1,296 @@
+package pics
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// exifTIFF returns a big-endian TIFF structure whose EXIF IFD holds createDate
+func exifTIFF(createDate string) []byte {
+	value := append([]byte(createDate), 0)
+	tiff := []byte("MM\x00\x2A\x00\x00\x00\x08")
+	// IFD0 with the EXIF IFD pointer alone, the EXIF IFD following it at offset 26
+	tiff = append(tiff, 0x00, 0x01, 0x87, 0x69, 0x00, 0x04, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 26, 0, 0, 0, 0)
+	// EXIF IFD with CreateDate alone, its value following it at offset 44
+	tiff = append(tiff, 0x00, 0x01, 0x90, 0x04, 0x00, 0x02, 0, 0, 0, byte(len(value)), 0x00, 0x00, 0x00, 44, 0, 0, 0, 0)
+	return append(tiff, value...)
+}
+
+// appleTIFF returns a big-endian TIFF structure whose EXIF IFD holds createDate and an
+// Apple MakerNote holding contentIdentifier
+func appleTIFF(createDate, contentIdentifier string) []byte {
+	date := append([]byte(createDate), 0)
+	id := append([]byte(contentIdentifier), 0)
+	// The MakerNote IFD follows its 14 byte header, the identifier following it at offset 32
+	makerNote := append([]byte("Apple iOS\x00\x00\x01MM"), 0x00, 0x01, 0x00, 0x11, 0x00, 0x02)
+	makerNote = append(append(append(makerNote, be32(uint32(len(id)))...), be32(32)...), 0, 0, 0, 0)
+	makerNote = append(makerNote, id...)
+
+	tiff := []byte("MM\x00\x2A\x00\x00\x00\x08")
+	// IFD0 with the EXIF IFD pointer alone, the EXIF IFD following it at offset 26
+	tiff = append(tiff, 0x00, 0x01, 0x87, 0x69, 0x00, 0x04, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 26, 0, 0, 0, 0)
+	// EXIF IFD with CreateDate and MakerNote, their values following it at offset 56
+	tiff = append(tiff, 0x00, 0x02, 0x90, 0x04, 0x00, 0x02)
+	tiff = append(append(tiff, be32(uint32(len(date)))...), be32(56)...)
+	tiff = append(tiff, 0x92, 0x7C, 0x00, 0x07)
+	tiff = append(append(tiff, be32(uint32(len(makerNote)))...), be32(uint32(56+len(date)))...)
+	tiff = append(tiff, 0, 0, 0, 0)
+	return append(append(tiff, date...), makerNote...)
+}
+
+// exifJPEG returns a JPEG file with an EXIF segment holding createDate
+func exifJPEG(createDate string) []byte {
+	return tiffJPEG(exifTIFF(createDate))
+}
+
+// tiffJPEG returns a JPEG file with an EXIF segment holding the TIFF structure tiff
+func tiffJPEG(tiff []byte) []byte {
+	return withExif(minimalJPEG(), tiff)
+}
+
+// withExif returns the JPEG file data with an EXIF segment holding the TIFF structure
+// tiff right after its SOI marker
+func withExif(data, tiff []byte) []byte {
+	exif := append([]byte("Exif\x00\x00"), tiff...)
+	app1 := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(app1[2:], uint16(len(exif)+2))
+	app1 = append(app1, exif...)
+	return append(append([]byte{0xFF, 0xD8}, app1...), data[2:]...)
+}
+
+// box returns an ISO-BMFF box of kind holding payload
+func box(kind string, payload ...[]byte) []byte {
+	data := bytes.Join(payload, nil)
+	header := make([]byte, 8)
+	binary.BigEndian.PutUint32(header, uint32(len(data)+8))
+	copy(header[4:], kind)
+	return append(header, data...)
+}
+
+// be32 returns n as 4 big-endian bytes
+func be32(n uint32) []byte {
+	return binary.BigEndian.AppendUint32(nil, n)
+}
+
+// exifHEIC returns a HEIC file whose Exif item, stored after its meta box, holds createDate
+func exifHEIC(createDate string) []byte {
+	ftyp := box("ftyp", []byte("heic"), be32(0), []byte("mif1heic"))
+	item := append(be32(0), exifTIFF(createDate)...)
+
+	infe := box("infe", []byte{2, 0, 0, 0}, []byte{0, 1, 0, 0}, []byte("Exif"), []byte{0})
+	iinf := box("iinf", []byte{0, 0, 0, 0, 0, 1}, infe)
+	iloc := func(offset uint32) []byte {
+		return box("iloc", []byte{0, 0, 0, 0, 0x44, 0x00}, []byte{0, 1, 0, 1, 0, 0, 0, 1}, be32(offset), be32(uint32(len(item))))
+	}
+	meta := func(offset uint32) []byte {
+		return box("meta", []byte{0, 0, 0, 0}, box("hdlr", make([]byte, 24)), iinf, iloc(offset))
+	}
+
+	// The item goes after the mdat header, which follows ftyp and meta
+	offset := uint32(len(ftyp) + len(meta(0)) + 8)
+	return bytes.Join([][]byte{ftyp, meta(offset), box("mdat", item)}, nil)
+}
+
+// quickTimeMovie returns a QuickTime file whose mvhd box holds created and, when not
+// empty, whose metadata holds creationDate under the Apple creation date key
+func quickTimeMovie(created time.Time, creationDate string) []byte {
+	if creationDate == "" {
+		return quickTimeMovieWithKeys(created)
+	}
+	return quickTimeMovieWithKeys(created, quickTimeCreationDateKey, creationDate)
+}
+
+// quickTimeMovieWithKeys returns a QuickTime file whose mvhd box holds created and whose
+// metadata holds the values of keyValues, given as key and value pairs
+func quickTimeMovieWithKeys(created time.Time, keyValues ...string) []byte {
+	ftyp := box("ftyp", []byte("qt  "), be32(0), []byte("qt  "))
+	seconds := uint32(created.Sub(quickTimeEpoch) / time.Second)
+	mvhd := box("mvhd", []byte{0, 0, 0, 0}, be32(seconds), be32(seconds), make([]byte, 88))
+
+	moov := [][]byte{mvhd}
+	if len(keyValues) > 0 {
+		keys := [][]byte{be32(0), be32(uint32(len(keyValues) / 2))}
+		var entries [][]byte
+		for i := 0; i+1 < len(keyValues); i += 2 {
+			key := keyValues[i]
+			keys = append(keys, be32(uint32(8+len(key))), []byte("mdta"+key))
+			entries = append(entries, box(string(be32(uint32(i/2+1))), box("data", be32(1), be32(0), []byte(keyValues[i+1]))))
+		}
+		moov = append(moov, box("meta", box("hdlr", make([]byte, 24)), box("keys", keys...), box("ilst", entries...)))
+	}
+	return append(ftyp, box("moov", moov...)...)
+}
+
+func writeTestFile(t *testing.T, dir, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("Failed to write %s: %v", name, err)
+	}
+	return path
+}
+
+func TestNativeDateExtractor_GetFileDate(t *testing.T) {
+	tmpDir := t.TempDir()
+	created := time.Date(2023, 5, 1, 18, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"photo.jpg", exifJPEG("2023:05:01 18:30:00")},
+		{"photo.heic", exifHEIC("2023:05:01 18:30:00")},
+		{"photo.dng", exifTIFF("2023:05:01 18:30:00")},
+		{"video.mov", quickTimeMovie(created, "")},
+	}
+
+	extractor := newNativeDateExtractor().withLocation(time.UTC)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			date, err := extractor.getFileDate(writeTestFile(t, tmpDir, tt.name, tt.data))
+			if err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+			if !date.Equal(created) {
+				t.Errorf("Expected %v, got %v", created, date)
+			}
+		})
+	}
+}
+
+func TestReadNativeMetadata_QuickTimeCreationDate(t *testing.T) {
+	path := writeTestFile(t, t.TempDir(), "video.mov",
+		quickTimeMovie(time.Date(2023, 5, 1, 16, 30, 0, 0, time.UTC), "2023-05-01T18:30:00+0200"))
+
+	tags, err := readNativeMetadata(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if tags["CreationDate"] != "2023:05:01 18:30:00+02:00" {
+		t.Errorf("Expected CreationDate 2023:05:01 18:30:00+02:00, got %q", tags["CreationDate"])
+	}
+	if tags["CreateDate"] != "2023:05:01 16:30:00" {
+		t.Errorf("Expected CreateDate 2023:05:01 16:30:00, got %q", tags["CreateDate"])
+	}
+}
+
+func TestReadNativeMetadata_ContentIdentifier(t *testing.T) {
+	tmpDir := t.TempDir()
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"IMG_0001.jpg", tiffJPEG(appleTIFF("2023:05:01 18:30:00", "A1B2-C3D4"))},
+		{"IMG_0001.mov", quickTimeMovieWithKeys(time.Date(2023, 5, 1, 16, 30, 0, 0, time.UTC),
+			quickTimeCreationDateKey, "2023-05-01T18:30:00+0200", quickTimeContentIdentifierKey, "A1B2-C3D4")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tags, err := readNativeMetadata(writeTestFile(t, tmpDir, tt.name, tt.data))
+			if err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+			if tags["ContentIdentifier"] != "A1B2-C3D4" {
+				t.Errorf("Expected ContentIdentifier A1B2-C3D4, got %q", tags["ContentIdentifier"])
+			}
+		})
+	}
+}
+
+func TestNativeDateExtractor_EveningVideo(t *testing.T) {
+	losAngeles, err := time.LoadLocation("America/Los_Angeles")
+	if err != nil {
+		t.Skipf("Timezone database not available: %v", err)
+	}
+	tmpDir := t.TempDir()
+	recorded := time.Date(2023, 5, 2, 3, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"creationdate.mov", quickTimeMovie(recorded, "2023-05-01T20:30:00-0700")},
+		{"mvhd.mov", quickTimeMovie(recorded, "")},
+	}
+
+	extractor := NewFileDateExtractor().withLocation(losAngeles)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			date, err := extractor.GetFileDate(writeTestFile(t, tmpDir, tt.name, tt.data))
+			if err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+			if dir := date.Format(dateDirLayout); dir != "2023 05 May 01" {
+				t.Errorf("Expected the video in 2023 05 May 01, got %s", dir)
+			}
+		})
+	}
+}
+
+func TestReadNativeMetadata_Errors(t *testing.T) {
+	tmpDir := t.TempDir()
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"notes.txt", []byte("test content")},
+		{"plain.jpg", minimalJPEG()},
+		{"truncated.jpg", exifJPEG("2023:05:01 18:30:00")[:30]},
+		{"empty.mov", box("ftyp", []byte("qt  "), be32(0))},
+		{"image.png", []byte("\x89PNG\r\n\x1a\n")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := readNativeMetadata(writeTestFile(t, tmpDir, tt.name, tt.data)); err == nil {
+				t.Error("Expected an error, got nil")
+			}
+		})
+	}
+}
+
+func TestNativeDateExtractor_Prefetch(t *testing.T) {
+	tmpDir := t.TempDir()
+	dated := writeTestFile(t, tmpDir, "photo.jpg", exifJPEG("2023:05:01 18:30:00"))
+	undated := writeTestFile(t, tmpDir, "plain.jpg", minimalJPEG())
+
+	extractor := newNativeDateExtractor().withLocation(time.UTC)
+	remaining := extractor.prefetch([]string{dated, undated})
+	if len(remaining) != 1 || remaining[0] != undated {
+		t.Errorf("Expected only %s left undated, got %v", undated, remaining)
+	}
+
+	// The date read ahead is used once, without reading the file again
+	writeTestFile(t, tmpDir, "photo.jpg", minimalJPEG())
+	date, err := extractor.getFileDate(dated)
+	if err != nil {
+		t.Fatalf("Expected the prefetched date, got: %v", err)
+	}
+	assertTimeEqual(t, time.Date(2023, 5, 1, 18, 30, 0, 0, time.UTC), date)
+	if _, err := extractor.getFileDate(dated); err == nil {
+		t.Error("Expected the file to be read again once its prefetched date was used")
+	}
+}
+
+func TestAggregatedFileDateExtractor_PrefersNative(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := writeTestFile(t, tmpDir, "photo.jpg", exifJPEG("2023:05:01 18:30:00"))
+	modTime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatalf("Failed to set file times: %v", err)
+	}
+
+	date, source, err := NewFileDateExtractor().withLocation(time.UTC).GetFileDateWithSource(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if source != "Native" {
+		t.Errorf("Expected source Native, got %s", source)
+	}
+	assertTimeEqual(t, time.Date(2023, 5, 1, 18, 30, 0, 0, time.UTC), date)
+}
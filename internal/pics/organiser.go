@@ -1,27 +1,77 @@
 package pics
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/acm19/pics/internal/logger"
 )
 
 // FileOrganiser defines the interface for organising files
 type FileOrganiser interface {
-	// OrganiseByDate moves files to date-based directories
-	OrganiseByDate(sourceDir, targetDir string, progressChan chan<- ProgressEvent) error
-	// OrganiseVideosAndRenameImages organises videos into subdirectories and renames images sequentially
-	OrganiseVideosAndRenameImages(targetDir string, progressChan chan<- ProgressEvent) error
+	// OrganiseByDate moves files to date-based directories, reusing an existing
+	// directory for the same date (named or not) when there is one. It returns
+	// the names of the directories that received files.
+	OrganiseByDate(sourceDir, targetDir string, progressChan chan<- ProgressEvent) ([]string, error)
+	// OrganiseVideosAndRenameImages organises videos and screenshots into subdirectories and renames new images sequentially,
+	// continuing after the highest existing sequence number. Only the given directories are processed;
+	// nil processes every directory in targetDir.
+	OrganiseVideosAndRenameImages(targetDir string, dirNames []string, progressChan chan<- ProgressEvent) error
+	// PlanOrganisation fills in the date, date directory and final path of each
+	// planned file as OrganiseByDate and OrganiseVideosAndRenameImages would,
+	// without touching the disk
+	PlanOrganisation(targetDir string, files []PlannedFile) error
+
+	// withMover returns a copy of the organiser that applies its changes through the given mover
+	withMover(mover fileMover) FileOrganiser
+	// withDateErrorHandler returns a copy of the organiser that calls handler for files whose
+	// date cannot be read. The file is skipped if handler returns nil.
+	withDateErrorHandler(handler dateErrorHandler) FileOrganiser
+	// withSidecarDates returns a copy of the organiser that dates the given files with
+	// the date read from their sidecars before trying anything else
+	withSidecarDates(dates map[string]time.Time) FileOrganiser
+	// withTimezone returns a copy of the organiser that puts every file in the directory of
+	// its calendar day in loc, the library timezone (nil for the system timezone)
+	withTimezone(loc *time.Location) FileOrganiser
+	// withDateRecorder returns a copy of the organiser that calls recorder with the name of the
+	// extractor that dated each file, before moving it
+	withDateRecorder(recorder dateRecorder) FileOrganiser
+	// withConversions returns a copy of the organiser that names a kept HEIC original after
+	// the JPEG converted from it only for the pairs conversions returns, keyed by the current
+	// path of the JPEG
+	withConversions(conversions func() map[string]string) FileOrganiser
+	// withExiftoolPool returns a copy of the organiser that reads metadata through up to size
+	// long-lived exiftool processes, which stop when ctx is done, and a function that stops
+	// them once the copy is no longer used
+	withExiftoolPool(ctx context.Context, size int) (FileOrganiser, func())
 }
 
+// dateErrorHandler handles a file whose date cannot be read
+type dateErrorHandler func(filePath string, err error) error
+
+// dateRecorder records which extractor dated a file
+type dateRecorder func(filePath, extractor string) error
+
+const (
+	// dateDirLayout is the time layout used to name date-based directories
+	dateDirLayout = "2006 01 January 02"
+)
+
 // fileOrganiser implements the FileOrganiser interface
 type fileOrganiser struct {
+	exiftoolPath  string
 	dateExtractor *AggregatedFileDateExtractor
 	extensions    Extensions
 	fileRenamer   FileRenamer
+	mover         fileMover
+	onDateError   dateErrorHandler
+	onDated       dateRecorder
+	companions    *companionFinder
 }
 
 // NewFileOrganiser creates a new FileOrganiser instance
@@ -30,42 +80,131 @@ func NewFileOrganiser() FileOrganiser {
 		dateExtractor: NewFileDateExtractor(),
 		extensions:    NewExtensions(),
 		fileRenamer:   NewFileRenamer(),
+		mover:         osFileMover{},
+		companions:    newCompanionFinder(""),
 	}
 }
 
 // NewFileOrganiserWithPaths creates a new FileOrganiser with custom binary paths
 func NewFileOrganiserWithPaths(exiftoolPath string) FileOrganiser {
 	return &fileOrganiser{
+		exiftoolPath:  exiftoolPath,
 		dateExtractor: NewFileDateExtractorWithPath(exiftoolPath),
 		extensions:    NewExtensions(),
 		fileRenamer:   NewFileRenamer(),
+		mover:         osFileMover{},
+		companions:    newCompanionFinder(exiftoolPath),
 	}
 }
 
+// withMover returns a copy of the organiser that applies its changes through the given mover
+func (o *fileOrganiser) withMover(mover fileMover) FileOrganiser {
+	organiser := *o
+	organiser.fileRenamer = o.fileRenamer.withMover(mover)
+	organiser.mover = mover
+	return &organiser
+}
+
+// withDateErrorHandler returns a copy of the organiser that calls handler for files whose date cannot be read
+func (o *fileOrganiser) withDateErrorHandler(handler dateErrorHandler) FileOrganiser {
+	organiser := *o
+	organiser.onDateError = handler
+	return &organiser
+}
+
+// withSidecarDates returns a copy of the organiser that prefers the sidecar dates of the given files
+func (o *fileOrganiser) withSidecarDates(dates map[string]time.Time) FileOrganiser {
+	organiser := *o
+	organiser.dateExtractor = o.dateExtractor.withFirst(newSidecarDateExtractor(dates))
+	return &organiser
+}
+
+// withTimezone returns a copy of the organiser that dates files in the library timezone loc
+func (o *fileOrganiser) withTimezone(loc *time.Location) FileOrganiser {
+	organiser := *o
+	organiser.dateExtractor = o.dateExtractor.withLocation(loc)
+	return &organiser
+}
+
+// withDateRecorder returns a copy of the organiser that records the extractor that dated each file
+func (o *fileOrganiser) withDateRecorder(recorder dateRecorder) FileOrganiser {
+	organiser := *o
+	organiser.onDated = recorder
+	return &organiser
+}
+
+// withConversions returns a copy of the organiser that only pairs the converted files conversions returns
+func (o *fileOrganiser) withConversions(conversions func() map[string]string) FileOrganiser {
+	organiser := *o
+	organiser.companions = o.companions.withConversions(conversions)
+	return &organiser
+}
+
+// withExiftoolPool returns a copy of the organiser that reads metadata through a pool of exiftool processes
+func (o *fileOrganiser) withExiftoolPool(ctx context.Context, size int) (FileOrganiser, func()) {
+	pool := newExiftoolPool(ctx, o.exiftoolPath, size)
+	organiser := *o
+	organiser.dateExtractor = o.dateExtractor.withExiftoolPool(pool)
+	organiser.companions = o.companions.withExiftoolPool(pool)
+	return &organiser, pool.close
+}
+
 // OrganiseByDate moves files to date-based directories
-func (o *fileOrganiser) OrganiseByDate(sourceDir, targetDir string, progressChan chan<- ProgressEvent) error {
+func (o *fileOrganiser) OrganiseByDate(sourceDir, targetDir string, progressChan chan<- ProgressEvent) ([]string, error) {
 	logger.Info("OrganiseByDate started", "sourceDir", sourceDir, "targetDir", targetDir)
 
 	entries, err := os.ReadDir(sourceDir)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	logger.Info("Directory read complete", "entries", len(entries))
 
-	// Count total files
-	totalFiles := 0
-	for _, entry := range entries {
-		if !entry.IsDir() {
-			totalFiles++
+	existingDirs, err := findDateDirs(targetDir)
+	if err != nil {
+		return nil, err
+	}
+
+	companions, err := o.companions.findInDir(sourceDir, true)
+	if err != nil {
+		return nil, err
+	}
+	primaries := make(map[string]string)
+	for primary, names := range companions {
+		for _, name := range names {
+			primaries[name] = primary
 		}
 	}
-	logger.Debug("Counted files", "totalFiles", totalFiles)
 
-	current := 0
+	// Companions go last so they can follow their primary into its directory
+	var files, followers []os.DirEntry
 	for _, entry := range entries {
 		if entry.IsDir() {
 			continue
 		}
+		if _, isCompanion := primaries[entry.Name()]; isCompanion {
+			followers = append(followers, entry)
+		} else {
+			files = append(files, entry)
+		}
+	}
+
+	// Companions are dated by their primary, the primaries are read ahead in batches
+	paths := make([]string, len(files))
+	for i, entry := range files {
+		paths[i] = filepath.Join(sourceDir, entry.Name())
+	}
+	o.dateExtractor.prefetch(paths)
+	files = append(files, followers...)
+
+	totalFiles := len(files)
+	logger.Debug("Counted files", "totalFiles", totalFiles)
+
+	touched := make(map[string]bool)
+	primaryDirs := make(map[string]string, len(companions))
+	primaryNames := make(map[string]string, len(companions))
+	extractors := make(map[string]string, len(companions))
+	current := 0
+	for _, entry := range files {
 		filePath := filepath.Join(sourceDir, entry.Name())
 		current++
 
@@ -84,58 +223,101 @@ func (o *fileOrganiser) OrganiseByDate(sourceDir, targetDir string, progressChan
 			}
 		}
 
-		// Get file date from EXIF if available, otherwise use ModTime
-		logger.Debug("Extracting date", "file", entry.Name(), "current", current, "total", totalFiles)
-		fileDate, err := o.dateExtractor.GetFileDate(filePath)
-		if err != nil {
-			logger.Error("Failed to get file date", "file", entry.Name(), "error", err)
-			return err
+		name := entry.Name()
+		primary, isCompanion := primaries[name]
+		dirName, follows := primaryDirs[primary]
+		extractor := extractors[primary]
+		switch {
+		case follows:
+			// Named after the primary, as a Live Photo may have been paired by ContentIdentifier
+			name = companionNames(primary, primaryNames[primary], companions[primary])[name]
+		case isCompanion && o.extensions.IsSidecar(name):
+			if err := o.skipUndated(filePath, fmt.Errorf("%s was not organised", primary)); err != nil {
+				return nil, err
+			}
+			continue
+		default:
+			// Get file date from EXIF if available, otherwise use ModTime
+			logger.Debug("Extracting date", "file", entry.Name(), "current", current, "total", totalFiles)
+			var fileDate time.Time
+			fileDate, extractor, err = o.dateExtractor.GetFileDateWithSource(filePath)
+			if err != nil {
+				if err := o.skipUndated(filePath, err); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			logger.Debug("Date extracted", "file", entry.Name(), "date", fileDate, "extractor", extractor)
+			dirName = dateDirFor(existingDirs, fileDate)
+		}
+
+		if o.onDated != nil {
+			if err := o.onDated(filePath, extractor); err != nil {
+				return nil, err
+			}
 		}
-		logger.Debug("Date extracted", "file", entry.Name(), "date", fileDate)
 
-		dirName := fileDate.Format("2006 01 January 02")
 		destDir := filepath.Join(targetDir, dirName)
-		if err := os.MkdirAll(destDir, 0755); err != nil {
-			return err
+		if err := o.mover.mkdirAll(destDir); err != nil {
+			return nil, err
 		}
-		if err := os.Rename(filePath, filepath.Join(destDir, entry.Name())); err != nil {
-			return err
+		// The directory may already hold a file of that name, which the move must not replace
+		destPath := freePath(destDir, name)
+		if destPath != filepath.Join(destDir, name) {
+			logger.Debug("Name taken in date directory", "file", name, "movedTo", filepath.Base(destPath))
 		}
+		if err := o.mover.rename(filePath, destPath); err != nil {
+			return nil, err
+		}
+		touched[dirName] = true
+		primaryDirs[entry.Name()] = dirName
+		primaryNames[entry.Name()] = filepath.Base(destPath)
+		extractors[entry.Name()] = extractor
 	}
-	return nil
+
+	dirNames := make([]string, 0, len(touched))
+	for dirName := range touched {
+		dirNames = append(dirNames, dirName)
+	}
+	sort.Strings(dirNames)
+	return dirNames, nil
 }
 
-// OrganiseVideosAndRenameImages organises videos into subdirectories and renames images sequentially
-func (o *fileOrganiser) OrganiseVideosAndRenameImages(targetDir string, progressChan chan<- ProgressEvent) error {
-	entries, err := os.ReadDir(targetDir)
-	if err != nil {
+// skipUndated hands a file whose date cannot be read to the date error handler, failing without one
+func (o *fileOrganiser) skipUndated(filePath string, err error) error {
+	if o.onDateError == nil {
+		logger.Error("Failed to get file date", "file", filepath.Base(filePath), "error", err)
 		return err
 	}
+	return o.onDateError(filePath, err)
+}
 
-	// Count total directories
-	totalDirs := 0
-	for _, entry := range entries {
-		if entry.IsDir() {
-			totalDirs++
+// OrganiseVideosAndRenameImages organises videos and screenshots into subdirectories and renames new images sequentially
+func (o *fileOrganiser) OrganiseVideosAndRenameImages(targetDir string, dirNames []string, progressChan chan<- ProgressEvent) error {
+	if dirNames == nil {
+		entries, err := os.ReadDir(targetDir)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if entry.IsDir() && !isInternalDir(entry.Name()) {
+				dirNames = append(dirNames, entry.Name())
+			}
 		}
 	}
 
-	current := 0
-	for _, entry := range entries {
-		if !entry.IsDir() {
-			continue
-		}
-		dirPath := filepath.Join(targetDir, entry.Name())
-		current++
+	totalDirs := len(dirNames)
+	for i, dirName := range dirNames {
+		dirPath := filepath.Join(targetDir, dirName)
 
 		// Emit progress event
 		if progressChan != nil {
 			select {
 			case progressChan <- ProgressEvent{
 				Stage:   "organising",
-				Current: current,
+				Current: i + 1,
 				Total:   totalDirs,
-				Message: fmt.Sprintf("Organising directory %d of %d", current, totalDirs),
+				Message: fmt.Sprintf("Organising directory %d of %d", i+1, totalDirs),
 				File:    dirPath,
 			}:
 			default:
@@ -143,36 +325,300 @@ func (o *fileOrganiser) OrganiseVideosAndRenameImages(targetDir string, progress
 			}
 		}
 
-		logger.Debug("Organising file %s/%s", dirPath, entry.Name())
-		if err := o.organiseVideos(dirPath, entry.Name(), progressChan); err != nil {
+		logger.Debug("Organising directory", "path", dirPath)
+		// OrganiseByDate names companions after their primary
+		companions, err := o.companions.findInDir(dirPath, false)
+		if err != nil {
+			return err
+		}
+		if err := o.organiseSubdir(dirPath, dirName, ClassVideo, o.extensions.IsVideo, companions, progressChan); err != nil {
 			return err
 		}
-		if err := o.renameImages(dirPath, entry.Name(), progressChan); err != nil {
+		if err := o.organiseSubdir(dirPath, dirName, ClassScreenshot, o.extensions.IsScreenshot, companions, progressChan); err != nil {
+			return err
+		}
+		if err := o.renameImages(dirPath, dirName, companions, progressChan); err != nil {
+			return err
+		}
+		if err := packRawFiles(dirPath, o.extensions, o.mover); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-// organiseVideos moves video files to a videos subdirectory and renames them sequentially
-func (o *fileOrganiser) organiseVideos(dir string, dirName string, progressChan chan<- ProgressEvent) error {
-	parts := strings.Fields(dirName)
-	if len(parts) != 4 {
-		return fmt.Errorf("unexpected directory name format: %s", dirName)
+// organiseSubdir moves new files of a class kept in a subdirectory (videos or screenshots) to it and
+// numbers them after the existing ones, along with their sidecars. Motion clips of Live Photos stay
+// next to their still.
+func (o *fileOrganiser) organiseSubdir(dir, dirName string, class MediaClass, filter fileFilter, companions map[string][]string, progressChan chan<- ProgressEvent) error {
+	baseName, err := dateDirBaseName(dirName)
+	if err != nil {
+		return err
+	}
+	subdir := filepath.Join(dir, o.extensions.Subdir(class))
+	isCompanion := companionSet(companions)
+	inClass := func(filePath string) bool {
+		return filter(filePath) && !isCompanion[filepath.Base(filePath)]
 	}
-	videosName := strings.Join(parts, "_")
-	videosDir := filepath.Join(dir, "videos")
-	_, err := o.fileRenamer.MoveAndRenameFilesWithPattern(dir, videosDir, videosName, o.extensions.IsVideo, progressChan)
+	renamer := o.fileRenamer.withCompanions(companionPaths(dir, companions))
+	_, err = renamer.AppendFilesWithPattern(dir, subdir, baseName, inClass, progressChan)
 	return err
 }
 
-// renameImages renames image files with a sequential pattern
-func (o *fileOrganiser) renameImages(dir, dirName string, progressChan chan<- ProgressEvent) error {
-	parts := strings.Fields(dirName)
-	if len(parts) != 4 {
-		return fmt.Errorf("unexpected directory name format: %s", dirName)
+// renameImages renames new image and RAW files with a sequential pattern, after the existing ones.
+// Their companions, such as the motion clip of a Live Photo or the RAW file shot along with a JPEG,
+// are named after them.
+func (o *fileOrganiser) renameImages(dir, dirName string, companions map[string][]string, progressChan chan<- ProgressEvent) error {
+	picsName, err := dateDirBaseName(dirName)
+	if err != nil {
+		return err
+	}
+	isCompanion := companionSet(companions)
+	isPhoto := func(filePath string) bool {
+		return o.isPhoto(filePath) && !isCompanion[filepath.Base(filePath)]
 	}
-	picsName := strings.Join(parts, "_")
-	_, err := o.fileRenamer.RenameFilesWithPattern(dir, picsName, o.extensions.IsImage, progressChan)
+	renamer := o.fileRenamer.
+		withCompanions(companionPaths(dir, companions)).
+		withSequenceFrom([]string{filepath.Join(dir, o.extensions.Subdir(ClassRaw))})
+	_, err = renamer.AppendFilesWithPattern(dir, dir, picsName, isPhoto, progressChan)
 	return err
 }
+
+// isPhoto reports whether a file is an image or a RAW file, which share their sequence numbers
+func (o *fileOrganiser) isPhoto(filePath string) bool {
+	return o.extensions.IsImage(filePath) || o.extensions.IsRaw(filePath)
+}
+
+// dateDirBaseName converts a date directory name (YYYY MM Month DD [name]) into the
+// base name used for the files inside it (YYYY_MM_Month_DD[_name])
+func dateDirBaseName(dirName string) (string, error) {
+	parts := strings.Fields(dirName)
+	if len(parts) < 4 {
+		return "", fmt.Errorf("unexpected directory name format: %s", dirName)
+	}
+	return strings.Join(parts, "_"), nil
+}
+
+// findDateDirs maps each date (formatted with dateDirLayout) to the existing
+// directory in targetDir that holds it. When several directories share a date,
+// the unnamed one wins, otherwise the first one alphabetically.
+func findDateDirs(targetDir string) (map[string]string, error) {
+	dirs := make(map[string]string)
+	entries, err := os.ReadDir(targetDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return dirs, nil
+		}
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		parts := strings.Fields(entry.Name())
+		if len(parts) < 4 {
+			continue
+		}
+		datePart := strings.Join(parts[:4], " ")
+		if _, err := time.Parse(dateDirLayout, datePart); err != nil {
+			continue
+		}
+
+		existing, found := dirs[datePart]
+		switch {
+		case !found:
+			dirs[datePart] = entry.Name()
+		case existing == datePart:
+			// Unnamed directory already selected
+		case entry.Name() == datePart:
+			dirs[datePart] = entry.Name()
+		default:
+			logger.Warn("Multiple directories for the same date, using the first one", "date", datePart, "using", existing, "ignoring", entry.Name())
+		}
+	}
+	return dirs, nil
+}
+
+// dateDirFor returns the directory name files taken on the given date go into
+func dateDirFor(existingDirs map[string]string, fileDate time.Time) string {
+	datePart := fileDate.Format(dateDirLayout)
+	if dirName, found := existingDirs[datePart]; found {
+		return dirName
+	}
+	return datePart
+}
+
+// PlanOrganisation fills in the date, date directory and final path of each planned file
+func (o *fileOrganiser) PlanOrganisation(targetDir string, files []PlannedFile) error {
+	existingDirs, err := findDateDirs(targetDir)
+	if err != nil {
+		return err
+	}
+
+	candidates := make([]companionFile, len(files))
+	indices := make(map[string]int, len(files))
+	for i := range files {
+		candidates[i] = companionFile{name: files[i].StagingName, path: files[i].Source}
+		indices[files[i].StagingName] = i
+	}
+	companions := o.companions.find(candidates, true)
+	isCompanion := companionSet(companions)
+
+	var paths []string
+	for i := range files {
+		if !isCompanion[files[i].StagingName] {
+			paths = append(paths, files[i].Source)
+		}
+	}
+	o.dateExtractor.prefetch(paths)
+
+	byDir := make(map[string][]int)
+	for i := range files {
+		if isCompanion[files[i].StagingName] {
+			continue
+		}
+		fileDate, extractor, err := o.dateExtractor.GetFileDateWithSource(files[i].Source)
+		if err != nil {
+			return err
+		}
+		files[i].Date = fileDate
+		files[i].Extractor = extractor
+		files[i].DateDir = dateDirFor(existingDirs, fileDate)
+		byDir[files[i].DateDir] = append(byDir[files[i].DateDir], i)
+	}
+
+	// Companions follow their primary
+	for primary, names := range companions {
+		p := indices[primary]
+		for _, name := range names {
+			c := indices[name]
+			files[c].Date = files[p].Date
+			files[c].Extractor = files[p].Extractor
+			files[c].DateDir = files[p].DateDir
+			byDir[files[c].DateDir] = append(byDir[files[c].DateDir], c)
+		}
+	}
+
+	for dirName, indices := range byDir {
+		if err := o.planDirectory(targetDir, dirName, files, indices, companions); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// planDirectory simulates the renaming of a single date directory, numbering
+// new files after the ones already in it
+func (o *fileOrganiser) planDirectory(targetDir, dirName string, files []PlannedFile, indices []int, plannedCompanions map[string][]string) error {
+	baseName, err := dateDirBaseName(dirName)
+	if err != nil {
+		return err
+	}
+	dirPath := filepath.Join(targetDir, dirName)
+
+	// Files already in the directory that are not numbered yet are renamed alongside the new ones
+	var candidates []companionFile
+	names := make(map[string]int)
+	entries, err := os.ReadDir(dirPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			candidates = append(candidates, companionFile{name: entry.Name()})
+		}
+	}
+	for _, i := range indices {
+		names[files[i].StagingName] = i
+		candidates = append(candidates, companionFile{name: files[i].StagingName})
+	}
+
+	companions := o.companions.find(candidates, false)
+	for primary, planned := range plannedCompanions {
+		if _, inDir := names[primary]; inDir {
+			companions[primary] = planned
+		}
+	}
+	isCompanion := companionSet(companions)
+
+	var newImages, newVideos, newScreenshots []string
+	for _, candidate := range candidates {
+		name := candidate.name
+		if _, planned := names[name]; !planned && o.isPhoto(name) {
+			if _, numbered := sequenceNumber(baseName, name); numbered {
+				continue
+			}
+		}
+		if isCompanion[name] {
+			continue
+		}
+		if o.extensions.IsVideo(name) {
+			newVideos = append(newVideos, name)
+		} else if o.extensions.IsScreenshot(name) {
+			newScreenshots = append(newScreenshots, name)
+		} else if o.isPhoto(name) {
+			newImages = append(newImages, name)
+		}
+	}
+
+	lastImage, err := lastSequenceNumber(dirPath, baseName, o.isPhoto)
+	if err != nil {
+		return err
+	}
+	lastRaw, err := lastSequenceNumber(filepath.Join(dirPath, o.extensions.Subdir(ClassRaw)), baseName, o.isPhoto)
+	if err != nil {
+		return err
+	}
+	lastImage = max(lastImage, lastRaw)
+	lastVideo, err := lastSequenceNumber(filepath.Join(dirPath, o.extensions.Subdir(ClassVideo)), baseName, o.extensions.IsVideo)
+	if err != nil {
+		return err
+	}
+	lastScreenshot, err := lastSequenceNumber(filepath.Join(dirPath, o.extensions.Subdir(ClassScreenshot)), baseName, o.extensions.IsScreenshot)
+	if err != nil {
+		return err
+	}
+
+	// Final paths relative to the date directory
+	finalPaths := make(map[string]string)
+	assign := func(group []string, subDir string, lastSeq int) {
+		sort.Strings(group)
+		for i, name := range group {
+			finalPaths[name] = filepath.Join(subDir, sequentialName(baseName, lastSeq+i+1, name))
+		}
+	}
+	assign(newImages, "", lastImage)
+	assign(newVideos, o.extensions.Subdir(ClassVideo), lastVideo)
+	assign(newScreenshots, o.extensions.Subdir(ClassScreenshot), lastScreenshot)
+
+	for primary, followers := range companions {
+		finalPath, renamed := finalPaths[primary]
+		if !renamed {
+			finalPath = primary
+		}
+		newNames := companionNames(primary, filepath.Base(finalPath), followers)
+		for _, name := range followers {
+			finalPaths[name] = filepath.Join(filepath.Dir(finalPath), newNames[name])
+		}
+	}
+
+	// RAW files and their sidecars end up in the raw subdirectory
+	allNames := make([]string, len(candidates))
+	for i, candidate := range candidates {
+		allNames[i] = candidate.name
+	}
+	primaries := sidecarPrimaries(allNames, o.extensions)
+	for name, finalPath := range finalPaths {
+		if o.extensions.IsRaw(name) || o.extensions.IsRaw(primaries[name]) {
+			finalPaths[name] = filepath.Join(o.extensions.Subdir(ClassRaw), finalPath)
+		}
+	}
+
+	for name, index := range names {
+		if finalPath, found := finalPaths[name]; found {
+			files[index].FinalPath = filepath.Join(dirName, finalPath)
+		}
+	}
+	return nil
+}
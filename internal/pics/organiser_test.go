@@ -74,7 +74,7 @@ func TestFileOrganiser_OrganiseByDate(t *testing.T) {
 
 	// Organise files by date
 	organiser := NewFileOrganiser()
-	err := organiser.OrganiseByDate(sourceDir, targetDir, nil)
+	_, err := organiser.OrganiseByDate(sourceDir, targetDir, nil)
 
 	if err != nil {
 		t.Errorf("Expected no error, got: %v", err)
@@ -91,6 +91,25 @@ func TestFileOrganiser_OrganiseByDate(t *testing.T) {
 	assertFileNotExists(t, file2)
 }
 
+func TestFileOrganiser_OrganiseByDate_Timezone(t *testing.T) {
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Skipf("Timezone database not available: %v", err)
+	}
+	tmpDir := t.TempDir()
+	sourceDir, targetDir := createDirs(t, tmpDir)
+
+	// Late evening in UTC is the next morning in Tokyo
+	createFileWithDate(t, sourceDir, "image1.jpg", time.Date(2023, 6, 15, 22, 0, 0, 0, time.UTC))
+
+	organiser := NewFileOrganiser().withTimezone(tokyo)
+	if _, err := organiser.OrganiseByDate(sourceDir, targetDir, nil); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	assertFileExists(t, filepath.Join(targetDir, "2023 06 June 16", "image1.jpg"))
+}
+
 func TestFileOrganiser_OrganiseByDate_MultipleDates(t *testing.T) {
 	tmpDir := t.TempDir()
 	sourceDir, targetDir := createDirs(t, tmpDir)
@@ -103,7 +122,7 @@ func TestFileOrganiser_OrganiseByDate_MultipleDates(t *testing.T) {
 	createFileWithDate(t, sourceDir, "july.jpg", date2)
 
 	organiser := NewFileOrganiser()
-	err := organiser.OrganiseByDate(sourceDir, targetDir, nil)
+	_, err := organiser.OrganiseByDate(sourceDir, targetDir, nil)
 
 	if err != nil {
 		t.Errorf("Expected no error, got: %v", err)
@@ -129,7 +148,7 @@ func TestFileOrganiser_OrganiseByDate_SkipsDirectories(t *testing.T) {
 	createFileWithDate(t, sourceDir, "image1.jpg", testDate)
 
 	organiser := NewFileOrganiser()
-	err := organiser.OrganiseByDate(sourceDir, targetDir, nil)
+	_, err := organiser.OrganiseByDate(sourceDir, targetDir, nil)
 
 	if err != nil {
 		t.Errorf("Expected no error, got: %v", err)
@@ -147,7 +166,7 @@ func TestFileOrganiser_OrganiseByDate_NonexistentSource(t *testing.T) {
 	targetDir := filepath.Join(tmpDir, "target")
 
 	organiser := NewFileOrganiser()
-	err := organiser.OrganiseByDate("/nonexistent/source", targetDir, nil)
+	_, err := organiser.OrganiseByDate("/nonexistent/source", targetDir, nil)
 
 	if err == nil {
 		t.Error("Expected error for nonexistent source directory")
@@ -164,14 +183,17 @@ func TestFileOrganiser_OrganiseVideosAndRenameImages(t *testing.T) {
 	createFile(t, dateDir, "img2.heic")
 	createFile(t, dateDir, "vid1.mov")
 	createFile(t, dateDir, "vid2.MOV")
+	// The quarantine is not a date directory and is left alone
+	quarantined := createFile(t, createDateDir(t, targetDir, quarantineDirName), "IMG_0001.jpg")
 
 	// Organise videos and rename images
 	organiser := NewFileOrganiser()
-	err := organiser.OrganiseVideosAndRenameImages(targetDir, nil)
+	err := organiser.OrganiseVideosAndRenameImages(targetDir, nil, nil)
 
 	if err != nil {
 		t.Errorf("Expected no error, got: %v", err)
 	}
+	assertFileExists(t, quarantined)
 
 	// Check images were renamed
 	assertFileExists(t, filepath.Join(dateDir, "2023_06_June_15_00001.jpg"))
@@ -198,7 +220,7 @@ func TestFileOrganiser_OrganiseVideosAndRenameImages_OnlyImages(t *testing.T) {
 	createFile(t, dateDir, "img2.jpeg")
 
 	organiser := NewFileOrganiser()
-	err := organiser.OrganiseVideosAndRenameImages(targetDir, nil)
+	err := organiser.OrganiseVideosAndRenameImages(targetDir, nil, nil)
 
 	if err != nil {
 		t.Errorf("Expected no error, got: %v", err)
@@ -221,7 +243,7 @@ func TestFileOrganiser_OrganiseVideosAndRenameImages_OnlyVideos(t *testing.T) {
 	createFile(t, dateDir, "vid1.mov")
 
 	organiser := NewFileOrganiser()
-	err := organiser.OrganiseVideosAndRenameImages(targetDir, nil)
+	err := organiser.OrganiseVideosAndRenameImages(targetDir, nil, nil)
 
 	if err != nil {
 		t.Errorf("Expected no error, got: %v", err)
@@ -241,7 +263,7 @@ func TestFileOrganiser_OrganiseVideosAndRenameImages_EmptyDirectory(t *testing.T
 	dateDir := createDateDir(t, targetDir, "2023 06 June 15")
 
 	organiser := NewFileOrganiser()
-	err := organiser.OrganiseVideosAndRenameImages(targetDir, nil)
+	err := organiser.OrganiseVideosAndRenameImages(targetDir, nil, nil)
 
 	if err != nil {
 		t.Errorf("Expected no error, got: %v", err)
@@ -262,7 +284,7 @@ func TestFileOrganiser_OrganiseVideosAndRenameImages_InvalidDirectoryFormat(t *t
 	createFile(t, invalidDir, "img.jpg")
 
 	organiser := NewFileOrganiser()
-	err := organiser.OrganiseVideosAndRenameImages(targetDir, nil)
+	err := organiser.OrganiseVideosAndRenameImages(targetDir, nil, nil)
 
 	if err == nil {
 		t.Error("Expected error for invalid directory format")
@@ -281,7 +303,7 @@ func TestFileOrganiser_OrganiseVideosAndRenameImages_SkipsFiles(t *testing.T) {
 	createFile(t, dateDir, "img1.jpg")
 
 	organiser := NewFileOrganiser()
-	err := organiser.OrganiseVideosAndRenameImages(targetDir, nil)
+	err := organiser.OrganiseVideosAndRenameImages(targetDir, nil, nil)
 
 	if err != nil {
 		t.Errorf("Expected no error, got: %v", err)
@@ -296,7 +318,7 @@ func TestFileOrganiser_OrganiseVideosAndRenameImages_SkipsFiles(t *testing.T) {
 
 func TestFileOrganiser_OrganiseVideosAndRenameImages_NonexistentTarget(t *testing.T) {
 	organiser := NewFileOrganiser()
-	err := organiser.OrganiseVideosAndRenameImages("/nonexistent/target", nil)
+	err := organiser.OrganiseVideosAndRenameImages("/nonexistent/target", nil, nil)
 
 	if err == nil {
 		t.Error("Expected error for nonexistent target directory")
@@ -316,7 +338,7 @@ func TestFileOrganiser_OrganiseVideosAndRenameImages_NormalisesExtensions(t *tes
 	createFile(t, dateDir, "vid1.MOV")
 
 	organiser := NewFileOrganiser()
-	err := organiser.OrganiseVideosAndRenameImages(targetDir, nil)
+	err := organiser.OrganiseVideosAndRenameImages(targetDir, nil, nil)
 
 	if err != nil {
 		t.Errorf("Expected no error, got: %v", err)
@@ -343,7 +365,7 @@ func TestFileOrganiser_OrganiseVideosAndRenameImages_MP4Videos(t *testing.T) {
 
 	// Organise videos and rename images
 	organiser := NewFileOrganiser()
-	err := organiser.OrganiseVideosAndRenameImages(targetDir, nil)
+	err := organiser.OrganiseVideosAndRenameImages(targetDir, nil, nil)
 
 	if err != nil {
 		t.Errorf("Expected no error, got: %v", err)
@@ -362,3 +384,139 @@ func TestFileOrganiser_OrganiseVideosAndRenameImages_MP4Videos(t *testing.T) {
 	assertFileNotExists(t, filepath.Join(dateDir, "vid1.mp4"))
 	assertFileNotExists(t, filepath.Join(dateDir, "vid2.MP4"))
 }
+
+func TestFileOrganiser_OrganiseByDate_ReusesNamedDirectory(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir, targetDir := createDirs(t, tmpDir)
+	namedDir := createDateDir(t, targetDir, "2023 06 June 15 Beach Trip")
+
+	testDate := time.Date(2023, 6, 15, 10, 30, 0, 0, time.UTC)
+	createFileWithDate(t, sourceDir, "image1.jpg", testDate)
+
+	organiser := NewFileOrganiser()
+	dirNames, err := organiser.OrganiseByDate(sourceDir, targetDir, nil)
+
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	// File should join the existing named directory instead of creating a new one
+	assertFileExists(t, filepath.Join(namedDir, "image1.jpg"))
+	assertFileNotExists(t, filepath.Join(targetDir, "2023 06 June 15"))
+
+	if len(dirNames) != 1 || dirNames[0] != "2023 06 June 15 Beach Trip" {
+		t.Errorf("Expected touched directories [2023 06 June 15 Beach Trip], got %v", dirNames)
+	}
+}
+
+func TestFileOrganiser_OrganiseByDate_KeepsExistingFiles(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir, targetDir := createDirs(t, tmpDir)
+	dateDir := createDateDir(t, targetDir, "2023 06 June 15")
+
+	testDate := time.Date(2023, 6, 15, 10, 30, 0, 0, time.UTC)
+	createFileWithContent(t, dateDir, "IMG_0001.jpg", []byte("library"), testDate)
+	createFileWithContent(t, dateDir, "IMG_0001.mov", []byte("library"), testDate)
+	createFileWithDate(t, sourceDir, "IMG_0001.jpg", testDate)
+	createFileWithDate(t, sourceDir, "IMG_0001.mov", testDate)
+
+	organiser := NewFileOrganiser()
+	if _, err := organiser.OrganiseByDate(sourceDir, targetDir, nil); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	// The files already in the library are kept, the Live Photo joins them under a free name
+	for _, name := range []string{"IMG_0001.jpg", "IMG_0001.mov"} {
+		if data, err := os.ReadFile(filepath.Join(dateDir, name)); err != nil || string(data) != "library" {
+			t.Errorf("Expected %s of the library to be kept, got %q (%v)", name, data, err)
+		}
+	}
+	assertFileExists(t, filepath.Join(dateDir, "IMG_0001-1.jpg"))
+	assertFileExists(t, filepath.Join(dateDir, "IMG_0001-1.mov"))
+}
+
+func TestFileOrganiser_OrganiseVideosAndRenameImages_ContinuesNumbering(t *testing.T) {
+	tmpDir := t.TempDir()
+	_, targetDir := createDirs(t, tmpDir)
+	dateDir := createDateDir(t, targetDir, "2023 06 June 15 Beach")
+	videosDir := createDateDir(t, dateDir, "videos")
+
+	// Existing library files, with a gap in the numbering
+	createFile(t, dateDir, "2023_06_June_15_Beach_00001.jpg")
+	createFile(t, dateDir, "2023_06_June_15_Beach_00003.heic")
+	createFile(t, videosDir, "2023_06_June_15_Beach_00001.mov")
+
+	// Newly imported files
+	createFile(t, dateDir, "root-new.jpg")
+	createFile(t, dateDir, "root-clip.mov")
+
+	organiser := NewFileOrganiser()
+	err := organiser.OrganiseVideosAndRenameImages(targetDir, []string{"2023 06 June 15 Beach"}, nil)
+
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	// Existing files keep their numbers
+	assertFileExists(t, filepath.Join(dateDir, "2023_06_June_15_Beach_00001.jpg"))
+	assertFileExists(t, filepath.Join(dateDir, "2023_06_June_15_Beach_00003.heic"))
+	assertFileExists(t, filepath.Join(videosDir, "2023_06_June_15_Beach_00001.mov"))
+
+	// New files continue after the highest existing number
+	assertFileExists(t, filepath.Join(dateDir, "2023_06_June_15_Beach_00004.jpg"))
+	assertFileExists(t, filepath.Join(videosDir, "2023_06_June_15_Beach_00002.mov"))
+	assertFileNotExists(t, filepath.Join(dateDir, "root-new.jpg"))
+	assertFileNotExists(t, filepath.Join(dateDir, "root-clip.mov"))
+}
+
+func TestFileOrganiser_OrganiseVideosAndRenameImages_OnlyGivenDirectories(t *testing.T) {
+	tmpDir := t.TempDir()
+	_, targetDir := createDirs(t, tmpDir)
+	touchedDir := createDateDir(t, targetDir, "2023 06 June 15")
+	untouchedDir := createDateDir(t, targetDir, "2023 07 July 20")
+	invalidDir := createDateDir(t, targetDir, "Misc")
+
+	createFile(t, touchedDir, "img.jpg")
+	createFile(t, untouchedDir, "img.jpg")
+	createFile(t, invalidDir, "img.jpg")
+
+	organiser := NewFileOrganiser()
+	err := organiser.OrganiseVideosAndRenameImages(targetDir, []string{"2023 06 June 15"}, nil)
+
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	assertFileExists(t, filepath.Join(touchedDir, "2023_06_June_15_00001.jpg"))
+	assertFileExists(t, filepath.Join(untouchedDir, "img.jpg"))
+	assertFileExists(t, filepath.Join(invalidDir, "img.jpg"))
+}
+
+func TestFileOrganiser_OrganiseVideosAndRenameImages_LivePhotos(t *testing.T) {
+	tmpDir := t.TempDir()
+	_, targetDir := createDirs(t, tmpDir)
+	dateDir := createDateDir(t, targetDir, "2023 06 June 15")
+
+	// An existing Live Photo stays where it is
+	createFile(t, dateDir, "2023_06_June_15_00001.heic")
+	createFile(t, dateDir, "2023_06_June_15_00001.mov")
+
+	// Newly imported files
+	createFile(t, dateDir, "root-IMG_0002.HEIC")
+	createFile(t, dateDir, "root-IMG_0002.MOV")
+	createFile(t, dateDir, "root-IMG_0003.MOV")
+
+	organiser := NewFileOrganiser()
+	err := organiser.OrganiseVideosAndRenameImages(targetDir, []string{"2023 06 June 15"}, nil)
+
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	assertFileExists(t, filepath.Join(dateDir, "2023_06_June_15_00001.heic"))
+	assertFileExists(t, filepath.Join(dateDir, "2023_06_June_15_00001.mov"))
+	assertFileExists(t, filepath.Join(dateDir, "2023_06_June_15_00002.heic"))
+	assertFileExists(t, filepath.Join(dateDir, "2023_06_June_15_00002.mov"))
+	assertFileExists(t, filepath.Join(dateDir, "videos", "2023_06_June_15_00001.mov"))
+	assertFileNotExists(t, filepath.Join(dateDir, "videos", "2023_06_June_15_00002.mov"))
+}
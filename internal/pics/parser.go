@@ -1,10 +1,15 @@
 package pics
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -15,67 +20,361 @@ import (
 
 // MediaParser defines the interface for parsing and organising media files
 type MediaParser interface {
-	// Parse processes media files from source to target directory
-	Parse(sourceDir, targetDir string, opts ParseOptions) error
+	// Parse processes media files from source to target directory, returning where every file went.
+	// With ErrorPolicyContinue the result is also returned alongside a PartialParseError.
+	Parse(sourceDir, targetDir string, opts ParseOptions) (*ParseResult, error)
+	// ParseContext is like Parse but stops when ctx is cancelled, rolling back
+	// whatever was already done to the target directory
+	ParseContext(ctx context.Context, sourceDir, targetDir string, opts ParseOptions) (*ParseResult, error)
+	// Plan computes what Parse would do without touching the disk
+	Plan(sourceDir, targetDir string, opts ParseOptions) (*ParsePlan, error)
+	// Resume finishes an interrupted Parse using the journal left in the target directory
+	Resume(targetDir string, opts ParseOptions) (*ParseResult, error)
+	// ResumeContext is like Resume but stops when ctx is cancelled, rolling back the whole parse
+	ResumeContext(ctx context.Context, targetDir string, opts ParseOptions) (*ParseResult, error)
+	// Rollback restores the target directory to how it was before an interrupted Parse
+	Rollback(targetDir string) error
 }
 
 // mediaParser implements the MediaParser interface
 type mediaParser struct {
-	compressor ImageCompressor
-	organiser  FileOrganiser
-	extensions Extensions
+	compressor   ImageCompressor
+	goCompressor ImageCompressor
+	converter    ImageConverter
+	video        VideoCompressor
+	organiser    FileOrganiser
+	extensions   Extensions
 }
 
 // NewMediaParser creates a new MediaParser instance
 func NewMediaParser() MediaParser {
 	return &mediaParser{
-		compressor: NewImageCompressor(),
-		organiser:  NewFileOrganiser(),
-		extensions: NewExtensions(),
+		compressor:   NewImageCompressor(),
+		goCompressor: NewGoImageCompressor(),
+		converter:    NewImageConverter(),
+		video:        NewVideoCompressor(),
+		organiser:    NewFileOrganiser(),
+		extensions:   NewExtensions(),
 	}
 }
 
 // NewMediaParserWithPaths creates a new MediaParser with custom binary paths
-func NewMediaParserWithPaths(jpegoptimPath string, organiser FileOrganiser) MediaParser {
+// (empty paths use the binaries in the system PATH)
+func NewMediaParserWithPaths(jpegoptimPath, converterPath, ffmpegPath string, organiser FileOrganiser) MediaParser {
 	return &mediaParser{
-		compressor: NewImageCompressorWithPath(jpegoptimPath),
-		organiser:  organiser,
-		extensions: NewExtensions(),
+		compressor:   NewImageCompressorWithPath(jpegoptimPath),
+		goCompressor: NewGoImageCompressor(),
+		converter:    NewImageConverterWithPath(converterPath),
+		video:        NewVideoCompressorWithPath(ffmpegPath),
+		organiser:    organiser,
+		extensions:   NewExtensions(),
 	}
 }
 
 // Parse processes media files from source to target directory
-func (p *mediaParser) Parse(sourceDir, targetDir string, opts ParseOptions) error {
+//
+// Every copy, move and rename is recorded in a journal inside the target
+// directory. If Parse fails or the process dies, the journal is kept so the
+// run can be finished with Resume or undone with Rollback.
+func (p *mediaParser) Parse(sourceDir, targetDir string, opts ParseOptions) (*ParseResult, error) {
+	return p.ParseContext(context.Background(), sourceDir, targetDir, opts)
+}
+
+// ParseContext processes media files from source to target directory until ctx is cancelled
+//
+// On cancellation the file discovery stops, queued files are skipped, running
+// jpegoptim processes are killed and every change already made to the target
+// directory is rolled back, so the target is left as it was before the parse.
+func (p *mediaParser) ParseContext(ctx context.Context, sourceDir, targetDir string, opts ParseOptions) (*ParseResult, error) {
 	sourceDir = strings.TrimSuffix(sourceDir, "/")
 	targetDir = strings.TrimSuffix(targetDir, "/")
 
-	// Create unique temporary directory in system temp with random suffix
-	tmpTarget, err := os.MkdirTemp("", "pics-*")
+	journal, err := createParseJournal(sourceDir, targetDir, opts)
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("Created parse journal", "path", journal.dir)
+
+	return p.run(ctx, journal, opts)
+}
+
+// Resume finishes an interrupted Parse using the journal left in the target directory
+func (p *mediaParser) Resume(targetDir string, opts ParseOptions) (*ParseResult, error) {
+	return p.ResumeContext(context.Background(), targetDir, opts)
+}
+
+// ResumeContext finishes an interrupted Parse until ctx is cancelled
+func (p *mediaParser) ResumeContext(ctx context.Context, targetDir string, opts ParseOptions) (*ParseResult, error) {
+	targetDir = strings.TrimSuffix(targetDir, "/")
+
+	journal, err := openParseJournal(targetDir)
+	if err != nil {
+		return nil, err
+	}
+
+	// Compression settings must match the interrupted run
+	opts.CompressJPEGs = journal.begin.CompressJPEGs
+	opts.JPEGQuality = journal.begin.JPEGQuality
+	opts.MaxLongEdge = journal.begin.MaxLongEdge
+	opts.Compressor = journal.begin.Compressor
+	opts.MoveSource = journal.begin.MoveSource
+	opts.SourceMode = journal.begin.SourceMode
+	opts.FixExtensions = journal.begin.FixExtensions
+	opts.ConvertHEIC = journal.begin.ConvertHEIC
+	opts.KeepHEIC = journal.begin.KeepHEIC
+	opts.Thumbnails = journal.begin.Thumbnails
+	opts.CompressVideos = journal.begin.VideoPreset != nil
+	if opts.CompressVideos {
+		opts.VideoPreset = *journal.begin.VideoPreset
+	}
+	opts.Timezone = nil
+	if journal.begin.Timezone != "" {
+		if opts.Timezone, err = time.LoadLocation(journal.begin.Timezone); err != nil {
+			journal.close()
+			return nil, fmt.Errorf("failed to load the timezone of the interrupted parse: %w", err)
+		}
+	}
+	logger.Info("Resuming parse", "source", journal.begin.SourceDir, "target", targetDir)
+
+	return p.run(ctx, journal, opts)
+}
+
+// Rollback restores the target directory to how it was before an interrupted Parse
+func (p *mediaParser) Rollback(targetDir string) error {
+	targetDir = strings.TrimSuffix(targetDir, "/")
+
+	journal, err := openParseJournal(targetDir)
 	if err != nil {
-		return fmt.Errorf("failed to create temp directory: %w", err)
+		return err
+	}
+
+	logger.Info("Rolling back parse", "source", journal.begin.SourceDir, "target", targetDir)
+	if err := journal.rollback(); err != nil {
+		journal.close()
+		return fmt.Errorf("failed to roll back: %w", err)
+	}
+
+	if err := journal.remove(); err != nil {
+		return fmt.Errorf("failed to remove journal: %w", err)
+	}
+	logger.Info("Rollback complete")
+	return nil
+}
+
+// run executes (or continues) the parse recorded in the journal. If ctx is
+// cancelled the parse is rolled back, otherwise a failed parse keeps its journal.
+func (p *mediaParser) run(ctx context.Context, journal *parseJournal, opts ParseOptions) (*ParseResult, error) {
+	sourceDir := journal.begin.SourceDir
+	targetDir := journal.begin.TargetDir
+	stagingDir := journal.stagingDir()
+
+	// In continue mode files that fail are quarantined instead of stopping the parse
+	var q *quarantine
+	if opts.ErrorPolicy == ErrorPolicyContinue {
+		q = newQuarantine(targetDir, contextMover{ctx: ctx, mover: journal})
 	}
-	defer os.RemoveAll(tmpTarget)
-	logger.Info("Created temporary directory", "path", tmpTarget)
 
-	logger.Info("Processing media files (copy and compress)", "source", sourceDir, "target", tmpTarget)
+	timer := &stageTimer{}
+	timer.begin("preparing")
+	sourceDirs, origins, err := p.prepareSource(ctx, sourceDir, journal.extractDir(), opts)
+	if err == nil {
+		err = p.stageAndOrganise(ctx, journal, q, timer, sourceDirs, stagingDir, targetDir, opts)
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, p.abort(ctx, journal)
+		}
+		journal.close()
+		logger.Error("Parse interrupted, journal kept for resume or rollback", "journal", journal.dir)
+		return nil, err
+	}
+
+	result := &ParseResult{
+		SourceDir: sourceDir,
+		TargetDir: targetDir,
+		Files:     journal.importedFiles(),
+		Renamed:   journal.renamedFiles(),
+	}
+	for i := range result.Files {
+		result.Files[i].Source = archiveSource(origins, result.Files[i].Source)
+	}
+	sort.Slice(result.Files, func(i, k int) bool {
+		return result.Files[i].Source < result.Files[k].Source
+	})
+	p.summarise(result, journal)
+
+	// Sources are verified while the journal still knows where every file went,
+	// but only deleted once the parse is committed, as a rollback could not bring them back
+	var verified []verifiedSource
+	if opts.MoveSource {
+		timer.begin("verifying")
+		verified = verifySources(journal, opts.MaxConcurrency)
+	}
+	dirNames := journal.touchedDirs()
+
+	if err := journal.remove(); err != nil {
+		return nil, fmt.Errorf("failed to remove journal: %w", err)
+	}
+
+	// Thumbnails are written once the parse is committed, a rollback has no record of them
+	if opts.Thumbnails {
+		timer.begin("thumbnails")
+		p.writeThumbnails(ctx, targetDir, dirNames, opts)
+	}
+	result.Stages = timer.stop()
+
+	if opts.MoveSource {
+		deleted := deleteSources(verified)
+		logger.Info("Deleted verified source files", "deleted", deleted)
+	}
+
+	logger.Info("Processing complete", "files", len(result.Files))
+	if err := q.err(); err != nil {
+		var partialErr *PartialParseError
+		if errors.As(err, &partialErr) {
+			result.Failures = partialErr.Failures
+			for i := range result.Failures {
+				result.Failures[i].File = archiveSource(origins, result.Failures[i].File)
+			}
+		}
+		return result, err
+	}
+	return result, nil
+}
+
+// writeThumbnails writes the thumbnails and contact sheets of the date directories in
+// targetDir named dirNames. Thumbnails are only previews, so a directory that fails is
+// logged and the parse goes on.
+func (p *mediaParser) writeThumbnails(ctx context.Context, targetDir string, dirNames []string, opts ParseOptions) {
+	thumbs := &thumbnailer{converter: p.converter, extensions: p.extensions}
+	for i, dirName := range dirNames {
+		if ctx.Err() != nil {
+			logger.Warn("Parse cancelled, thumbnails not written", "directories", len(dirNames)-i)
+			return
+		}
+
+		// Emit thumbnails progress event
+		if opts.ProgressChan != nil {
+			select {
+			case opts.ProgressChan <- ProgressEvent{
+				Stage:   "thumbnails",
+				Current: i + 1,
+				Total:   len(dirNames),
+				Message: fmt.Sprintf("Writing thumbnails for directory %d of %d", i+1, len(dirNames)),
+				File:    dirName,
+			}:
+			default:
+				logger.Debug("Progress event dropped (channel full)", "stage", "thumbnails")
+			}
+		}
+
+		written, err := thumbs.writeDir(ctx, filepath.Join(targetDir, dirName))
+		if err != nil {
+			logger.Warn("Failed to write thumbnails", "directory", dirName, "error", err)
+			continue
+		}
+		logger.Debug("Wrote thumbnails", "directory", dirName, "count", written)
+	}
+}
+
+// abort rolls back a cancelled parse and removes its journal
+func (p *mediaParser) abort(ctx context.Context, journal *parseJournal) error {
+	logger.Warn("Parse cancelled, rolling back", "journal", journal.dir)
+	if err := journal.rollback(); err != nil {
+		journal.close()
+		return fmt.Errorf("parse cancelled, rollback failed (journal kept): %w", errors.Join(ctx.Err(), err))
+	}
+	if err := journal.remove(); err != nil {
+		return fmt.Errorf("parse cancelled, failed to remove journal: %w", errors.Join(ctx.Err(), err))
+	}
+	logger.Info("Rollback complete")
+	return fmt.Errorf("parse cancelled: %w", ctx.Err())
+}
+
+// prepareSource returns the directories media files are read from. For a Takeout
+// export the archives in sourceDir are extracted into extractDir first, and the
+// archive entry every extracted file came from is returned along with it.
+func (p *mediaParser) prepareSource(ctx context.Context, sourceDir, extractDir string, opts ParseOptions) ([]string, map[string]string, error) {
+	if opts.SourceMode != SourceModeTakeout {
+		return []string{sourceDir}, nil, nil
+	}
+	origins, err := extractTakeoutArchives(ctx, sourceDir, extractDir, p.extensions)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to extract Takeout archives: %w", err)
+	}
+	if len(origins) == 0 {
+		return []string{sourceDir}, origins, nil
+	}
+	return []string{sourceDir, extractDir}, origins, nil
+}
+
+// sidecarDates reads the sidecar dates of the media files in sourceDirs. With stagingDir
+// set the dates are keyed by the path the files get in the staging directory.
+func (p *mediaParser) sidecarDates(sourceDirs []string, stagingDir string, mode SourceMode) (map[string]time.Time, error) {
+	dates := make(map[string]time.Time)
+	for _, sourceDir := range sourceDirs {
+		sourceDates, err := loadSidecarDates(mode, sourceDir, p.extensions)
+		if err != nil {
+			return nil, err
+		}
+		for path, date := range sourceDates {
+			if stagingDir != "" {
+				name, err := stagingName(sourceDir, path)
+				if err != nil {
+					return nil, err
+				}
+				path = filepath.Join(stagingDir, name)
+			}
+			dates[path] = date
+		}
+	}
+	return dates, nil
+}
+
+// stageAndOrganise copies files into the staging directory and organises them into the target
+func (p *mediaParser) stageAndOrganise(ctx context.Context, journal *parseJournal, q *quarantine, timer *stageTimer, sourceDirs []string, stagingDir, targetDir string, opts ParseOptions) error {
+	logger.Info("Processing media files (copy and compress)", "source", sourceDirs, "target", stagingDir)
+	timer.begin("copying")
 	processStart := time.Now()
-	if err := p.copyAndCompressFiles(sourceDir, tmpTarget, opts); err != nil {
+	if err := p.copyAndCompressFiles(ctx, sourceDirs, stagingDir, opts, journal, q); err != nil {
 		return fmt.Errorf("failed to process media files: %w", err)
 	}
 	processDuration := time.Since(processStart)
 	logger.Info("Processing completed", "duration_seconds", processDuration.Seconds())
 
+	dates, err := p.sidecarDates(sourceDirs, stagingDir, opts.SourceMode)
+	if err != nil {
+		return err
+	}
+
+	// Metadata is read by long-lived exiftool processes, stopped once the files are organised
+	organiser, stopExiftool := p.organiser.withExiftoolPool(ctx, exiftoolPoolSize(opts.MaxConcurrency))
+	defer stopExiftool()
+
+	// Every move and rename checks ctx first, so organising stops between files
+	organiser = organiser.withMover(contextMover{ctx: ctx, mover: journal}).withSidecarDates(dates).withTimezone(opts.Timezone).withDateRecorder(journal.dated).withConversions(journal.conversions)
+	if q != nil {
+		sources := journal.stagedSources()
+		organiser = organiser.withDateErrorHandler(func(filePath string, err error) error {
+			_, qerr := q.add(sources[filePath], filePath, "organising", err)
+			return qerr
+		})
+	}
+
 	logger.Info("Organising files by date")
-	if err := p.organiser.OrganiseByDate(tmpTarget, targetDir, opts.ProgressChan); err != nil {
+	timer.begin("organising")
+	if _, err := organiser.OrganiseByDate(stagingDir, targetDir, opts.ProgressChan); err != nil {
 		return fmt.Errorf("failed to organise by date: %w", err)
 	}
 
-	logger.Info("Organising videos and renaming images")
-	if err := p.organiser.OrganiseVideosAndRenameImages(targetDir, opts.ProgressChan); err != nil {
+	// Only the directories that received files (in this run or an interrupted one)
+	// are renamed, the rest of the library is left untouched
+	dirNames := journal.touchedDirs()
+	logger.Info("Organising videos and renaming images", "directories", len(dirNames))
+	timer.begin("renaming")
+	if err := organiser.OrganiseVideosAndRenameImages(targetDir, dirNames, opts.ProgressChan); err != nil {
 		return fmt.Errorf("failed to organise videos and rename images: %w", err)
 	}
-
-	logger.Info("Processing complete")
 	return nil
 }
 
@@ -83,37 +382,191 @@ type fileToProcess struct {
 	srcPath  string
 	destPath string
 	isJPEG   bool
+	isVideo  bool
+	// convertTo is where the HEIC file is converted to, empty if it is not converted
+	convertTo string
+	// original is the staged HEIC file kept next to the converted one
+	original string
 }
 
-// countFiles counts the total number of supported files in the source directory
-func (p *mediaParser) countFiles(sourceDir string) (int, error) {
+// countFiles counts the supported files in the source directories that still need to be copied
+func (p *mediaParser) countFiles(sourceDirs []string, copied map[string]bool) (int, error) {
 	count := 0
-	err := filepath.Walk(sourceDir, func(path string, info os.FileInfo, err error) error {
+	for _, sourceDir := range sourceDirs {
+		err := p.walkMediaFiles(sourceDir, func(path string) error {
+			if !copied[path] {
+				count++
+			}
+			return nil
+		})
 		if err != nil {
+			return count, err
+		}
+	}
+	return count, nil
+}
+
+// walkMediaFiles walks sourceDir recursively and calls fn for every supported
+// media file and every sidecar next to its media file, skipping dot files and
+// dot directories inside it
+func (p *mediaParser) walkMediaFiles(sourceDir string, fn func(path string) error) error {
+	// Directory -> sidecars in it that have a media file
+	sidecars := make(map[string]map[string]string)
+	return filepath.Walk(sourceDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			logger.Debug("Error accessing path", "path", path, "error", err)
 			return err
 		}
 
-		// Skip dot files and dot directories
-		if strings.HasPrefix(info.Name(), ".") {
+		// Skip dot files and dot directories (but not sourceDir itself)
+		if strings.HasPrefix(info.Name(), ".") && path != sourceDir {
 			if info.IsDir() {
 				return filepath.SkipDir
 			}
 			return nil
 		}
 
-		if !info.IsDir() && p.extensions.IsSupported(path) {
-			count++
+		if info.IsDir() {
+			return nil
 		}
-		return nil
+		if p.extensions.IsSidecar(path) {
+			dir := filepath.Dir(path)
+			if _, read := sidecars[dir]; !read {
+				names, err := readDirNames(dir)
+				if err != nil {
+					return err
+				}
+				sidecars[dir] = sidecarPrimaries(names, p.extensions)
+			}
+			if _, found := sidecars[dir][info.Name()]; !found {
+				logger.Debug("Skipping sidecar without its media file", "path", path)
+				return nil
+			}
+			return fn(path)
+		}
+		if !p.extensions.IsSupported(path) {
+			return nil
+		}
+		return fn(path)
 	})
-	return count, err
+}
+
+// readDirNames returns the names of the files directly inside dir
+func readDirNames(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
+
+// stagingName returns the name a source file gets in the staging directory,
+// using its directory structure relative to sourceDir as a prefix
+func stagingName(sourceDir, path string) (string, error) {
+	relPath, err := filepath.Rel(sourceDir, path)
+	if err != nil {
+		return "", err
+	}
+
+	// Use directory structure as prefix, replacing path separators with dashes
+	prefix := strings.ReplaceAll(filepath.Dir(relPath), string(filepath.Separator), "-")
+	if prefix == "." {
+		prefix = "root"
+	}
+	return fmt.Sprintf("%s-%s", prefix, filepath.Base(path)), nil
+}
+
+// Plan computes what Parse would do without touching the disk
+//
+// The archives of a Takeout export are extracted to a temporary directory, as
+// their files have to be read to be dated.
+func (p *mediaParser) Plan(sourceDir, targetDir string, opts ParseOptions) (*ParsePlan, error) {
+	sourceDir = strings.TrimSuffix(sourceDir, "/")
+	targetDir = strings.TrimSuffix(targetDir, "/")
+	logger.Debug("Planning media parsing", "source", sourceDir, "target", targetDir)
+
+	extractDir := ""
+	if opts.SourceMode == SourceModeTakeout {
+		tmpDir, err := os.MkdirTemp("", "pics-plan-")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create temporary directory: %w", err)
+		}
+		defer os.RemoveAll(tmpDir)
+		extractDir = filepath.Join(tmpDir, extractDirName)
+	}
+	sourceDirs, origins, err := p.prepareSource(context.Background(), sourceDir, extractDir, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []PlannedFile
+	for _, dir := range sourceDirs {
+		err := p.walkMediaFiles(dir, func(path string) error {
+			name, err := stagingName(dir, path)
+			if err != nil {
+				return err
+			}
+			name, compressible := p.checkContent(path, name, opts)
+			convert := p.converts(path, name, opts)
+			if convert {
+				// The converted JPEG is always above the quality it gets compressed at
+				name = convertedName(path, name)
+				compressible = p.extensions.IsCompressible(name)
+			}
+			files = append(files, PlannedFile{
+				Source:      path,
+				StagingName: name,
+				Convert:     convert,
+				Compress:    (opts.CompressJPEGs && compressible && (convert || compressionSkip(path, opts.JPEGQuality) == "")) || (opts.CompressVideos && p.extensions.IsVideo(path)),
+			})
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to discover media files: %w", err)
+		}
+	}
+
+	dates, err := p.sidecarDates(sourceDirs, "", opts.SourceMode)
+	if err != nil {
+		return nil, err
+	}
+	organiser, stopExiftool := p.organiser.withExiftoolPool(context.Background(), exiftoolPoolSize(opts.MaxConcurrency))
+	defer stopExiftool()
+	// Nothing is converted while planning, so no HEIC file is paired with a JPEG
+	noConversions := func() map[string]string { return map[string]string{} }
+	if err := organiser.withSidecarDates(dates).withTimezone(opts.Timezone).withConversions(noConversions).PlanOrganisation(targetDir, files); err != nil {
+		return nil, fmt.Errorf("failed to plan organisation: %w", err)
+	}
+
+	for i := range files {
+		files[i].Source = archiveSource(origins, files[i].Source)
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].FinalPath < files[j].FinalPath
+	})
+
+	return &ParsePlan{
+		SourceDir: sourceDir,
+		TargetDir: targetDir,
+		Files:     files,
+	}, nil
 }
 
 // copyAndCompressFiles copies and optionally compresses files in parallel using a worker pool
-func (p *mediaParser) copyAndCompressFiles(sourceDir, tmpTarget string, opts ParseOptions) error {
+// Files the journal already records as copied are skipped. Files that fail are
+// quarantined when q is not nil.
+func (p *mediaParser) copyAndCompressFiles(ctx context.Context, sourceDirs []string, tmpTarget string, opts ParseOptions, journal *parseJournal, q *quarantine) error {
+	copied := journal.copiedFiles()
+
 	// Count total files upfront for accurate progress reporting
-	logger.Info("Counting files", "source", sourceDir)
-	totalFiles, err := p.countFiles(sourceDir)
+	logger.Info("Counting files", "source", sourceDirs)
+	totalFiles, err := p.countFiles(sourceDirs, copied)
 	if err != nil {
 		return fmt.Errorf("failed to count files: %w", err)
 	}
@@ -137,15 +590,20 @@ func (p *mediaParser) copyAndCompressFiles(sourceDir, tmpTarget string, opts Par
 	// Start worker pool first
 	for i := 0; i < numWorkers; i++ {
 		wg.Add(1)
-		go p.processFileWorker(jobs, errChan, opts, &wg, &processedCount, &totalCount)
+		go p.processFileWorker(ctx, jobs, errChan, opts, journal, q, &wg, &processedCount, &totalCount)
 	}
 
 	// Discover files in background (feeds workers as it discovers)
-	go p.discoverFiles(sourceDir, tmpTarget, jobs)
+	go p.discoverFiles(ctx, sourceDirs, tmpTarget, copied, opts, jobs)
 
 	wg.Wait()
 	close(errChan)
 
+	// Errors caused by the cancellation itself (killed processes) are not worth reporting
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	// Collect all errors from workers
 	var errors []error
 	for err := range errChan {
@@ -168,10 +626,15 @@ func (p *mediaParser) copyAndCompressFiles(sourceDir, tmpTarget string, opts Par
 	return nil
 }
 
-// processFileWorker processes files from the jobs channel
-func (p *mediaParser) processFileWorker(jobs <-chan fileToProcess, errChan chan<- error, opts ParseOptions, wg *sync.WaitGroup, processedCount *atomic.Int64, totalCount *atomic.Int64) {
+// processFileWorker processes files from the jobs channel. Once ctx is
+// cancelled, the remaining jobs are drained without being processed.
+func (p *mediaParser) processFileWorker(ctx context.Context, jobs <-chan fileToProcess, errChan chan<- error, opts ParseOptions, journal *parseJournal, q *quarantine, wg *sync.WaitGroup, processedCount *atomic.Int64, totalCount *atomic.Int64) {
 	defer wg.Done()
 	for file := range jobs {
+		if ctx.Err() != nil {
+			logger.Debug("Skipping file, parse cancelled", "path", file.srcPath)
+			continue
+		}
 		logger.Debug("Copying file", "from", file.srcPath, "to", file.destPath)
 
 		// Increment processed count
@@ -195,129 +658,443 @@ func (p *mediaParser) processFileWorker(jobs <-chan fileToProcess, errChan chan<
 			}
 		}
 
-		if err := copyFilePreserveTime(file.srcPath, file.destPath); err != nil {
-			errChan <- fmt.Errorf("failed to copy %s: %w", file.srcPath, err)
+		if err := journal.planned(journalOpCopy, file.srcPath, file.destPath); err != nil {
+			errChan <- err
 			continue
 		}
 
-		if file.isJPEG && opts.CompressJPEGs {
-			logger.Debug("Compressing file", "path", file.destPath)
-
-			// Emit compression progress event
-			if opts.ProgressChan != nil {
-				current := processedCount.Load()
-				total := totalCount.Load()
-
-				select {
-				case opts.ProgressChan <- ProgressEvent{
-					Stage:   "compressing",
-					Current: int(current),
-					Total:   int(total),
-					Message: fmt.Sprintf("Compressing file %d of %d", current, total),
-					File:    file.destPath,
-				}:
-				default:
-					logger.Debug("Progress event dropped (channel full)", "stage", "compressing")
-				}
+		sourceHash, err := copyFilePreserveTime(file.srcPath, file.destPath)
+		if err == nil && opts.MoveSource {
+			// Read the copy back, the source is only deleted if what landed on disk matches it
+			err = checkHash(file.destPath, sourceHash)
+		}
+		if err != nil {
+			err = fmt.Errorf("failed to copy %s: %w", file.srcPath, err)
+			if !q.accepts(ctx, err) {
+				errChan <- err
+				continue
+			}
+			// Nothing worth keeping was copied, so only the failure is recorded
+			os.Remove(file.destPath)
+			if _, err := q.add(file.srcPath, "", "copying", err); err != nil {
+				errChan <- err
 			}
+			continue
+		}
 
-			if err := p.compressor.CompressFile(file.destPath, opts.JPEGQuality); err != nil {
-				errChan <- fmt.Errorf("failed to compress %s: %w", file.destPath, err)
+		if file.convertTo != "" && !p.convert(ctx, &file, opts, journal, q, errChan, processedCount, totalCount) {
+			continue
+		}
+
+		compressed := false
+		if file.isJPEG && opts.MaxLongEdge > 0 {
+			downscaled, ok := p.downscale(ctx, file, opts, journal, q, errChan, processedCount, totalCount)
+			if !ok {
 				continue
 			}
+			compressed = downscaled
+		}
+		// A downscaled file was already re-encoded at the target quality
+		if file.isJPEG && opts.CompressJPEGs && !compressed {
+			if reason := compressionSkip(file.destPath, opts.JPEGQuality); reason != "" {
+				logger.Debug("Skipping compression", "path", file.destPath, "reason", reason)
+				p.emitSkip(opts, file, reason, processedCount, totalCount)
+			} else {
+				replaced, ok := p.compress(ctx, file, opts, journal, q, errChan, processedCount, totalCount)
+				if !ok {
+					continue
+				}
+				compressed = replaced
+			}
+		}
+		if file.isVideo && opts.CompressVideos {
+			transcoded, ok := p.transcode(ctx, file, opts, journal, q, errChan, processedCount, totalCount)
+			if !ok {
+				continue
+			}
+			compressed = transcoded
+		}
+
+		if err := p.recordCopy(journal, file, sourceHash, compressed, opts); err != nil {
+			errChan <- err
+			continue
 		}
 
 		logger.Debug("Finished processing file", "path", file.destPath)
 	}
 }
 
-// discoverFiles walks directories recursively and sends files to the jobs channel
-func (p *mediaParser) discoverFiles(sourceDir, tmpTarget string, jobs chan<- fileToProcess) {
-	defer close(jobs)
-	logger.Info("Discovering files to process", "source", sourceDir)
+// transcode compresses a staged video, reporting how far along it is. It returns whether
+// the video was replaced by a smaller one, and false as its second value when the file
+// failed, after reporting the error or quarantining the file.
+func (p *mediaParser) transcode(ctx context.Context, file fileToProcess, opts ParseOptions, journal *parseJournal, q *quarantine, errChan chan<- error, processedCount *atomic.Int64, totalCount *atomic.Int64) (bool, bool) {
+	logger.Debug("Transcoding file", "path", file.destPath, "codec", opts.VideoPreset.Codec, "crf", opts.VideoPreset.CRF)
 
-	filepath.Walk(sourceDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			logger.Debug("Error accessing path", "path", path, "error", err)
-			return err
+	// Emitted whenever the percentage changes, as transcoding a long video takes a while
+	lastPercent := -1
+	progress := func(done float64) {
+		percent := int(done * 100)
+		if opts.ProgressChan == nil || percent == lastPercent {
+			return
 		}
+		lastPercent = percent
+		current := processedCount.Load()
+		total := totalCount.Load()
 
-		// Skip dot files and dot directories
-		if strings.HasPrefix(info.Name(), ".") {
-			if info.IsDir() {
-				return filepath.SkipDir
-			}
-			return nil
+		select {
+		case opts.ProgressChan <- ProgressEvent{
+			Stage:   "transcoding",
+			Current: int(current),
+			Total:   int(total),
+			Message: fmt.Sprintf("Transcoding file %d of %d: %d%%", current, total, percent),
+			File:    file.destPath,
+		}:
+		default:
+			logger.Debug("Progress event dropped (channel full)", "stage", "transcoding")
 		}
+	}
+	progress(0)
 
-		if info.IsDir() {
-			return nil
+	transcoded, err := p.video.CompressVideo(ctx, file.destPath, opts.VideoPreset, progress)
+	if err != nil {
+		return false, p.fail(ctx, file, "transcoding", fmt.Errorf("failed to transcode %s: %w", file.destPath, err), q, journal, errChan)
+	}
+	return transcoded, true
+}
+
+// convert converts a staged HEIC file to JPEG, which then takes its place in the
+// pipeline. The HEIC file is kept with opts.KeepHEIC, and removed otherwise. It returns
+// false when the file failed, after reporting the error or quarantining the file.
+func (p *mediaParser) convert(ctx context.Context, file *fileToProcess, opts ParseOptions, journal *parseJournal, q *quarantine, errChan chan<- error, processedCount *atomic.Int64, totalCount *atomic.Int64) bool {
+	logger.Debug("Converting file", "path", file.destPath, "to", file.convertTo)
+
+	// Emit conversion progress event
+	if opts.ProgressChan != nil {
+		current := processedCount.Load()
+		total := totalCount.Load()
+
+		select {
+		case opts.ProgressChan <- ProgressEvent{
+			Stage:   "converting",
+			Current: int(current),
+			Total:   int(total),
+			Message: fmt.Sprintf("Converting file %d of %d", current, total),
+			File:    file.destPath,
+		}:
+		default:
+			logger.Debug("Progress event dropped (channel full)", "stage", "converting")
+		}
+	}
+
+	err := p.converter.ConvertFile(ctx, file.destPath, file.convertTo)
+	if err == nil && !opts.KeepHEIC {
+		err = os.Remove(file.destPath)
+	}
+	if err != nil {
+		os.Remove(file.convertTo)
+		return p.fail(ctx, *file, "converting", fmt.Errorf("failed to convert %s: %w", file.destPath, err), q, journal, errChan)
+	}
+
+	if opts.KeepHEIC {
+		file.original = file.destPath
+	}
+	file.destPath = file.convertTo
+	file.isJPEG = p.extensions.IsCompressible(file.destPath)
+	return true
+}
+
+// converts reports whether a file is converted to JPEG, which files holding HEIC
+// content are when opts.ConvertHEIC is set
+func (p *mediaParser) converts(path, name string, opts ParseOptions) bool {
+	if !opts.ConvertHEIC || p.extensions.IsSidecar(path) {
+		return false
+	}
+	if format, known := sniffFormat(path); known {
+		return format.name == formatHEIC.name
+	}
+	return formatHEIC.fits(name, p.extensions)
+}
+
+// fail handles a staged file that failed in stage. The error stops the parse unless q
+// accepts it, in which case the staged file is quarantined and recorded as done so a
+// resumed parse does not try it again. It returns false, for the stage to return.
+func (p *mediaParser) fail(ctx context.Context, file fileToProcess, stage string, err error, q *quarantine, journal *parseJournal, errChan chan<- error) bool {
+	if !q.accepts(ctx, err) {
+		errChan <- err
+		return false
+	}
+	quarantined, err := q.add(file.srcPath, file.destPath, stage, err)
+	if err == nil {
+		err = journal.done(journalOpCopy, file.srcPath, quarantined)
+	}
+	if err != nil {
+		errChan <- err
+	}
+	return false
+}
+
+// compressorFor returns the compressor of the backend selected in opts, jpegoptim by default
+func (p *mediaParser) compressorFor(opts ParseOptions) ImageCompressor {
+	if opts.Compressor == CompressorGo {
+		return p.goCompressor
+	}
+	return p.compressor
+}
+
+// emitSkip reports a JPEG file that is not compressed, and why
+func (p *mediaParser) emitSkip(opts ParseOptions, file fileToProcess, reason string, processedCount *atomic.Int64, totalCount *atomic.Int64) {
+	if opts.ProgressChan == nil {
+		return
+	}
+	current := processedCount.Load()
+	total := totalCount.Load()
+
+	select {
+	case opts.ProgressChan <- ProgressEvent{
+		Stage:   "skipping",
+		Current: int(current),
+		Total:   int(total),
+		Message: fmt.Sprintf("Not compressing file %d of %d: %s", current, total, reason),
+		File:    file.destPath,
+	}:
+	default:
+		logger.Debug("Progress event dropped (channel full)", "stage", "skipping")
+	}
+}
+
+// downscale shrinks a staged JPEG file larger than opts.MaxLongEdge, re-encoding it at
+// opts.JPEGQuality when compressing. It returns whether the file was downscaled, and
+// false as its second value when the file failed, after reporting the error or
+// quarantining the file.
+func (p *mediaParser) downscale(ctx context.Context, file fileToProcess, opts ParseOptions, journal *parseJournal, q *quarantine, errChan chan<- error, processedCount *atomic.Int64, totalCount *atomic.Int64) (bool, bool) {
+	quality := convertQuality
+	if opts.CompressJPEGs {
+		quality = opts.JPEGQuality
+	}
+
+	downscaled, err := downscaleJPEG(ctx, file.destPath, opts.MaxLongEdge, quality)
+	if err != nil {
+		return false, p.fail(ctx, file, "downscaling", fmt.Errorf("failed to downscale %s: %w", file.destPath, err), q, journal, errChan)
+	}
+	if !downscaled {
+		return false, true
+	}
+
+	// Emit downscaling progress event
+	if opts.ProgressChan != nil {
+		current := processedCount.Load()
+		total := totalCount.Load()
+
+		select {
+		case opts.ProgressChan <- ProgressEvent{
+			Stage:   "downscaling",
+			Current: int(current),
+			Total:   int(total),
+			Message: fmt.Sprintf("Downscaled file %d of %d to %d pixels", current, total, opts.MaxLongEdge),
+			File:    file.destPath,
+		}:
+		default:
+			logger.Debug("Progress event dropped (channel full)", "stage", "downscaling")
+		}
+	}
+
+	if opts.CompressJPEGs {
+		// The marker only spares the file a second compression, the parse goes on without it
+		if err := markCompressed(file.destPath, opts.JPEGQuality); err != nil {
+			logger.Warn("Failed to mark file as compressed", "path", file.destPath, "error", err)
+		}
+	}
+	return true, true
+}
+
+// compress compresses a staged JPEG file and marks it as compressed by pics. It returns
+// whether the compressor replaced the file, and false as its second value when the file
+// failed, after reporting the error or quarantining the file.
+func (p *mediaParser) compress(ctx context.Context, file fileToProcess, opts ParseOptions, journal *parseJournal, q *quarantine, errChan chan<- error, processedCount *atomic.Int64, totalCount *atomic.Int64) (bool, bool) {
+	logger.Debug("Compressing file", "path", file.destPath)
+
+	// Emit compression progress event
+	if opts.ProgressChan != nil {
+		current := processedCount.Load()
+		total := totalCount.Load()
+
+		select {
+		case opts.ProgressChan <- ProgressEvent{
+			Stage:   "compressing",
+			Current: int(current),
+			Total:   int(total),
+			Message: fmt.Sprintf("Compressing file %d of %d", current, total),
+			File:    file.destPath,
+		}:
+		default:
+			logger.Debug("Progress event dropped (channel full)", "stage", "compressing")
+		}
+	}
+
+	replaced, err := p.compressorFor(opts).CompressFile(ctx, file.destPath, opts.JPEGQuality)
+	if err != nil {
+		return false, p.fail(ctx, file, "compressing", fmt.Errorf("failed to compress %s: %w", file.destPath, err), q, journal, errChan)
+	}
+	if !replaced {
+		// The original was kept, so it is neither marked nor counted as compressed
+		logger.Debug("Compressor kept the original", "path", file.destPath)
+		return false, true
+	}
+
+	// The marker only spares the file a second compression, the parse goes on without it
+	if err := markCompressed(file.destPath, opts.JPEGQuality); err != nil {
+		logger.Warn("Failed to mark file as compressed", "path", file.destPath, "error", err)
+	}
+	return true, true
+}
+
+// recordCopy records a completed copy in the journal, along with the sizes of the
+// source and of the (possibly compressed) staged copy. When moving, their hashes
+// are recorded too.
+func (p *mediaParser) recordCopy(journal *parseJournal, file fileToProcess, sourceHash string, compressed bool, opts ParseOptions) error {
+	record := journalRecord{From: file.srcPath, To: file.destPath, Compressed: compressed, Original: file.original}
+
+	srcInfo, err := os.Stat(file.srcPath)
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", file.srcPath, err)
+	}
+	destInfo, err := os.Stat(file.destPath)
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", file.destPath, err)
+	}
+	record.Size = srcInfo.Size()
+	record.StagedSize = destInfo.Size()
+
+	if opts.MoveSource {
+		record.SourceHash = sourceHash
+		record.Hash = sourceHash
+		if record.Compressed || file.convertTo != "" {
+			if record.Hash, err = hashFile(file.destPath); err != nil {
+				return fmt.Errorf("failed to hash %s: %w", file.destPath, err)
+			}
 		}
+	}
+	return journal.copied(record)
+}
+
+// discoverFiles walks directories recursively and sends files that are not copied yet to the jobs channel,
+// stopping the walk when ctx is cancelled
+func (p *mediaParser) discoverFiles(ctx context.Context, sourceDirs []string, tmpTarget string, copied map[string]bool, opts ParseOptions, jobs chan<- fileToProcess) {
+	defer close(jobs)
 
-		if p.extensions.IsSupported(path) {
-			// Calculate relative path from source directory for prefixing
-			relPath, err := filepath.Rel(sourceDir, path)
+	for _, sourceDir := range sourceDirs {
+		logger.Info("Discovering files to process", "source", sourceDir)
+		err := p.walkMediaFiles(sourceDir, func(path string) error {
+			if err := ctx.Err(); err != nil {
+				logger.Debug("Stopping file discovery, parse cancelled")
+				return err
+			}
+			if copied[path] {
+				logger.Debug("Skipping file already copied", "path", path)
+				return nil
+			}
+
+			name, err := stagingName(sourceDir, path)
 			if err != nil {
 				logger.Debug("Failed to calculate relative path", "path", path, "error", err)
 				return err
 			}
 
-			// Use directory structure as prefix, replacing path separators with dashes
-			prefix := strings.ReplaceAll(filepath.Dir(relPath), string(filepath.Separator), "-")
-			if prefix == "." {
-				prefix = "root"
+			name, compressible := p.checkContent(path, name, opts)
+			destPath := filepath.Join(tmpTarget, name)
+			convertTo := ""
+			if p.converts(path, name, opts) {
+				convertTo = filepath.Join(tmpTarget, convertedName(path, name))
 			}
-
-			destPath := filepath.Join(tmpTarget, fmt.Sprintf("%s-%s", prefix, filepath.Base(path)))
 			logger.Debug("Discovered file", "path", path, "dest", destPath)
 
-			jobs <- fileToProcess{
-				srcPath:  path,
-				destPath: destPath,
-				isJPEG:   p.extensions.IsJPEG(path),
+			select {
+			case jobs <- fileToProcess{
+				srcPath:   path,
+				destPath:  destPath,
+				isJPEG:    compressible,
+				isVideo:   p.extensions.IsVideo(path),
+				convertTo: convertTo,
+			}:
+				return nil
+			case <-ctx.Done():
+				logger.Debug("Stopping file discovery, parse cancelled")
+				return ctx.Err()
 			}
+		})
+		if err != nil {
+			return
 		}
-		return nil
-	})
+	}
 }
 
-// copyFilePreserveTime copies a file and preserves its modification time
-func copyFilePreserveTime(src, dst string) error {
+// checkContent compares the content of a media file with its extension. It returns the name
+// the file is staged under, with the extension of its content when opts.FixExtensions is set,
+// and whether it can be compressed, which only JPEG content can.
+func (p *mediaParser) checkContent(path, name string, opts ParseOptions) (string, bool) {
+	compressible := p.extensions.IsCompressible(path)
+	if p.extensions.IsSidecar(path) {
+		return name, false
+	}
+	format, known := sniffFormat(path)
+	if !known || format.fits(path, p.extensions) {
+		return name, compressible
+	}
+
+	fixed := format.rename(name)
+	logger.Warn("File content does not match its extension", "file", path, "format", format.name)
+	if opts.FixExtensions && fixed != name && p.extensions.IsSupported(fixed) {
+		logger.Info("Correcting file extension", "file", path, "name", fixed)
+		name = fixed
+	}
+	return name, format.name == formatJPEG.name && p.extensions.IsCompressible(format.rename(path))
+}
+
+// copyFilePreserveTime copies a file and preserves its modification time,
+// returning the SHA-256 hash of the copied content
+func copyFilePreserveTime(src, dst string) (string, error) {
 	logger.Debug("Starting file copy", "from", src, "to", dst)
 
 	srcInfo, err := os.Stat(src)
 	if err != nil {
 		logger.Debug("Failed to stat source file", "file", src, "error", err)
-		return err
+		return "", err
 	}
 
 	srcFile, err := os.Open(src)
 	if err != nil {
 		logger.Debug("Failed to open source file", "file", src, "error", err)
-		return err
+		return "", err
 	}
 	defer srcFile.Close()
 
 	dstFile, err := os.Create(dst)
 	if err != nil {
 		logger.Debug("Failed to create destination file", "file", dst, "error", err)
-		return err
+		return "", err
 	}
-	defer dstFile.Close()
 
-	bytesWritten, err := io.Copy(dstFile, srcFile)
+	hash := sha256.New()
+	bytesWritten, err := io.Copy(io.MultiWriter(dstFile, hash), srcFile)
 	if err != nil {
+		dstFile.Close()
 		logger.Debug("Failed to copy file contents", "from", src, "to", dst, "error", err)
-		return err
+		return "", err
+	}
+	// Closed before hashing is trusted, as a failed flush (full disk, network filesystem)
+	// only shows here and the source may be deleted on the strength of the copy
+	if err := dstFile.Close(); err != nil {
+		logger.Debug("Failed to close destination file", "file", dst, "error", err)
+		return "", err
 	}
 
 	logger.Debug("File copied successfully", "from", src, "to", dst, "bytes", bytesWritten)
 
 	if err := os.Chtimes(dst, time.Now(), srcInfo.ModTime()); err != nil {
 		logger.Debug("Failed to preserve modification time", "file", dst, "error", err)
-		return err
+		return "", err
 	}
 
 	logger.Debug("Modification time preserved", "file", dst, "modTime", srcInfo.ModTime())
-	return nil
+	return hex.EncodeToString(hash.Sum(nil)), nil
 }
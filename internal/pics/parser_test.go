@@ -1,6 +1,8 @@
 package pics
 
 import (
+	"context"
+	"errors"
 	"os"
 	"path/filepath"
 	"testing"
@@ -92,7 +94,7 @@ func TestMediaParser_Parse(t *testing.T) {
 	createMediaFile(t, sourceDir, "video1.mov", testDate)
 
 	// Parse files
-	err := testParser.Parse(sourceDir, targetDir, testParseOptions)
+	_, err := testParser.Parse(sourceDir, targetDir, testParseOptions)
 
 	if err != nil {
 		t.Errorf("Expected no error, got: %v", err)
@@ -117,7 +119,7 @@ func TestMediaParser_Parse_EmptySource(t *testing.T) {
 	sourceDir, targetDir := createSourceAndTarget(t, tmpDir)
 
 	// Parse with no files in source
-	err := testParser.Parse(sourceDir, targetDir, testParseOptions)
+	_, err := testParser.Parse(sourceDir, targetDir, testParseOptions)
 
 	if err != nil {
 		t.Errorf("Expected no error for empty source, got: %v", err)
@@ -136,7 +138,7 @@ func TestMediaParser_Parse_MultipleDates(t *testing.T) {
 	createMediaFile(t, sourceDir, "july.jpg", date2)
 
 	// Parse files
-	err := testParser.Parse(sourceDir, targetDir, testParseOptions)
+	_, err := testParser.Parse(sourceDir, targetDir, testParseOptions)
 
 	if err != nil {
 		t.Errorf("Expected no error, got: %v", err)
@@ -160,7 +162,7 @@ func TestMediaParser_Parse_WithSubdirectories(t *testing.T) {
 	createMediaFile(t, subdir2, "image2.jpeg", testDate)
 
 	// Parse files
-	err := testParser.Parse(sourceDir, targetDir, testParseOptions)
+	_, err := testParser.Parse(sourceDir, targetDir, testParseOptions)
 
 	if err != nil {
 		t.Errorf("Expected no error, got: %v", err)
@@ -199,7 +201,7 @@ func TestMediaParser_Parse_SkipsDotFiles(t *testing.T) {
 	createMediaFile(t, sourceDir, ".hidden.jpg", testDate)
 
 	// Parse files
-	err := testParser.Parse(sourceDir, targetDir, testParseOptions)
+	_, err := testParser.Parse(sourceDir, targetDir, testParseOptions)
 
 	if err != nil {
 		t.Errorf("Expected no error, got: %v", err)
@@ -237,7 +239,7 @@ func TestMediaParser_Parse_SkipsDotDirectories(t *testing.T) {
 	createMediaFile(t, dotSubdir, "image2.jpg", testDate)
 
 	// Parse files
-	err := testParser.Parse(sourceDir, targetDir, testParseOptions)
+	_, err := testParser.Parse(sourceDir, targetDir, testParseOptions)
 
 	if err != nil {
 		t.Errorf("Expected no error, got: %v", err)
@@ -274,7 +276,7 @@ func TestMediaParser_Parse_MixedFileTypes(t *testing.T) {
 	createMediaFile(t, sourceDir, "video.mov", testDate)
 
 	// Parse files
-	err := testParser.Parse(sourceDir, targetDir, testParseOptions)
+	_, err := testParser.Parse(sourceDir, targetDir, testParseOptions)
 
 	if err != nil {
 		t.Errorf("Expected no error, got: %v", err)
@@ -301,7 +303,7 @@ func TestMediaParser_Parse_IgnoresUnsupportedFiles(t *testing.T) {
 	createMediaFile(t, sourceDir, "video.avi", testDate)
 
 	// Parse files
-	err := testParser.Parse(sourceDir, targetDir, testParseOptions)
+	_, err := testParser.Parse(sourceDir, targetDir, testParseOptions)
 
 	if err != nil {
 		t.Errorf("Expected no error, got: %v", err)
@@ -327,7 +329,7 @@ func TestMediaParser_Parse_MP4Videos(t *testing.T) {
 	createMediaFile(t, sourceDir, "video2.MP4", testDate)
 
 	// Parse files
-	err := testParser.Parse(sourceDir, targetDir, testParseOptions)
+	_, err := testParser.Parse(sourceDir, targetDir, testParseOptions)
 
 	if err != nil {
 		t.Errorf("Expected no error, got: %v", err)
@@ -344,6 +346,233 @@ func TestMediaParser_Parse_MP4Videos(t *testing.T) {
 	assertMediaFileExists(t, filepath.Join(videosDir, "2023_06_June_15_00002.mp4"))
 }
 
+func TestMediaParser_Plan(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir, targetDir := createSourceAndTarget(t, tmpDir)
+
+	testDate := time.Date(2023, 6, 15, 10, 30, 0, 0, time.UTC)
+	subdir := createSubdir(t, sourceDir, "vacation")
+	createMediaFile(t, subdir, "image1.jpg", testDate)
+	createMediaFile(t, sourceDir, "image2.HEIC", testDate)
+	createMediaFile(t, sourceDir, "video1.mov", testDate)
+	createMediaFile(t, sourceDir, "document.txt", testDate)
+
+	plan, err := testParser.Plan(sourceDir, targetDir, DefaultParseOptions())
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if len(plan.Files) != 3 {
+		t.Fatalf("Expected 3 planned files, got %d", len(plan.Files))
+	}
+
+	// Sorted by final path: images first, then the videos subdirectory
+	expected := []PlannedFile{
+		{StagingName: "root-image2.HEIC", Compress: false, FinalPath: filepath.Join("2023 06 June 15", "2023_06_June_15_00001.heic")},
+		{StagingName: "vacation-image1.jpg", Compress: true, FinalPath: filepath.Join("2023 06 June 15", "2023_06_June_15_00002.jpg")},
+		{StagingName: "root-video1.mov", Compress: false, FinalPath: filepath.Join("2023 06 June 15", "videos", "2023_06_June_15_00001.mov")},
+	}
+	for i, want := range expected {
+		got := plan.Files[i]
+		if got.StagingName != want.StagingName {
+			t.Errorf("File %d: expected staging name %s, got %s", i, want.StagingName, got.StagingName)
+		}
+		if got.Compress != want.Compress {
+			t.Errorf("File %d: expected compress %v, got %v", i, want.Compress, got.Compress)
+		}
+		if got.FinalPath != want.FinalPath {
+			t.Errorf("File %d: expected final path %s, got %s", i, want.FinalPath, got.FinalPath)
+		}
+		if got.DateDir != "2023 06 June 15" {
+			t.Errorf("File %d: expected date dir '2023 06 June 15', got %s", i, got.DateDir)
+		}
+		if got.Extractor == "" {
+			t.Errorf("File %d: expected extractor to be set", i)
+		}
+	}
+
+	// Nothing should have been written to the target
+	entries, err := os.ReadDir(targetDir)
+	if err != nil {
+		t.Fatalf("Failed to read target directory: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("Expected target to be untouched, found %d entries", len(entries))
+	}
+}
+
+func TestMediaParser_Plan_MatchesParse(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir, targetDir := createSourceAndTarget(t, tmpDir)
+
+	date1 := time.Date(2023, 6, 15, 10, 30, 0, 0, time.UTC)
+	date2 := time.Date(2023, 7, 20, 14, 0, 0, 0, time.UTC)
+	createMediaFile(t, sourceDir, "b.jpg", date1)
+	createMediaFile(t, sourceDir, "a.jpeg", date1)
+	createMediaFile(t, sourceDir, "c.mp4", date2)
+	createMediaFile(t, createSubdir(t, sourceDir, "trip"), "d.jpg", date2)
+
+	plan, err := testParser.Plan(sourceDir, targetDir, testParseOptions)
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+
+	if _, err := testParser.Parse(sourceDir, targetDir, testParseOptions); err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	for _, file := range plan.Files {
+		assertMediaFileExists(t, filepath.Join(targetDir, file.FinalPath))
+	}
+}
+
+func TestMediaParser_Parse_MergesIntoExistingLibrary(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir, targetDir := createSourceAndTarget(t, tmpDir)
+
+	// Existing library: a named directory for the import date and an unrelated one
+	namedDir := createSubdir(t, targetDir, "2023 06 June 15 Beach")
+	createMediaFile(t, namedDir, "2023_06_June_15_Beach_00001.jpg", time.Now())
+	createMediaFile(t, namedDir, "2023_06_June_15_Beach_00002.jpg", time.Now())
+	otherDir := createSubdir(t, targetDir, "2022 01 January 01")
+	createMediaFile(t, otherDir, "unsorted.jpg", time.Now())
+
+	testDate := time.Date(2023, 6, 15, 10, 30, 0, 0, time.UTC)
+	createMediaFile(t, sourceDir, "new.jpg", testDate)
+	createMediaFile(t, sourceDir, "clip.mov", testDate)
+
+	plan, err := testParser.Plan(sourceDir, targetDir, testParseOptions)
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+
+	if _, err := testParser.Parse(sourceDir, targetDir, testParseOptions); err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	assertMediaFileExists(t, filepath.Join(namedDir, "2023_06_June_15_Beach_00001.jpg"))
+	assertMediaFileExists(t, filepath.Join(namedDir, "2023_06_June_15_Beach_00002.jpg"))
+	assertMediaFileExists(t, filepath.Join(namedDir, "2023_06_June_15_Beach_00003.jpg"))
+	assertMediaFileExists(t, filepath.Join(namedDir, "videos", "2023_06_June_15_Beach_00001.mov"))
+	assertMediaFileNotExists(t, filepath.Join(targetDir, "2023 06 June 15"))
+
+	// Directories not touched by the import are left alone
+	assertMediaFileExists(t, filepath.Join(otherDir, "unsorted.jpg"))
+
+	// The plan agrees with what Parse did
+	for _, file := range plan.Files {
+		assertMediaFileExists(t, filepath.Join(targetDir, file.FinalPath))
+	}
+}
+
+func TestMediaParser_Parse_ReturnsResult(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir, targetDir := createSourceAndTarget(t, tmpDir)
+
+	namedDir := createSubdir(t, targetDir, "2023 06 June 15 Beach")
+	createMediaFile(t, namedDir, "unsorted.jpg", time.Now())
+
+	testDate := time.Date(2023, 6, 15, 10, 30, 0, 0, time.UTC)
+	createMediaFile(t, sourceDir, "image.jpg", testDate)
+	createMediaFile(t, createSubdir(t, sourceDir, "phone"), "video.mov", testDate)
+
+	result, err := testParser.Parse(sourceDir, targetDir, testParseOptions)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	if len(result.Files) != 2 {
+		t.Fatalf("Expected 2 imported files, got %d", len(result.Files))
+	}
+	expectedSources := []string{filepath.Join(sourceDir, "image.jpg"), filepath.Join(sourceDir, "phone", "video.mov")}
+	for i, file := range result.Files {
+		if file.Source != expectedSources[i] {
+			t.Errorf("Expected source %s, got %s", expectedSources[i], file.Source)
+		}
+		assertMediaFileExists(t, filepath.Join(targetDir, file.FinalPath))
+	}
+	if result.Files[1].FinalPath != filepath.Join("2023 06 June 15 Beach", "videos", "2023_06_June_15_Beach_00001.mov") {
+		t.Errorf("Unexpected final path for video: %s", result.Files[1].FinalPath)
+	}
+
+	if len(result.Renamed) != 1 || result.Renamed[0].From != filepath.Join("2023 06 June 15 Beach", "unsorted.jpg") {
+		t.Fatalf("Expected unsorted.jpg to be renamed, got %+v", result.Renamed)
+	}
+	assertMediaFileExists(t, filepath.Join(targetDir, result.Renamed[0].To))
+}
+
+// cancellingCompressor cancels the parse the first time it is called
+type cancellingCompressor struct {
+	cancel context.CancelFunc
+}
+
+func (c *cancellingCompressor) CompressFile(ctx context.Context, path string, quality int) (bool, error) {
+	c.cancel()
+	return false, ctx.Err()
+}
+
+func TestMediaParser_ParseContext_AlreadyCancelled(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir, targetDir := createSourceAndTarget(t, tmpDir)
+	createMediaFile(t, sourceDir, "image.jpg", time.Date(2023, 6, 15, 10, 30, 0, 0, time.UTC))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := testParser.ParseContext(ctx, sourceDir, targetDir, testParseOptions)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got: %v", err)
+	}
+
+	entries, err := os.ReadDir(targetDir)
+	if err != nil {
+		t.Fatalf("Failed to read target: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("Expected empty target after cancellation, got %d entries", len(entries))
+	}
+	assertMediaFileExists(t, filepath.Join(sourceDir, "image.jpg"))
+}
+
+func TestMediaParser_ParseContext_CancelledWhileCompressing(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir, targetDir := createSourceAndTarget(t, tmpDir)
+
+	existingDir := createSubdir(t, targetDir, "2023 06 June 15")
+	createMediaFile(t, existingDir, "2023_06_June_15_00001.jpg", time.Now())
+
+	testDate := time.Date(2023, 6, 15, 10, 30, 0, 0, time.UTC)
+	for _, name := range []string{"a.jpg", "b.jpg", "c.jpg", "d.mov"} {
+		createMediaFile(t, sourceDir, name, testDate)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	parser := &mediaParser{
+		compressor: &cancellingCompressor{cancel: cancel},
+		organiser:  NewFileOrganiser(),
+		extensions: NewExtensions(),
+	}
+	opts := testParseOptions
+	opts.CompressJPEGs = true
+	opts.MaxConcurrency = 1
+
+	_, err := parser.ParseContext(ctx, sourceDir, targetDir, opts)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got: %v", err)
+	}
+
+	// The target is left as it was before the parse
+	entries, err := os.ReadDir(existingDir)
+	if err != nil {
+		t.Fatalf("Failed to read existing directory: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("Expected 1 file in existing directory, got %d", len(entries))
+	}
+	assertMediaFileNotExists(t, filepath.Join(targetDir, journalDirName))
+}
+
 func TestCopyFilePreserveTime(t *testing.T) {
 	tmpDir := t.TempDir()
 
@@ -359,7 +588,7 @@ func TestCopyFilePreserveTime(t *testing.T) {
 
 	// Copy file
 	dstPath := filepath.Join(tmpDir, "destination.txt")
-	err := copyFilePreserveTime(srcPath, dstPath)
+	hash, err := copyFilePreserveTime(srcPath, dstPath)
 
 	if err != nil {
 		t.Errorf("Expected no error, got: %v", err)
@@ -368,6 +597,13 @@ func TestCopyFilePreserveTime(t *testing.T) {
 	// Check destination file exists
 	assertMediaFileExists(t, dstPath)
 
+	// Check the returned hash is the hash of the content
+	// (SHA-256 of "test content")
+	expectedHash := "6ae8a75555209fd6c44157c0aed8016e763ff435a19cf186f76863140143ff72"
+	if hash != expectedHash {
+		t.Errorf("Expected hash %s, got %s", expectedHash, hash)
+	}
+
 	// Check content was copied
 	content, err := os.ReadFile(dstPath)
 	if err != nil {
@@ -387,7 +623,7 @@ func TestCopyFilePreserveTime_NonexistentSource(t *testing.T) {
 	srcPath := filepath.Join(tmpDir, "nonexistent.txt")
 	dstPath := filepath.Join(tmpDir, "destination.txt")
 
-	err := copyFilePreserveTime(srcPath, dstPath)
+	_, err := copyFilePreserveTime(srcPath, dstPath)
 
 	if err == nil {
 		t.Error("Expected error for nonexistent source file")
@@ -406,7 +642,7 @@ func TestCopyFilePreserveTime_InvalidDestination(t *testing.T) {
 	// Try to copy to invalid destination (directory that doesn't exist)
 	dstPath := filepath.Join(tmpDir, "nonexistent_dir", "destination.txt")
 
-	err := copyFilePreserveTime(srcPath, dstPath)
+	_, err := copyFilePreserveTime(srcPath, dstPath)
 
 	if err == nil {
 		t.Error("Expected error for invalid destination path")
@@ -462,7 +698,8 @@ func TestMediaParser_ParseWithProgressChannel(t *testing.T) {
 	// Run parse in goroutine so we can read from channel
 	done := make(chan error)
 	go func() {
-		done <- testParser.Parse(sourceDir, targetDir, opts)
+		_, err := testParser.Parse(sourceDir, targetDir, opts)
+		done <- err
 	}()
 
 	// Collect progress events
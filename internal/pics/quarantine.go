@@ -0,0 +1,124 @@
+package pics
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/acm19/pics/internal/logger"
+)
+
+// quarantineDirName is the directory inside the target that holds files that could not be imported
+const quarantineDirName = "_quarantine"
+
+// isInternalDir reports whether a directory of the library holds the state of pics (the
+// parse journal and other dot directories, or the quarantine) rather than media
+func isInternalDir(name string) bool {
+	return strings.HasPrefix(name, ".") || name == quarantineDirName
+}
+
+// PartialParseError is returned when a parse with ErrorPolicyContinue completes
+// but some files could not be imported
+type PartialParseError struct {
+	// Failures lists every file that could not be imported
+	Failures []FileFailure
+	// ReportPath is the JSON report written to the quarantine directory
+	ReportPath string
+}
+
+func (e *PartialParseError) Error() string {
+	return fmt.Sprintf("%d file(s) could not be imported, see %s", len(e.Failures), e.ReportPath)
+}
+
+// quarantine moves files that could not be imported out of the way and records why
+type quarantine struct {
+	dir      string
+	mover    fileMover
+	mu       sync.Mutex
+	failures []FileFailure
+}
+
+// newQuarantine creates a quarantine in targetDir that moves files through mover
+func newQuarantine(targetDir string, mover fileMover) *quarantine {
+	return &quarantine{
+		dir:   filepath.Join(targetDir, quarantineDirName),
+		mover: mover,
+	}
+}
+
+// accepts reports whether err can be handled by quarantining the file. Errors
+// that would affect every file (missing binaries, cancellation) always stop the parse.
+func (q *quarantine) accepts(ctx context.Context, err error) bool {
+	return q != nil && ctx.Err() == nil && !errors.Is(err, exec.ErrNotFound)
+}
+
+// add records a failure for source and moves stagedPath (if not empty) into the
+// quarantine directory. It returns where the file was moved to.
+func (q *quarantine) add(source, stagedPath, stage string, cause error) (string, error) {
+	failure := FileFailure{File: source, Stage: stage, Cause: cause.Error()}
+
+	// Held while moving too, so two failures with the same name cannot pick the same path
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if stagedPath != "" {
+		if err := q.mover.mkdirAll(q.dir); err != nil {
+			return "", fmt.Errorf("failed to create quarantine directory: %w", err)
+		}
+		// Staged names repeat across parses (every watch batch shares the quarantine), and
+		// the reports of earlier parses still point at the files they quarantined
+		dest := freePath(q.dir, filepath.Base(stagedPath))
+		if err := q.mover.rename(stagedPath, dest); err != nil {
+			return "", fmt.Errorf("failed to quarantine %s: %w", stagedPath, err)
+		}
+		failure.QuarantinedAs = dest
+	}
+
+	logger.Warn("File could not be imported", "file", source, "stage", stage, "error", cause, "quarantinedAs", failure.QuarantinedAs)
+
+	q.failures = append(q.failures, failure)
+	return failure.QuarantinedAs, nil
+}
+
+// err returns a PartialParseError after writing the failure report, or nil if nothing failed
+func (q *quarantine) err() error {
+	if q == nil {
+		return nil
+	}
+
+	q.mu.Lock()
+	failures := slices.Clone(q.failures)
+	q.mu.Unlock()
+	if len(failures) == 0 {
+		return nil
+	}
+	sort.Slice(failures, func(i, j int) bool {
+		return failures[i].File < failures[j].File
+	})
+
+	if err := os.MkdirAll(q.dir, 0755); err != nil {
+		return fmt.Errorf("failed to create quarantine directory: %w", err)
+	}
+	// Parses failing within the same second, as watch batches can, each keep their report
+	reportPath := freePath(q.dir, fmt.Sprintf("report-%s.json", time.Now().Format("20060102-150405")))
+	data, err := json.MarshalIndent(struct {
+		Failures []FileFailure `json:"failures"`
+	}{failures}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode failure report: %w", err)
+	}
+	if err := os.WriteFile(reportPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write failure report: %w", err)
+	}
+
+	return &PartialParseError{Failures: failures, ReportPath: reportPath}
+}
@@ -0,0 +1,197 @@
+package pics
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// failingDateExtractor fails for files whose path contains failOn and uses ModTime otherwise
+type failingDateExtractor struct {
+	failOn string
+}
+
+func (e *failingDateExtractor) name() string {
+	return "Failing"
+}
+
+func (e *failingDateExtractor) getFileDate(filePath string) (time.Time, error) {
+	if strings.Contains(filePath, e.failOn) {
+		return time.Time{}, fmt.Errorf("simulated unreadable date for %s", filePath)
+	}
+	return newModTimeExtractor().getFileDate(filePath)
+}
+
+// newParserWithFailures creates a parser whose compressor fails on compressFailOn
+// and whose date extractor fails on dateFailOn
+func newParserWithFailures(compressFailOn, dateFailOn string) *mediaParser {
+	organiser := NewFileOrganiser().(*fileOrganiser)
+	organiser.dateExtractor = &AggregatedFileDateExtractor{
+		extractors: []fileDateExtractor{&failingDateExtractor{failOn: dateFailOn}},
+	}
+	return &mediaParser{
+		compressor: &failingCompressor{failOn: compressFailOn},
+		organiser:  organiser,
+		extensions: NewExtensions(),
+	}
+}
+
+func TestMediaParser_Parse_ContinueQuarantinesFailedFiles(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir, targetDir := createSourceAndTarget(t, tmpDir)
+	testDate := time.Date(2023, 6, 15, 10, 30, 0, 0, time.UTC)
+	createMediaFile(t, sourceDir, "good.jpg", testDate)
+	createMediaFile(t, sourceDir, "corrupt.jpg", testDate)
+	createMediaFile(t, sourceDir, "nodate.mov", testDate)
+
+	parser := newParserWithFailures("corrupt", "nodate")
+	opts := testParseOptions
+	opts.CompressJPEGs = true
+	opts.ErrorPolicy = ErrorPolicyContinue
+
+	_, err := parser.Parse(sourceDir, targetDir, opts)
+	var partialErr *PartialParseError
+	if !errors.As(err, &partialErr) {
+		t.Fatalf("Expected PartialParseError, got: %v", err)
+	}
+
+	// The good file is imported
+	dateDir := filepath.Join(targetDir, "2023 06 June 15")
+	assertMediaFileExists(t, filepath.Join(dateDir, "2023_06_June_15_00001.jpg"))
+	assertMediaFileNotExists(t, filepath.Join(dateDir, "2023_06_June_15_00002.jpg"))
+	assertMediaFileNotExists(t, filepath.Join(targetDir, journalDirName))
+
+	// The failed files are quarantined
+	quarantineDir := filepath.Join(targetDir, quarantineDirName)
+	assertMediaFileExists(t, filepath.Join(quarantineDir, "root-corrupt.jpg"))
+	assertMediaFileExists(t, filepath.Join(quarantineDir, "root-nodate.mov"))
+
+	expected := []FileFailure{
+		{File: filepath.Join(sourceDir, "corrupt.jpg"), Stage: "compressing", QuarantinedAs: filepath.Join(quarantineDir, "root-corrupt.jpg")},
+		{File: filepath.Join(sourceDir, "nodate.mov"), Stage: "organising", QuarantinedAs: filepath.Join(quarantineDir, "root-nodate.mov")},
+	}
+	if len(partialErr.Failures) != len(expected) {
+		t.Fatalf("Expected %d failures, got %d: %+v", len(expected), len(partialErr.Failures), partialErr.Failures)
+	}
+	for i, failure := range partialErr.Failures {
+		if failure.File != expected[i].File || failure.Stage != expected[i].Stage || failure.QuarantinedAs != expected[i].QuarantinedAs {
+			t.Errorf("Failure %d: expected %+v, got %+v", i, expected[i], failure)
+		}
+		if failure.Cause == "" {
+			t.Errorf("Failure %d has no cause", i)
+		}
+	}
+
+	// The report on disk matches the returned failures
+	data, err := os.ReadFile(partialErr.ReportPath)
+	if err != nil {
+		t.Fatalf("Failed to read report: %v", err)
+	}
+	var report struct {
+		Failures []FileFailure `json:"failures"`
+	}
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatalf("Failed to decode report: %v", err)
+	}
+	if len(report.Failures) != len(expected) {
+		t.Errorf("Expected %d failures in report, got %d", len(expected), len(report.Failures))
+	}
+}
+
+func TestQuarantine_AddKeepsEarlierFiles(t *testing.T) {
+	tmpDir := t.TempDir()
+	targetDir := createTestDir(t, tmpDir, "target")
+	q := newQuarantine(targetDir, osFileMover{})
+
+	var quarantined []string
+	for _, content := range []string{"first", "second"} {
+		staged := filepath.Join(tmpDir, "root-IMG_0001.jpg")
+		if err := os.WriteFile(staged, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write staged file: %v", err)
+		}
+		dest, err := q.add("IMG_0001.jpg", staged, "compressing", errors.New("simulated failure"))
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		quarantined = append(quarantined, dest)
+	}
+
+	expected := []string{
+		filepath.Join(targetDir, quarantineDirName, "root-IMG_0001.jpg"),
+		filepath.Join(targetDir, quarantineDirName, "root-IMG_0001-1.jpg"),
+	}
+	for i, content := range []string{"first", "second"} {
+		if quarantined[i] != expected[i] {
+			t.Errorf("Expected %s, got %s", expected[i], quarantined[i])
+		}
+		if data, _ := os.ReadFile(expected[i]); string(data) != content {
+			t.Errorf("Expected %s to hold %q, got %q", expected[i], content, data)
+		}
+	}
+}
+
+func TestQuarantine_ErrKeepsEarlierReports(t *testing.T) {
+	targetDir := createTestDir(t, t.TempDir(), "target")
+
+	// Two parses failing in a row, most likely within the same second
+	var reports []string
+	for range 2 {
+		q := newQuarantine(targetDir, osFileMover{})
+		if _, err := q.add("IMG_0001.jpg", "", "dating", errors.New("simulated failure")); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		var partialErr *PartialParseError
+		if !errors.As(q.err(), &partialErr) {
+			t.Fatal("Expected a PartialParseError")
+		}
+		reports = append(reports, partialErr.ReportPath)
+	}
+
+	if reports[0] == reports[1] {
+		t.Errorf("Expected a report of its own for every parse, both wrote %s", reports[0])
+	}
+	for _, report := range reports {
+		assertFileExists(t, report)
+	}
+}
+
+func TestMediaParser_Parse_ContinueWithoutFailures(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir, targetDir := createSourceAndTarget(t, tmpDir)
+	createMediaFile(t, sourceDir, "good.jpg", time.Date(2023, 6, 15, 10, 30, 0, 0, time.UTC))
+
+	opts := testParseOptions
+	opts.ErrorPolicy = ErrorPolicyContinue
+
+	if _, err := testParser.Parse(sourceDir, targetDir, opts); err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	assertMediaFileNotExists(t, filepath.Join(targetDir, quarantineDirName))
+}
+
+func TestMediaParser_Parse_FailFastOnUnreadableDate(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir, targetDir := createSourceAndTarget(t, tmpDir)
+	testDate := time.Date(2023, 6, 15, 10, 30, 0, 0, time.UTC)
+	createMediaFile(t, sourceDir, "good.jpg", testDate)
+	createMediaFile(t, sourceDir, "nodate.jpg", testDate)
+
+	parser := newParserWithFailures("nothing", "nodate")
+	opts := testParseOptions
+	opts.ErrorPolicy = ErrorPolicyFailFast
+
+	_, err := parser.Parse(sourceDir, targetDir, opts)
+	if err == nil {
+		t.Fatal("Expected parse to fail")
+	}
+	var partialErr *PartialParseError
+	if errors.As(err, &partialErr) {
+		t.Errorf("Expected a plain error in fail-fast mode, got: %v", err)
+	}
+	assertMediaFileNotExists(t, filepath.Join(targetDir, quarantineDirName))
+}
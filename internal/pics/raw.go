@@ -0,0 +1,77 @@
+package pics
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// rawFiles returns the RAW files directly inside dir along with their sidecars,
+// which all live in the raw subdirectory of a date directory
+func rawFiles(dir string, extensions Extensions) ([]string, error) {
+	names, err := readDirNames(dir)
+	if err != nil {
+		return nil, err
+	}
+	primaries := sidecarPrimaries(names, extensions)
+	var files []string
+	for _, name := range names {
+		if extensions.IsRaw(name) || extensions.IsRaw(primaries[name]) {
+			files = append(files, name)
+		}
+	}
+	return files, nil
+}
+
+// packRawFiles moves the RAW files of a date directory, already numbered, and their
+// sidecars into its raw subdirectory
+func packRawFiles(dir string, extensions Extensions, mover fileMover) error {
+	files, err := rawFiles(dir, extensions)
+	if err != nil {
+		return fmt.Errorf("failed to read directory: %w", err)
+	}
+	if len(files) == 0 {
+		return nil
+	}
+
+	rawDir := filepath.Join(dir, extensions.Subdir(ClassRaw))
+	if err := mover.mkdirAll(rawDir); err != nil {
+		return fmt.Errorf("failed to create raw directory: %w", err)
+	}
+	for _, name := range files {
+		newPath := filepath.Join(rawDir, name)
+		if _, err := os.Stat(newPath); err == nil {
+			return fmt.Errorf("failed to move %s: %s already exists", name, newPath)
+		}
+		if err := mover.rename(filepath.Join(dir, name), newPath); err != nil {
+			return fmt.Errorf("failed to move %s to %s: %w", name, newPath, err)
+		}
+	}
+	return nil
+}
+
+// unpackRawFiles moves the RAW files of a date directory and their sidecars out of its
+// raw subdirectory, so they can be renumbered along with the images they were shot with
+func unpackRawFiles(dir string, extensions Extensions, mover fileMover) error {
+	rawDir := filepath.Join(dir, extensions.Subdir(ClassRaw))
+	files, err := rawFiles(rawDir, extensions)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read directory: %w", err)
+	}
+
+	// Check every file first so nothing is moved when one of them cannot be
+	for _, name := range files {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			return fmt.Errorf("failed to move %s: %s already exists in %s", name, name, dir)
+		}
+	}
+	for _, name := range files {
+		if err := mover.rename(filepath.Join(rawDir, name), filepath.Join(dir, name)); err != nil {
+			return fmt.Errorf("failed to move %s to %s: %w", name, dir, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package pics
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMediaParser_Parse_RawFiles(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir, targetDir := createSourceAndTarget(t, tmpDir)
+	testDate := time.Date(2023, 6, 15, 10, 30, 0, 0, time.UTC)
+
+	createMediaFile(t, sourceDir, "IMG_0001.JPG", testDate)
+	// The RAW file follows its JPEG even when dated differently
+	createMediaFile(t, sourceDir, "IMG_0001.CR2", testDate.AddDate(0, 0, 1))
+	createMediaFile(t, sourceDir, "IMG_0001.CR2.xmp", testDate)
+	createMediaFile(t, sourceDir, "IMG_0002.JPG", testDate)
+	createMediaFile(t, sourceDir, "IMG_0003.NEF", testDate)
+
+	plan, err := testParser.Plan(sourceDir, targetDir, testParseOptions)
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+	if _, err := testParser.Parse(sourceDir, targetDir, testParseOptions); err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	dateDir := "2023 06 June 15"
+	expected := map[string]string{
+		"IMG_0001.JPG":     filepath.Join(dateDir, "2023_06_June_15_00001.jpg"),
+		"IMG_0001.CR2":     filepath.Join(dateDir, "raw", "2023_06_June_15_00001.cr2"),
+		"IMG_0001.CR2.xmp": filepath.Join(dateDir, "raw", "2023_06_June_15_00001.cr2.xmp"),
+		"IMG_0002.JPG":     filepath.Join(dateDir, "2023_06_June_15_00002.jpg"),
+		"IMG_0003.NEF":     filepath.Join(dateDir, "raw", "2023_06_June_15_00003.nef"),
+	}
+	for _, file := range plan.Files {
+		name := filepath.Base(file.Source)
+		if file.FinalPath != expected[name] {
+			t.Errorf("Expected %s planned at %s, got %s", name, expected[name], file.FinalPath)
+		}
+	}
+	for _, finalPath := range expected {
+		assertMediaFileExists(t, filepath.Join(targetDir, finalPath))
+	}
+	assertMediaFileNotExists(t, filepath.Join(targetDir, "2023 06 June 16"))
+
+	// New images are numbered after the RAW files already in the library
+	newSourceDir := createSubdir(t, tmpDir, "more")
+	createMediaFile(t, newSourceDir, "IMG_0004.JPG", testDate)
+	if _, err := testParser.Parse(newSourceDir, targetDir, testParseOptions); err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	assertMediaFileExists(t, filepath.Join(targetDir, dateDir, "2023_06_June_15_00004.jpg"))
+}
@@ -5,6 +5,7 @@ import (
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/acm19/pics/internal/logger"
@@ -52,14 +53,70 @@ type FileRenamer interface {
 	//   - int: The number of files that were moved and renamed
 	//   - error: An error if directories cannot be accessed or files cannot be moved
 	MoveAndRenameFilesWithPattern(sourceDir, targetDir, baseName string, filter fileFilter, progressChan chan<- ProgressEvent) (int, error)
+
+	// AppendFilesWithPattern moves new files into a target directory, numbering them after the files already there.
+	//
+	// Files in targetDir that already follow the {baseName}_NNNNN.ext pattern are left untouched and their highest
+	// sequence number is used as the starting point. Files in sourceDir matching the filter that do not already
+	// follow the pattern (or that live in a different directory) are sorted alphabetically, moved to targetDir and
+	// numbered from that point onwards. File extensions are normalised to lowercase.
+	//
+	// Parameters:
+	//   - sourceDir: The directory containing new files (may be the same as targetDir)
+	//   - targetDir: The directory where files will be moved (created if needed and files exist)
+	//   - baseName: The base name to use for renamed files
+	//   - filter: A function that determines which files should be moved and renamed
+	//   - progressChan: Optional channel for progress events
+	//
+	// Returns:
+	//   - int: The number of files that were moved and renamed
+	//   - error: An error if directories cannot be accessed, files cannot be moved or a target name is taken
+	AppendFilesWithPattern(sourceDir, targetDir, baseName string, filter fileFilter, progressChan chan<- ProgressEvent) (int, error)
+
+	// withMover returns a copy of the renamer that applies its changes through the given mover
+	withMover(mover fileMover) FileRenamer
+	// withCompanions returns a copy of the renamer that moves the companions of each renamed
+	// file (keyed by the file's path) along with it, naming them after the file as
+	// companionNames does
+	withCompanions(companions map[string][]string) FileRenamer
+	// withSequenceFrom returns a copy of the renamer whose AppendFilesWithPattern also numbers new
+	// files after the numbered files in the given directories, which share the sequence of targetDir
+	withSequenceFrom(dirs []string) FileRenamer
 }
 
 // fileRenamer implements the FileRenamer interface
-type fileRenamer struct{}
+type fileRenamer struct {
+	mover        fileMover
+	companions   map[string][]string
+	sequenceDirs []string
+}
 
 // NewFileRenamer creates a new FileRenamer instance
 func NewFileRenamer() FileRenamer {
-	return &fileRenamer{}
+	return &fileRenamer{
+		mover: osFileMover{},
+	}
+}
+
+// withMover returns a copy of the renamer that applies its changes through the given mover
+func (r *fileRenamer) withMover(mover fileMover) FileRenamer {
+	renamer := *r
+	renamer.mover = mover
+	return &renamer
+}
+
+// withCompanions returns a copy of the renamer that moves the companions of each renamed file along with it
+func (r *fileRenamer) withCompanions(companions map[string][]string) FileRenamer {
+	renamer := *r
+	renamer.companions = companions
+	return &renamer
+}
+
+// withSequenceFrom returns a copy of the renamer that numbers new files after the ones in dirs too
+func (r *fileRenamer) withSequenceFrom(dirs []string) FileRenamer {
+	renamer := *r
+	renamer.sequenceDirs = dirs
+	return &renamer
 }
 
 // RenameFilesWithPattern renames files in a directory based on a filter and naming pattern
@@ -72,25 +129,64 @@ func (r *fileRenamer) MoveAndRenameFilesWithPattern(sourceDir, targetDir, baseNa
 	return r.renameFilesWithPatternInDir(sourceDir, targetDir, baseName, filter, progressChan)
 }
 
+// AppendFilesWithPattern moves new files into a target directory, numbering them after the existing ones
+func (r *fileRenamer) AppendFilesWithPattern(sourceDir, targetDir, baseName string, filter fileFilter, progressChan chan<- ProgressEvent) (int, error) {
+	lastSeq := 0
+	for _, dir := range append([]string{targetDir}, r.sequenceDirs...) {
+		seq, err := lastSequenceNumber(dir, baseName, filter)
+		if err != nil {
+			return 0, fmt.Errorf("failed to read directory: %w", err)
+		}
+		lastSeq = max(lastSeq, seq)
+	}
+
+	isNew := filter
+	if sourceDir == targetDir {
+		isNew = func(filePath string) bool {
+			_, numbered := sequenceNumber(baseName, filepath.Base(filePath))
+			return filter(filePath) && !numbered
+		}
+	}
+
+	filesToRename, err := collectFiles(sourceDir, isNew)
+	if err != nil {
+		return 0, err
+	}
+	return r.renameFiles(filesToRename, sourceDir, targetDir, baseName, lastSeq, true, progressChan)
+}
+
 // renameFilesWithPatternInDir is the internal implementation
 func (r *fileRenamer) renameFilesWithPatternInDir(sourceDir, targetDir, baseName string, filter fileFilter, progressChan chan<- ProgressEvent) (int, error) {
-	entries, err := os.ReadDir(sourceDir)
+	filesToRename, err := collectFiles(sourceDir, filter)
+	if err != nil {
+		return 0, err
+	}
+	return r.renameFiles(filesToRename, sourceDir, targetDir, baseName, 0, false, progressChan)
+}
+
+// collectFiles returns the files directly inside dir that match the filter
+func collectFiles(dir string, filter fileFilter) ([]string, error) {
+	entries, err := os.ReadDir(dir)
 	if err != nil {
-		return 0, fmt.Errorf("failed to read directory: %w", err)
+		return nil, fmt.Errorf("failed to read directory: %w", err)
 	}
 
-	// Collect files matching the filter
-	var filesToRename []string
+	var files []string
 	for _, entry := range entries {
 		if entry.IsDir() {
 			continue
 		}
-		filePath := filepath.Join(sourceDir, entry.Name())
+		filePath := filepath.Join(dir, entry.Name())
 		if filter(filePath) {
-			filesToRename = append(filesToRename, filePath)
+			files = append(files, filePath)
 		}
 	}
+	return files, nil
+}
 
+// renameFiles moves the given files to targetDir with sequential numbering starting after lastSeq.
+// When noClobber is set, it fails instead of overwriting an existing file.
+func (r *fileRenamer) renameFiles(filesToRename []string, sourceDir, targetDir, baseName string, lastSeq int, noClobber bool, progressChan chan<- ProgressEvent) (int, error) {
 	// Nothing to rename
 	if len(filesToRename) == 0 {
 		return 0, nil
@@ -98,7 +194,7 @@ func (r *fileRenamer) renameFilesWithPatternInDir(sourceDir, targetDir, baseName
 
 	// Create target directory only if there are files to move
 	if sourceDir != targetDir {
-		if err := os.MkdirAll(targetDir, 0755); err != nil {
+		if err := r.mover.mkdirAll(targetDir); err != nil {
 			return 0, fmt.Errorf("failed to create target directory: %w", err)
 		}
 	}
@@ -124,14 +220,88 @@ func (r *fileRenamer) renameFilesWithPatternInDir(sourceDir, targetDir, baseName
 			}
 		}
 
-		ext := strings.ToLower(filepath.Ext(file))
-		newFileName := fmt.Sprintf("%s_%05d%s", baseName, i+1, ext)
-		newFilePath := filepath.Join(targetDir, newFileName)
+		newFilePath := filepath.Join(targetDir, sequentialName(baseName, lastSeq+i+1, file))
 
-		if err := os.Rename(file, newFilePath); err != nil {
+		if noClobber {
+			if _, err := os.Stat(newFilePath); err == nil {
+				return 0, fmt.Errorf("failed to rename %s: %s already exists", file, newFilePath)
+			}
+		}
+
+		if err := r.mover.rename(file, newFilePath); err != nil {
 			return 0, fmt.Errorf("failed to rename %s to %s: %w", file, newFilePath, err)
 		}
+		if err := r.renameCompanions(file, newFilePath, noClobber); err != nil {
+			return 0, err
+		}
 	}
 
 	return len(filesToRename), nil
 }
+
+// renameCompanions moves the companions of file next to its new path, named after it
+func (r *fileRenamer) renameCompanions(file, newFilePath string, noClobber bool) error {
+	companions := r.companions[file]
+	if len(companions) == 0 {
+		return nil
+	}
+	names := make([]string, len(companions))
+	for i, companion := range companions {
+		names[i] = filepath.Base(companion)
+	}
+	newNames := companionNames(filepath.Base(file), filepath.Base(newFilePath), names)
+
+	for i, companion := range companions {
+		newCompanionPath := filepath.Join(filepath.Dir(newFilePath), newNames[names[i]])
+		if noClobber {
+			if _, err := os.Stat(newCompanionPath); err == nil {
+				return fmt.Errorf("failed to rename %s: %s already exists", companion, newCompanionPath)
+			}
+		}
+		if err := r.mover.rename(companion, newCompanionPath); err != nil {
+			return fmt.Errorf("failed to rename %s to %s: %w", companion, newCompanionPath, err)
+		}
+	}
+	return nil
+}
+
+// sequentialName builds the name a file gets when renamed with a pattern,
+// e.g. {baseName}_00001.jpg. The extension is normalised to lowercase.
+func sequentialName(baseName string, seq int, file string) string {
+	ext := strings.ToLower(filepath.Ext(file))
+	return fmt.Sprintf("%s_%05d%s", baseName, seq, ext)
+}
+
+// sequenceNumber returns the sequence number of a file named {baseName}_NNNNN.ext
+// and whether the file follows that pattern
+func sequenceNumber(baseName, fileName string) (int, bool) {
+	stem := strings.TrimSuffix(fileName, filepath.Ext(fileName))
+	digits, found := strings.CutPrefix(stem, baseName+"_")
+	if !found || len(digits) < 5 {
+		return 0, false
+	}
+	seq, err := strconv.Atoi(digits)
+	if err != nil || seq < 1 || strings.ContainsAny(digits, "+-") {
+		return 0, false
+	}
+	return seq, true
+}
+
+// lastSequenceNumber returns the highest sequence number among the files in dir
+// that match the filter and follow the {baseName}_NNNNN.ext pattern
+func lastSequenceNumber(dir, baseName string, filter fileFilter) (int, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+	lastSeq := 0
+	for _, entry := range entries {
+		if entry.IsDir() || !filter(filepath.Join(dir, entry.Name())) {
+			continue
+		}
+		if seq, ok := sequenceNumber(baseName, entry.Name()); ok && seq > lastSeq {
+			lastSeq = seq
+		}
+	}
+	return lastSeq, nil
+}
@@ -342,3 +342,116 @@ func TestFileRenamer_MoveAndRenameFilesWithPattern_CreatesTargetOnlyWhenNeeded(t
 	// Non-matching file should remain in source
 	assertFileExists(t, filepath.Join(sourceDir, "document.txt"))
 }
+
+func TestFileRenamer_AppendFilesWithPattern(t *testing.T) {
+	tmpDir := t.TempDir()
+	testDir := filepath.Join(tmpDir, "test")
+	if err := os.MkdirAll(testDir, 0755); err != nil {
+		t.Fatalf("Failed to create test directory: %v", err)
+	}
+
+	createFile(t, testDir, "test_prefix_00001.jpg")
+	createFile(t, testDir, "test_prefix_00002.jpg")
+	createFile(t, testDir, "new_b.JPG")
+	createFile(t, testDir, "new_a.heic")
+
+	renamer := NewFileRenamer()
+	ext := NewExtensions()
+	count, err := renamer.AppendFilesWithPattern(testDir, testDir, "test_prefix", ext.IsImage, nil)
+
+	if err != nil {
+		t.Errorf("Expected no error, got: %v", err)
+	}
+
+	if count != 2 {
+		t.Errorf("Expected 2 files renamed, got: %d", count)
+	}
+
+	assertFileExists(t, filepath.Join(testDir, "test_prefix_00001.jpg"))
+	assertFileExists(t, filepath.Join(testDir, "test_prefix_00002.jpg"))
+	assertFileExists(t, filepath.Join(testDir, "test_prefix_00003.heic"))
+	assertFileExists(t, filepath.Join(testDir, "test_prefix_00004.jpg"))
+}
+
+func TestFileRenamer_AppendFilesWithPattern_MovesToTarget(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir := filepath.Join(tmpDir, "source")
+	targetDir := filepath.Join(tmpDir, "source", "videos")
+	if err := os.MkdirAll(targetDir, 0755); err != nil {
+		t.Fatalf("Failed to create directories: %v", err)
+	}
+
+	createFile(t, targetDir, "test_prefix_00007.mov")
+	createFile(t, sourceDir, "clip.MOV")
+
+	renamer := NewFileRenamer()
+	ext := NewExtensions()
+	count, err := renamer.AppendFilesWithPattern(sourceDir, targetDir, "test_prefix", ext.IsVideo, nil)
+
+	if err != nil {
+		t.Errorf("Expected no error, got: %v", err)
+	}
+
+	if count != 1 {
+		t.Errorf("Expected 1 file moved, got: %d", count)
+	}
+
+	assertFileExists(t, filepath.Join(targetDir, "test_prefix_00007.mov"))
+	assertFileExists(t, filepath.Join(targetDir, "test_prefix_00008.mov"))
+	assertFileNotExists(t, filepath.Join(sourceDir, "clip.MOV"))
+}
+
+func TestSequenceNumber(t *testing.T) {
+	tests := []struct {
+		fileName    string
+		expectedSeq int
+		expectedOK  bool
+	}{
+		{"base_00001.jpg", 1, true},
+		{"base_00042.HEIC", 42, true},
+		{"base_123456.jpg", 123456, true},
+		{"base_0001.jpg", 0, false},
+		{"base_00000.jpg", 0, false},
+		{"other_00001.jpg", 0, false},
+		{"base_abcde.jpg", 0, false},
+		{"base_00001_copy.jpg", 0, false},
+		{"root-IMG_0001.jpg", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fileName, func(t *testing.T) {
+			seq, ok := sequenceNumber("base", tt.fileName)
+			if seq != tt.expectedSeq || ok != tt.expectedOK {
+				t.Errorf("Expected (%d, %v), got (%d, %v)", tt.expectedSeq, tt.expectedOK, seq, ok)
+			}
+		})
+	}
+}
+
+func TestFileRenamer_AppendFilesWithPattern_Companions(t *testing.T) {
+	tmpDir := t.TempDir()
+	testDir := filepath.Join(tmpDir, "test")
+	if err := os.MkdirAll(testDir, 0755); err != nil {
+		t.Fatalf("Failed to create test directory: %v", err)
+	}
+
+	createFile(t, testDir, "test_prefix_00001.jpg")
+	still := createFile(t, testDir, "IMG_0001.HEIC")
+	clip := createFile(t, testDir, "IMG_0001.MOV")
+
+	renamer := NewFileRenamer().withCompanions(map[string][]string{still: {clip}})
+	ext := NewExtensions()
+	count, err := renamer.AppendFilesWithPattern(testDir, testDir, "test_prefix", ext.IsImage, nil)
+
+	if err != nil {
+		t.Errorf("Expected no error, got: %v", err)
+	}
+
+	if count != 1 {
+		t.Errorf("Expected 1 file renamed, got: %d", count)
+	}
+
+	assertFileExists(t, filepath.Join(testDir, "test_prefix_00002.heic"))
+	assertFileExists(t, filepath.Join(testDir, "test_prefix_00002.mov"))
+	assertFileNotExists(t, clip)
+}
@@ -0,0 +1,97 @@
+package pics
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/acm19/pics/internal/logger"
+)
+
+// sidecarDateExtractor extracts the capture date an export recorded for a file in its sidecars.
+// Exported files often have their EXIF stripped and their modification time set to the
+// day of the export, so the sidecar date is preferred over anything read from the file.
+type sidecarDateExtractor struct {
+	dates map[string]time.Time
+}
+
+func newSidecarDateExtractor(dates map[string]time.Time) *sidecarDateExtractor {
+	return &sidecarDateExtractor{dates: dates}
+}
+
+func (e *sidecarDateExtractor) name() string {
+	return "Sidecar"
+}
+
+func (e *sidecarDateExtractor) getFileDate(filePath string) (time.Time, error) {
+	date, found := e.dates[filePath]
+	if !found {
+		return time.Time{}, fmt.Errorf("no sidecar date")
+	}
+	logger.Debug("Using sidecar date", "file", filepath.Base(filePath), "date", date)
+	return date, nil
+}
+
+// prefetch returns the paths with no sidecar date, as the dates are all known already
+func (e *sidecarDateExtractor) prefetch(paths []string) []string {
+	var undated []string
+	for _, path := range paths {
+		if _, found := e.dates[path]; !found {
+			undated = append(undated, path)
+		}
+	}
+	return undated
+}
+
+// loadSidecarDates reads the sidecars of the export in dir and returns the
+// capture date of every media file they describe, keyed by its path
+func loadSidecarDates(mode SourceMode, dir string, extensions Extensions) (map[string]time.Time, error) {
+	switch mode {
+	case SourceModeTakeout:
+		return loadTakeoutDates(dir, extensions)
+	case SourceModeICloud:
+		return loadICloudDates(dir)
+	default:
+		return map[string]time.Time{}, nil
+	}
+}
+
+// walkExportDirs calls fn with the sorted names of the files in root and in every
+// directory below it, skipping dot files and dot directories inside root
+func walkExportDirs(root string, fn func(dir string, names []string) error) error {
+	names := make(map[string][]string)
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(entry.Name(), ".") && path != root {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !entry.IsDir() {
+			dir := filepath.Dir(path)
+			names[dir] = append(names[dir], entry.Name())
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	dirs := make([]string, 0, len(names))
+	for dir := range names {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	for _, dir := range dirs {
+		if err := fn(dir, names[dir]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package pics
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSidecarDateExtractor(t *testing.T) {
+	extractor := newSidecarDateExtractor(map[string]time.Time{"/export/IMG_0001.JPG": takeoutTakenAt})
+
+	if extractor.name() != "Sidecar" {
+		t.Errorf("Expected name 'Sidecar', got '%s'", extractor.name())
+	}
+
+	date, err := extractor.getFileDate("/export/IMG_0001.JPG")
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	assertTimeEqual(t, takeoutTakenAt, date)
+
+	if _, err := extractor.getFileDate("/export/IMG_0002.JPG"); err == nil {
+		t.Error("Expected error for file without sidecar date")
+	}
+}
+
+func TestAggregatedFileDateExtractor_SidecarFirst(t *testing.T) {
+	tmpDir := t.TempDir()
+	exportDay := time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC)
+	withSidecar := createTestFileWithTime(t, tmpDir, "with.jpg", exportDay)
+	withoutSidecar := createTestFileWithTime(t, tmpDir, "without.jpg", exportDay)
+
+	extractor := NewFileDateExtractor().withFirst(newSidecarDateExtractor(map[string]time.Time{withSidecar: takeoutTakenAt}))
+
+	date, source, err := extractor.GetFileDateWithSource(withSidecar)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	assertTimeEqual(t, takeoutTakenAt, date)
+	if source != "Sidecar" {
+		t.Errorf("Expected source 'Sidecar', got '%s'", source)
+	}
+
+	date, _, err = extractor.GetFileDateWithSource(withoutSidecar)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	assertTimeEqual(t, exportDay, date)
+}
+
+func TestMediaParser_Parse_TakeoutArchives(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir, targetDir := createSourceAndTarget(t, tmpDir)
+
+	createZip(t, filepath.Join(sourceDir, "takeout-001.zip"), map[string]string{
+		"Takeout/Google Photos/Trip/IMG_0001.JPG": "photo",
+	})
+	createZip(t, filepath.Join(sourceDir, "takeout-002.zip"), map[string]string{
+		"Takeout/Google Photos/Trip/IMG_0001.JPG.json": takeoutSidecarJSON("IMG_0001.JPG", takeoutTakenAt),
+	})
+
+	opts := testParseOptions
+	opts.SourceMode = SourceModeTakeout
+
+	plan, err := testParser.Plan(sourceDir, targetDir, opts)
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+	// The sidecar follows its photo
+	if len(plan.Files) != 2 || plan.Files[0].Extractor != "Sidecar" {
+		t.Fatalf("Expected a file dated by its sidecar and the sidecar, got %+v", plan.Files)
+	}
+
+	result, err := testParser.Parse(sourceDir, targetDir, opts)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	finalPath := filepath.Join("2019 06 June 15", "2019_06_June_15_00001.jpg")
+	assertMediaFileExists(t, filepath.Join(targetDir, finalPath))
+	assertMediaFileExists(t, filepath.Join(targetDir, finalPath+".json"))
+	assertMediaFileNotExists(t, filepath.Join(targetDir, journalDirName))
+	if plan.Files[0].FinalPath != finalPath || plan.Files[1].FinalPath != finalPath+".json" {
+		t.Errorf("Expected planned paths %s and %s.json, got %+v", finalPath, finalPath, plan.Files)
+	}
+
+	expected := []ImportedFile{
+		{
+			Source:    filepath.Join(sourceDir, "takeout-001.zip", "Takeout", "Google Photos", "Trip", "IMG_0001.JPG"),
+			FinalPath: finalPath,
+			Class:     ClassImage,
+			Extractor: "Sidecar",
+		},
+		{
+			Source:    filepath.Join(sourceDir, "takeout-002.zip", "Takeout", "Google Photos", "Trip", "IMG_0001.JPG.json"),
+			FinalPath: finalPath + ".json",
+			Class:     ClassSidecar,
+			Extractor: "Sidecar",
+		},
+	}
+	for i := range result.Files {
+		result.Files[i].BytesBefore, result.Files[i].BytesAfter = 0, 0
+	}
+	if !reflect.DeepEqual(result.Files, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, result.Files)
+	}
+}
+
+func TestMediaParser_Parse_ICloudExport(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir, targetDir := createSourceAndTarget(t, tmpDir)
+	photosDir := createSubdir(t, sourceDir, "Photos")
+
+	exportDay := time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC)
+	createMediaFile(t, photosDir, "IMG_0001.JPG", exportDay)
+	createMediaFile(t, photosDir, "IMG_0002.MOV", exportDay)
+	createICloudDetails(t, photosDir, "Photo Details.csv",
+		"imgName,originalCreationDate\n"+
+			"IMG_0001.JPG,\"Saturday June 15,2019 12:05 PM GMT\"\n"+
+			"IMG_0002.MOV,\"Saturday June 15,2019 12:30 PM GMT\"\n")
+
+	opts := testParseOptions
+	opts.SourceMode = SourceModeICloud
+
+	if _, err := testParser.Parse(sourceDir, targetDir, opts); err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	dateDir := filepath.Join(targetDir, "2019 06 June 15")
+	assertMediaFileExists(t, filepath.Join(dateDir, "2019_06_June_15_00001.jpg"))
+	assertMediaFileExists(t, filepath.Join(dateDir, "videos", "2019_06_June_15_00001.mov"))
+	assertMediaFileNotExists(t, filepath.Join(targetDir, "2024 01 January 02"))
+}
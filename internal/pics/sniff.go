@@ -0,0 +1,99 @@
+package pics
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// sniffLength is how many bytes are read from the start of a file to recognise its format
+const sniffLength = 32
+
+// mediaFormat is a file format recognised from the first bytes of a file
+type mediaFormat struct {
+	name string
+	// exts are the extensions files in the format are named with, the canonical one
+	// first. TIFF-based RAW formats share their header, so they have none.
+	exts []string
+}
+
+var (
+	formatJPEG    = mediaFormat{name: "JPEG", exts: []string{".jpg", ".jpeg"}}
+	formatPNG     = mediaFormat{name: "PNG", exts: []string{".png"}}
+	formatHEIC    = mediaFormat{name: "HEIC", exts: []string{".heic", ".heif"}}
+	formatMOV     = mediaFormat{name: "QuickTime", exts: []string{".mov", ".qt"}}
+	formatMP4     = mediaFormat{name: "MP4", exts: []string{".mp4", ".m4v", ".3gp", ".3g2"}}
+	formatCR2     = mediaFormat{name: "CR2", exts: []string{".cr2"}}
+	formatCR3     = mediaFormat{name: "CR3", exts: []string{".cr3"}}
+	formatTIFFRaw = mediaFormat{name: "TIFF-based RAW"}
+)
+
+// sniffFormat recognises the format of a file from its first bytes. It returns
+// false when the file cannot be read or its format is not recognised.
+func sniffFormat(path string) (mediaFormat, bool) {
+	file, err := os.Open(path)
+	if err != nil {
+		return mediaFormat{}, false
+	}
+	defer file.Close()
+
+	header := make([]byte, sniffLength)
+	n, _ := io.ReadFull(file, header)
+	return detectFormat(header[:n])
+}
+
+// detectFormat recognises a format from the first bytes of a file: the JPEG SOI
+// marker, the PNG signature, the ftyp brand of ISO-BMFF files (HEIC, MOV, MP4, CR3)
+// and the TIFF header of most RAW formats
+func detectFormat(header []byte) (mediaFormat, bool) {
+	switch {
+	case bytes.HasPrefix(header, []byte{0xFF, 0xD8, 0xFF}):
+		return formatJPEG, true
+	case bytes.HasPrefix(header, []byte("\x89PNG\r\n\x1a\n")):
+		return formatPNG, true
+	case len(header) >= 12 && string(header[4:8]) == "ftyp":
+		return ftypFormat(string(header[8:12]))
+	case bytes.HasPrefix(header, []byte("II*\x00")), bytes.HasPrefix(header, []byte("MM\x00*")):
+		// Canon CR2 files mark their TIFF header
+		if len(header) >= 10 && string(header[8:10]) == "CR" {
+			return formatCR2, true
+		}
+		return formatTIFFRaw, true
+	}
+	return mediaFormat{}, false
+}
+
+// ftypFormat returns the format of an ISO-BMFF file from its major brand
+func ftypFormat(brand string) (mediaFormat, bool) {
+	switch brand {
+	case "heic", "heix", "heim", "heis", "hevc", "hevx", "mif1", "msf1":
+		return formatHEIC, true
+	case "qt  ":
+		return formatMOV, true
+	case "crx ":
+		return formatCR3, true
+	case "isom", "iso2", "iso4", "iso5", "iso6", "mp41", "mp42", "avc1", "M4V ", "M4VP", "3gp4", "3gp5", "3gp6", "3g2a", "dash", "MSNV":
+		return formatMP4, true
+	}
+	return mediaFormat{}, false
+}
+
+// fits reports whether a file named filePath can hold the format
+func (f mediaFormat) fits(filePath string, extensions Extensions) bool {
+	if len(f.exts) == 0 {
+		return extensions.IsRaw(filePath)
+	}
+	return slices.Contains(f.exts, strings.ToLower(filepath.Ext(filePath)))
+}
+
+// rename returns name with the canonical extension of the format, or name
+// unchanged when the format has none
+func (f mediaFormat) rename(name string) string {
+	if len(f.exts) == 0 {
+		return name
+	}
+	return strings.TrimSuffix(name, filepath.Ext(name)) + f.exts[0]
+}
@@ -0,0 +1,132 @@
+package pics
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var (
+	jpegHeader = []byte{0xFF, 0xD8, 0xFF, 0xE1, 0x00, 0x10, 'E', 'x', 'i', 'f'}
+	heicHeader = []byte("\x00\x00\x00\x18ftypheic\x00\x00\x00\x00mif1heic")
+)
+
+func createFileWithContent(t *testing.T, dir, filename string, content []byte, modTime time.Time) string {
+	t.Helper()
+	filePath := filepath.Join(dir, filename)
+	if err := os.WriteFile(filePath, content, 0644); err != nil {
+		t.Fatalf("Failed to create file %s: %v", filename, err)
+	}
+	if err := os.Chtimes(filePath, modTime, modTime); err != nil {
+		t.Fatalf("Failed to set file times: %v", err)
+	}
+	return filePath
+}
+
+func TestDetectFormat(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   []byte
+		expected string
+	}{
+		{"JPEG", jpegHeader, "JPEG"},
+		{"PNG", []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"), "PNG"},
+		{"HEIC", heicHeader, "HEIC"},
+		{"HEIF", []byte("\x00\x00\x00\x18ftypmif1\x00\x00\x00\x00mif1heic"), "HEIC"},
+		{"QuickTime", []byte("\x00\x00\x00\x14ftypqt  \x00\x00\x02\x00qt  "), "QuickTime"},
+		{"MP4", []byte("\x00\x00\x00\x20ftypisom\x00\x00\x02\x00isomiso2avc1mp41"), "MP4"},
+		{"CR3", []byte("\x00\x00\x00\x18ftypcrx \x00\x00\x00\x01crx isom"), "CR3"},
+		{"CR2", []byte("II*\x00\x10\x00\x00\x00CR\x02\x00"), "CR2"},
+		{"DNG", []byte("II*\x00\x08\x00\x00\x00\x1c\x00"), "TIFF-based RAW"},
+		{"NEF big endian", []byte("MM\x00*\x00\x00\x00\x08\x00\x1c"), "TIFF-based RAW"},
+		{"unknown brand", []byte("\x00\x00\x00\x18ftypabcd\x00\x00\x00\x00"), ""},
+		{"text", []byte("test media content"), ""},
+		{"empty", nil, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			format, known := detectFormat(tt.header)
+			if known != (tt.expected != "") || format.name != tt.expected {
+				t.Errorf("detectFormat() = %q, %v, expected %q", format.name, known, tt.expected)
+			}
+		})
+	}
+}
+
+func TestMediaFormat_Fits(t *testing.T) {
+	ext := NewExtensions()
+
+	tests := []struct {
+		format   mediaFormat
+		filePath string
+		expected bool
+	}{
+		{formatJPEG, "photo.JPG", true},
+		{formatJPEG, "photo.jpeg", true},
+		{formatHEIC, "photo.jpg", false},
+		{formatMP4, "clip.mov", false},
+		{formatMP4, "clip.m4v", true},
+		{formatTIFFRaw, "photo.NEF", true},
+		{formatTIFFRaw, "photo.jpg", false},
+	}
+
+	for _, tt := range tests {
+		if result := tt.format.fits(tt.filePath, ext); result != tt.expected {
+			t.Errorf("%s.fits(%s) = %v, expected %v", tt.format.name, tt.filePath, result, tt.expected)
+		}
+	}
+}
+
+func TestMediaFormat_Rename(t *testing.T) {
+	if name := formatHEIC.rename("chat-IMG_0001.JPG"); name != "chat-IMG_0001.heic" {
+		t.Errorf("Expected chat-IMG_0001.heic, got %s", name)
+	}
+	if name := formatTIFFRaw.rename("IMG_0001.jpg"); name != "IMG_0001.jpg" {
+		t.Errorf("Expected the name unchanged, got %s", name)
+	}
+}
+
+func TestMediaParser_Parse_SniffsContent(t *testing.T) {
+	tests := []struct {
+		name     string
+		fix      bool
+		expected string
+	}{
+		{"keep extension", false, "2023_06_June_15_00001.jpg"},
+		{"fix extension", true, "2023_06_June_15_00001.heic"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			sourceDir, targetDir := createSourceAndTarget(t, tmpDir)
+			testDate := time.Date(2023, 6, 15, 10, 30, 0, 0, time.UTC)
+
+			// A HEIC file saved as .jpg by a messaging app must not be handed to jpegoptim
+			createFileWithContent(t, sourceDir, "IMG_0001.jpg", heicHeader, testDate)
+
+			opts := testParseOptions
+			opts.CompressJPEGs = true
+			opts.FixExtensions = tt.fix
+
+			plan, err := testParser.Plan(sourceDir, targetDir, opts)
+			if err != nil {
+				t.Fatalf("Plan failed: %v", err)
+			}
+			if len(plan.Files) != 1 || plan.Files[0].Compress {
+				t.Fatalf("Expected a single file planned without compression, got %+v", plan.Files)
+			}
+			if _, err := testParser.Parse(sourceDir, targetDir, opts); err != nil {
+				t.Fatalf("Parse failed: %v", err)
+			}
+
+			expected := filepath.Join("2023 06 June 15", tt.expected)
+			if plan.Files[0].FinalPath != expected {
+				t.Errorf("Expected the file planned at %s, got %s", expected, plan.Files[0].FinalPath)
+			}
+			assertMediaFileExists(t, filepath.Join(targetDir, expected))
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 )
 
@@ -13,14 +14,25 @@ type FileStats interface {
 	ValidateDirectories(sourceDir, targetDir string) error
 	// GetFileCount returns the number of files in a directory recursively
 	GetFileCount(dir string) (int, error)
+	// Snapshot lists the files in a directory recursively, excluding dot files
+	// and the quarantine directory
+	Snapshot(dir string) (*DirSnapshot, error)
+	// Reconcile tracks every file of the source snapshot through the parse result
+	// and checks it against the target, comparing it with the target snapshot
+	// taken before the parse. Both snapshots must be taken before the parse.
+	Reconcile(source, targetBefore *DirSnapshot, result *ParseResult) (*Reconciliation, error)
 }
 
 // fileStats implements the FileStats interface
-type fileStats struct{}
+type fileStats struct {
+	extensions Extensions
+}
 
 // NewFileStats creates a new FileStats instance
 func NewFileStats() FileStats {
-	return &fileStats{}
+	return &fileStats{
+		extensions: NewExtensions(),
+	}
 }
 
 // ValidateDirectories checks if source and target directories exist
@@ -57,3 +69,150 @@ func (f *fileStats) GetFileCount(dir string) (int, error) {
 	})
 	return count, err
 }
+
+// Snapshot lists the files in a directory recursively, excluding dot files and the quarantine directory
+func (f *fileStats) Snapshot(dir string) (*DirSnapshot, error) {
+	dir = strings.TrimSuffix(dir, "/")
+	files := []string{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// Skip dot files, dot directories and quarantined files
+		if path != dir && isInternalDir(info.Name()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !info.IsDir() {
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+			files = append(files, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	return &DirSnapshot{Dir: dir, Files: files}, nil
+}
+
+// Reconcile tracks every source file through the parse result and checks it against the target
+func (f *fileStats) Reconcile(source, targetBefore *DirSnapshot, result *ParseResult) (*Reconciliation, error) {
+	targetAfter, err := f.Snapshot(targetBefore.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list target files: %w", err)
+	}
+	before := toSet(targetBefore.Files)
+	after := toSet(targetAfter.Files)
+
+	imported := make(map[string][]string)
+	claims := make(map[string][]string)
+	for _, file := range result.Files {
+		rel, err := filepath.Rel(source.Dir, file.Source)
+		if err != nil {
+			return nil, err
+		}
+		imported[rel] = append(imported[rel], file.FinalPath)
+		claims[file.FinalPath] = append(claims[file.FinalPath], rel)
+		if file.Original != "" {
+			claims[file.Original] = append(claims[file.Original], rel)
+		}
+	}
+	failures := make(map[string]FileFailure)
+	for _, failure := range result.Failures {
+		if rel, err := filepath.Rel(source.Dir, failure.File); err == nil {
+			failures[rel] = failure
+		}
+	}
+
+	r := &Reconciliation{
+		SourceFiles: len(source.Files),
+		Missing:     []ReconciledFile{},
+		Duplicated:  []ReconciledFile{},
+		Extra:       []ReconciledFile{},
+		Skipped:     []ReconciledFile{},
+	}
+
+	// Every source file must be at its organised location exactly once
+	for _, rel := range source.Files {
+		finalPaths := imported[rel]
+		switch {
+		case len(finalPaths) > 0:
+		case f.extensions.IsSidecar(rel):
+			r.Skipped = append(r.Skipped, ReconciledFile{Source: rel, Reason: "sidecar without its media file"})
+			continue
+		case !f.extensions.IsSupported(rel):
+			r.Skipped = append(r.Skipped, ReconciledFile{Source: rel, Reason: "unsupported file type"})
+			continue
+		case failures[rel].Stage != "":
+			failure := failures[rel]
+			r.Missing = append(r.Missing, ReconciledFile{Source: rel, Target: quarantinedPath(targetBefore.Dir, failure), Reason: fmt.Sprintf("failed while %s: %s", failure.Stage, failure.Cause)})
+			continue
+		default:
+			r.Missing = append(r.Missing, ReconciledFile{Source: rel, Reason: "not imported"})
+			continue
+		}
+
+		for i, finalPath := range finalPaths {
+			switch {
+			case !after[finalPath]:
+				r.Missing = append(r.Missing, ReconciledFile{Source: rel, Target: finalPath, Reason: "organised file not found"})
+			case i > 0:
+				r.Duplicated = append(r.Duplicated, ReconciledFile{Source: rel, Target: finalPath, Reason: "source imported more than once"})
+			case len(claims[finalPath]) > 1 && claims[finalPath][0] != rel:
+				r.Duplicated = append(r.Duplicated, ReconciledFile{Source: rel, Target: finalPath, Reason: "organised file shared with " + claims[finalPath][0]})
+			case before[finalPath]:
+				r.Duplicated = append(r.Duplicated, ReconciledFile{Source: rel, Target: finalPath, Reason: "organised file was already in the target"})
+			default:
+				r.ImportedFiles++
+			}
+		}
+	}
+
+	// Files already in the target may only have been renamed by the parse
+	renamed := make(map[string]bool)
+	for _, file := range result.Renamed {
+		renamed[file.From] = true
+		claims[file.To] = append(claims[file.To], "")
+	}
+	for _, rel := range targetBefore.Files {
+		if !after[rel] && !renamed[rel] {
+			r.Missing = append(r.Missing, ReconciledFile{Target: rel, Reason: "file already in the target disappeared"})
+		}
+	}
+	for _, rel := range targetAfter.Files {
+		if !before[rel] && len(claims[rel]) == 0 {
+			r.Extra = append(r.Extra, ReconciledFile{Target: rel, Reason: "not produced by any source file"})
+		}
+	}
+
+	return r, nil
+}
+
+// quarantinedPath returns where a failed file was quarantined, relative to targetDir
+func quarantinedPath(targetDir string, failure FileFailure) string {
+	if failure.QuarantinedAs == "" {
+		return ""
+	}
+	rel, err := filepath.Rel(targetDir, failure.QuarantinedAs)
+	if err != nil {
+		return failure.QuarantinedAs
+	}
+	return rel
+}
+
+// toSet converts a list of strings into a set
+func toSet(values []string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, value := range values {
+		set[value] = true
+	}
+	return set
+}
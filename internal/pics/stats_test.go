@@ -3,7 +3,9 @@ package pics
 import (
 	"os"
 	"path/filepath"
+	"reflect"
 	"testing"
+	"time"
 )
 
 // Helper functions
@@ -231,3 +233,149 @@ func TestFileStats_GetFileCount_OnlyDirectories(t *testing.T) {
 		t.Errorf("Expected count 0, got %d", count)
 	}
 }
+
+func TestFileStats_Snapshot(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	createTestFile(t, tmpDir, "b.jpg")
+	createTestFile(t, tmpDir, "a.jpg")
+	createTestFile(t, tmpDir, ".hidden")
+	subDir := createTestDir(t, tmpDir, "sub")
+	createTestFile(t, subDir, "c.mov")
+	createTestFile(t, createTestDir(t, tmpDir, ".pics-journal"), "journal.jsonl")
+	createTestFile(t, createTestDir(t, tmpDir, quarantineDirName), "bad.jpg")
+
+	snapshot, err := NewFileStats().Snapshot(tmpDir)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	expected := []string{"a.jpg", "b.jpg", filepath.Join("sub", "c.mov")}
+	if !reflect.DeepEqual(snapshot.Files, expected) {
+		t.Errorf("Expected %v, got %v", expected, snapshot.Files)
+	}
+	if snapshot.Dir != tmpDir {
+		t.Errorf("Expected dir %s, got %s", tmpDir, snapshot.Dir)
+	}
+}
+
+func TestFileStats_Reconcile_AfterParse(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir, targetDir := createSourceAndTarget(t, tmpDir)
+
+	// Existing library with a file the import renames
+	existingDir := createSubdir(t, targetDir, "2023 06 June 15 Beach")
+	createMediaFile(t, existingDir, "2023_06_June_15_Beach_00001.jpg", time.Now())
+	createMediaFile(t, existingDir, "unsorted.jpg", time.Now())
+
+	testDate := time.Date(2023, 6, 15, 10, 30, 0, 0, time.UTC)
+	createMediaFile(t, sourceDir, "image.jpg", testDate)
+	createMediaFile(t, createSubdir(t, sourceDir, "phone"), "video.mov", testDate)
+	createMediaFile(t, sourceDir, "image.xmp", testDate)
+	createTestFile(t, sourceDir, "notes.txt")
+	createTestFile(t, sourceDir, "orphan.xmp")
+
+	stats := NewFileStats()
+	source, err := stats.Snapshot(sourceDir)
+	if err != nil {
+		t.Fatalf("Failed to snapshot source: %v", err)
+	}
+	targetBefore, err := stats.Snapshot(targetDir)
+	if err != nil {
+		t.Fatalf("Failed to snapshot target: %v", err)
+	}
+
+	result, err := testParser.Parse(sourceDir, targetDir, testParseOptions)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	reconciliation, err := stats.Reconcile(source, targetBefore, result)
+	if err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+	if !reconciliation.OK() {
+		t.Errorf("Expected reconciliation to pass, got: %+v", reconciliation)
+	}
+	if reconciliation.SourceFiles != 5 || reconciliation.ImportedFiles != 3 {
+		t.Errorf("Expected 5 source files and 3 imported, got %d and %d", reconciliation.SourceFiles, reconciliation.ImportedFiles)
+	}
+	expectedSkipped := []ReconciledFile{
+		{Source: "notes.txt", Reason: "unsupported file type"},
+		{Source: "orphan.xmp", Reason: "sidecar without its media file"},
+	}
+	if !reflect.DeepEqual(reconciliation.Skipped, expectedSkipped) {
+		t.Errorf("Expected skipped %v, got %v", expectedSkipped, reconciliation.Skipped)
+	}
+}
+
+func TestFileStats_Reconcile_ReportsProblems(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir := createTestDir(t, tmpDir, "source")
+	targetDir := createTestDir(t, tmpDir, "target")
+
+	for _, name := range []string{"ok.jpg", "lost.jpg", "twin1.jpg", "twin2.jpg", "skipped.jpg", "failed.jpg"} {
+		createTestFile(t, sourceDir, name)
+	}
+	dateDir := createTestDir(t, targetDir, "2023 06 June 15")
+	createTestFile(t, dateDir, "old.jpg")
+
+	stats := NewFileStats()
+	source, _ := stats.Snapshot(sourceDir)
+	targetBefore, _ := stats.Snapshot(targetDir)
+
+	// Simulate what a broken parse could leave behind
+	os.Remove(filepath.Join(dateDir, "old.jpg"))
+	createTestFile(t, dateDir, "2023_06_June_15_00001.jpg")
+	createTestFile(t, dateDir, "2023_06_June_15_00003.jpg")
+	createTestFile(t, dateDir, "stray.jpg")
+
+	result := &ParseResult{
+		SourceDir: sourceDir,
+		TargetDir: targetDir,
+		Files: []ImportedFile{
+			{Source: filepath.Join(sourceDir, "lost.jpg"), FinalPath: filepath.Join("2023 06 June 15", "2023_06_June_15_00002.jpg")},
+			{Source: filepath.Join(sourceDir, "ok.jpg"), FinalPath: filepath.Join("2023 06 June 15", "2023_06_June_15_00001.jpg")},
+			{Source: filepath.Join(sourceDir, "twin1.jpg"), FinalPath: filepath.Join("2023 06 June 15", "2023_06_June_15_00003.jpg")},
+			{Source: filepath.Join(sourceDir, "twin2.jpg"), FinalPath: filepath.Join("2023 06 June 15", "2023_06_June_15_00003.jpg")},
+		},
+		Failures: []FileFailure{
+			{File: filepath.Join(sourceDir, "failed.jpg"), Stage: "compressing", Cause: "corrupt"},
+		},
+	}
+
+	reconciliation, err := stats.Reconcile(source, targetBefore, result)
+	if err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+	if reconciliation.OK() {
+		t.Error("Expected reconciliation to fail")
+	}
+
+	expectedMissing := []ReconciledFile{
+		{Source: "failed.jpg", Reason: "failed while compressing: corrupt"},
+		{Source: "lost.jpg", Target: filepath.Join("2023 06 June 15", "2023_06_June_15_00002.jpg"), Reason: "organised file not found"},
+		{Source: "skipped.jpg", Reason: "not imported"},
+		{Target: filepath.Join("2023 06 June 15", "old.jpg"), Reason: "file already in the target disappeared"},
+	}
+	if !reflect.DeepEqual(reconciliation.Missing, expectedMissing) {
+		t.Errorf("Expected missing %+v, got %+v", expectedMissing, reconciliation.Missing)
+	}
+
+	expectedDuplicated := []ReconciledFile{
+		{Source: "twin2.jpg", Target: filepath.Join("2023 06 June 15", "2023_06_June_15_00003.jpg"), Reason: "organised file shared with twin1.jpg"},
+	}
+	if !reflect.DeepEqual(reconciliation.Duplicated, expectedDuplicated) {
+		t.Errorf("Expected duplicated %+v, got %+v", expectedDuplicated, reconciliation.Duplicated)
+	}
+
+	expectedExtra := []ReconciledFile{
+		{Target: filepath.Join("2023 06 June 15", "stray.jpg"), Reason: "not produced by any source file"},
+	}
+	if !reflect.DeepEqual(reconciliation.Extra, expectedExtra) {
+		t.Errorf("Expected extra %+v, got %+v", expectedExtra, reconciliation.Extra)
+	}
+	if reconciliation.ImportedFiles != 2 {
+		t.Errorf("Expected 2 imported files, got %d", reconciliation.ImportedFiles)
+	}
+}
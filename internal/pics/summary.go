@@ -0,0 +1,61 @@
+package pics
+
+import (
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// stageTimer measures how long each stage of a parse takes
+type stageTimer struct {
+	stages []StageTiming
+	stage  string
+	start  time.Time
+}
+
+// begin ends the current stage, if any, and starts timing the next one
+func (t *stageTimer) begin(stage string) {
+	t.end()
+	t.stage = stage
+	t.start = time.Now()
+}
+
+// end records how long the current stage took
+func (t *stageTimer) end() {
+	if t.stage == "" {
+		return
+	}
+	t.stages = append(t.stages, StageTiming{Stage: t.stage, Duration: time.Since(t.start)})
+	t.stage = ""
+}
+
+// stop ends the current stage and returns the timings of every stage
+func (t *stageTimer) stop() []StageTiming {
+	t.end()
+	return t.stages
+}
+
+// summarise fills in the per-class counts, the byte totals and the date directories of a result
+func (p *mediaParser) summarise(result *ParseResult, journal *parseJournal) {
+	created := journal.createdDirs()
+	files := make(map[string]int)
+
+	result.FilesByClass = make(map[MediaClass]int)
+	for i := range result.Files {
+		file := &result.Files[i]
+		file.Class = p.extensions.Class(file.FinalPath)
+		result.FilesByClass[file.Class]++
+		result.BytesBefore += file.BytesBefore
+		result.BytesAfter += file.BytesAfter
+		files[strings.Split(file.FinalPath, string(filepath.Separator))[0]]++
+	}
+
+	result.DateDirs = []DateDirSummary{}
+	for _, dirName := range journal.touchedDirs() {
+		result.DateDirs = append(result.DateDirs, DateDirSummary{
+			Name:    dirName,
+			Created: created[filepath.Join(result.TargetDir, dirName)],
+			Files:   files[dirName],
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package pics
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStageTimer(t *testing.T) {
+	timer := &stageTimer{}
+	timer.begin("copying")
+	timer.begin("organising")
+	stages := timer.stop()
+
+	if len(stages) != 2 || stages[0].Stage != "copying" || stages[1].Stage != "organising" {
+		t.Fatalf("Expected the copying and organising stages, got %+v", stages)
+	}
+	if stages := timer.stop(); len(stages) != 2 {
+		t.Errorf("Expected stopping twice not to add a stage, got %+v", stages)
+	}
+}
+
+func TestMediaParser_Parse_Summary(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir, targetDir := createSourceAndTarget(t, tmpDir)
+	june := time.Date(2023, 6, 15, 10, 30, 0, 0, time.UTC)
+	july := time.Date(2023, 7, 1, 10, 30, 0, 0, time.UTC)
+
+	createMediaFile(t, sourceDir, "IMG_0001.jpg", june)
+	createMediaFile(t, sourceDir, "IMG_0001.xmp", june)
+	createMediaFile(t, sourceDir, "VID_0001.mov", july)
+	existingDir := createSubdir(t, targetDir, "2023 07 July 01")
+	createMediaFile(t, existingDir, "2023_07_July_01_00001.jpg", july)
+
+	result, err := testParser.Parse(sourceDir, targetDir, testParseOptions)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	expectedClasses := map[MediaClass]int{ClassImage: 1, ClassSidecar: 1, ClassVideo: 1}
+	for class, count := range expectedClasses {
+		if result.FilesByClass[class] != count {
+			t.Errorf("Expected %d %s files, got %d", count, class, result.FilesByClass[class])
+		}
+	}
+
+	size := int64(len("test media content"))
+	if result.BytesBefore != 3*size || result.BytesAfter != 3*size {
+		t.Errorf("Expected %d bytes before and after, got %d and %d", 3*size, result.BytesBefore, result.BytesAfter)
+	}
+	for _, file := range result.Files {
+		if file.Extractor != "ModTime" {
+			t.Errorf("Expected %s to be dated by ModTime, got %q", file.Source, file.Extractor)
+		}
+	}
+
+	expectedDirs := []DateDirSummary{
+		{Name: "2023 06 June 15", Created: true, Files: 2},
+		{Name: "2023 07 July 01", Created: false, Files: 1},
+	}
+	if len(result.DateDirs) != len(expectedDirs) {
+		t.Fatalf("Expected date directories %+v, got %+v", expectedDirs, result.DateDirs)
+	}
+	for i, dir := range expectedDirs {
+		if result.DateDirs[i] != dir {
+			t.Errorf("Expected date directory %+v, got %+v", dir, result.DateDirs[i])
+		}
+	}
+
+	stages := []string{}
+	for _, stage := range result.Stages {
+		stages = append(stages, stage.Stage)
+	}
+	if len(stages) != 4 || stages[0] != "preparing" || stages[3] != "renaming" {
+		t.Errorf("Expected the preparing, copying, organising and renaming stages, got %v", stages)
+	}
+}
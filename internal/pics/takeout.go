@@ -0,0 +1,302 @@
+package pics
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/acm19/pics/internal/logger"
+)
+
+const (
+	// takeoutSupplementalSuffix is added to sidecar names by newer Takeout exports
+	// ("IMG_1234.JPG.supplemental-metadata.json")
+	takeoutSupplementalSuffix = ".supplemental-metadata"
+	// takeoutTruncatedLength is the length from which a sidecar name may have been
+	// cut short, Takeout limits them to about 50 characters
+	takeoutTruncatedLength = 46
+)
+
+// takeoutCounter matches the counter Takeout adds to duplicate names, after the
+// media extension in sidecar names ("IMG_1234.JPG(1).json" describes "IMG_1234(1).JPG")
+var takeoutCounter = regexp.MustCompile(`\(\d+\)$`)
+
+// takeoutMetadata is the part of a Takeout JSON sidecar that holds the capture date
+type takeoutMetadata struct {
+	Title          string `json:"title"`
+	PhotoTakenTime struct {
+		Timestamp string `json:"timestamp"`
+	} `json:"photoTakenTime"`
+}
+
+// takeoutSidecar is a JSON sidecar found next to the media files it may describe
+type takeoutSidecar struct {
+	mediaName string
+	title     string
+	truncated bool
+	date      time.Time
+}
+
+// loadTakeoutDates pairs every media file below root with the JSON sidecar in its
+// directory and returns the photoTakenTime of the paired files
+func loadTakeoutDates(root string, extensions Extensions) (map[string]time.Time, error) {
+	dates := make(map[string]time.Time)
+	err := walkExportDirs(root, func(dir string, names []string) error {
+		var media []string
+		var sidecars []takeoutSidecar
+		for _, name := range names {
+			switch {
+			case strings.EqualFold(filepath.Ext(name), ".json"):
+				sidecar, err := readTakeoutSidecar(filepath.Join(dir, name))
+				if err != nil {
+					logger.Debug("Skipping JSON file that is not a Takeout sidecar", "file", filepath.Join(dir, name), "error", err)
+					continue
+				}
+				sidecars = append(sidecars, sidecar)
+			case extensions.IsSupported(name):
+				media = append(media, name)
+			}
+		}
+
+		for _, name := range media {
+			if sidecar, found := matchTakeoutSidecar(name, sidecars); found {
+				dates[filepath.Join(dir, name)] = sidecar.date
+			} else {
+				logger.Debug("No Takeout sidecar found", "file", filepath.Join(dir, name))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Takeout sidecars: %w", err)
+	}
+	logger.Info("Read Takeout sidecars", "files", len(dates))
+	return dates, nil
+}
+
+// readTakeoutSidecar reads the capture date of a Takeout JSON sidecar
+func readTakeoutSidecar(path string) (takeoutSidecar, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return takeoutSidecar{}, err
+	}
+	var metadata takeoutMetadata
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return takeoutSidecar{}, err
+	}
+	timestamp, err := strconv.ParseInt(metadata.PhotoTakenTime.Timestamp, 10, 64)
+	if err != nil || timestamp <= 0 {
+		return takeoutSidecar{}, fmt.Errorf("no photoTakenTime")
+	}
+
+	name := filepath.Base(path)
+	return takeoutSidecar{
+		mediaName: takeoutMediaName(name),
+		title:     metadata.Title,
+		truncated: len(name) >= takeoutTruncatedLength,
+		date:      time.Unix(timestamp, 0),
+	}, nil
+}
+
+// takeoutMediaName returns the name of the media file a sidecar is named after,
+// e.g. "IMG_1234.JPG.supplemental-metadata(1).json" -> "IMG_1234(1).JPG"
+func takeoutMediaName(sidecarName string) string {
+	name := strings.TrimSuffix(sidecarName, filepath.Ext(sidecarName))
+
+	counter := takeoutCounter.FindString(name)
+	name = strings.TrimSuffix(name, counter)
+
+	// The supplemental-metadata suffix may itself have been cut short
+	if i := strings.LastIndex(name, "."); i > 0 && strings.HasPrefix(takeoutSupplementalSuffix, name[i:]) {
+		name = name[:i]
+	}
+
+	ext := filepath.Ext(name)
+	return strings.TrimSuffix(name, ext) + counter + ext
+}
+
+// matchTakeoutSidecar finds the sidecar describing the media file name among the
+// sidecars of its directory
+func matchTakeoutSidecar(name string, sidecars []takeoutSidecar) (takeoutSidecar, bool) {
+	// Edited copies share the sidecar of the original
+	ext := filepath.Ext(name)
+	original := strings.TrimSuffix(strings.TrimSuffix(name, ext), "-edited") + ext
+
+	for _, candidate := range []string{name, original} {
+		for _, sidecar := range sidecars {
+			if sidecar.mediaName == candidate {
+				return sidecar, true
+			}
+		}
+	}
+
+	// Sidecars of long names are truncated, so their name is a prefix of the media name
+	for _, candidate := range []string{name, original} {
+		for _, sidecar := range sidecars {
+			if sidecar.truncated && strings.HasPrefix(candidate, sidecar.mediaName) {
+				return sidecar, true
+			}
+		}
+	}
+
+	// Otherwise fall back to the title recorded in the sidecar, as long as it is unambiguous
+	var match takeoutSidecar
+	matches := 0
+	for _, sidecar := range sidecars {
+		if sidecar.title == name || sidecar.title == original {
+			match = sidecar
+			matches++
+		}
+	}
+	return match, matches == 1
+}
+
+// extractTakeoutArchives extracts the media files and JSON sidecars of every zip
+// archive in sourceDir into destDir. The archives are merged into a single tree,
+// as a Takeout export split into several parts can keep a photo and its sidecar
+// in different parts. It returns the archive entry every extracted file came from.
+//
+// Archives are only extracted once: if destDir already exists (a resumed parse)
+// the entries are listed but not extracted again.
+func extractTakeoutArchives(ctx context.Context, sourceDir, destDir string, extensions Extensions) (map[string]string, error) {
+	archives, err := findTakeoutArchives(sourceDir)
+	if err != nil {
+		return nil, err
+	}
+	origins := make(map[string]string)
+	if len(archives) == 0 {
+		return origins, nil
+	}
+
+	extract := true
+	if _, err := os.Stat(destDir); err == nil {
+		extract = false
+		logger.Info("Takeout archives already extracted", "dir", destDir)
+	}
+
+	// Extracted into a separate directory first, so an interrupted extraction is started over
+	partialDir := destDir + ".partial"
+	if extract {
+		if err := os.RemoveAll(partialDir); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, archive := range archives {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		logger.Info("Reading Takeout archive", "archive", archive, "extract", extract)
+		if err := readTakeoutArchive(ctx, archive, destDir, partialDir, extract, extensions, origins); err != nil {
+			return nil, fmt.Errorf("failed to extract %s: %w", archive, err)
+		}
+	}
+
+	if extract {
+		if err := os.MkdirAll(partialDir, 0755); err != nil {
+			return nil, err
+		}
+		if err := os.Rename(partialDir, destDir); err != nil {
+			return nil, err
+		}
+	}
+	return origins, nil
+}
+
+// findTakeoutArchives returns the zip archives in sourceDir, sorted
+func findTakeoutArchives(sourceDir string) ([]string, error) {
+	var archives []string
+	err := filepath.WalkDir(sourceDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(entry.Name(), ".") && path != sourceDir {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !entry.IsDir() && strings.EqualFold(filepath.Ext(path), ".zip") {
+			archives = append(archives, path)
+		}
+		return nil
+	})
+	sort.Strings(archives)
+	return archives, err
+}
+
+// readTakeoutArchive records the origin of every media file and JSON sidecar in
+// archive, extracting them into partialDir when extract is set
+func readTakeoutArchive(ctx context.Context, archive, destDir, partialDir string, extract bool, extensions Extensions, origins map[string]string) error {
+	reader, err := zip.OpenReader(archive)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	for _, entry := range reader.File {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		name := filepath.FromSlash(entry.Name)
+		if entry.FileInfo().IsDir() || (!extensions.IsSupported(name) && !strings.EqualFold(filepath.Ext(name), ".json")) {
+			continue
+		}
+		if !filepath.IsLocal(name) {
+			logger.Warn("Skipping archive entry outside of the archive", "archive", archive, "entry", entry.Name)
+			continue
+		}
+
+		origins[filepath.Join(destDir, name)] = filepath.Join(archive, name)
+		if extract {
+			if err := extractZipEntry(entry, filepath.Join(partialDir, name)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// extractZipEntry writes a zip entry to dest, keeping its modification time
+func extractZipEntry(entry *zip.File, dest string) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+
+	src, err := entry.Open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	return os.Chtimes(dest, time.Now(), entry.Modified)
+}
+
+// archiveSource returns the archive entry an extracted file came from, or path itself
+func archiveSource(origins map[string]string, path string) string {
+	if origin, found := origins[path]; found {
+		return origin
+	}
+	return path
+}
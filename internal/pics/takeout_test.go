@@ -0,0 +1,195 @@
+package pics
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// takeoutTakenAt is the photoTakenTime used by the Takeout tests, midday UTC so the date is the same in most time zones
+var takeoutTakenAt = time.Date(2019, 6, 15, 12, 0, 0, 0, time.UTC)
+
+func takeoutSidecarJSON(title string, takenAt time.Time) string {
+	return fmt.Sprintf(`{"title": %q, "photoTakenTime": {"timestamp": "%d", "formatted": "Jun 15, 2019"}}`, title, takenAt.Unix())
+}
+
+func createTakeoutSidecar(t *testing.T, dir, name, title string, takenAt time.Time) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(takeoutSidecarJSON(title, takenAt)), 0644); err != nil {
+		t.Fatalf("Failed to create sidecar %s: %v", name, err)
+	}
+}
+
+// createZip creates a zip archive holding the given entries (name -> content)
+func createZip(t *testing.T, path string, entries map[string]string) {
+	t.Helper()
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create archive: %v", err)
+	}
+	defer file.Close()
+
+	writer := zip.NewWriter(file)
+	for name, content := range entries {
+		entry, err := writer.Create(name)
+		if err != nil {
+			t.Fatalf("Failed to create archive entry: %v", err)
+		}
+		if _, err := entry.Write([]byte(content)); err != nil {
+			t.Fatalf("Failed to write archive entry: %v", err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Failed to close archive: %v", err)
+	}
+}
+
+func TestTakeoutMediaName(t *testing.T) {
+	tests := []struct {
+		sidecar  string
+		expected string
+	}{
+		{"IMG_1234.JPG.json", "IMG_1234.JPG"},
+		{"IMG_1234.JPG.supplemental-metadata.json", "IMG_1234.JPG"},
+		{"IMG_1234.JPG.supplemental-met.json", "IMG_1234.JPG"},
+		{"IMG_1234.JPG.s.json", "IMG_1234.JPG"},
+		{"IMG_1234.JPG(1).json", "IMG_1234(1).JPG"},
+		{"IMG_1234.JPG.supplemental-metadata(2).json", "IMG_1234(2).JPG"},
+		{"a_very_long_file_name_from_a_messaging_app_201.json", "a_very_long_file_name_from_a_messaging_app_201"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.sidecar, func(t *testing.T) {
+			if actual := takeoutMediaName(tt.sidecar); actual != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, actual)
+			}
+		})
+	}
+}
+
+func TestLoadTakeoutDates(t *testing.T) {
+	tmpDir := t.TempDir()
+	albumDir := createSubdir(t, tmpDir, "Photos from 2019")
+	exportDay := time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC)
+	other := takeoutTakenAt.AddDate(0, 1, 0)
+
+	createMediaFile(t, albumDir, "IMG_0001.JPG", exportDay)
+	createTakeoutSidecar(t, albumDir, "IMG_0001.JPG.json", "IMG_0001.JPG", takeoutTakenAt)
+
+	createMediaFile(t, albumDir, "IMG_0002(1).JPG", exportDay)
+	createTakeoutSidecar(t, albumDir, "IMG_0002.JPG.supplemental-metadata(1).json", "IMG_0002.JPG", other)
+
+	createMediaFile(t, albumDir, "IMG_0003-edited.JPG", exportDay)
+	createTakeoutSidecar(t, albumDir, "IMG_0003.JPG.supplemental-metadata.json", "IMG_0003.JPG", takeoutTakenAt)
+
+	longName := "a_very_long_file_name_from_a_messaging_app_2019_06.jpg"
+	createMediaFile(t, albumDir, longName, exportDay)
+	createTakeoutSidecar(t, albumDir, "a_very_long_file_name_from_a_messaging_app_201.json", longName, takeoutTakenAt)
+
+	createMediaFile(t, albumDir, "renamed.mov", exportDay)
+	createTakeoutSidecar(t, albumDir, "original-name.json", "renamed.mov", takeoutTakenAt)
+
+	createMediaFile(t, albumDir, "no-sidecar.jpg", exportDay)
+	if err := os.WriteFile(filepath.Join(albumDir, "metadata.json"), []byte(`{"title": "Photos from 2019"}`), 0644); err != nil {
+		t.Fatalf("Failed to create album metadata: %v", err)
+	}
+
+	dates, err := loadTakeoutDates(tmpDir, NewExtensions())
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	expected := map[string]time.Time{
+		"IMG_0001.JPG":        takeoutTakenAt,
+		"IMG_0002(1).JPG":     other,
+		"IMG_0003-edited.JPG": takeoutTakenAt,
+		longName:              takeoutTakenAt,
+		"renamed.mov":         takeoutTakenAt,
+	}
+	if len(dates) != len(expected) {
+		t.Errorf("Expected %d dates, got %d: %v", len(expected), len(dates), dates)
+	}
+	for name, date := range expected {
+		actual, found := dates[filepath.Join(albumDir, name)]
+		if !found {
+			t.Errorf("Expected a date for %s", name)
+			continue
+		}
+		if !actual.Equal(date) {
+			t.Errorf("Expected %v for %s, got %v", date, name, actual)
+		}
+	}
+}
+
+func TestExtractTakeoutArchives(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir := createSubdir(t, tmpDir, "source")
+	destDir := filepath.Join(tmpDir, "extracted")
+
+	// The photo and its sidecar are in different parts of the export
+	createZip(t, filepath.Join(sourceDir, "takeout-001.zip"), map[string]string{
+		"Takeout/Google Photos/Trip/IMG_0001.JPG": "photo",
+		"Takeout/Google Photos/Trip/index.html":   "not extracted",
+		"../escape.jpg":                           "outside",
+	})
+	createZip(t, filepath.Join(sourceDir, "takeout-002.zip"), map[string]string{
+		"Takeout/Google Photos/Trip/IMG_0001.JPG.json": takeoutSidecarJSON("IMG_0001.JPG", takeoutTakenAt),
+	})
+
+	origins, err := extractTakeoutArchives(context.Background(), sourceDir, destDir, NewExtensions())
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	tripDir := filepath.Join(destDir, "Takeout", "Google Photos", "Trip")
+	photo := filepath.Join(tripDir, "IMG_0001.JPG")
+	assertMediaFileExists(t, photo)
+	assertMediaFileExists(t, filepath.Join(tripDir, "IMG_0001.JPG.json"))
+	assertMediaFileNotExists(t, filepath.Join(tripDir, "index.html"))
+	assertMediaFileNotExists(t, filepath.Join(tmpDir, "escape.jpg"))
+	assertMediaFileNotExists(t, destDir+".partial")
+
+	expectedOrigin := filepath.Join(sourceDir, "takeout-001.zip", "Takeout", "Google Photos", "Trip", "IMG_0001.JPG")
+	if origins[photo] != expectedOrigin {
+		t.Errorf("Expected origin %s, got %s", expectedOrigin, origins[photo])
+	}
+
+	dates, err := loadTakeoutDates(destDir, NewExtensions())
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if !dates[photo].Equal(takeoutTakenAt) {
+		t.Errorf("Expected %v, got %v", takeoutTakenAt, dates[photo])
+	}
+
+	// A resumed parse lists the archives again without extracting them
+	if err := os.Remove(photo); err != nil {
+		t.Fatalf("Failed to remove extracted file: %v", err)
+	}
+	origins, err = extractTakeoutArchives(context.Background(), sourceDir, destDir, NewExtensions())
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if origins[photo] != expectedOrigin {
+		t.Errorf("Expected origin %s, got %s", expectedOrigin, origins[photo])
+	}
+	assertMediaFileNotExists(t, photo)
+}
+
+func TestExtractTakeoutArchives_NoArchives(t *testing.T) {
+	tmpDir := t.TempDir()
+	destDir := filepath.Join(tmpDir, "extracted")
+
+	origins, err := extractTakeoutArchives(context.Background(), tmpDir, destDir, NewExtensions())
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if len(origins) != 0 {
+		t.Errorf("Expected no origins, got %v", origins)
+	}
+	assertMediaFileNotExists(t, destDir)
+}
@@ -0,0 +1,323 @@
+package pics
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/acm19/pics/internal/logger"
+)
+
+const (
+	// thumbsDirName is the directory in every date directory that holds its thumbnails and
+	// contact sheet. As a dot directory it is left out of file counts, snapshots and renames.
+	thumbsDirName = ".thumbs"
+	// contactSheetName is the name of the contact sheet in the thumbnails directory
+	contactSheetName = "contact-sheet.jpg"
+	// thumbnailLongEdge is the long edge of a thumbnail in pixels
+	thumbnailLongEdge = 320
+	// contactSheetCell is the size of the square cell every image takes on a contact sheet
+	contactSheetCell = 160
+	// contactSheetColumns is the number of cells in a row of a contact sheet
+	contactSheetColumns = 10
+	// thumbnailQuality is the JPEG quality thumbnails and contact sheets are encoded at
+	thumbnailQuality = 80
+)
+
+// errSegmentFound stops walking the segments of a JPEG file once the one looked for is found
+var errSegmentFound = errors.New("segment found")
+
+// thumbnailer writes the thumbnails and contact sheet of date directories. JPEG files
+// are decoded in Go, HEIC files are converted to JPEG with the converter first.
+type thumbnailer struct {
+	converter  ImageConverter
+	extensions Extensions
+}
+
+// writeDir brings the thumbnails of the images in dir up to date: missing ones are
+// written, the ones left behind by removed images are deleted, and the contact sheet is
+// rewritten when anything changed. It returns the number of thumbnails written. An image
+// that cannot be read is logged and left without a thumbnail.
+func (t *thumbnailer) writeDir(ctx context.Context, dir string) (int, error) {
+	images, err := t.images(dir)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read %s: %w", dir, err)
+	}
+	if len(images) == 0 {
+		return 0, nil
+	}
+
+	thumbsDir := filepath.Join(dir, thumbsDirName)
+	if err := os.MkdirAll(thumbsDir, 0755); err != nil {
+		return 0, fmt.Errorf("failed to create thumbnails directory: %w", err)
+	}
+
+	// Thumbnails are named after their image without its extension
+	changed := false
+	names, err := readDirNames(thumbsDir)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read %s: %w", thumbsDir, err)
+	}
+	for _, name := range names {
+		if _, found := images[strings.TrimSuffix(name, ".jpg")]; name == contactSheetName || found {
+			continue
+		}
+		if err := os.Remove(filepath.Join(thumbsDir, name)); err != nil {
+			return 0, fmt.Errorf("failed to remove stale thumbnail: %w", err)
+		}
+		changed = true
+	}
+
+	written := 0
+	var stems []string
+	for _, stem := range slices.Sorted(maps.Keys(images)) {
+		if err := ctx.Err(); err != nil {
+			return written, err
+		}
+		thumbPath := filepath.Join(thumbsDir, stem+".jpg")
+		if _, err := os.Stat(thumbPath); err != nil {
+			if err := t.writeThumbnail(ctx, images[stem], thumbPath); err != nil {
+				logger.Warn("Failed to write thumbnail", "path", images[stem], "error", err)
+				continue
+			}
+			written++
+		}
+		stems = append(stems, stem)
+	}
+
+	sheetPath := filepath.Join(thumbsDir, contactSheetName)
+	if _, err := os.Stat(sheetPath); err == nil && written == 0 && !changed {
+		return 0, nil
+	}
+	if err := writeContactSheet(thumbsDir, stems, sheetPath); err != nil {
+		return written, fmt.Errorf("failed to write contact sheet of %s: %w", dir, err)
+	}
+	return written, nil
+}
+
+// images returns the JPEG and HEIC images directly in dir, keyed by their name without
+// extension. A HEIC original kept next to its JPEG shares its name, the JPEG is used.
+func (t *thumbnailer) images(dir string) (map[string]string, error) {
+	names, err := readDirNames(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	images := make(map[string]string)
+	for _, name := range slices.Sorted(slices.Values(names)) {
+		path := filepath.Join(dir, name)
+		if !t.extensions.IsImage(path) {
+			continue
+		}
+		format, ok := sniffFormat(path)
+		if !ok || (format.name != formatJPEG.name && format.name != formatHEIC.name) {
+			continue
+		}
+		stem := strings.TrimSuffix(name, filepath.Ext(name))
+		if _, taken := images[stem]; !taken || format.name == formatJPEG.name {
+			images[stem] = path
+		}
+	}
+	return images, nil
+}
+
+// writeThumbnail writes a thumbnail of the image at src into dst, turned the way the
+// EXIF orientation of the image says it is displayed, as the thumbnail has no EXIF data
+func (t *thumbnailer) writeThumbnail(ctx context.Context, src, dst string) error {
+	if format, _ := sniffFormat(src); format.name == formatHEIC.name {
+		converted := filepath.Join(filepath.Dir(dst), "."+filepath.Base(dst)+".converting.jpg")
+		defer os.Remove(converted)
+		if err := t.converter.ConvertFile(ctx, src, converted); err != nil {
+			return err
+		}
+		src = converted
+	}
+
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", src, err)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to decode %s: %w", src, err)
+	}
+	width, height, _ := scaledSize(img.Bounds().Dx(), img.Bounds().Dy(), thumbnailLongEdge)
+	return writeJPEG(dst, orient(resizeArea(img, width, height), exifOrientation(data)))
+}
+
+// writeContactSheet lays out the thumbnails of stems in a grid, in order, and writes it into dst
+func writeContactSheet(thumbsDir string, stems []string, dst string) error {
+	if len(stems) == 0 {
+		os.Remove(dst)
+		return nil
+	}
+
+	columns := min(len(stems), contactSheetColumns)
+	rows := (len(stems) + columns - 1) / columns
+	sheet := image.NewRGBA(image.Rect(0, 0, columns*contactSheetCell, rows*contactSheetCell))
+	draw.Draw(sheet, sheet.Bounds(), image.NewUniform(color.RGBA{R: 32, G: 32, B: 32, A: 255}), image.Point{}, draw.Src)
+
+	// Every thumbnail is centred in its cell, with a margin around it
+	const margin = 4
+	for i, stem := range stems {
+		data, err := os.ReadFile(filepath.Join(thumbsDir, stem+".jpg"))
+		if err != nil {
+			return err
+		}
+		img, err := jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			return fmt.Errorf("failed to decode thumbnail %s: %w", stem, err)
+		}
+		width, height, _ := scaledSize(img.Bounds().Dx(), img.Bounds().Dy(), contactSheetCell-2*margin)
+		x := (i%columns)*contactSheetCell + (contactSheetCell-width)/2
+		y := (i/columns)*contactSheetCell + (contactSheetCell-height)/2
+		draw.Draw(sheet, image.Rect(x, y, x+width, y+height), resizeArea(img, width, height), image.Point{}, draw.Src)
+	}
+	return writeJPEG(dst, sheet)
+}
+
+// writeJPEG encodes img into path through a temporary file, so an interrupted write
+// never leaves a truncated file under path
+func writeJPEG(path string, img image.Image) error {
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, img, &jpeg.Options{Quality: thumbnailQuality}); err != nil {
+		return fmt.Errorf("failed to encode %s: %w", path, err)
+	}
+	tmpPath := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".writing")
+	if err := os.WriteFile(tmpPath, encoded.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", tmpPath, err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to replace %s: %w", path, err)
+	}
+	return nil
+}
+
+// exifOrientation returns the EXIF orientation (1 to 8) of the JPEG file in data, 1 when
+// it has none
+func exifOrientation(data []byte) int {
+	orientation := 1
+	walkJPEGSegments(bytes.NewReader(data), func(marker byte, payload []byte) error {
+		tiff, found := bytes.CutPrefix(payload, []byte("Exif\x00\x00"))
+		if marker != 0xE1 || !found {
+			return nil
+		}
+		if value := tiffOrientation(tiff); value >= 1 && value <= 8 {
+			orientation = value
+		}
+		return errSegmentFound
+	})
+	return orientation
+}
+
+// tiffOrientation returns the orientation tag of the first IFD of a TIFF structure, 0 when
+// it has none
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 0
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+
+	offset := int(order.Uint32(tiff[4:]))
+	if offset < 8 || offset+2 > len(tiff) {
+		return 0
+	}
+	count := int(order.Uint16(tiff[offset:]))
+	for i := 0; i < count; i++ {
+		entry := offset + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 0
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			return int(order.Uint16(tiff[entry+8:]))
+		}
+	}
+	return 0
+}
+
+// orient turns img the way an EXIF orientation says the stored image is displayed
+func orient(img *image.RGBA, orientation int) *image.RGBA {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+
+	// source returns the pixel of img shown at x, y
+	var source func(x, y int) (int, int)
+	switch orientation {
+	case 2:
+		source = func(x, y int) (int, int) { return w - 1 - x, y }
+	case 3:
+		source = func(x, y int) (int, int) { return w - 1 - x, h - 1 - y }
+	case 4:
+		source = func(x, y int) (int, int) { return x, h - 1 - y }
+	case 5:
+		source = func(x, y int) (int, int) { return y, x }
+	case 6:
+		source = func(x, y int) (int, int) { return y, h - 1 - x }
+	case 7:
+		source = func(x, y int) (int, int) { return w - 1 - y, h - 1 - x }
+	case 8:
+		source = func(x, y int) (int, int) { return w - 1 - y, x }
+	default:
+		return img
+	}
+
+	// Orientations 5 to 8 turn the image a quarter, swapping its width and height
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			sx, sy := source(x, y)
+			copy(dst.Pix[dst.PixOffset(x, y):dst.PixOffset(x, y)+4], img.Pix[img.PixOffset(sx, sy):img.PixOffset(sx, sy)+4])
+		}
+	}
+	return dst
+}
+
+// thumbnailMover renames the thumbnail of every image it renames, so a renamed
+// directory keeps its previews
+type thumbnailMover struct {
+	mover fileMover
+}
+
+func (m thumbnailMover) mkdirAll(dir string) error {
+	return m.mover.mkdirAll(dir)
+}
+
+func (m thumbnailMover) rename(from, to string) error {
+	if err := m.mover.rename(from, to); err != nil {
+		return err
+	}
+	if filepath.Dir(from) != filepath.Dir(to) {
+		return nil
+	}
+	thumbsDir := filepath.Join(filepath.Dir(from), thumbsDirName)
+	thumb := func(path string) string {
+		return filepath.Join(thumbsDir, strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))+".jpg")
+	}
+	if err := os.Rename(thumb(from), thumb(to)); err != nil && !os.IsNotExist(err) {
+		logger.Warn("Failed to rename thumbnail", "path", thumb(from), "error", err)
+	}
+	return nil
+}
@@ -0,0 +1,220 @@
+package pics
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// jpegConverter writes a decodable 40x30 JPEG for every file
+type jpegConverter struct{}
+
+func (jpegConverter) ConvertFile(ctx context.Context, src, dst string) error {
+	img := image.NewGray(image.Rect(0, 0, 40, 30))
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		return err
+	}
+	return os.WriteFile(dst, buf.Bytes(), 0644)
+}
+
+func decodeJPEGFile(t *testing.T, path string) image.Image {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", path, err)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Failed to decode %s: %v", path, err)
+	}
+	return img
+}
+
+func TestExifOrientation(t *testing.T) {
+	if orientation := exifOrientation(orientedJPEG(t, 8, 4, 6)); orientation != 6 {
+		t.Errorf("Expected orientation 6, got %d", orientation)
+	}
+	if orientation := exifOrientation(encodeJPEG(t, 80)); orientation != 1 {
+		t.Errorf("Expected orientation 1 without EXIF data, got %d", orientation)
+	}
+
+	// A little-endian TIFF header and an IFD with the orientation tag alone
+	tiff := []byte("II\x2A\x00\x08\x00\x00\x00\x01\x00\x12\x01\x03\x00\x01\x00\x00\x00\x08\x00\x00\x00\x00\x00\x00\x00")
+	if orientation := tiffOrientation(tiff); orientation != 8 {
+		t.Errorf("Expected orientation 8, got %d", orientation)
+	}
+	if orientation := tiffOrientation([]byte("II\x2A\x00\xFF\x00\x00\x00")); orientation != 0 {
+		t.Errorf("Expected no orientation for a truncated IFD, got %d", orientation)
+	}
+}
+
+func TestOrient(t *testing.T) {
+	// A 3x2 image whose pixels hold their own coordinates
+	img := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 3; x++ {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), 0, 255})
+		}
+	}
+
+	tests := []struct {
+		orientation   int
+		width, height int
+		topLeft       color.RGBA
+	}{
+		{1, 3, 2, color.RGBA{0, 0, 0, 255}},
+		{2, 3, 2, color.RGBA{2, 0, 0, 255}},
+		{3, 3, 2, color.RGBA{2, 1, 0, 255}},
+		{4, 3, 2, color.RGBA{0, 1, 0, 255}},
+		{5, 2, 3, color.RGBA{0, 0, 0, 255}},
+		{6, 2, 3, color.RGBA{0, 1, 0, 255}},
+		{7, 2, 3, color.RGBA{2, 1, 0, 255}},
+		{8, 2, 3, color.RGBA{2, 0, 0, 255}},
+	}
+
+	for _, tt := range tests {
+		oriented := orient(img, tt.orientation)
+		if oriented.Bounds().Dx() != tt.width || oriented.Bounds().Dy() != tt.height {
+			t.Errorf("Orientation %d: expected %dx%d, got %v", tt.orientation, tt.width, tt.height, oriented.Bounds())
+		}
+		if topLeft := oriented.RGBAAt(0, 0); topLeft != tt.topLeft {
+			t.Errorf("Orientation %d: expected top left pixel %v, got %v", tt.orientation, tt.topLeft, topLeft)
+		}
+	}
+}
+
+func TestThumbnailer_WriteDir(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	createFileWithContent(t, dir, "2023_06_June_15_00001.jpg", orientedJPEG(t, 64, 48, 6), now)
+	createFileWithContent(t, dir, "2023_06_June_15_00001.heic", heicHeader, now)
+	createFileWithContent(t, dir, "2023_06_June_15_00002.heic", heicHeader, now)
+	createFileWithContent(t, dir, "2023_06_June_15_00003.jpg", []byte("test media content"), now)
+	createMediaFile(t, dir, "2023_06_June_15_00001.mov", now)
+
+	stats := NewFileStats()
+	before, err := stats.GetFileCount(dir)
+	if err != nil {
+		t.Fatalf("GetFileCount failed: %v", err)
+	}
+
+	thumbs := &thumbnailer{converter: jpegConverter{}, extensions: NewExtensions()}
+	written, err := thumbs.writeDir(context.Background(), dir)
+	if err != nil {
+		t.Fatalf("writeDir failed: %v", err)
+	}
+	if written != 2 {
+		t.Errorf("Expected 2 thumbnails, got %d", written)
+	}
+
+	thumbsDir := filepath.Join(dir, thumbsDirName)
+	names, err := readDirNames(thumbsDir)
+	if err != nil {
+		t.Fatalf("Failed to read thumbnails: %v", err)
+	}
+	expected := []string{"2023_06_June_15_00001.jpg", "2023_06_June_15_00002.jpg", contactSheetName}
+	if slices.Sort(names); !slices.Equal(names, expected) {
+		t.Errorf("Expected thumbnails %v, got %v", expected, names)
+	}
+
+	// The JPEG stored sideways is shown upright, the HEIC file goes through the converter
+	if bounds := decodeJPEGFile(t, filepath.Join(thumbsDir, "2023_06_June_15_00001.jpg")).Bounds(); bounds.Dx() != 48 || bounds.Dy() != 64 {
+		t.Errorf("Expected an upright 48x64 thumbnail, got %v", bounds)
+	}
+	if bounds := decodeJPEGFile(t, filepath.Join(thumbsDir, "2023_06_June_15_00002.jpg")).Bounds(); bounds.Dx() != 40 || bounds.Dy() != 30 {
+		t.Errorf("Expected a 40x30 thumbnail of the converted HEIC file, got %v", bounds)
+	}
+	if bounds := decodeJPEGFile(t, filepath.Join(thumbsDir, contactSheetName)).Bounds(); bounds.Dx() != 2*contactSheetCell || bounds.Dy() != contactSheetCell {
+		t.Errorf("Expected a single row of two cells, got %v", bounds)
+	}
+
+	// Thumbnails are not media files of the directory
+	if after, err := stats.GetFileCount(dir); err != nil || after != before {
+		t.Errorf("Expected %d files with thumbnails, got %d, %v", before, after, err)
+	}
+	backup := &s3Backup{extensions: NewExtensions()}
+	if images, _, videos, err := backup.countMediaFiles(dir); err != nil || images != 4 || videos != 1 {
+		t.Errorf("Expected 4 images and 1 video, got %d, %d, %v", images, videos, err)
+	}
+
+	// Nothing is written again, and the thumbnail of a removed image goes with it
+	if written, err := thumbs.writeDir(context.Background(), dir); err != nil || written != 0 {
+		t.Errorf("Expected no thumbnails written again, got %d, %v", written, err)
+	}
+	if err := os.Remove(filepath.Join(dir, "2023_06_June_15_00002.heic")); err != nil {
+		t.Fatalf("Failed to remove image: %v", err)
+	}
+	if _, err := thumbs.writeDir(context.Background(), dir); err != nil {
+		t.Fatalf("writeDir failed: %v", err)
+	}
+	assertMediaFileNotExists(t, filepath.Join(thumbsDir, "2023_06_June_15_00002.jpg"))
+	if bounds := decodeJPEGFile(t, filepath.Join(thumbsDir, contactSheetName)).Bounds(); bounds.Dx() != contactSheetCell {
+		t.Errorf("Expected the contact sheet to be rewritten with a single cell, got %v", bounds)
+	}
+}
+
+func TestDirectoryRenamer_RenameDirectory_RenamesThumbnails(t *testing.T) {
+	tmpDir := t.TempDir()
+	dir := createSubdir(t, tmpDir, "2023 06 June 15")
+	createFileWithContent(t, dir, "2023_06_June_15_00001.jpg", orientedJPEG(t, 16, 12, 1), time.Now())
+	thumbs := &thumbnailer{converter: jpegConverter{}, extensions: NewExtensions()}
+	if _, err := thumbs.writeDir(context.Background(), dir); err != nil {
+		t.Fatalf("writeDir failed: %v", err)
+	}
+
+	if err := NewDirectoryRenamer().RenameDirectory(dir, "Picnic"); err != nil {
+		t.Fatalf("RenameDirectory failed: %v", err)
+	}
+
+	thumbsDir := filepath.Join(tmpDir, "2023 06 June 15 Picnic", thumbsDirName)
+	assertMediaFileExists(t, filepath.Join(thumbsDir, "2023_06_June_15_Picnic_00001.jpg"))
+	assertMediaFileNotExists(t, filepath.Join(thumbsDir, "2023_06_June_15_00001.jpg"))
+	assertMediaFileExists(t, filepath.Join(thumbsDir, contactSheetName))
+}
+
+func TestMediaParser_Parse_Thumbnails(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir, targetDir := createSourceAndTarget(t, tmpDir)
+	testDate := time.Date(2023, 6, 15, 10, 30, 0, 0, time.UTC)
+	createFileWithContent(t, sourceDir, "IMG_0001.jpg", orientedJPEG(t, 16, 12, 1), testDate)
+	createMediaFile(t, sourceDir, "video.mov", testDate)
+
+	progressChan := make(chan ProgressEvent, 100)
+	opts := testParseOptions
+	opts.Thumbnails = true
+	opts.ProgressChan = progressChan
+
+	result, err := testParser.Parse(sourceDir, targetDir, opts)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	close(progressChan)
+
+	thumbsDir := filepath.Join(targetDir, "2023 06 June 15", thumbsDirName)
+	assertMediaFileExists(t, filepath.Join(thumbsDir, "2023_06_June_15_00001.jpg"))
+	assertMediaFileExists(t, filepath.Join(thumbsDir, contactSheetName))
+
+	if count, err := NewFileStats().GetFileCount(targetDir); err != nil || count != 2 {
+		t.Errorf("Expected the two imported files to be counted, got %d, %v", count, err)
+	}
+	if last := result.Stages[len(result.Stages)-1]; last.Stage != "thumbnails" {
+		t.Errorf("Expected the thumbnails stage last, got %+v", result.Stages)
+	}
+	thumbnailEvents := 0
+	for event := range progressChan {
+		if event.Stage == "thumbnails" {
+			thumbnailEvents++
+		}
+	}
+	if thumbnailEvents != 1 {
+		t.Errorf("Expected a thumbnails progress event, got %d", thumbnailEvents)
+	}
+}
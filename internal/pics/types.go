@@ -1,33 +1,126 @@
 package pics
 
+import "time"
+
 // ParseOptions holds configuration options for parsing.
 type ParseOptions struct {
 	// CompressJPEGs enables JPEG compression.
 	CompressJPEGs bool
 	// JPEGQuality is the quality level for JPEG compression (0-100).
 	JPEGQuality int
+	// Compressor is the backend that compresses JPEG files.
+	Compressor CompressorBackend
+	// MaxLongEdge shrinks JPEG files whose long edge is larger than this many pixels (0 = unlimited).
+	// They are re-encoded at JPEGQuality when compressing JPEGs.
+	MaxLongEdge int
+	// CompressVideos transcodes videos with VideoPreset, keeping the original when the
+	// transcoded copy is not smaller.
+	CompressVideos bool
+	// VideoPreset holds the settings videos are transcoded with.
+	VideoPreset VideoPreset
 	// TempDirName is the name of the temporary directory to use.
 	TempDirName string
 	// MaxConcurrency is the maximum number of files to process concurrently (0 = unlimited).
 	MaxConcurrency int
 	// ProgressChan is an optional channel for receiving progress events.
 	ProgressChan chan<- ProgressEvent
+	// ErrorPolicy controls what happens when a single file cannot be imported.
+	ErrorPolicy ErrorPolicy
+	// MoveSource deletes each source file once its organised copy has been verified against it.
+	MoveSource bool
+	// SourceMode is the kind of export the source directory holds.
+	SourceMode SourceMode
+	// FixExtensions renames files whose content does not match their extension, such as
+	// HEIC files named .jpg, with the extension of their content.
+	FixExtensions bool
+	// ConvertHEIC converts HEIC files to JPEG, which are then compressed and organised like any other JPEG.
+	ConvertHEIC bool
+	// KeepHEIC keeps the original of every converted HEIC file next to its JPEG, under the same name.
+	KeepHEIC bool
+	// Thumbnails writes a thumbnail of every JPEG and HEIC image, and a contact sheet of the
+	// whole day, into the .thumbs directory of every date directory that received files.
+	Thumbnails bool
+	// Timezone is the library timezone: every file goes into the directory of its calendar
+	// day there, and dates recorded without a timezone are read as its wall clock. Nil uses
+	// the system timezone.
+	Timezone *time.Location
 }
 
+// ErrorPolicy controls what Parse does when a single file cannot be imported.
+type ErrorPolicy string
+
+const (
+	// ErrorPolicyFailFast stops the parse at the first file that fails.
+	ErrorPolicyFailFast ErrorPolicy = "fail-fast"
+	// ErrorPolicyContinue moves files that fail to the quarantine directory and carries on.
+	ErrorPolicyContinue ErrorPolicy = "continue"
+)
+
+// CompressorBackend selects the ImageCompressor used to compress JPEG files.
+type CompressorBackend string
+
+const (
+	// CompressorJpegoptim compresses JPEG files with the jpegoptim binary.
+	CompressorJpegoptim CompressorBackend = "jpegoptim"
+	// CompressorGo re-encodes JPEG files in Go, without any external binary.
+	CompressorGo CompressorBackend = "go"
+)
+
+// SourceMode tells Parse what kind of export the source directory holds.
+type SourceMode string
+
+const (
+	// SourceModeDirectory reads media files from a plain directory tree.
+	SourceModeDirectory SourceMode = "directory"
+	// SourceModeTakeout reads a Google Takeout export (directories or zip archives) and
+	// dates files with the photoTakenTime of their JSON sidecars.
+	SourceModeTakeout SourceMode = "takeout"
+	// SourceModeICloud reads an iCloud Photos export and dates files with the
+	// originalCreationDate listed in its "Photo Details" CSV files.
+	SourceModeICloud SourceMode = "icloud"
+)
+
 // DefaultParseOptions returns the default parsing options.
 func DefaultParseOptions() ParseOptions {
 	return ParseOptions{
 		CompressJPEGs:  true,
 		JPEGQuality:    50,
+		Compressor:     CompressorJpegoptim,
+		VideoPreset:    videoPresets[DefaultVideoPresetName],
 		TempDirName:    "tmp_image",
 		MaxConcurrency: 100,
 		ProgressChan:   nil,
+		ErrorPolicy:    ErrorPolicyFailFast,
+		SourceMode:     SourceModeDirectory,
+	}
+}
+
+// WatchOptions holds configuration options for watching an inbox directory.
+type WatchOptions struct {
+	// ParseOptions are used for every batch imported from the inbox.
+	ParseOptions ParseOptions
+	// SettleTime is how long a file must stay unchanged before it is imported.
+	SettleTime time.Duration
+	// MaxBatchSize is the maximum number of files imported in a single batch (0 = unlimited).
+	MaxBatchSize int
+}
+
+// DefaultWatchOptions returns the default watch options.
+func DefaultWatchOptions() WatchOptions {
+	parseOpts := DefaultParseOptions()
+	parseOpts.ErrorPolicy = ErrorPolicyContinue
+	return WatchOptions{
+		ParseOptions: parseOpts,
+		SettleTime:   5 * time.Second,
+		MaxBatchSize: 500,
 	}
 }
 
 // ProgressEvent represents a progress update during file processing operations.
 type ProgressEvent struct {
-	// Stage indicates the current processing stage ("copying", "compressing", "organising", "renaming").
+	// Stage indicates the current processing stage ("copying", "converting", "downscaling", "compressing", "skipping", "transcoding", "organising", "renaming", "thumbnails").
+	// "skipping" reports a JPEG file left uncompressed, as it is already at or below the target quality.
+	// "transcoding" is reported repeatedly for a video, with the percentage transcoded in Message.
 	Stage string
 	// Current is the number of items processed so far.
 	Current int
@@ -39,6 +132,162 @@ type ProgressEvent struct {
 	File string
 }
 
+// FileFailure describes a file that could not be imported.
+type FileFailure struct {
+	// File is the path of the file in the source directory.
+	File string `json:"file"`
+	// Stage is the processing stage that failed ("copying", "converting", "downscaling", "compressing", "transcoding", "organising").
+	Stage string `json:"stage"`
+	// Cause is the error that made the stage fail.
+	Cause string `json:"cause"`
+	// QuarantinedAs is where the copy of the file was moved to, empty if it was never copied.
+	QuarantinedAs string `json:"quarantinedAs,omitempty"`
+}
+
+// ImportedFile describes where a parse put a single source file.
+type ImportedFile struct {
+	// Source is the path of the file in the source directory.
+	Source string `json:"source"`
+	// FinalPath is the location of the organised file, relative to the target directory.
+	FinalPath string `json:"finalPath"`
+	// Original is the location of the HEIC original kept next to a converted file, relative
+	// to the target directory (KeepHEIC only).
+	Original string `json:"original,omitempty"`
+	// Class is the media class of the organised file.
+	Class MediaClass `json:"class"`
+	// Extractor is the name of the date extractor that dated the file (e.g. "Native", "EXIF", "ModTime").
+	// Companions, such as sidecars, are dated by their media file's extractor.
+	Extractor string `json:"extractor,omitempty"`
+	// Compressed indicates whether the file was compressed (or, for a video, transcoded).
+	Compressed bool `json:"compressed"`
+	// BytesBefore is the size of the source file.
+	BytesBefore int64 `json:"bytesBefore"`
+	// BytesAfter is the size of the organised file, after compression.
+	BytesAfter int64 `json:"bytesAfter"`
+}
+
+// DateDirSummary describes a date directory that received files.
+type DateDirSummary struct {
+	// Name is the name of the directory in the target directory.
+	Name string `json:"name"`
+	// Created indicates whether the parse created the directory, rather than extending an existing one.
+	Created bool `json:"created"`
+	// Files is the number of imported files that ended up in the directory.
+	Files int `json:"files"`
+}
+
+// StageTiming is how long a stage of a parse took.
+type StageTiming struct {
+	// Stage is the name of the stage ("preparing", "copying", "organising", "renaming", "verifying").
+	Stage string `json:"stage"`
+	// Duration is how long the stage took (in nanoseconds in JSON).
+	Duration time.Duration `json:"duration"`
+}
+
+// RenamedFile describes a file already in the target directory that a parse renamed.
+type RenamedFile struct {
+	// From is the previous location of the file, relative to the target directory.
+	From string `json:"from"`
+	// To is the new location of the file, relative to the target directory.
+	To string `json:"to"`
+}
+
+// ParseResult describes what a parse did.
+type ParseResult struct {
+	// SourceDir is the directory media files were read from.
+	SourceDir string `json:"sourceDir"`
+	// TargetDir is the directory media files were organised into.
+	TargetDir string `json:"targetDir"`
+	// Files holds one entry per imported source file, sorted by source path.
+	Files []ImportedFile `json:"files"`
+	// Renamed lists the files already in the target directory that were renamed
+	// to be numbered along with the imported ones.
+	Renamed []RenamedFile `json:"renamed"`
+	// Failures lists the files that could not be imported (ErrorPolicyContinue only).
+	Failures []FileFailure `json:"failures,omitempty"`
+	// FilesByClass is the number of imported files of each media class.
+	FilesByClass map[MediaClass]int `json:"filesByClass"`
+	// BytesBefore is the total size of the imported source files.
+	BytesBefore int64 `json:"bytesBefore"`
+	// BytesAfter is the total size of the organised files, after compression.
+	BytesAfter int64 `json:"bytesAfter"`
+	// DateDirs lists the date directories that received files, sorted by name.
+	DateDirs []DateDirSummary `json:"dateDirs"`
+	// Stages lists how long each stage of the parse took, in the order they ran.
+	// A resumed parse only times the stages it ran itself.
+	Stages []StageTiming `json:"stages"`
+}
+
+// DirSnapshot lists the files in a directory tree at a point in time.
+type DirSnapshot struct {
+	// Dir is the directory the snapshot was taken of.
+	Dir string `json:"dir"`
+	// Files holds the path of every file relative to Dir, sorted.
+	Files []string `json:"files"`
+}
+
+// ReconciledFile is a single finding of a reconciliation.
+type ReconciledFile struct {
+	// Source is the path of the file relative to the source directory, if any.
+	Source string `json:"source,omitempty"`
+	// Target is the path of the file relative to the target directory, if any.
+	Target string `json:"target,omitempty"`
+	// Reason explains the finding.
+	Reason string `json:"reason"`
+}
+
+// Reconciliation accounts for every source file after a parse.
+type Reconciliation struct {
+	// SourceFiles is the number of files in the source directory.
+	SourceFiles int `json:"sourceFiles"`
+	// ImportedFiles is the number of source files found at their organised location.
+	ImportedFiles int `json:"importedFiles"`
+	// Missing lists source files that did not make it into the target, and
+	// target files that disappeared.
+	Missing []ReconciledFile `json:"missing"`
+	// Duplicated lists files that were imported more than once, or onto the same organised file.
+	Duplicated []ReconciledFile `json:"duplicated"`
+	// Extra lists new files in the target that no source file accounts for.
+	Extra []ReconciledFile `json:"extra"`
+	// Skipped lists source files the parse does not import (unsupported types).
+	Skipped []ReconciledFile `json:"skipped"`
+}
+
+// OK reports whether every source file was accounted for.
+func (r *Reconciliation) OK() bool {
+	return len(r.Missing) == 0 && len(r.Duplicated) == 0 && len(r.Extra) == 0
+}
+
+// PlannedFile describes what Parse would do with a single source file.
+type PlannedFile struct {
+	// Source is the path of the file in the source directory.
+	Source string `json:"source"`
+	// StagingName is the name the file gets in the temporary staging directory.
+	StagingName string `json:"stagingName"`
+	// Convert indicates whether the file would be converted from HEIC to JPEG.
+	Convert bool `json:"convert"`
+	// Compress indicates whether the file would be compressed.
+	Compress bool `json:"compress"`
+	// Date is the date detected for the file.
+	Date time.Time `json:"date"`
+	// Extractor is the name of the date extractor that produced Date (e.g. "Native", "EXIF", "ModTime").
+	Extractor string `json:"extractor"`
+	// DateDir is the name of the date-based directory the file would be moved to.
+	DateDir string `json:"dateDir"`
+	// FinalPath is the final location of the file, relative to the target directory.
+	FinalPath string `json:"finalPath"`
+}
+
+// ParsePlan is the full plan produced by a dry run of Parse.
+type ParsePlan struct {
+	// SourceDir is the directory media files would be read from.
+	SourceDir string `json:"sourceDir"`
+	// TargetDir is the directory media files would be organised into.
+	TargetDir string `json:"targetDir"`
+	// Files holds one entry per supported source file, sorted by final path.
+	Files []PlannedFile `json:"files"`
+}
+
 // RestoreFilter defines the date range filter for restoring backups.
 type RestoreFilter struct {
 	// FromYear is the lower bound year (0 means no lower bound).
@@ -0,0 +1,231 @@
+package pics
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/acm19/pics/internal/logger"
+)
+
+// VideoPreset holds the settings videos are transcoded with.
+type VideoPreset struct {
+	// Codec is the ffmpeg video encoder (e.g. "libx265", "libx264").
+	Codec string `json:"codec"`
+	// CRF is the constant rate factor of the encoder, lower is better quality.
+	CRF int `json:"crf"`
+	// MaxResolution is the maximum length of the long edge in pixels, 0 keeps the resolution.
+	MaxResolution int `json:"maxResolution"`
+}
+
+// videoPresets are the presets that can be selected by name
+var videoPresets = map[string]VideoPreset{
+	"archive":  {Codec: "libx265", CRF: 22},
+	"balanced": {Codec: "libx265", CRF: 26, MaxResolution: 1920},
+	"small":    {Codec: "libx265", CRF: 30, MaxResolution: 1280},
+}
+
+// DefaultVideoPresetName is the name of the preset used unless another one is selected.
+const DefaultVideoPresetName = "balanced"
+
+// LookupVideoPreset returns the preset with the given name.
+func LookupVideoPreset(name string) (VideoPreset, error) {
+	preset, found := videoPresets[name]
+	if !found {
+		return VideoPreset{}, fmt.Errorf("unknown video preset %q (expected one of %s)", name, strings.Join(VideoPresetNames(), ", "))
+	}
+	return preset, nil
+}
+
+// VideoPresetNames returns the names of the presets, sorted.
+func VideoPresetNames() []string {
+	names := make([]string, 0, len(videoPresets))
+	for name := range videoPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// VideoCompressor defines the interface for compressing videos
+type VideoCompressor interface {
+	// CompressVideo transcodes a video with preset, stopping if ctx is cancelled. The video
+	// is only replaced when the transcoded copy is smaller, which the returned bool reports.
+	// progress, if not nil, is called with the fraction of the video transcoded so far.
+	CompressVideo(ctx context.Context, path string, preset VideoPreset, progress func(done float64)) (bool, error)
+}
+
+// ffmpegCompressor implements the VideoCompressor interface
+type ffmpegCompressor struct {
+	ffmpegPath string
+}
+
+// NewVideoCompressor creates a new VideoCompressor instance using system ffmpeg
+func NewVideoCompressor() VideoCompressor {
+	return &ffmpegCompressor{}
+}
+
+// NewVideoCompressorWithPath creates a new VideoCompressor with a custom ffmpeg path
+func NewVideoCompressorWithPath(ffmpegPath string) VideoCompressor {
+	return &ffmpegCompressor{
+		ffmpegPath: ffmpegPath,
+	}
+}
+
+// CompressVideo transcodes a single video using ffmpeg, keeping its metadata (creation
+// time included) and its modification time
+func (c *ffmpegCompressor) CompressVideo(ctx context.Context, path string, preset VideoPreset, progress func(done float64)) (bool, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, fmt.Errorf("file does not exist: %w", err)
+	}
+
+	// Determine ffmpeg path
+	ffmpeg := c.ffmpegPath
+	if ffmpeg == "" {
+		ffmpeg = "ffmpeg" // Use system PATH
+	}
+
+	// Written next to the video with its extension, which ffmpeg picks the container from
+	tmpPath := filepath.Join(filepath.Dir(path), ".transcoding-"+filepath.Base(path))
+	defer os.Remove(tmpPath)
+
+	cmd := exec.CommandContext(ctx, ffmpeg, ffmpegArgs(path, tmpPath, preset)...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return false, err
+	}
+	stderr := &durationWriter{}
+	cmd.Stderr = stderr
+	if err := cmd.Start(); err != nil {
+		return false, fmt.Errorf("failed to start ffmpeg: %w", err)
+	}
+	readFFmpegProgress(stdout, stderr.duration, progress)
+	err = cmd.Wait()
+	if ctx.Err() != nil {
+		return false, ctx.Err()
+	}
+	if err != nil {
+		return false, fmt.Errorf("ffmpeg failed for %s: %w, output: %s", path, err, stderr.tail())
+	}
+
+	transcoded, err := os.Stat(tmpPath)
+	if err != nil {
+		return false, fmt.Errorf("failed to stat %s: %w", tmpPath, err)
+	}
+	if transcoded.Size() >= info.Size() {
+		logger.Debug("Keeping original, transcoded video is not smaller", "path", path, "original", info.Size(), "transcoded", transcoded.Size())
+		return false, nil
+	}
+
+	if err := os.Chtimes(tmpPath, time.Now(), info.ModTime()); err != nil {
+		return false, fmt.Errorf("failed to set file times: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return false, fmt.Errorf("failed to replace %s: %w", path, err)
+	}
+	return true, nil
+}
+
+// ffmpegArgs returns the arguments that transcode src into dst with preset, reporting
+// progress on stdout
+func ffmpegArgs(src, dst string, preset VideoPreset) []string {
+	args := []string{
+		"-hide_banner", "-nostdin", "-y",
+		"-i", src,
+		// Global and stream metadata hold the creation time, QuickTime keys the Apple one
+		"-map_metadata", "0",
+		"-movflags", "use_metadata_tags",
+		"-c:v", preset.Codec,
+		"-crf", strconv.Itoa(preset.CRF),
+		"-c:a", "copy",
+	}
+	if preset.MaxResolution > 0 {
+		// Shrink the long edge only, keeping the aspect ratio and an even short edge
+		n := preset.MaxResolution
+		args = append(args, "-vf", fmt.Sprintf("scale='if(gte(iw,ih),min(iw,%d),-2)':'if(gte(iw,ih),-2,min(ih,%d))'", n, n))
+	}
+	if strings.Contains(preset.Codec, "265") || strings.Contains(preset.Codec, "hevc") {
+		// Apple players only play HEVC tagged as hvc1
+		args = append(args, "-tag:v", "hvc1")
+	}
+	return append(args, "-progress", "pipe:1", "-nostats", dst)
+}
+
+// readFFmpegProgress reads the progress ffmpeg writes with -progress and calls progress
+// with the fraction of the video transcoded so far, once duration is known
+func readFFmpegProgress(r io.Reader, duration func() time.Duration, progress func(done float64)) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		value, found := strings.CutPrefix(scanner.Text(), "out_time_us=")
+		if !found || progress == nil {
+			continue
+		}
+		us, err := strconv.ParseInt(value, 10, 64)
+		if total := duration(); err == nil && total > 0 {
+			progress(min(1, float64(time.Duration(us)*time.Microsecond)/float64(total)))
+		}
+	}
+}
+
+// ffmpegDurationPattern matches the duration ffmpeg logs for its input
+var ffmpegDurationPattern = regexp.MustCompile(`Duration: (\d+):(\d{2}):(\d{2})\.(\d{2})`)
+
+// parseFFmpegDuration returns the duration of the input ffmpeg logged in output
+func parseFFmpegDuration(output []byte) (time.Duration, bool) {
+	match := ffmpegDurationPattern.FindSubmatch(output)
+	if match == nil {
+		return 0, false
+	}
+	var parts [4]int
+	for i := range parts {
+		parts[i], _ = strconv.Atoi(string(match[i+1]))
+	}
+	return time.Duration(parts[0])*time.Hour + time.Duration(parts[1])*time.Minute +
+		time.Duration(parts[2])*time.Second + time.Duration(parts[3])*10*time.Millisecond, true
+}
+
+// durationWriter collects the log ffmpeg writes to stderr, where it reports the duration of its input
+type durationWriter struct {
+	mu     sync.Mutex
+	output bytes.Buffer
+	parsed time.Duration
+}
+
+func (w *durationWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.output.Write(p)
+}
+
+// duration returns the duration of the input, 0 until ffmpeg logged it
+func (w *durationWriter) duration() time.Duration {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.parsed == 0 {
+		w.parsed, _ = parseFFmpegDuration(w.output.Bytes())
+	}
+	return w.parsed
+}
+
+// tail returns the end of the log, where ffmpeg reports errors
+func (w *durationWriter) tail() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	output := w.output.Bytes()
+	if len(output) > 2048 {
+		output = output[len(output)-2048:]
+	}
+	return string(output)
+}
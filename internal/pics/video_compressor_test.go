@@ -0,0 +1,208 @@
+package pics
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeVideoCompressor truncates every video to half its size, reporting progress half way
+// and when done, and fails for paths that contain failOn
+type fakeVideoCompressor struct {
+	failOn string
+}
+
+func (c *fakeVideoCompressor) CompressVideo(ctx context.Context, path string, preset VideoPreset, progress func(done float64)) (bool, error) {
+	if c.failOn != "" && strings.Contains(path, c.failOn) {
+		return false, fmt.Errorf("simulated failure for %s", path)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	progress(0.5)
+	if err := os.Truncate(path, info.Size()/2); err != nil {
+		return false, err
+	}
+	progress(1)
+	return true, os.Chtimes(path, info.ModTime(), info.ModTime())
+}
+
+func TestLookupVideoPreset(t *testing.T) {
+	for _, name := range VideoPresetNames() {
+		preset, err := LookupVideoPreset(name)
+		if err != nil {
+			t.Errorf("LookupVideoPreset(%q) failed: %v", name, err)
+		}
+		if preset.Codec == "" || preset.CRF == 0 {
+			t.Errorf("Expected a codec and CRF for preset %q, got %+v", name, preset)
+		}
+	}
+	if _, err := LookupVideoPreset("tiny"); err == nil {
+		t.Error("Expected an error for an unknown preset")
+	}
+	if preset := DefaultParseOptions().VideoPreset; preset != videoPresets[DefaultVideoPresetName] {
+		t.Errorf("Expected the default options to use the %s preset, got %+v", DefaultVideoPresetName, preset)
+	}
+}
+
+func TestFFmpegArgs(t *testing.T) {
+	args := ffmpegArgs("in.mov", "out.mov", VideoPreset{Codec: "libx265", CRF: 26, MaxResolution: 1920})
+	joined := strings.Join(args, " ")
+	for _, expected := range []string{"-map_metadata 0", "-c:v libx265", "-crf 26", "-tag:v hvc1", "min(iw,1920)", "-progress pipe:1"} {
+		if !strings.Contains(joined, expected) {
+			t.Errorf("Expected %q in %q", expected, joined)
+		}
+	}
+	if args[len(args)-1] != "out.mov" {
+		t.Errorf("Expected the output last, got %q", args[len(args)-1])
+	}
+
+	args = ffmpegArgs("in.mov", "out.mov", VideoPreset{Codec: "libx264", CRF: 23})
+	if slices.Contains(args, "-vf") || slices.Contains(args, "-tag:v") {
+		t.Errorf("Expected no scaling or tag for a full resolution H.264 preset, got %v", args)
+	}
+}
+
+func TestParseFFmpegDuration(t *testing.T) {
+	output := []byte("Input #0, mov,mp4,m4a,3gp,3g2,mj2, from 'in.mov':\n  Duration: 01:02:03.45, start: 0.000000, bitrate: 1000 kb/s\n")
+	duration, found := parseFFmpegDuration(output)
+	expected := time.Hour + 2*time.Minute + 3*time.Second + 450*time.Millisecond
+	if !found || duration != expected {
+		t.Errorf("Expected %v, got %v (found %v)", expected, duration, found)
+	}
+	if _, found := parseFFmpegDuration([]byte("Duration: N/A")); found {
+		t.Error("Expected no duration for N/A")
+	}
+}
+
+func TestReadFFmpegProgress(t *testing.T) {
+	progress := "frame=10\nout_time_us=2500000\nprogress=continue\nout_time_us=N/A\nout_time_us=12000000\nprogress=end\n"
+	duration := func() time.Duration { return 10 * time.Second }
+
+	var reported []float64
+	readFFmpegProgress(strings.NewReader(progress), duration, func(done float64) {
+		reported = append(reported, done)
+	})
+
+	expected := []float64{0.25, 1}
+	if !slices.Equal(reported, expected) {
+		t.Errorf("Expected %v, got %v", expected, reported)
+	}
+
+	// Nothing is reported until the duration is known
+	reported = nil
+	readFFmpegProgress(strings.NewReader(progress), func() time.Duration { return 0 }, func(done float64) {
+		reported = append(reported, done)
+	})
+	if len(reported) != 0 {
+		t.Errorf("Expected no progress without a duration, got %v", reported)
+	}
+}
+
+func TestFFmpegCompressor_CompressVideo(t *testing.T) {
+	tmpDir := t.TempDir()
+	preset := videoPresets[DefaultVideoPresetName]
+
+	if _, err := NewVideoCompressor().CompressVideo(context.Background(), filepath.Join(tmpDir, "missing.mov"), preset, nil); err == nil {
+		t.Error("Expected an error for a missing file")
+	}
+
+	path := createMediaFile(t, tmpDir, "VID_0001.mov", time.Now())
+	compressor := NewVideoCompressorWithPath(filepath.Join(tmpDir, "no-such-ffmpeg"))
+	if _, err := compressor.CompressVideo(context.Background(), path, preset, nil); err == nil {
+		t.Error("Expected an error for a missing ffmpeg binary")
+	}
+	if entries, _ := os.ReadDir(tmpDir); len(entries) != 1 {
+		t.Errorf("Expected no temporary file left behind, got %d entries", len(entries))
+	}
+}
+
+func TestFFmpegCompressor_CompressVideo_FFmpeg(t *testing.T) {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		t.Skip("ffmpeg not installed, skipping test")
+	}
+
+	// A file that is not a video must fail and stay untouched
+	tmpDir := t.TempDir()
+	path := createMediaFile(t, tmpDir, "VID_0001.mov", time.Now())
+	if _, err := NewVideoCompressor().CompressVideo(context.Background(), path, videoPresets["small"], nil); err == nil {
+		t.Error("Expected an error for a file that is not a video")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil || string(data) != "test media content" {
+		t.Errorf("Expected the file to be left untouched, got %q, %v", data, err)
+	}
+}
+
+func TestMediaParser_Parse_CompressesVideos(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir, targetDir := createSourceAndTarget(t, tmpDir)
+	testDate := time.Date(2023, 6, 15, 10, 30, 0, 0, time.UTC)
+	createMediaFile(t, sourceDir, "VID_0001.mov", testDate)
+	createMediaFile(t, sourceDir, "VID_0002-broken.mov", testDate)
+	createMediaFile(t, sourceDir, "IMG_0001.jpg", testDate)
+
+	parser := &mediaParser{
+		compressor: NewImageCompressor(),
+		video:      &fakeVideoCompressor{failOn: "broken"},
+		organiser:  NewFileOrganiser(),
+		extensions: NewExtensions(),
+	}
+	progressChan := make(chan ProgressEvent, 100)
+	opts := testParseOptions
+	opts.CompressVideos = true
+	opts.ErrorPolicy = ErrorPolicyContinue
+	opts.ProgressChan = progressChan
+
+	plan, err := parser.Plan(sourceDir, targetDir, opts)
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+	for _, file := range plan.Files {
+		if file.Compress != parser.extensions.IsVideo(file.Source) {
+			t.Errorf("Expected only videos to be compressed, got %+v", file)
+		}
+	}
+
+	result, err := parser.Parse(sourceDir, targetDir, opts)
+	if err == nil {
+		t.Fatal("Expected the failed transcode to be reported")
+	}
+	close(progressChan)
+
+	if result == nil || len(result.Files) != 2 || len(result.Failures) != 1 || result.Failures[0].Stage != "transcoding" {
+		t.Fatalf("Expected two imported files and a transcoding failure, got %+v, %+v", result.Files, result.Failures)
+	}
+	for _, file := range result.Files {
+		if file.Compressed != (file.Class == ClassVideo) {
+			t.Errorf("Expected only the video to be compressed, got %+v", file)
+		}
+	}
+
+	video := filepath.Join(targetDir, "2023 06 June 15", "videos", "2023_06_June_15_00001.mov")
+	info, err := os.Stat(video)
+	if err != nil {
+		t.Fatalf("Failed to stat imported video: %v", err)
+	}
+	if info.Size() != int64(len("test media content")/2) {
+		t.Errorf("Expected the transcoded video to be imported, got %d bytes", info.Size())
+	}
+
+	var percents []string
+	for event := range progressChan {
+		if event.Stage == "transcoding" && strings.Contains(event.File, "VID_0001") {
+			percents = append(percents, event.Message[strings.LastIndex(event.Message, " ")+1:])
+		}
+	}
+	expected := []string{"0%", "50%", "100%"}
+	if !slices.Equal(percents, expected) {
+		t.Errorf("Expected transcoding progress %v, got %v", expected, percents)
+	}
+}
@@ -0,0 +1,436 @@
+package pics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/acm19/pics/internal/logger"
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	// batchDirName is the directory inside the inbox that holds the batch being imported
+	batchDirName = ".pics-batch"
+	// importedDirName is the directory inside the inbox that imported batches are moved to
+	importedDirName = ".imported"
+)
+
+// ErrUnfinishedBatch is returned when the inbox still holds a batch from a watch that stopped mid-import
+var ErrUnfinishedBatch = errors.New("inbox has an unfinished batch")
+
+// InboxWatcher defines the interface for importing files dropped into an inbox directory
+type InboxWatcher interface {
+	// Watch imports media files from inboxDir into libraryDir as they arrive, until ctx is cancelled.
+	//
+	// Files are imported once they have not changed for opts.SettleTime. Files that
+	// settle together are imported as one batch: they are moved into a batch
+	// directory inside the inbox, parsed into the library and then moved to
+	// inboxDir/.imported so they are not imported again.
+	//
+	// Parameters:
+	//   - ctx: Stops watching when cancelled, rolling back the batch being imported
+	//   - inboxDir: Directory to watch (recursively)
+	//   - libraryDir: Directory to organise the files into
+	//   - opts: Settle time, batch size and the options for every parse
+	//
+	// Returns:
+	//   - error: nil when ctx is cancelled, otherwise the error that stopped the watch
+	Watch(ctx context.Context, inboxDir, libraryDir string, opts WatchOptions) error
+}
+
+// inboxWatcher implements the InboxWatcher interface
+type inboxWatcher struct {
+	parser     MediaParser
+	extensions Extensions
+}
+
+// NewInboxWatcher creates a new InboxWatcher that imports files with the given parser
+func NewInboxWatcher(parser MediaParser) InboxWatcher {
+	return &inboxWatcher{
+		parser:     parser,
+		extensions: NewExtensions(),
+	}
+}
+
+// Watch imports media files from inboxDir into libraryDir as they arrive, until ctx is cancelled
+func (w *inboxWatcher) Watch(ctx context.Context, inboxDir, libraryDir string, opts WatchOptions) error {
+	inboxDir = strings.TrimSuffix(inboxDir, "/")
+	libraryDir = strings.TrimSuffix(libraryDir, "/")
+
+	batchDir := filepath.Join(inboxDir, batchDirName)
+	if _, err := os.Stat(batchDir); err == nil {
+		return fmt.Errorf("%w: %s (finish or roll back the parse in the library, then move its files to %s or back to the inbox)",
+			ErrUnfinishedBatch, batchDir, importedDirName)
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create watcher: %w", err)
+	}
+	defer watcher.Close()
+
+	// Files already in the inbox are imported like new ones
+	pending := newPendingFiles()
+	if err := w.watchTree(watcher, pending, inboxDir, time.Now()); err != nil {
+		return fmt.Errorf("failed to watch inbox: %w", err)
+	}
+	logger.Info("Watching inbox", "inbox", inboxDir, "library", libraryDir, "settleTime", opts.SettleTime, "pending", pending.len())
+
+	ticker := time.NewTicker(pollInterval(opts.SettleTime))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Info("Stopped watching inbox", "inbox", inboxDir)
+			return nil
+
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			w.handleEvent(watcher, pending, inboxDir, event)
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			logger.Warn("Watcher error", "error", err)
+			if errors.Is(err, fsnotify.ErrEventOverflow) {
+				// Events were lost, so rescan the whole inbox
+				if err := w.watchTree(watcher, pending, inboxDir, time.Now()); err != nil {
+					return fmt.Errorf("failed to rescan inbox: %w", err)
+				}
+			}
+
+		case now := <-ticker.C:
+			pending.refresh(now)
+			files := w.withSidecars(pending.ready(now, opts.SettleTime, opts.MaxBatchSize), pending)
+			if len(files) == 0 {
+				continue
+			}
+			if err := w.importBatch(ctx, files, inboxDir, libraryDir, opts.ParseOptions); err != nil {
+				if ctx.Err() != nil {
+					logger.Info("Stopped watching inbox", "inbox", inboxDir)
+					return nil
+				}
+				return err
+			}
+		}
+	}
+}
+
+// pollInterval returns how often pending files are checked for changes
+func pollInterval(settleTime time.Duration) time.Duration {
+	return max(settleTime/4, 50*time.Millisecond)
+}
+
+// isHidden reports whether path is (or is inside) a dot file or directory below inboxDir
+func isHidden(inboxDir, path string) bool {
+	rel, err := filepath.Rel(inboxDir, path)
+	if err != nil {
+		return true
+	}
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		if strings.HasPrefix(part, ".") && part != "." {
+			return true
+		}
+	}
+	return false
+}
+
+// tracked reports whether path is imported from the inbox, as a media file or as the sidecar of one
+func (w *inboxWatcher) tracked(path string) bool {
+	return w.extensions.IsSupported(path) || w.extensions.IsSidecar(path)
+}
+
+// watchTree adds a watch for dir and every directory below it, and queues the media files
+// and sidecars it holds
+func (w *inboxWatcher) watchTree(watcher *fsnotify.Watcher, pending *pendingFiles, dir string, now time.Time) error {
+	return filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			// The directory may have been removed since the event was received
+			logger.Debug("Error accessing path", "path", path, "error", err)
+			return nil
+		}
+		if path != dir && strings.HasPrefix(entry.Name(), ".") {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if entry.IsDir() {
+			logger.Debug("Watching directory", "path", path)
+			return watcher.Add(path)
+		}
+		if w.tracked(path) {
+			pending.touch(path, now)
+		}
+		return nil
+	})
+}
+
+// handleEvent updates the pending files for a single filesystem event
+func (w *inboxWatcher) handleEvent(watcher *fsnotify.Watcher, pending *pendingFiles, inboxDir string, event fsnotify.Event) {
+	if isHidden(inboxDir, event.Name) {
+		return
+	}
+	logger.Debug("Inbox event", "event", event.Op.String(), "path", event.Name)
+
+	now := time.Now()
+	switch {
+	case event.Has(fsnotify.Remove), event.Has(fsnotify.Rename):
+		pending.remove(event.Name)
+	case event.Has(fsnotify.Create), event.Has(fsnotify.Write):
+		info, err := os.Stat(event.Name)
+		if err != nil {
+			return
+		}
+		if info.IsDir() {
+			// Files may have landed in the directory before the watch was added
+			if err := w.watchTree(watcher, pending, event.Name, now); err != nil {
+				logger.Warn("Failed to watch directory", "path", event.Name, "error", err)
+			}
+			return
+		}
+		if w.tracked(event.Name) {
+			pending.touch(event.Name, now)
+		}
+	}
+}
+
+// importBatch moves files into the batch directory and parses them into the library
+func (w *inboxWatcher) importBatch(ctx context.Context, files []string, inboxDir, libraryDir string, opts ParseOptions) error {
+	batchDir := filepath.Join(inboxDir, batchDirName)
+
+	// Batch path -> inbox path, keeping the inbox structure so staging names match a plain parse
+	moved := make(map[string]string)
+	for _, path := range files {
+		rel, err := filepath.Rel(inboxDir, path)
+		if err != nil {
+			return err
+		}
+		dest := filepath.Join(batchDir, rel)
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return fmt.Errorf("failed to create batch directory: %w", err)
+		}
+		if err := os.Rename(path, dest); err != nil {
+			logger.Warn("Skipping file that could not be moved into the batch", "file", path, "error", err)
+			continue
+		}
+		moved[dest] = path
+	}
+	if len(moved) == 0 {
+		return os.RemoveAll(batchDir)
+	}
+
+	logger.Info("Importing batch", "files", len(moved), "library", libraryDir)
+	_, err := w.parser.ParseContext(ctx, batchDir, libraryDir, opts)
+
+	var partialErr *PartialParseError
+	switch {
+	case err == nil:
+	case errors.As(err, &partialErr):
+		logger.Warn("Batch imported with failures", "failed", len(partialErr.Failures), "report", partialErr.ReportPath)
+	case !errors.Is(err, ErrJournalExists) && journalExists(libraryDir):
+		// The parse can still be resumed or rolled back, which needs the batch
+		return fmt.Errorf("failed to import batch, files kept in %s: %w", batchDir, err)
+	default:
+		// Nothing was left behind in the library, so the files can go back to the inbox
+		w.restoreBatch(moved, batchDir)
+		return fmt.Errorf("failed to import batch: %w", err)
+	}
+
+	importedDir := filepath.Join(inboxDir, importedDirName, time.Now().Format("20060102-150405.000"))
+	if err := os.MkdirAll(filepath.Dir(importedDir), 0755); err != nil {
+		return fmt.Errorf("failed to create imported directory: %w", err)
+	}
+	if err := os.Rename(batchDir, importedDir); err != nil {
+		return fmt.Errorf("failed to move imported batch: %w", err)
+	}
+	logger.Info("Batch imported", "files", len(moved), "movedTo", importedDir)
+	return nil
+}
+
+// withSidecars returns the settled files with the sidecars next to their media files, so
+// they are imported together. A media file whose sidecar is still pending (being written)
+// is held back with its other sidecars, as is a sidecar whose media file is still pending,
+// until the whole group has settled. A sidecar whose media file was imported in an earlier
+// batch goes on its own, so it does not stay in the inbox.
+func (w *inboxWatcher) withSidecars(files []string, pending *pendingFiles) []string {
+	batch := make(map[string]bool, len(files))
+	for _, path := range files {
+		batch[path] = true
+	}
+
+	primaries := make(map[string]map[string]string)
+	for _, path := range files {
+		dir := filepath.Dir(path)
+		if _, read := primaries[dir]; !read {
+			names, err := readDirNames(dir)
+			if err != nil {
+				logger.Debug("Cannot look for sidecars", "dir", dir, "error", err)
+			}
+			primaries[dir] = sidecarPrimaries(names, w.extensions)
+		}
+	}
+
+	held := make(map[string]bool)
+	for dir, sidecars := range primaries {
+		for sidecar, primary := range sidecars {
+			if pending.has(filepath.Join(dir, sidecar)) {
+				held[filepath.Join(dir, primary)] = true
+			}
+		}
+	}
+	var extra []string
+	for dir, sidecars := range primaries {
+		for sidecar, primary := range sidecars {
+			sidecarPath, primaryPath := filepath.Join(dir, sidecar), filepath.Join(dir, primary)
+			switch {
+			case held[primaryPath] || pending.has(primaryPath):
+				held[sidecarPath] = batch[sidecarPath]
+			case batch[primaryPath] && !batch[sidecarPath]:
+				extra = append(extra, sidecarPath)
+				batch[sidecarPath] = true
+			}
+		}
+	}
+
+	var withSidecars []string
+	for _, path := range append(files, extra...) {
+		if held[path] && batch[path] {
+			logger.Debug("Holding file until its media file or sidecars settle", "file", path)
+			pending.restore(path)
+			continue
+		}
+		withSidecars = append(withSidecars, path)
+	}
+	return withSidecars
+}
+
+// restoreBatch moves the files of a batch that was not imported back to the inbox
+func (w *inboxWatcher) restoreBatch(moved map[string]string, batchDir string) {
+	for dest, path := range moved {
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		if err == nil {
+			err = os.Rename(dest, path)
+		}
+		if err != nil {
+			logger.Error("Failed to restore file to the inbox", "file", dest, "error", err)
+			return
+		}
+	}
+	if err := os.RemoveAll(batchDir); err != nil {
+		logger.Warn("Failed to remove batch directory", "path", batchDir, "error", err)
+	}
+}
+
+// journalExists reports whether targetDir holds the journal of an unfinished parse
+func journalExists(targetDir string) bool {
+	_, err := os.Stat(filepath.Join(targetDir, journalDirName))
+	return err == nil
+}
+
+// pendingFile tracks when a file in the inbox last changed
+type pendingFile struct {
+	size      int64
+	modTime   time.Time
+	changedAt time.Time
+}
+
+// pendingFiles tracks the files in the inbox that are waiting to be imported
+type pendingFiles struct {
+	files map[string]*pendingFile
+}
+
+func newPendingFiles() *pendingFiles {
+	return &pendingFiles{files: make(map[string]*pendingFile)}
+}
+
+func (p *pendingFiles) len() int {
+	return len(p.files)
+}
+
+// touch records that path changed at now
+func (p *pendingFiles) touch(path string, now time.Time) {
+	if file, found := p.files[path]; found {
+		file.changedAt = now
+		return
+	}
+	p.files[path] = &pendingFile{size: -1, changedAt: now}
+}
+
+// remove stops tracking path
+func (p *pendingFiles) remove(path string) {
+	delete(p.files, path)
+}
+
+// has reports whether path is still tracked
+func (p *pendingFiles) has(path string) bool {
+	_, found := p.files[path]
+	return found
+}
+
+// restore tracks path again as a file that has already settled, for a file returned by
+// ready that has to wait for others
+func (p *pendingFiles) restore(path string) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	p.files[path] = &pendingFile{size: info.Size(), modTime: info.ModTime()}
+}
+
+// refresh checks every pending file for changes missed by the events (or made before the
+// watch started), dropping files that no longer exist
+func (p *pendingFiles) refresh(now time.Time) {
+	for path, file := range p.files {
+		info, err := os.Stat(path)
+		if err != nil {
+			delete(p.files, path)
+			continue
+		}
+		if info.Size() != file.size || !info.ModTime().Equal(file.modTime) {
+			file.size = info.Size()
+			file.modTime = info.ModTime()
+			file.changedAt = now
+		}
+	}
+}
+
+// ready returns (and stops tracking) the files that have not changed for settleTime.
+// Files are only returned once every pending file has settled, so a burst of files
+// is imported together, unless maxBatch settled files are already waiting.
+func (p *pendingFiles) ready(now time.Time, settleTime time.Duration, maxBatch int) []string {
+	var settled []string
+	for path, file := range p.files {
+		if now.Sub(file.changedAt) >= settleTime {
+			settled = append(settled, path)
+		}
+	}
+	if len(settled) == 0 {
+		return nil
+	}
+
+	full := maxBatch > 0 && len(settled) >= maxBatch
+	if len(settled) < len(p.files) && !full {
+		return nil
+	}
+
+	sort.Strings(settled)
+	if maxBatch > 0 && len(settled) > maxBatch {
+		settled = settled[:maxBatch]
+	}
+	for _, path := range settled {
+		delete(p.files, path)
+	}
+	return settled
+}
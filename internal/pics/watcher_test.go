@@ -0,0 +1,194 @@
+package pics
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// waitForFile polls until path exists or the timeout expires
+func waitForFile(t *testing.T, path string, timeout time.Duration) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if _, err := os.Stat(path); err == nil {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("Timed out waiting for %s", path)
+}
+
+func TestPendingFiles_Ready(t *testing.T) {
+	start := time.Now()
+	settle := 5 * time.Second
+
+	pending := newPendingFiles()
+	pending.touch("/inbox/a.jpg", start)
+	pending.touch("/inbox/b.jpg", start)
+
+	if files := pending.ready(start.Add(settle-time.Millisecond), settle, 0); files != nil {
+		t.Errorf("Expected no files before settling, got %v", files)
+	}
+
+	// b keeps changing, so the burst waits for it
+	pending.touch("/inbox/b.jpg", start.Add(time.Second))
+	if files := pending.ready(start.Add(settle), settle, 0); files != nil {
+		t.Errorf("Expected no files while b is changing, got %v", files)
+	}
+
+	files := pending.ready(start.Add(time.Second+settle), settle, 0)
+	expected := []string{"/inbox/a.jpg", "/inbox/b.jpg"}
+	if !reflect.DeepEqual(files, expected) {
+		t.Errorf("Expected %v, got %v", expected, files)
+	}
+	if pending.len() != 0 {
+		t.Errorf("Expected no pending files after import, got %d", pending.len())
+	}
+}
+
+func TestPendingFiles_Ready_MaxBatch(t *testing.T) {
+	start := time.Now()
+	settle := time.Second
+
+	pending := newPendingFiles()
+	pending.touch("/inbox/a.jpg", start)
+	pending.touch("/inbox/b.jpg", start)
+	pending.touch("/inbox/c.jpg", start)
+	pending.touch("/inbox/still-copying.mov", start.Add(settle))
+
+	// A full batch is imported even though another file is still changing
+	files := pending.ready(start.Add(settle), settle, 2)
+	expected := []string{"/inbox/a.jpg", "/inbox/b.jpg"}
+	if !reflect.DeepEqual(files, expected) {
+		t.Errorf("Expected %v, got %v", expected, files)
+	}
+	if pending.len() != 2 {
+		t.Errorf("Expected 2 pending files, got %d", pending.len())
+	}
+}
+
+func TestInboxWatcher_Watch(t *testing.T) {
+	tmpDir := t.TempDir()
+	inboxDir, libraryDir := createSourceAndTarget(t, tmpDir)
+	testDate := time.Date(2023, 6, 15, 10, 30, 0, 0, time.UTC)
+
+	// Files already in the inbox are imported too
+	createMediaFile(t, inboxDir, "existing.jpg", testDate)
+
+	opts := DefaultWatchOptions()
+	opts.ParseOptions = testParseOptions
+	opts.SettleTime = 100 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- NewInboxWatcher(testParser).Watch(ctx, inboxDir, libraryDir, opts)
+	}()
+
+	dateDir := filepath.Join(libraryDir, "2023 06 June 15")
+	waitForFile(t, filepath.Join(dateDir, "2023_06_June_15_00001.jpg"), 5*time.Second)
+
+	// New files dropped into a new subdirectory are imported in a later batch
+	phoneDir := createSubdir(t, inboxDir, "phone")
+	createMediaFile(t, phoneDir, "new.jpg", testDate)
+	createMediaFile(t, phoneDir, "clip.mov", testDate)
+	waitForFile(t, filepath.Join(dateDir, "2023_06_June_15_00002.jpg"), 5*time.Second)
+	waitForFile(t, filepath.Join(dateDir, "videos", "2023_06_June_15_00001.mov"), 5*time.Second)
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+
+	// Imported files are moved out of the way
+	assertMediaFileNotExists(t, filepath.Join(inboxDir, "existing.jpg"))
+	assertMediaFileNotExists(t, filepath.Join(phoneDir, "new.jpg"))
+	assertMediaFileNotExists(t, filepath.Join(inboxDir, batchDirName))
+	batches, err := os.ReadDir(filepath.Join(inboxDir, importedDirName))
+	if err != nil {
+		t.Fatalf("Failed to read imported directory: %v", err)
+	}
+	if len(batches) != 2 {
+		t.Errorf("Expected 2 imported batches, got %d", len(batches))
+	}
+}
+
+func TestInboxWatcher_Watch_UnfinishedBatch(t *testing.T) {
+	tmpDir := t.TempDir()
+	inboxDir, libraryDir := createSourceAndTarget(t, tmpDir)
+	createSubdir(t, inboxDir, batchDirName)
+
+	err := NewInboxWatcher(testParser).Watch(context.Background(), inboxDir, libraryDir, DefaultWatchOptions())
+	if !errors.Is(err, ErrUnfinishedBatch) {
+		t.Errorf("Expected ErrUnfinishedBatch, got: %v", err)
+	}
+}
+
+func TestInboxWatcher_ImportBatch_RestoresFilesOnFailure(t *testing.T) {
+	tmpDir := t.TempDir()
+	inboxDir, libraryDir := createSourceAndTarget(t, tmpDir)
+	file := createMediaFile(t, inboxDir, "image.jpg", time.Now())
+
+	// A journal left by another parse makes the import fail before touching the library
+	createSubdir(t, libraryDir, journalDirName)
+
+	watcher := NewInboxWatcher(testParser).(*inboxWatcher)
+	err := watcher.importBatch(context.Background(), []string{file}, inboxDir, libraryDir, testParseOptions)
+	if !errors.Is(err, ErrJournalExists) {
+		t.Fatalf("Expected ErrJournalExists, got: %v", err)
+	}
+
+	assertMediaFileExists(t, file)
+	assertMediaFileNotExists(t, filepath.Join(inboxDir, batchDirName))
+}
+
+func TestInboxWatcher_WithSidecars(t *testing.T) {
+	tmpDir := t.TempDir()
+	inboxDir := createSubdir(t, tmpDir, "inbox")
+	image := createMediaFile(t, inboxDir, "image.jpg", time.Now())
+	sidecar := createMediaFile(t, inboxDir, "image.xmp", time.Now())
+	createMediaFile(t, inboxDir, "later.jpg", time.Now())
+	createMediaFile(t, inboxDir, "later.xmp", time.Now())
+
+	// Sidecars of files left for a later batch stay in the inbox
+	watcher := NewInboxWatcher(testParser).(*inboxWatcher)
+	files := watcher.withSidecars([]string{image}, newPendingFiles())
+
+	expected := []string{image, sidecar}
+	if !reflect.DeepEqual(files, expected) {
+		t.Errorf("Expected %v, got %v", expected, files)
+	}
+}
+
+func TestInboxWatcher_WithSidecars_HoldsUnsettledGroups(t *testing.T) {
+	tmpDir := t.TempDir()
+	inboxDir := createSubdir(t, tmpDir, "inbox")
+	image := createMediaFile(t, inboxDir, "image.jpg", time.Now())
+	writing := createMediaFile(t, inboxDir, "image.xmp", time.Now())
+	video := createMediaFile(t, inboxDir, "video.mov", time.Now())
+	settled := createMediaFile(t, inboxDir, "video.mov.xmp", time.Now())
+	orphan := createMediaFile(t, inboxDir, "imported.xmp", time.Now())
+
+	// The image waits for its sidecar, the sidecar of the video waits for the video
+	pending := newPendingFiles()
+	pending.touch(writing, time.Now())
+	pending.touch(video, time.Now())
+
+	watcher := NewInboxWatcher(testParser).(*inboxWatcher)
+	files := watcher.withSidecars([]string{image, orphan, settled}, pending)
+
+	expected := []string{orphan}
+	if !reflect.DeepEqual(files, expected) {
+		t.Errorf("Expected %v, got %v", expected, files)
+	}
+	for _, path := range []string{image, settled} {
+		if !pending.has(path) {
+			t.Errorf("Expected %s to be pending again", path)
+		}
+	}
+}